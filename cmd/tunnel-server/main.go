@@ -1,112 +1,413 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"crypto/tls"
+	"crypto/x509"
+	"errors"
 	"flag"
 	"fmt"
-	"log"
+	"log/slog"
 	"net"
+	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
 	"syscall"
 	"time"
 
-	"github.com/hydragon2m/tunnel-protocol/go/v1"
+	"github.com/hydragon2m/tunnel-core/internal/admin"
+	"github.com/hydragon2m/tunnel-core/internal/certs"
+	"github.com/hydragon2m/tunnel-core/internal/config"
 	"github.com/hydragon2m/tunnel-core/internal/connection"
+	"github.com/hydragon2m/tunnel-core/internal/control"
+	"github.com/hydragon2m/tunnel-core/internal/domains"
 	"github.com/hydragon2m/tunnel-core/internal/handshake"
 	"github.com/hydragon2m/tunnel-core/internal/listener"
+	"github.com/hydragon2m/tunnel-core/internal/logging"
+	"github.com/hydragon2m/tunnel-core/internal/metrics"
 	"github.com/hydragon2m/tunnel-core/internal/quota"
 	"github.com/hydragon2m/tunnel-core/internal/registry"
+	"github.com/hydragon2m/tunnel-core/internal/revocation"
 	"github.com/hydragon2m/tunnel-core/internal/router"
+	"github.com/hydragon2m/tunnel-protocol/go/v1"
 )
 
 var (
+	// Config file
+	configFile = flag.String("config", "", "Path to YAML config file (env: TUNNEL_CONFIG)")
+
 	// Agent listener config
 	agentAddr     = flag.String("agent-addr", ":8443", "Address to listen for agent connections")
 	agentTLS      = flag.Bool("agent-tls", true, "Enable TLS for agent connections")
-	agentCertFile  = flag.String("agent-cert", "", "TLS certificate file for agent connections")
-	agentKeyFile   = flag.String("agent-key", "", "TLS key file for agent connections")
+	agentCertFile = flag.String("agent-cert", "", "TLS certificate file for agent connections")
+	agentKeyFile  = flag.String("agent-key", "", "TLS key file for agent connections")
 
 	// Public listener config
-	publicAddr    = flag.String("public-addr", ":8080", "Address to listen for public HTTP requests")
-	publicTLS     = flag.Bool("public-tls", false, "Enable TLS for public connections")
+	publicAddr     = flag.String("public-addr", ":8080", "Address to listen for public HTTP requests")
+	publicTLS      = flag.Bool("public-tls", false, "Enable TLS for public connections")
 	publicCertFile = flag.String("public-cert", "", "TLS certificate file for public connections")
 	publicKeyFile  = flag.String("public-key", "", "TLS key file for public connections")
 
+	// TCP tunnels config
+	tcpPortRange        = flag.String("tcp-port-range", "", "Public port range for TCP tunnels, e.g. 20000-20999 (empty = disabled)")
+	tcpBindAddr         = flag.String("tcp-bind-addr", "", "Host to bind TCP tunnel ports (empty = all interfaces)")
+	tcpMaxPortsPerAgent = flag.Int("tcp-max-ports-per-agent", 5, "Maximum TCP tunnel ports per agent (0 = unlimited)")
+	tcpReleaseGrace     = flag.Duration("tcp-release-grace", 0, "Keep an agent's TCP port reserved after disconnect")
+
 	// Base domain
 	baseDomain = flag.String("base-domain", "localhost", "Base domain for tunnels")
 
+	// Agent authentication
+	authBackend = flag.String("auth-backend", "", "Token validator: insecure, static, jwt, webhook (required)")
+
 	// Config
-	maxConnections    = flag.Int("max-connections", 1000, "Maximum number of agent connections")
-	heartbeatTimeout  = flag.Duration("heartbeat-timeout", 30*time.Second, "Heartbeat timeout")
-	authTimeout       = flag.Duration("auth-timeout", 10*time.Second, "Authentication timeout")
+	maxConnections   = flag.Int("max-connections", 1000, "Maximum number of agent connections")
+	maxStreams       = flag.Int("max-streams", 10000, "Maximum concurrent streams globally")
+	heartbeatTimeout = flag.Duration("heartbeat-timeout", 30*time.Second, "Heartbeat timeout")
+	authTimeout      = flag.Duration("auth-timeout", 10*time.Second, "Authentication timeout")
+	drainTimeout     = flag.Duration("drain-timeout", 30*time.Second, "Time to let active streams finish on shutdown")
+
+	// Logging
+	logLevel = flag.String("log-level", "info", "Log level: debug, info, warn, error")
+	logFile  = flag.String("log-file", "", "Log file path (empty = stdout)")
 )
 
+// flagKeys map tên flag → key trong config file
+var flagKeys = map[string]string{
+	"agent-addr":              "agent.addr",
+	"agent-tls":               "agent.tls",
+	"agent-cert":              "agent.cert_file",
+	"agent-key":               "agent.key_file",
+	"public-addr":             "public.addr",
+	"public-tls":              "public.tls",
+	"public-cert":             "public.cert_file",
+	"public-key":              "public.key_file",
+	"tcp-port-range":          "tcp.port_range",
+	"tcp-bind-addr":           "tcp.bind_addr",
+	"tcp-max-ports-per-agent": "tcp.max_ports_per_agent",
+	"tcp-release-grace":       "tcp.release_grace",
+	"base-domain":             "base_domain",
+	"auth-backend":            "auth.backend",
+	"max-connections":         "limits.max_connections",
+	"max-streams":             "limits.max_streams",
+	"heartbeat-timeout":       "limits.heartbeat_timeout",
+	"auth-timeout":            "limits.auth_timeout",
+	"drain-timeout":           "shutdown.drain_timeout",
+	"log-level":               "logging.level",
+	"log-file":                "logging.file",
+}
+
+// loadConfig load config theo thứ tự ưu tiên: defaults < file < env < flags
+func loadConfig() (*config.Config, error) {
+	path := *configFile
+	if path == "" {
+		path = os.Getenv("TUNNEL_CONFIG")
+	}
+
+	cfg := config.Default()
+	if path != "" {
+		var err error
+		if cfg, err = config.Load(path); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := cfg.ApplyEnv("TUNNEL_", os.LookupEnv); err != nil {
+		return nil, err
+	}
+
+	// Chỉ override bằng flags được set tường minh
+	var flagErr error
+	flag.Visit(func(f *flag.Flag) {
+		key, ok := flagKeys[f.Name]
+		if !ok || flagErr != nil {
+			return
+		}
+		value := f.Value.String()
+		if d, ok := f.Value.(flag.Getter).Get().(time.Duration); ok {
+			// Duration flags → seconds trong config, không làm tròn (500ms → 0 = tắt với một số keys)
+			if d%time.Second != 0 {
+				flagErr = fmt.Errorf("flag -%s: %w: %s is not a whole number of seconds", f.Name, config.ErrInvalidValue, d)
+				return
+			}
+			value = strconv.Itoa(int(d / time.Second))
+		}
+		if err := cfg.Set(key, value); err != nil {
+			flagErr = fmt.Errorf("flag -%s: %w", f.Name, err)
+		}
+	})
+	if flagErr != nil {
+		return nil, flagErr
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
 func main() {
 	flag.Parse()
 
+	cfg, err := loadConfig()
+	if err != nil {
+		fatal("Invalid configuration", "error", err)
+	}
+
 	// Setup logging
-	log.SetFlags(log.LstdFlags | log.Lshortfile)
-	log.Println("Starting Tunnel Core Server...")
+	logCloser, err := logging.Setup(cfg.Logging.Level, cfg.Logging.File, cfg.Logging.Structured)
+	if err != nil {
+		fatal("Failed to setup logging", "error", err)
+	}
+	defer logCloser.Close()
+	slog.Info("Starting Tunnel Core Server")
 
 	// Create context for graceful shutdown
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
 	// Initialize components
-	connManager := connection.NewManager(*maxConnections, *heartbeatTimeout)
-	reg := registry.NewRegistry(*baseDomain)
-	limiter := quota.NewLimiter(*maxConnections, 10000) // Max 10000 concurrent streams globally
+	connManager := connection.NewManager(cfg.Limits.MaxConnections, cfg.Limits.HeartbeatTimeoutDuration())
+	connManager.SetWriteTimeout(cfg.Limits.WriteTimeoutDuration())
+	connManager.SetSendQueueSize(cfg.Limits.SendQueueSize)
+	if cfg.FlowControl.Enabled {
+		connManager.SetFlowControl(connection.FlowControl{
+			StreamWindow:     uint32(cfg.FlowControl.StreamWindow),
+			ConnectionWindow: uint32(cfg.FlowControl.ConnectionWindow),
+		})
+	}
+	if cfg.Ping.Enabled {
+		connManager.SetPingPolicy(connection.PingPolicy{
+			Interval:  cfg.Ping.IntervalDuration(),
+			MaxMissed: cfg.Ping.MaxMissed,
+		})
+	}
+	reg := registry.NewRegistry(cfg.BaseDomain)
+	limiter := quota.NewLimiter(cfg.Limits.MaxConnections, cfg.Limits.MaxStreams)
+	if cfg.RateLimiting.Enabled {
+		agentDefaults := cfg.RateLimiting.DefaultAgent
+		domainDefaults := cfg.RateLimiting.DefaultDomain
+		limiter.SetDefaultAgentLimit(agentDefaults.MaxStreams, agentDefaults.MaxBandwidth, agentDefaults.RateLimit)
+		limiter.SetDefaultDomainLimit(domainDefaults.MaxStreams, domainDefaults.MaxBandwidth, domainDefaults.RateLimit)
+	}
+
+	// Chống flood/brute-force trên agent listener trước khi agent xác thực
+	handshakeLimits := cfg.Limits.Handshake
+	guard := handshake.NewGuard(handshake.GuardConfig{
+		MaxPending:  handshakeLimits.MaxPending,
+		PerIPRate:   handshakeLimits.PerIPRate,
+		PerIPBurst:  handshakeLimits.PerIPBurst,
+		MaxFailures: handshakeLimits.MaxFailures,
+		Lockout:     handshakeLimits.LockoutDuration(),
+		MaxLockout:  handshakeLimits.MaxLockoutDuration(),
+	})
+
+	// Metrics (nil = tắt, các component bỏ qua)
+	var serverMetrics *metrics.Metrics
+	if cfg.Metrics.Enabled {
+		serverMetrics = newServerMetrics(connManager, reg, guard)
+		metricsServer, err := startMetricsServer(cfg.Metrics, serverMetrics)
+		if err != nil {
+			fatal("Failed to start metrics server", "port", cfg.Metrics.Port, "error", err)
+		}
+		defer metricsServer.Close()
+		slog.Info("Metrics server started", "port", cfg.Metrics.Port, "endpoint", cfg.Metrics.Endpoint)
+	}
+	connManager.SetMetrics(serverMetrics)
+	limiter.SetMetrics(serverMetrics)
+	guard.SetMetrics(serverMetrics)
+
+	// Token validator theo auth.backend (mode cert không cần backend)
+	var validator handshake.TokenValidator
+	if cfg.Auth.Backend != "" {
+		if validator, err = newTokenValidator(cfg.Auth); err != nil {
+			fatal("Failed to create token validator", "backend", cfg.Auth.Backend, "error", err)
+		}
+	}
+	if cfg.Auth.Backend == config.AuthBackendInsecure {
+		slog.Warn("Auth backend accepts any token, do not use in production", "backend", cfg.Auth.Backend)
+	}
 
-	// Simple token validator (replace with your auth logic)
-	validateToken := func(token string) (agentID string, err error) {
-		// TODO: Implement actual token validation
-		// For now, accept any non-empty token
-		if token == "" {
-			return "", handshake.ErrInvalidToken
+	// Client certificates (mTLS) trên agent listener
+	var certVerifier *handshake.ClientCertVerifier
+	if cfg.Auth.ClientCert.CAFile != "" {
+		certVerifier, err = handshake.NewClientCertVerifier(handshake.ClientCertConfig{
+			CAFile:      cfg.Auth.ClientCert.CAFile,
+			CRLFile:     cfg.Auth.ClientCert.CRLFile,
+			AgentIDFrom: cfg.Auth.ClientCert.AgentID,
+		})
+		if err != nil {
+			fatal("Failed to load client CA", "file", cfg.Auth.ClientCert.CAFile, "error", err)
 		}
-		// Extract agent ID from token or use token as agent ID
-		return token, nil
+		slog.Info("Agent client certificates enabled", "mode", cfg.Auth.Mode,
+			"crl", cfg.Auth.ClientCert.CRLFile != "", "agent_id", cfg.Auth.ClientCert.AgentID)
 	}
 
-	authenticator := handshake.NewAuthenticator(validateToken, *authTimeout)
+	authenticator := handshake.NewAuthenticator(validator, cfg.Limits.AuthTimeoutDuration())
+	authenticator.SetMode(handshake.AuthMode(cfg.Auth.Mode))
+	authenticator.SetMetrics(serverMetrics)
+
+	// Revocation list: kiểm tra lúc handshake và định kỳ cho connections đang sống
+	revocations, err := revocation.NewList(cfg.Auth.Revocation.File)
+	if err != nil {
+		fatal("Failed to load revocation list", "file", cfg.Auth.Revocation.File, "error", err)
+	}
+	authenticator.SetRevocations(revocations)
+
+	// Control messages từ agent (register/unregister tunnel)
+	controlHandler := control.NewHandler(reg)
+	connManager.SetOnControlMessage(controlHandler.HandleFrame)
+
+	// Custom domains (optional): agent claim domain riêng và xác minh qua DNS TXT hoặc HTTP
+	var domainManager *domains.Manager
+	if cfg.Domains.Enabled {
+		domainManager = domains.NewManager(cfg.BaseDomain, domains.Config{
+			Timeout:    cfg.Domains.VerifyTimeoutDuration(),
+			MaxPending: cfg.Domains.MaxPendingClaims,
+		})
+		controlHandler.SetDomains(domainManager)
+		slog.Info("Custom domains enabled")
+	}
+
+	// TCP tunnels (optional)
+	var tcpListener *listener.TCPListener
+	if cfg.TCP.PortRange != "" {
+		start, end, err := cfg.TCP.PortRangeBounds()
+		if err != nil {
+			fatal("Invalid TCP port range", "error", err)
+		}
+
+		tcpListener, err = listener.NewTCPListener(listener.TCPConfig{
+			BindAddr:         cfg.TCP.BindAddr,
+			PortRangeStart:   start,
+			PortRangeEnd:     end,
+			MaxPortsPerAgent: cfg.TCP.MaxPortsPerAgent,
+			ReleaseGrace:     cfg.TCP.ReleaseGraceDuration(),
+		}, reg, connManager, limiter)
+		if err != nil {
+			fatal("Failed to create TCP listener", "error", err)
+		}
+		tcpListener.SetMetrics(serverMetrics)
+		defer tcpListener.Close()
+
+		controlHandler.SetTCPListener(tcpListener)
+		slog.Info("TCP tunnels enabled", "port_start", start, "port_end", end)
+	}
 
 	// Setup connection callbacks
 	connManager.SetOnConnectionClosed(func(connID string) {
-		log.Printf("Connection closed: %s", connID)
+		slog.Info("Connection closed", "conn_id", connID)
 		// Cleanup tunnels for this connection
+		if tcpListener != nil {
+			tcpListener.ReleaseConnection(connID)
+		}
 		reg.UnregisterConnectionTunnels(connID)
 	})
 
 	// Start agent listener
-	agentListener, err := startAgentListener(*agentAddr, *agentTLS, *agentCertFile, *agentKeyFile)
+	agentListener, err := startAgentListener(cfg.Agent, certVerifier, cfg.Auth.Mode != config.AuthModeToken)
 	if err != nil {
-		log.Fatalf("Failed to start agent listener: %v", err)
+		fatal("Failed to start agent listener", "addr", cfg.Agent.Addr, "error", err)
 	}
 	defer agentListener.Close()
 
-	log.Printf("Agent listener started on %s (TLS: %v)", *agentAddr, *agentTLS)
+	slog.Info("Agent listener started", "addr", cfg.Agent.Addr, "tls", cfg.Agent.TLS)
+
+	// Admin API (optional)
+	if cfg.Admin.Enabled {
+		adminServer, err := startAdminServer(cfg.Admin, reg, connManager, limiter, tcpListener, domainManager, revocations)
+		if err != nil {
+			fatal("Failed to start admin server", "addr", cfg.Admin.Addr, "error", err)
+		}
+		defer adminServer.Close()
+		slog.Info("Admin API started", "addr", cfg.Admin.Addr)
+	}
 
 	// Create router with limiter
-	httpRouter := router.NewRouter(reg, connManager, limiter, 30*time.Second)
+	httpRouter := router.NewRouter(reg, connManager, limiter, cfg.Limits.RequestIdleTimeoutDuration())
+	httpRouter.SetRequestTimeout(cfg.Limits.RequestTimeoutDuration())
+	httpRouter.SetMetrics(serverMetrics)
+
+	// Start public listener (cert theo SNI qua ACME nếu bật, ngược lại cert/key tĩnh)
+	var publicListener *listener.HTTPListener
+	if cfg.ACME.Enabled {
+		certManager, err := newCertManager(cfg, domainManager)
+		if err != nil {
+			fatal("Failed to create ACME certificate manager", "error", err)
+		}
+		go certManager.Run(ctx)
 
-	// Start public listener
-	publicListener, err := listener.NewHTTPListener(*publicAddr, *publicTLS, *publicCertFile, *publicKeyFile, httpRouter)
-	if err != nil {
-		log.Fatalf("Failed to start public listener: %v", err)
+		if cfg.ACME.HTTPAddr != "" {
+			challengeServer, err := startInternalServer("acme-http", cfg.ACME.HTTPAddr, certManager.HTTPHandler(nil))
+			if err != nil {
+				fatal("Failed to start ACME HTTP challenge listener", "addr", cfg.ACME.HTTPAddr, "error", err)
+			}
+			defer challengeServer.Close()
+		}
+
+		publicListener, err = listener.NewHTTPListenerWithTLS(cfg.Public.Addr, certManager.TLSConfig(), httpRouter)
+		if err != nil {
+			fatal("Failed to start public listener", "addr", cfg.Public.Addr, "error", err)
+		}
+		slog.Info("ACME certificates enabled", "directory", cfg.ACME.DirectoryURL,
+			"wildcard", cfg.ACME.DNSCommand != "", "http_challenge_addr", cfg.ACME.HTTPAddr)
+	} else {
+		publicListener, err = listener.NewHTTPListener(cfg.Public.Addr, cfg.Public.TLS, cfg.Public.CertFile, cfg.Public.KeyFile, httpRouter)
+		if err != nil {
+			fatal("Failed to start public listener", "addr", cfg.Public.Addr, "error", err)
+		}
 	}
 	defer publicListener.Close()
 
-	log.Printf("Public listener started on %s (TLS: %v)", *publicAddr, *publicTLS)
+	// TLS passthrough: connections có SNI của passthrough tunnel đi thẳng đến agent, không terminate
+	if cfg.Public.TLS {
+		passthrough := listener.NewPassthrough(reg, connManager, limiter)
+		passthrough.SetMetrics(serverMetrics)
+		publicListener.SetPassthrough(passthrough)
+		controlHandler.SetPassthrough(true)
+	}
+
+	slog.Info("Public listener started", "addr", cfg.Public.Addr, "tls", cfg.Public.TLS)
+
+	// Ngắt agents có credentials bị thu hồi (revoke qua admin API hoặc sửa file)
+	go revocation.NewEnforcer(revocations, connManager, cfg.Auth.Revocation.CheckIntervalDuration()).Run(ctx)
+
+	// Features thỏa thuận với agent lúc handshake: chỉ những gì server đang bật
+	features := []string{}
+	if cfg.FlowControl.Enabled {
+		features = append(features, connection.CapabilityFlowControl)
+	}
+	if cfg.Ping.Enabled {
+		features = append(features, connection.CapabilityPing)
+	}
+	if tcpListener != nil {
+		features = append(features, connection.CapabilityTCPTunnels)
+	}
+	if cfg.Public.TLS {
+		features = append(features, connection.CapabilityTLSPassthrough)
+	}
+	if domainManager != nil {
+		features = append(features, connection.CapabilityCustomDomains)
+	}
+	authenticator.SetFeatures(features)
+	slog.Info("Agent protocol", "protocol_version", v1.Version, "features", features)
+
+	// Server settings gửi cho agent trong AuthResponse.Config
+	serverConfig := map[string]interface{}{
+		"heartbeat_timeout": int(cfg.Limits.HeartbeatTimeoutDuration() / time.Second),
+		"base_domain":       cfg.BaseDomain,
+	}
 
 	// Handle agent connections
-	go handleAgentConnections(ctx, agentListener, connManager, reg, authenticator)
+	go handleAgentConnections(ctx, agentListener, connManager, reg, authenticator, certVerifier, guard, serverConfig, cfg.Limits.AuthTimeoutDuration())
 
 	// Handle public HTTP requests
 	go func() {
-		if err := publicListener.StartWithContext(ctx); err != nil {
-			log.Printf("Public listener error: %v", err)
+		if err := publicListener.Start(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("Public listener error", "error", err)
 		}
 	}()
 
@@ -114,42 +415,226 @@ func main() {
 	sigCh := make(chan os.Signal, 1)
 	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
 
-	log.Println("Server started. Press Ctrl+C to stop.")
+	slog.Info("Server started. Press Ctrl+C to stop.")
 	<-sigCh
 
-	log.Println("Shutting down...")
+	drainDeadline := time.Now().Add(cfg.Shutdown.DrainTimeoutDuration())
+	slog.Info("Shutting down, draining connections", "deadline", drainDeadline.Format(time.RFC3339))
+
+	// Ngừng nhận agents mới
 	cancel()
+	agentListener.Close()
 
-	// Graceful shutdown
-	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer shutdownCancel()
+	drainCtx, drainCancel := context.WithDeadline(context.Background(), drainDeadline)
+	defer drainCancel()
 
-	// Close listeners
-	agentListener.Close()
-	publicListener.Close()
+	// Ngừng nhận public requests/TCP connections mới, requests đang chạy được hoàn tất
+	publicDone := make(chan error, 1)
+	go func() {
+		publicDone <- publicListener.Shutdown(drainCtx)
+	}()
+	if tcpListener != nil {
+		tcpListener.StopAccepting()
+	}
+
+	// Báo agents server sắp đóng, chờ streams kết thúc rồi đóng tất cả connections
+	report := connManager.Drain(drainCtx, func(c *connection.Connection) error {
+		return control.SendGoingAway(c, "server shutting down", drainDeadline)
+	})
+	if tcpListener != nil {
+		tcpListener.Close()
+	}
+	if err := <-publicDone; err != nil {
+		slog.Warn("Public listener shutdown", "error", err)
+	}
+
+	for _, f := range report.Forced {
+		slog.Warn("Forcibly closed connection with active streams", "conn_id", f.ConnID, "agent_id", f.AgentID, "streams", f.Streams)
+	}
+	slog.Info("Shutdown complete",
+		"connections", report.Connections, "forced", len(report.Forced), "forced_streams", report.ForcedStreams())
+}
+
+// fatal log lỗi ở level ERROR rồi thoát với exit code 1
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
+
+// newServerMetrics tạo metrics và đăng ký gauges đọc trạng thái hiện tại lúc scrape
+func newServerMetrics(connManager *connection.Manager, reg *registry.Registry, guard *handshake.Guard) *metrics.Metrics {
+	m := metrics.New()
+	m.Registry().NewGaugeFunc("tunnel_agent_connections", "Active agent connections.", func() float64 {
+		return float64(len(connManager.Connections()))
+	})
+	m.Registry().NewGaugeFunc("tunnel_active_streams", "Open streams across all agent connections.", func() float64 {
+		return float64(connManager.ActiveStreams())
+	})
+	m.Registry().NewGaugeFunc("tunnel_tunnels", "Registered tunnels.", func() float64 {
+		return float64(len(reg.ListTunnels()))
+	})
+	m.Registry().NewGaugeFunc("tunnel_pending_handshakes", "Agent connections accepted but not yet authenticated.", func() float64 {
+		return float64(guard.Pending())
+	})
+	return m
+}
+
+// startMetricsServer expose metrics trên port riêng
+func startMetricsServer(cfg config.MetricsConfig, m *metrics.Metrics) (*http.Server, error) {
+	mux := http.NewServeMux()
+	mux.Handle(cfg.Endpoint, m.Handler())
+	return startInternalServer("metrics", fmt.Sprintf(":%d", cfg.Port), mux)
+}
+
+// newCertManager tạo ACME certificate manager cho public listener
+// Wildcard cert của base domain cần acme.dns_command; cert on-demand chỉ cấp cho custom domains đã xác minh
+func newCertManager(cfg *config.Config, domainManager *domains.Manager) (*certs.Manager, error) {
+	certConfig := certs.Config{
+		DirectoryURL: cfg.ACME.DirectoryURL,
+		Email:        cfg.ACME.Email,
+		CacheDir:     cfg.ACME.CacheDir,
+		BaseDomain:   cfg.BaseDomain,
+		RenewBefore:  cfg.ACME.RenewBeforeDuration(),
+	}
+
+	if cfg.ACME.DNSCommand != "" {
+		certConfig.DNSSolver = certs.ExecSolver{Command: cfg.ACME.DNSCommand}
+	}
+
+	if domainManager != nil {
+		certConfig.HostPolicy = func(ctx context.Context, host string) error {
+			if _, ok := domainManager.Lookup(host); ok {
+				return nil
+			}
+			return certs.ErrHostNotAllowed
+		}
+	}
+
+	if cfg.ACME.CAFile != "" {
+		pem, err := os.ReadFile(cfg.ACME.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read ACME CA file: %w", err)
+		}
+		roots, err := x509.SystemCertPool()
+		if err != nil {
+			roots = x509.NewCertPool()
+		}
+		if !roots.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in ACME CA file %s", cfg.ACME.CAFile)
+		}
+		certConfig.HTTPClient = &http.Client{
+			Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots}},
+			Timeout:   30 * time.Second,
+		}
+	}
+
+	if cfg.Public.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.Public.CertFile, cfg.Public.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load fallback TLS certificate: %w", err)
+		}
+		certConfig.Fallback = &cert
+	}
 
-	// Close all connections
-	// TODO: Implement graceful connection close
+	return certs.NewManager(certConfig)
+}
 
-	select {
-	case <-shutdownCtx.Done():
-		log.Println("Shutdown timeout")
-	case <-time.After(1 * time.Second):
-		log.Println("Shutdown complete")
+// startAdminServer expose admin API trên listener riêng
+func startAdminServer(cfg config.AdminConfig, reg *registry.Registry, connManager *connection.Manager, limiter *quota.Limiter, tcpListener *listener.TCPListener, domainManager *domains.Manager, revocations *revocation.List) (*http.Server, error) {
+	token := cfg.Token
+	if cfg.TokenFile != "" {
+		data, err := os.ReadFile(cfg.TokenFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read admin token file: %w", err)
+		}
+		token = strings.TrimSpace(string(data))
+	}
+	if token == "" {
+		return nil, fmt.Errorf("admin token is empty")
+	}
+
+	adminServer := admin.NewServer(token, reg, connManager, limiter)
+	if tcpListener != nil {
+		adminServer.SetTCPListener(tcpListener)
+	}
+	if domainManager != nil {
+		adminServer.SetDomains(domainManager)
+	}
+	adminServer.SetRevocations(revocations)
+	return startInternalServer("admin", cfg.Addr, adminServer.Handler())
+}
+
+// startInternalServer listen và serve HTTP server nội bộ (metrics, admin) trong goroutine
+func startInternalServer(name, addr string, handler http.Handler) (*http.Server, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	server := &http.Server{
+		Handler:           handler,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	go func() {
+		if err := server.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("Internal HTTP server error", "server", name, "error", err)
+		}
+	}()
+	return server, nil
+}
+
+// newTokenValidator tạo token validator theo backend trong config
+func newTokenValidator(cfg config.AuthConfig) (handshake.TokenValidator, error) {
+	switch cfg.Backend {
+	case config.AuthBackendStatic:
+		return handshake.LoadStaticValidator(cfg.Static.TokensFile)
+
+	case config.AuthBackendJWT:
+		secret := []byte(cfg.JWT.Secret)
+		if cfg.JWT.SecretFile != "" {
+			data, err := os.ReadFile(cfg.JWT.SecretFile)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read JWT secret file: %w", err)
+			}
+			secret = bytes.TrimSpace(data)
+		}
+		return handshake.NewJWTValidator(handshake.JWTConfig{
+			Secret:        secret,
+			Issuer:        cfg.JWT.Issuer,
+			Audience:      cfg.JWT.Audience,
+			AgentIDClaim:  cfg.JWT.AgentIDClaim,
+			RequireExpiry: cfg.JWT.RequireExpiry,
+			Leeway:        cfg.JWT.LeewayDuration(),
+		})
+
+	case config.AuthBackendWebhook:
+		return handshake.NewWebhookValidator(handshake.WebhookConfig{
+			URL:     cfg.Webhook.URL,
+			Secret:  cfg.Webhook.Secret,
+			Timeout: cfg.Webhook.TimeoutDuration(),
+		})
+
+	case config.AuthBackendInsecure:
+		return handshake.InsecureValidator{}, nil
+
+	default:
+		return nil, fmt.Errorf("unknown auth backend %q", cfg.Backend)
 	}
 }
 
 // startAgentListener starts TCP/TLS listener for agent connections
-func startAgentListener(addr string, useTLS bool, certFile, keyFile string) (net.Listener, error) {
+// certVerifier != nil: bật client certificates (bắt buộc nếu requireCert)
+func startAgentListener(cfg config.ListenerConfig, certVerifier *handshake.ClientCertVerifier, requireCert bool) (net.Listener, error) {
 	var listener net.Listener
 	var err error
 
-	if useTLS {
-		if certFile == "" || keyFile == "" {
+	if cfg.TLS {
+		if cfg.CertFile == "" || cfg.KeyFile == "" {
 			return nil, fmt.Errorf("TLS certificate and key files required when TLS is enabled")
 		}
 
-		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
 		if err != nil {
 			return nil, fmt.Errorf("failed to load TLS certificate: %w", err)
 		}
@@ -158,14 +643,17 @@ func startAgentListener(addr string, useTLS bool, certFile, keyFile string) (net
 			Certificates: []tls.Certificate{cert},
 			MinVersion:   tls.VersionTLS12,
 		}
+		if certVerifier != nil {
+			certVerifier.ConfigureTLS(config, requireCert)
+		}
 
-		listener, err = tls.Listen("tcp", addr, config)
+		listener, err = tls.Listen("tcp", cfg.Addr, config)
 	} else {
-		listener, err = net.Listen("tcp", addr)
+		listener, err = net.Listen("tcp", cfg.Addr)
 	}
 
 	if err != nil {
-		return nil, fmt.Errorf("failed to listen on %s: %w", addr, err)
+		return nil, fmt.Errorf("failed to listen on %s: %w", cfg.Addr, err)
 	}
 
 	return listener, nil
@@ -178,6 +666,10 @@ func handleAgentConnections(
 	connManager *connection.Manager,
 	reg *registry.Registry,
 	authenticator *handshake.Authenticator,
+	certVerifier *handshake.ClientCertVerifier,
+	guard *handshake.Guard,
+	serverConfig map[string]interface{},
+	authTimeout time.Duration,
 ) {
 	for {
 		select {
@@ -191,13 +683,21 @@ func handleAgentConnections(
 				case <-ctx.Done():
 					return
 				default:
-					log.Printf("Failed to accept connection: %v", err)
+					slog.Warn("Failed to accept connection", "error", err)
 					continue
 				}
 			}
 
+			// Từ chối trước khi tốn goroutine/TLS handshake: quá nhiều handshake đang chờ, IP vượt rate hoặc bị khóa
+			release, err := guard.Admit(conn.RemoteAddr().String())
+			if err != nil {
+				slog.Debug("Agent connection rejected", "remote_addr", conn.RemoteAddr().String(), "error", err)
+				conn.Close()
+				continue
+			}
+
 			// Handle connection in goroutine
-			go handleAgentConnection(ctx, conn, connManager, reg, authenticator)
+			go handleAgentConnection(ctx, conn, connManager, reg, authenticator, certVerifier, guard, release, serverConfig, authTimeout)
 		}
 	}
 }
@@ -209,48 +709,94 @@ func handleAgentConnection(
 	connManager *connection.Manager,
 	reg *registry.Registry,
 	authenticator *handshake.Authenticator,
+	certVerifier *handshake.ClientCertVerifier,
+	guard *handshake.Guard,
+	release func(),
+	serverConfig map[string]interface{},
+	authTimeout time.Duration,
 ) {
 	defer rawConn.Close()
+	// Slot handshake đang chờ được trả ngay sau khi xác thực xong (release idempotent)
+	defer release()
 
 	remoteAddr := rawConn.RemoteAddr().String()
-	log.Printf("New agent connection from %s", remoteAddr)
+	slog.Debug("New agent connection", "remote_addr", remoteAddr)
 
 	// Wrap connection
 	conn := &netConnWrapper{Conn: rawConn}
 
-	// Set read deadline for auth (10 seconds default)
-	conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+	// Toàn bộ handshake (TLS, đọc auth frame, ghi response) phải xong trong authTimeout
+	conn.SetDeadline(time.Now().Add(authTimeout))
+
+	// mTLS: handshake trước để lấy client certificate (cert không hợp lệ/bị thu hồi → handshake lỗi)
+	var clientCert *handshake.ClientCert
+	if tlsConn, ok := rawConn.(*tls.Conn); ok && certVerifier != nil {
+		if err := tlsConn.Handshake(); err != nil {
+			slog.Warn("Agent TLS handshake failed", "remote_addr", remoteAddr, "error", err)
+			guard.Failure(remoteAddr, err)
+			return
+		}
+		clientCert = certVerifier.Identify(tlsConn.ConnectionState())
+	}
 
 	// Read and decode first frame (should be FrameAuth)
 	frame, err := v1.Decode(conn)
 	if err != nil {
-		log.Printf("Failed to decode auth frame from %s: %v", remoteAddr, err)
+		slog.Warn("Failed to decode auth frame", "remote_addr", remoteAddr, "error", err)
+		guard.Failure(remoteAddr, err)
 		return
 	}
 
 	// Handle authentication
-	agentID, metadata, err := authenticator.HandleAuth(frame)
+	agentID, metadata, err := authenticator.HandleAuth(frame, clientCert)
 	if err != nil {
-		log.Printf("Authentication failed for %s: %v", remoteAddr, err)
-		// Send error response
-		errorFrame, _ := authenticator.CreateAuthErrorResponse(err.Error())
+		if handshake.IsAuthError(err) {
+			slog.Warn("Authentication failed", "remote_addr", remoteAddr, "error", err)
+		} else if handshake.IsNegotiationError(err) {
+			slog.Warn("Incompatible agent rejected", "remote_addr", remoteAddr, "error", err)
+		} else {
+			// Backend lỗi (webhook down, ...): agent nên thử lại, không phải đổi token
+			slog.Error("Token validation error", "remote_addr", remoteAddr, "error", err)
+		}
+		// Send error response (không lộ chi tiết backend cho agent chưa xác thực)
+		errorFrame, _ := authenticator.CreateAuthErrorResponse(handshake.AgentErrorMessage(err))
 		_ = v1.Encode(conn, errorFrame)
+		guard.Failure(remoteAddr, err)
 		return
 	}
 
-	// Send success response
-	successFrame, err := authenticator.CreateAuthSuccessResponse(agentID, nil)
+	// Send success response: protocol version, features đã thỏa thuận, server settings và windows/ping policy
+	authConfig := handshake.NegotiatedConfig(metadata)
+	for k, v := range serverConfig {
+		authConfig[k] = v
+	}
+	if fc, ok := connManager.FlowControlFor(metadata); ok {
+		authConfig["flow_control"] = fc
+	}
+	if p, ok := connManager.PingPolicyFor(metadata); ok {
+		authConfig["ping"] = map[string]int{
+			"interval":   int(p.Interval / time.Second),
+			"max_missed": p.MaxMissed,
+		}
+	}
+	successFrame, err := authenticator.CreateAuthSuccessResponse(agentID, authConfig)
 	if err != nil {
-		log.Printf("Failed to create auth response: %v", err)
+		slog.Error("Failed to create auth response", "remote_addr", remoteAddr, "error", err)
 		return
 	}
 
 	if err := v1.Encode(conn, successFrame); err != nil {
-		log.Printf("Failed to send auth response: %v", err)
+		slog.Warn("Failed to send auth response", "remote_addr", remoteAddr, "error", err)
 		return
 	}
 
-	log.Printf("Agent authenticated: %s from %s", agentID, remoteAddr)
+	// Handshake xong: bỏ deadline (connection manager tự quản lý read/write deadlines)
+	guard.Success(remoteAddr)
+	release()
+	conn.SetDeadline(time.Time{})
+
+	slog.Info("Agent authenticated", "agent_id", agentID, "remote_addr", remoteAddr, "cert_fingerprint", metadata["cert_fingerprint"],
+		"protocol_version", metadata[handshake.MetadataProtocolVersion], "features", metadata[connection.MetadataFeatures])
 
 	// Generate connection ID
 	connID := fmt.Sprintf("%s-%d", agentID, time.Now().UnixNano())
@@ -258,15 +804,15 @@ func handleAgentConnection(
 	// Register connection
 	registeredConn, err := connManager.RegisterConnection(connID, agentID, conn, metadata)
 	if err != nil {
-		log.Printf("Failed to register connection: %v", err)
+		slog.Warn("Failed to register connection", "agent_id", agentID, "error", err)
 		return
 	}
 
-	log.Printf("Connection registered: %s (agent: %s)", connID, agentID)
+	slog.Info("Connection registered", "conn_id", connID, "agent_id", agentID)
 
 	// Wait for connection to close
 	<-registeredConn.Context().Done()
-	log.Printf("Connection closed: %s", connID)
+	slog.Debug("Agent connection handler exiting", "conn_id", connID)
 }
 
 // netConnWrapper wraps net.Conn to implement connection.Conn interface
@@ -3,3 +3,11 @@ module github.com/hydragon2m/tunnel-core
 go 1.22
 
 require github.com/hydragon2m/tunnel-protocol v0.1.1
+
+require gopkg.in/yaml.v3 v3.0.1
+
+require (
+	golang.org/x/crypto v0.33.0
+	golang.org/x/net v0.21.0
+	golang.org/x/text v0.22.0 // indirect
+)
@@ -0,0 +1,132 @@
+package admin
+
+import (
+	"log/slog"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/hydragon2m/tunnel-core/internal/connection"
+)
+
+// ConnectionInfo là thông tin agent connection trả về qua admin API
+type ConnectionInfo struct {
+	ID            string            `json:"id"`
+	AgentID       string            `json:"agent_id"`
+	RemoteAddr    string            `json:"remote_addr"`
+	Metadata      map[string]string `json:"metadata,omitempty"`
+	CreatedAt     time.Time         `json:"created_at"`
+	LastHeartbeat time.Time         `json:"last_heartbeat"`
+	RTTMs         float64           `json:"rtt_ms"`    // 0 = chưa đo được (agent không hỗ trợ ping)
+	JitterMs      float64           `json:"jitter_ms"` // 0 = chưa đo được
+	MissedPings   int               `json:"missed_pings"`
+	ActiveStreams int               `json:"active_streams"`
+	Tunnels       []string          `json:"tunnels"`
+	Streams       []StreamInfo      `json:"streams,omitempty"` // chỉ có khi xem chi tiết 1 connection
+}
+
+// StreamInfo là thông tin 1 stream đang mở
+type StreamInfo struct {
+	ID        uint32    `json:"id"`
+	State     string    `json:"state"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func (s *Server) newConnectionInfo(c *connection.Connection) ConnectionInfo {
+	tunnels := make([]string, 0)
+	for _, t := range s.registry.GetConnectionTunnels(c.ID) {
+		tunnels = append(tunnels, t.Key())
+	}
+	sort.Strings(tunnels)
+
+	return ConnectionInfo{
+		ID:            c.ID,
+		AgentID:       c.AgentID,
+		RemoteAddr:    c.Conn.RemoteAddr(),
+		Metadata:      c.Metadata,
+		CreatedAt:     c.CreatedAt,
+		LastHeartbeat: c.LastHeartbeatTime(),
+		RTTMs:         durationMs(c.RTT()),
+		JitterMs:      durationMs(c.Jitter()),
+		MissedPings:   c.MissedPings(),
+		ActiveStreams: c.StreamCount(),
+		Tunnels:       tunnels,
+	}
+}
+
+// durationMs đổi duration sang milliseconds
+func durationMs(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// listConnections: GET /api/connections
+func (s *Server) listConnections(w http.ResponseWriter, r *http.Request) {
+	conns := s.connManager.Connections()
+	sort.Slice(conns, func(i, j int) bool { return conns[i].ID < conns[j].ID })
+
+	infos := make([]ConnectionInfo, 0, len(conns))
+	for _, c := range conns {
+		infos = append(infos, s.newConnectionInfo(c))
+	}
+	writeJSON(w, http.StatusOK, infos)
+}
+
+// getConnection: GET /api/connections/{id}, kèm danh sách streams đang mở
+func (s *Server) getConnection(w http.ResponseWriter, r *http.Request) {
+	c, ok := s.connManager.GetConnection(r.PathValue("id"))
+	if !ok {
+		writeError(w, http.StatusNotFound, ErrConnectionNotFound)
+		return
+	}
+
+	info := s.newConnectionInfo(c)
+	for _, stream := range c.Streams() {
+		info.Streams = append(info.Streams, StreamInfo{
+			ID:        stream.ID,
+			State:     stream.GetState().String(),
+			CreatedAt: stream.CreatedAt,
+		})
+	}
+	writeJSON(w, http.StatusOK, info)
+}
+
+// deleteConnection: DELETE /api/connections/{id}
+// Tunnels/ports của connection được dọn qua onConnectionClosed
+func (s *Server) deleteConnection(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	c, ok := s.connManager.GetConnection(id)
+	if !ok {
+		writeError(w, http.StatusNotFound, ErrConnectionNotFound)
+		return
+	}
+
+	if err := s.connManager.CloseConnection(id); err != nil {
+		writeError(w, http.StatusNotFound, ErrConnectionNotFound)
+		return
+	}
+
+	slog.Info("Admin: connection closed", "conn_id", id, "agent_id", c.AgentID)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// disconnectAgent: DELETE /api/agents/{agent_id}, đóng mọi connection của agent
+func (s *Server) disconnectAgent(w http.ResponseWriter, r *http.Request) {
+	agentID := r.PathValue("agent_id")
+
+	closed := 0
+	for _, c := range s.connManager.Connections() {
+		if c.AgentID != agentID {
+			continue
+		}
+		if err := s.connManager.CloseConnection(c.ID); err == nil {
+			closed++
+		}
+	}
+	if closed == 0 {
+		writeError(w, http.StatusNotFound, ErrAgentNotFound)
+		return
+	}
+
+	slog.Info("Admin: agent disconnected", "agent_id", agentID, "connections", closed)
+	w.WriteHeader(http.StatusNoContent)
+}
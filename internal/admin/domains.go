@@ -0,0 +1,74 @@
+package admin
+
+import (
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/hydragon2m/tunnel-core/internal/domains"
+)
+
+// DomainInfo là custom domain claim trả về qua admin API
+type DomainInfo struct {
+	Domain     string     `json:"domain"`
+	AgentID    string     `json:"agent_id"`
+	Method     string     `json:"method"`
+	Status     string     `json:"status"`
+	CreatedAt  time.Time  `json:"created_at"`
+	VerifiedAt *time.Time `json:"verified_at,omitempty"`
+}
+
+func newDomainInfo(c domains.Claim) DomainInfo {
+	info := DomainInfo{
+		Domain:    c.Domain,
+		AgentID:   c.AgentID,
+		Method:    c.Method,
+		Status:    c.Status,
+		CreatedAt: c.CreatedAt,
+	}
+	if !c.VerifiedAt.IsZero() {
+		info.VerifiedAt = &c.VerifiedAt
+	}
+	return info
+}
+
+// listDomains: GET /api/domains
+func (s *Server) listDomains(w http.ResponseWriter, r *http.Request) {
+	infos := []DomainInfo{}
+	if s.domains != nil {
+		for _, c := range s.domains.Claims() {
+			infos = append(infos, newDomainInfo(c))
+		}
+	}
+	writeJSON(w, http.StatusOK, infos)
+}
+
+// revokeDomain: DELETE /api/domains/{domain}
+// Xóa claim (đã xác minh hoặc đang chờ) và đóng tunnel đang dùng domain, agent được báo qua MsgTunnelClosed
+func (s *Server) revokeDomain(w http.ResponseWriter, r *http.Request) {
+	if s.domains == nil {
+		writeError(w, http.StatusNotFound, ErrDomainNotFound)
+		return
+	}
+
+	domain := r.PathValue("domain")
+	claim, verified := s.domains.Lookup(domain)
+	if err := s.domains.Revoke(domain); err != nil {
+		writeError(w, http.StatusNotFound, ErrDomainNotFound)
+		return
+	}
+
+	// Chỉ domain đã xác minh mới có tunnels: mọi path prefix (hoặc passthrough) của domain và *.domain
+	if verified {
+		for _, host := range []string{claim.Domain, "*." + claim.Domain} {
+			for _, tunnel := range s.registry.HostTunnels(host) {
+				if s.registry.UnregisterTunnel(tunnel.Key()) == nil {
+					s.notifyTunnelClosed(tunnel, "domain revoked by admin")
+				}
+			}
+		}
+	}
+
+	slog.Info("Admin: domain revoked", "domain", domain, "agent_id", claim.AgentID)
+	w.WriteHeader(http.StatusNoContent)
+}
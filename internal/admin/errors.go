@@ -0,0 +1,16 @@
+package admin
+
+import "errors"
+
+var (
+	ErrUnauthorized        = errors.New("unauthorized")
+	ErrTunnelNotFound      = errors.New("tunnel not found")
+	ErrConnectionNotFound  = errors.New("connection not found")
+	ErrAgentNotFound       = errors.New("agent has no active connections")
+	ErrLimitNotFound       = errors.New("limit not found")
+	ErrDomainNotFound      = errors.New("domain not found")
+	ErrInvalidLimit        = errors.New("invalid limit: max_streams and rate_limit must be > 0, max_bandwidth >= 0")
+	ErrInvalidBody         = errors.New("invalid request body")
+	ErrInvalidRevocation   = errors.New("set exactly one of token, token_sha256, agent_id, cert_fingerprint")
+	ErrRevocationsDisabled = errors.New("revocation list not configured")
+)
@@ -0,0 +1,194 @@
+package admin
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/hydragon2m/tunnel-core/internal/quota"
+)
+
+// maxBodySize giới hạn body của PUT limit
+const maxBodySize = 1 << 16
+
+// AgentLimitInfo là limit của agent trả về qua admin API
+type AgentLimitInfo struct {
+	AgentID         string    `json:"agent_id"`
+	MaxStreams      int       `json:"max_streams"`
+	MaxBandwidth    int64     `json:"max_bandwidth"`
+	RateLimit       int       `json:"rate_limit"`
+	CurrentStreams  int       `json:"current_streams"`
+	AvailableTokens float64   `json:"available_tokens"`
+	ThroughputIn    float64   `json:"throughput_in"`
+	ThroughputOut   float64   `json:"throughput_out"`
+	LastReset       time.Time `json:"last_reset"`
+}
+
+// DomainLimitInfo là limit của domain trả về qua admin API
+type DomainLimitInfo struct {
+	Domain          string    `json:"domain"`
+	MaxStreams      int       `json:"max_streams"`
+	MaxBandwidth    int64     `json:"max_bandwidth"`
+	RateLimit       int       `json:"rate_limit"`
+	CurrentStreams  int       `json:"current_streams"`
+	AvailableTokens float64   `json:"available_tokens"`
+	ThroughputIn    float64   `json:"throughput_in"`
+	ThroughputOut   float64   `json:"throughput_out"`
+	LastReset       time.Time `json:"last_reset"`
+}
+
+// SetLimitRequest là body của PUT /api/limits/{agents,domains}/...
+// MaxBandwidth là bytes/second mỗi chiều, 0 = unlimited
+type SetLimitRequest struct {
+	MaxStreams   int   `json:"max_streams"`
+	MaxBandwidth int64 `json:"max_bandwidth"`
+	RateLimit    int   `json:"rate_limit"`
+}
+
+func newAgentLimitInfo(st quota.AgentLimitStatus) AgentLimitInfo {
+	return AgentLimitInfo{
+		AgentID:         st.AgentID,
+		MaxStreams:      st.MaxStreams,
+		MaxBandwidth:    st.MaxBandwidth,
+		RateLimit:       st.RateLimit,
+		CurrentStreams:  st.CurrentStreams,
+		AvailableTokens: st.AvailableTokens,
+		ThroughputIn:    st.ThroughputIn,
+		ThroughputOut:   st.ThroughputOut,
+		LastReset:       st.LastReset,
+	}
+}
+
+func newDomainLimitInfo(st quota.DomainLimitStatus) DomainLimitInfo {
+	return DomainLimitInfo{
+		Domain:          st.Domain,
+		MaxStreams:      st.MaxStreams,
+		MaxBandwidth:    st.MaxBandwidth,
+		RateLimit:       st.RateLimit,
+		CurrentStreams:  st.CurrentStreams,
+		AvailableTokens: st.AvailableTokens,
+		ThroughputIn:    st.ThroughputIn,
+		ThroughputOut:   st.ThroughputOut,
+		LastReset:       st.LastReset,
+	}
+}
+
+// decodeLimit đọc và validate SetLimitRequest
+func decodeLimit(r *http.Request) (SetLimitRequest, error) {
+	var req SetLimitRequest
+	dec := json.NewDecoder(http.MaxBytesReader(nil, r.Body, maxBodySize))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		return req, ErrInvalidBody
+	}
+	// Limiter coi 0 là chặn hết (không phải unlimited) nên bắt buộc > 0
+	if req.MaxStreams <= 0 || req.RateLimit <= 0 || req.MaxBandwidth < 0 {
+		return req, ErrInvalidLimit
+	}
+	return req, nil
+}
+
+// listAgentLimits: GET /api/limits/agents
+func (s *Server) listAgentLimits(w http.ResponseWriter, r *http.Request) {
+	statuses := s.limiter.AgentLimitStatuses()
+	infos := make([]AgentLimitInfo, 0, len(statuses))
+	for _, st := range statuses {
+		infos = append(infos, newAgentLimitInfo(st))
+	}
+	writeJSON(w, http.StatusOK, infos)
+}
+
+// getAgentLimit: GET /api/limits/agents/{agent_id}
+func (s *Server) getAgentLimit(w http.ResponseWriter, r *http.Request) {
+	st, ok := s.limiter.AgentLimitStatus(r.PathValue("agent_id"))
+	if !ok {
+		writeError(w, http.StatusNotFound, ErrLimitNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, newAgentLimitInfo(st))
+}
+
+// setAgentLimit: PUT /api/limits/agents/{agent_id}
+func (s *Server) setAgentLimit(w http.ResponseWriter, r *http.Request) {
+	agentID := r.PathValue("agent_id")
+	req, err := decodeLimit(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	s.limiter.SetAgentLimit(agentID, req.MaxStreams, req.MaxBandwidth, req.RateLimit)
+	slog.Info("Admin: agent limit set", "agent_id", agentID,
+		"max_streams", req.MaxStreams, "max_bandwidth", req.MaxBandwidth, "rate_limit", req.RateLimit)
+
+	st, _ := s.limiter.AgentLimitStatus(agentID)
+	writeJSON(w, http.StatusOK, newAgentLimitInfo(st))
+}
+
+// resetAgentLimit: POST /api/limits/agents/{agent_id}/reset
+func (s *Server) resetAgentLimit(w http.ResponseWriter, r *http.Request) {
+	agentID := r.PathValue("agent_id")
+	if _, ok := s.limiter.GetAgentLimit(agentID); !ok {
+		writeError(w, http.StatusNotFound, ErrLimitNotFound)
+		return
+	}
+
+	s.limiter.ResetAgentLimits(agentID)
+	slog.Info("Admin: agent limit reset", "agent_id", agentID)
+
+	st, _ := s.limiter.AgentLimitStatus(agentID)
+	writeJSON(w, http.StatusOK, newAgentLimitInfo(st))
+}
+
+// listDomainLimits: GET /api/limits/domains
+func (s *Server) listDomainLimits(w http.ResponseWriter, r *http.Request) {
+	statuses := s.limiter.DomainLimitStatuses()
+	infos := make([]DomainLimitInfo, 0, len(statuses))
+	for _, st := range statuses {
+		infos = append(infos, newDomainLimitInfo(st))
+	}
+	writeJSON(w, http.StatusOK, infos)
+}
+
+// getDomainLimit: GET /api/limits/domains/{domain}
+func (s *Server) getDomainLimit(w http.ResponseWriter, r *http.Request) {
+	st, ok := s.limiter.DomainLimitStatus(r.PathValue("domain"))
+	if !ok {
+		writeError(w, http.StatusNotFound, ErrLimitNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, newDomainLimitInfo(st))
+}
+
+// setDomainLimit: PUT /api/limits/domains/{domain}
+func (s *Server) setDomainLimit(w http.ResponseWriter, r *http.Request) {
+	domain := r.PathValue("domain")
+	req, err := decodeLimit(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	s.limiter.SetDomainLimit(domain, req.MaxStreams, req.MaxBandwidth, req.RateLimit)
+	slog.Info("Admin: domain limit set", "domain", domain,
+		"max_streams", req.MaxStreams, "max_bandwidth", req.MaxBandwidth, "rate_limit", req.RateLimit)
+
+	st, _ := s.limiter.DomainLimitStatus(domain)
+	writeJSON(w, http.StatusOK, newDomainLimitInfo(st))
+}
+
+// resetDomainLimit: POST /api/limits/domains/{domain}/reset
+func (s *Server) resetDomainLimit(w http.ResponseWriter, r *http.Request) {
+	domain := r.PathValue("domain")
+	if _, ok := s.limiter.GetDomainLimit(domain); !ok {
+		writeError(w, http.StatusNotFound, ErrLimitNotFound)
+		return
+	}
+
+	s.limiter.ResetDomainLimits(domain)
+	slog.Info("Admin: domain limit reset", "domain", domain)
+
+	st, _ := s.limiter.DomainLimitStatus(domain)
+	writeJSON(w, http.StatusOK, newDomainLimitInfo(st))
+}
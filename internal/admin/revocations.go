@@ -0,0 +1,133 @@
+package admin
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/hydragon2m/tunnel-core/internal/handshake"
+	"github.com/hydragon2m/tunnel-core/internal/revocation"
+)
+
+// RevokeRequest là body của POST /api/revocations, chỉ 1 field được set
+// Token gửi dạng raw được hash ngay, chỉ SHA-256 được lưu
+type RevokeRequest struct {
+	Token           string `json:"token,omitempty"`
+	TokenSHA256     string `json:"token_sha256,omitempty"`
+	AgentID         string `json:"agent_id,omitempty"`
+	CertFingerprint string `json:"cert_fingerprint,omitempty"`
+}
+
+// entry trả về kind và value của request
+func (req RevokeRequest) entry() (revocation.Kind, string, error) {
+	var kind revocation.Kind
+	var value string
+	set := 0
+	for _, f := range []struct {
+		kind  revocation.Kind
+		value string
+	}{
+		{revocation.KindToken, req.TokenSHA256},
+		{revocation.KindAgent, req.AgentID},
+		{revocation.KindCert, req.CertFingerprint},
+	} {
+		if f.value != "" {
+			kind, value = f.kind, f.value
+			set++
+		}
+	}
+	if req.Token != "" {
+		kind, value = revocation.KindToken, handshake.TokenHash(req.Token)
+		set++
+	}
+	if set != 1 {
+		return "", "", ErrInvalidRevocation
+	}
+	return kind, value, nil
+}
+
+// revocationStatus map lỗi của revocation list → HTTP status
+func revocationStatus(err error) int {
+	switch {
+	case errors.Is(err, revocation.ErrNotRevoked):
+		return http.StatusNotFound
+	case errors.Is(err, revocation.ErrInvalidKind), errors.Is(err, revocation.ErrEmptyValue),
+		errors.Is(err, revocation.ErrInvalidEntry), errors.Is(err, revocation.ErrNoListFile):
+		return http.StatusBadRequest
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// listRevocations: GET /api/revocations
+func (s *Server) listRevocations(w http.ResponseWriter, r *http.Request) {
+	if s.revocations == nil {
+		writeError(w, http.StatusNotFound, ErrRevocationsDisabled)
+		return
+	}
+	writeJSON(w, http.StatusOK, s.revocations.Entries())
+}
+
+// revoke: POST /api/revocations
+// Connections đang dùng credentials bị ngắt ngay bởi revocation.Enforcer
+func (s *Server) revoke(w http.ResponseWriter, r *http.Request) {
+	if s.revocations == nil {
+		writeError(w, http.StatusNotFound, ErrRevocationsDisabled)
+		return
+	}
+
+	var req RevokeRequest
+	dec := json.NewDecoder(http.MaxBytesReader(nil, r.Body, maxBodySize))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, ErrInvalidBody)
+		return
+	}
+	kind, value, err := req.entry()
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := s.revocations.Revoke(kind, value); err != nil {
+		writeError(w, revocationStatus(err), err)
+		return
+	}
+
+	slog.Info("Admin: credentials revoked", "kind", kind, "value", value)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// unrevoke: DELETE /api/revocations/{kind}/{value}, kind = tokens (SHA-256) | agents | cert_fingerprints
+func (s *Server) unrevoke(w http.ResponseWriter, r *http.Request) {
+	if s.revocations == nil {
+		writeError(w, http.StatusNotFound, ErrRevocationsDisabled)
+		return
+	}
+
+	kind, value := revocation.Kind(r.PathValue("kind")), r.PathValue("value")
+	if err := s.revocations.Unrevoke(kind, value); err != nil {
+		writeError(w, revocationStatus(err), err)
+		return
+	}
+
+	slog.Info("Admin: revocation removed", "kind", kind, "value", value)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// reloadRevocations: POST /api/revocations/reload, đọc lại file ngay (không chờ check interval)
+func (s *Server) reloadRevocations(w http.ResponseWriter, r *http.Request) {
+	if s.revocations == nil {
+		writeError(w, http.StatusNotFound, ErrRevocationsDisabled)
+		return
+	}
+
+	if err := s.revocations.Reload(); err != nil {
+		writeError(w, revocationStatus(err), err)
+		return
+	}
+
+	slog.Info("Admin: revocation list reloaded", "file", s.revocations.Path())
+	writeJSON(w, http.StatusOK, s.revocations.Entries())
+}
@@ -0,0 +1,117 @@
+// Package admin cung cấp admin REST API (JSON) để xem và quản lý tunnels, custom domains, connections, limits và revocations lúc runtime
+package admin
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/hydragon2m/tunnel-core/internal/connection"
+	"github.com/hydragon2m/tunnel-core/internal/domains"
+	"github.com/hydragon2m/tunnel-core/internal/listener"
+	"github.com/hydragon2m/tunnel-core/internal/quota"
+	"github.com/hydragon2m/tunnel-core/internal/registry"
+	"github.com/hydragon2m/tunnel-core/internal/revocation"
+)
+
+// Server là admin API, mọi request phải có header "Authorization: Bearer <token>"
+type Server struct {
+	registry    *registry.Registry
+	connManager *connection.Manager
+	limiter     *quota.Limiter
+	tcp         *listener.TCPListener // nil = TCP tunnels disabled
+	domains     *domains.Manager      // nil = custom domains disabled
+	revocations *revocation.List      // nil = revocation API disabled
+
+	tokenHash [sha256.Size]byte
+}
+
+// NewServer tạo admin Server với bearer token
+func NewServer(token string, reg *registry.Registry, connManager *connection.Manager, limiter *quota.Limiter) *Server {
+	return &Server{
+		registry:    reg,
+		connManager: connManager,
+		limiter:     limiter,
+		tokenHash:   sha256.Sum256([]byte(token)),
+	}
+}
+
+// SetTCPListener cho phép unregister TCP tunnel (đóng public port)
+func (s *Server) SetTCPListener(tcp *listener.TCPListener) {
+	s.tcp = tcp
+}
+
+// SetDomains cho phép xem và revoke custom domains
+func (s *Server) SetDomains(d *domains.Manager) {
+	s.domains = d
+}
+
+// SetRevocations cho phép xem, thêm, xóa và reload credentials bị thu hồi
+func (s *Server) SetRevocations(l *revocation.List) {
+	s.revocations = l
+}
+
+// Handler trả về http.Handler của admin API
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("GET /api/tunnels", s.listTunnels)
+	mux.HandleFunc("GET /api/tunnels/{domain...}", s.getTunnel)
+	mux.HandleFunc("DELETE /api/tunnels/{domain...}", s.deleteTunnel)
+
+	mux.HandleFunc("GET /api/domains", s.listDomains)
+	mux.HandleFunc("DELETE /api/domains/{domain}", s.revokeDomain)
+
+	mux.HandleFunc("GET /api/connections", s.listConnections)
+	mux.HandleFunc("GET /api/connections/{id}", s.getConnection)
+	mux.HandleFunc("DELETE /api/connections/{id}", s.deleteConnection)
+	mux.HandleFunc("DELETE /api/agents/{agent_id}", s.disconnectAgent)
+
+	mux.HandleFunc("GET /api/revocations", s.listRevocations)
+	mux.HandleFunc("POST /api/revocations", s.revoke)
+	mux.HandleFunc("POST /api/revocations/reload", s.reloadRevocations)
+	mux.HandleFunc("DELETE /api/revocations/{kind}/{value}", s.unrevoke)
+
+	mux.HandleFunc("GET /api/limits/agents", s.listAgentLimits)
+	mux.HandleFunc("GET /api/limits/agents/{agent_id}", s.getAgentLimit)
+	mux.HandleFunc("PUT /api/limits/agents/{agent_id}", s.setAgentLimit)
+	mux.HandleFunc("POST /api/limits/agents/{agent_id}/reset", s.resetAgentLimit)
+
+	mux.HandleFunc("GET /api/limits/domains", s.listDomainLimits)
+	mux.HandleFunc("GET /api/limits/domains/{domain}", s.getDomainLimit)
+	mux.HandleFunc("PUT /api/limits/domains/{domain}", s.setDomainLimit)
+	mux.HandleFunc("POST /api/limits/domains/{domain}/reset", s.resetDomainLimit)
+
+	return s.authenticate(mux)
+}
+
+// authenticate kiểm tra bearer token (so sánh hash constant-time)
+func (s *Server) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		hash := sha256.Sum256([]byte(token))
+		if !ok || subtle.ConstantTimeCompare(hash[:], s.tokenHash[:]) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="tunnel-admin"`)
+			writeError(w, http.StatusUnauthorized, ErrUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// errorResponse là body của response lỗi
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, errorResponse{Error: err.Error()})
+}
@@ -0,0 +1,434 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hydragon2m/tunnel-core/internal/connection"
+	"github.com/hydragon2m/tunnel-core/internal/connection/conntest"
+	"github.com/hydragon2m/tunnel-core/internal/control"
+	"github.com/hydragon2m/tunnel-core/internal/domains"
+	"github.com/hydragon2m/tunnel-core/internal/handshake"
+	"github.com/hydragon2m/tunnel-core/internal/quota"
+	"github.com/hydragon2m/tunnel-core/internal/registry"
+	"github.com/hydragon2m/tunnel-core/internal/revocation"
+	v1 "github.com/hydragon2m/tunnel-protocol/go/v1"
+)
+
+const testToken = "admin-secret"
+
+type testEnv struct {
+	server  *httptest.Server
+	reg     *registry.Registry
+	cm      *connection.Manager
+	limiter *quota.Limiter
+	frames  chan *v1.Frame // frames agent nhận được
+}
+
+// newTestEnv tạo admin API với 1 agent connection "conn-1" và tunnel "app.localhost"
+func newTestEnv(t *testing.T) *testEnv {
+	t.Helper()
+
+	env := &testEnv{
+		reg:     registry.NewRegistry("localhost"),
+		cm:      connection.NewManager(10, 30*time.Second),
+		limiter: quota.NewLimiter(10, 100),
+		frames:  make(chan *v1.Frame, 10),
+	}
+	env.cm.SetOnConnectionClosed(env.reg.UnregisterConnectionTunnels)
+
+	_, agent := conntest.Register(t, env.cm, "conn-1", "agent-1")
+	go readFrames(agent, env.frames)
+	if _, err := env.reg.RegisterTunnel("", "app", "conn-1", "agent-1", nil); err != nil {
+		t.Fatalf("RegisterTunnel failed: %v", err)
+	}
+
+	env.server = httptest.NewServer(NewServer(testToken, env.reg, env.cm, env.limiter).Handler())
+	t.Cleanup(env.server.Close)
+	return env
+}
+
+func readFrames(agent net.Conn, frames chan<- *v1.Frame) {
+	for {
+		frame, err := v1.Decode(agent)
+		if err != nil {
+			return
+		}
+		frames <- frame
+	}
+}
+
+// do gửi request với admin token, decode JSON response vào out (nếu != nil)
+func (env *testEnv) do(t *testing.T, method, path, body string, out interface{}) int {
+	t.Helper()
+
+	req, _ := http.NewRequest(method, env.server.URL+path, strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+testToken)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("%s %s failed: %v", method, path, err)
+	}
+	defer resp.Body.Close()
+
+	if out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			t.Fatalf("%s %s: decode response: %v", method, path, err)
+		}
+	}
+	return resp.StatusCode
+}
+
+func TestServer_RequiresToken(t *testing.T) {
+	env := newTestEnv(t)
+
+	for _, header := range []string{"", "Bearer wrong", "Basic " + testToken, testToken} {
+		req, _ := http.NewRequest(http.MethodGet, env.server.URL+"/api/tunnels", nil)
+		if header != "" {
+			req.Header.Set("Authorization", header)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusUnauthorized {
+			t.Errorf("Authorization %q: expected 401, got %d", header, resp.StatusCode)
+		}
+	}
+}
+
+func TestServer_Tunnels(t *testing.T) {
+	env := newTestEnv(t)
+
+	var tunnels []TunnelInfo
+	if code := env.do(t, http.MethodGet, "/api/tunnels", "", &tunnels); code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", code)
+	}
+	if len(tunnels) != 1 || tunnels[0].FullDomain != "app.localhost" || tunnels[0].AgentID != "agent-1" {
+		t.Fatalf("Unexpected tunnels: %+v", tunnels)
+	}
+
+	var tunnel TunnelInfo
+	if code := env.do(t, http.MethodGet, "/api/tunnels/app.localhost", "", &tunnel); code != http.StatusOK || tunnel.ConnectionID != "conn-1" {
+		t.Errorf("Unexpected tunnel: %d %+v", code, tunnel)
+	}
+
+	if code := env.do(t, http.MethodDelete, "/api/tunnels/app.localhost", "", nil); code != http.StatusNoContent {
+		t.Fatalf("Expected 204, got %d", code)
+	}
+	if _, ok := env.reg.GetTunnel("app.localhost"); ok {
+		t.Error("Expected tunnel to be unregistered")
+	}
+
+	// Agent được báo tunnel đã đóng
+	select {
+	case frame := <-env.frames:
+		var req control.Request
+		var msg control.TunnelClosedMessage
+		json.Unmarshal(frame.Payload, &req)
+		json.Unmarshal(req.Payload, &msg)
+		if req.Type != control.MsgTunnelClosed || msg.FullDomain != "app.localhost" {
+			t.Errorf("Unexpected notification: %s", frame.Payload)
+		}
+	case <-time.After(time.Second):
+		t.Error("Expected tunnel_closed notification")
+	}
+
+	if code := env.do(t, http.MethodDelete, "/api/tunnels/app.localhost", "", nil); code != http.StatusNotFound {
+		t.Errorf("Expected 404 for unknown tunnel, got %d", code)
+	}
+}
+
+func TestServer_Connections(t *testing.T) {
+	env := newTestEnv(t)
+
+	conn, _ := env.cm.GetConnection("conn-1")
+	if _, err := conn.OpenStream([]byte("GET / HTTP/1.1\r\n\r\n")); err != nil {
+		t.Fatalf("OpenStream failed: %v", err)
+	}
+
+	var conns []ConnectionInfo
+	env.do(t, http.MethodGet, "/api/connections", "", &conns)
+	if len(conns) != 1 || conns[0].ActiveStreams != 1 || len(conns[0].Tunnels) != 1 || conns[0].Streams != nil {
+		t.Fatalf("Unexpected connections: %+v", conns)
+	}
+
+	var info ConnectionInfo
+	env.do(t, http.MethodGet, "/api/connections/conn-1", "", &info)
+	if len(info.Streams) != 1 || info.Streams[0].ID != 1 || info.Streams[0].State != "open" {
+		t.Errorf("Unexpected streams: %+v", info.Streams)
+	}
+
+	if code := env.do(t, http.MethodDelete, "/api/connections/conn-1", "", nil); code != http.StatusNoContent {
+		t.Fatalf("Expected 204, got %d", code)
+	}
+	if _, ok := env.cm.GetConnection("conn-1"); ok {
+		t.Error("Expected connection to be closed")
+	}
+	if _, ok := env.reg.GetTunnel("app.localhost"); ok {
+		t.Error("Expected tunnels of closed connection to be unregistered")
+	}
+	if code := env.do(t, http.MethodGet, "/api/connections/conn-1", "", nil); code != http.StatusNotFound {
+		t.Errorf("Expected 404, got %d", code)
+	}
+}
+
+func TestServer_DisconnectAgent(t *testing.T) {
+	env := newTestEnv(t)
+	conntest.Register(t, env.cm, "conn-2", "agent-1")
+	conntest.Register(t, env.cm, "conn-3", "agent-2")
+
+	if code := env.do(t, http.MethodDelete, "/api/agents/agent-1", "", nil); code != http.StatusNoContent {
+		t.Fatalf("Expected 204, got %d", code)
+	}
+
+	conns := env.cm.Connections()
+	if len(conns) != 1 || conns[0].AgentID != "agent-2" {
+		t.Errorf("Expected only agent-2 connection left, got %d connections", len(conns))
+	}
+	if code := env.do(t, http.MethodDelete, "/api/agents/agent-1", "", nil); code != http.StatusNotFound {
+		t.Errorf("Expected 404, got %d", code)
+	}
+}
+
+func TestServer_AgentLimits(t *testing.T) {
+	env := newTestEnv(t)
+
+	if code := env.do(t, http.MethodGet, "/api/limits/agents/agent-1", "", nil); code != http.StatusNotFound {
+		t.Errorf("Expected 404 before limit is set, got %d", code)
+	}
+
+	var limit AgentLimitInfo
+	code := env.do(t, http.MethodPut, "/api/limits/agents/agent-1", `{"max_streams": 2, "max_bandwidth": 1024, "rate_limit": 1}`, &limit)
+	if code != http.StatusOK || limit.MaxStreams != 2 || limit.MaxBandwidth != 1024 || limit.RateLimit != 1 {
+		t.Fatalf("Unexpected limit: %d %+v", code, limit)
+	}
+
+	// Limit có hiệu lực ngay
+	if err := env.limiter.CheckAgentRateLimit("agent-1"); err != nil {
+		t.Fatalf("First request should pass: %v", err)
+	}
+	if err := env.limiter.CheckAgentRateLimit("agent-1"); err != quota.ErrAgentRateLimitExceeded {
+		t.Fatalf("Expected rate limit, got %v", err)
+	}
+
+	// Reset nạp lại rate limit, giữ streams đang active
+	env.limiter.AcquireStream("agent-1", "app.localhost")
+	if code := env.do(t, http.MethodPost, "/api/limits/agents/agent-1/reset", "", &limit); code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", code)
+	}
+	if limit.CurrentStreams != 1 {
+		t.Errorf("Expected active stream to be kept after reset, got %d", limit.CurrentStreams)
+	}
+	if err := env.limiter.CheckAgentRateLimit("agent-1"); err != nil {
+		t.Errorf("Expected rate limit to be refilled after reset: %v", err)
+	}
+
+	var limits []AgentLimitInfo
+	env.do(t, http.MethodGet, "/api/limits/agents", "", &limits)
+	if len(limits) != 1 || limits[0].AgentID != "agent-1" {
+		t.Errorf("Unexpected limits: %+v", limits)
+	}
+
+	for _, body := range []string{`{"max_streams": 0, "rate_limit": 1}`, `{"max_streams": 1}`, `{"max_streams": 1, "rate_limit": 1, "burst": 3}`, `not json`} {
+		if code := env.do(t, http.MethodPut, "/api/limits/agents/agent-1", body, nil); code != http.StatusBadRequest {
+			t.Errorf("Body %s: expected 400, got %d", body, code)
+		}
+	}
+}
+
+func TestServer_DomainLimits(t *testing.T) {
+	env := newTestEnv(t)
+
+	var limit DomainLimitInfo
+	if code := env.do(t, http.MethodPut, "/api/limits/domains/app.localhost", `{"max_streams": 5, "rate_limit": 10}`, &limit); code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", code)
+	}
+	if limit.Domain != "app.localhost" || limit.MaxStreams != 5 || limit.RateLimit != 10 {
+		t.Errorf("Unexpected limit: %+v", limit)
+	}
+
+	if code := env.do(t, http.MethodGet, "/api/limits/domains/app.localhost", "", &limit); code != http.StatusOK || limit.MaxStreams != 5 {
+		t.Errorf("Unexpected limit: %d %+v", code, limit)
+	}
+	if code := env.do(t, http.MethodPost, "/api/limits/domains/app.localhost/reset", "", nil); code != http.StatusOK {
+		t.Errorf("Expected 200, got %d", code)
+	}
+	if code := env.do(t, http.MethodPost, "/api/limits/domains/other.localhost/reset", "", nil); code != http.StatusNotFound {
+		t.Errorf("Expected 404, got %d", code)
+	}
+}
+
+// txtResolver là domains.Resolver giả với TXT records cố định
+type txtResolver map[string][]string
+
+func (r txtResolver) LookupTXT(ctx context.Context, name string) ([]string, error) {
+	return r[name], nil
+}
+
+func TestServer_RevokeDomain(t *testing.T) {
+	env := newTestEnv(t)
+
+	resolver := txtResolver{}
+	dm := domains.NewManager("localhost", domains.Config{Resolver: resolver})
+	claim, _ := dm.Claim("app.example.com", "agent-1", domains.MethodDNS)
+	resolver[claim.RecordName()] = []string{claim.Token}
+	if _, err := dm.Verify(context.Background(), "app.example.com", "agent-1"); err != nil {
+		t.Fatalf("Verify failed: %v", err)
+	}
+	if _, err := env.reg.RegisterCustomTunnel("app.example.com", registry.Route{}, "conn-1", "agent-1", nil); err != nil {
+		t.Fatalf("RegisterCustomTunnel failed: %v", err)
+	}
+
+	srv := NewServer(testToken, env.reg, env.cm, env.limiter)
+	srv.SetDomains(dm)
+	env.server = httptest.NewServer(srv.Handler())
+	t.Cleanup(env.server.Close)
+
+	var infos []DomainInfo
+	if code := env.do(t, http.MethodGet, "/api/domains", "", &infos); code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", code)
+	}
+	if len(infos) != 1 || infos[0].Status != domains.StatusVerified || infos[0].VerifiedAt == nil {
+		t.Fatalf("Unexpected domains: %+v", infos)
+	}
+
+	if code := env.do(t, http.MethodDelete, "/api/domains/app.example.com", "", nil); code != http.StatusNoContent {
+		t.Fatalf("Expected 204, got %d", code)
+	}
+	if _, ok := env.reg.GetTunnel("app.example.com"); ok {
+		t.Error("Expected custom domain tunnel to be unregistered")
+	}
+	if dm.IsVerified("app.example.com", "agent-1") {
+		t.Error("Expected domain to be revoked")
+	}
+
+	select {
+	case frame := <-env.frames:
+		var req control.Request
+		var msg control.TunnelClosedMessage
+		json.Unmarshal(frame.Payload, &req)
+		json.Unmarshal(req.Payload, &msg)
+		if req.Type != control.MsgTunnelClosed || msg.FullDomain != "app.example.com" {
+			t.Errorf("Unexpected notification: %s", frame.Payload)
+		}
+	case <-time.After(time.Second):
+		t.Error("Expected tunnel_closed notification")
+	}
+
+	if code := env.do(t, http.MethodDelete, "/api/domains/app.example.com", "", nil); code != http.StatusNotFound {
+		t.Errorf("Expected 404 for revoked domain, got %d", code)
+	}
+}
+
+func TestServer_RevokeDomainPassthrough(t *testing.T) {
+	env := newTestEnv(t)
+
+	resolver := txtResolver{}
+	dm := domains.NewManager("localhost", domains.Config{Resolver: resolver})
+	claim, _ := dm.Claim("secure.example.com", "agent-1", domains.MethodDNS)
+	resolver[claim.RecordName()] = []string{claim.Token}
+	if _, err := dm.Verify(context.Background(), "secure.example.com", "agent-1"); err != nil {
+		t.Fatalf("Verify failed: %v", err)
+	}
+
+	// TLS passthrough tunnels không nằm trong HTTP routes của host nhưng vẫn phải bị gỡ
+	for _, route := range []registry.Route{{Passthrough: true}, {Passthrough: true, Wildcard: true}} {
+		if _, err := env.reg.RegisterCustomTunnel("secure.example.com", route, "conn-1", "agent-1", nil); err != nil {
+			t.Fatalf("RegisterCustomTunnel failed: %v", err)
+		}
+	}
+	if _, ok := env.reg.ResolveSNI("api.secure.example.com"); !ok {
+		t.Fatal("Expected wildcard passthrough tunnel to resolve")
+	}
+
+	srv := NewServer(testToken, env.reg, env.cm, env.limiter)
+	srv.SetDomains(dm)
+	env.server = httptest.NewServer(srv.Handler())
+	t.Cleanup(env.server.Close)
+
+	if code := env.do(t, http.MethodDelete, "/api/domains/secure.example.com", "", nil); code != http.StatusNoContent {
+		t.Fatalf("Expected 204, got %d", code)
+	}
+	for _, name := range []string{"secure.example.com", "api.secure.example.com"} {
+		if tunnel, ok := env.reg.ResolveSNI(name); ok {
+			t.Errorf("Expected no passthrough tunnel for %s, got %s", name, tunnel.Key())
+		}
+	}
+
+	closed := map[string]bool{}
+	for i := 0; i < 2; i++ {
+		select {
+		case frame := <-env.frames:
+			var req control.Request
+			var msg control.TunnelClosedMessage
+			json.Unmarshal(frame.Payload, &req)
+			json.Unmarshal(req.Payload, &msg)
+			closed[msg.FullDomain] = req.Type == control.MsgTunnelClosed
+		case <-time.After(time.Second):
+			t.Fatalf("Expected 2 tunnel_closed notifications, got %v", closed)
+		}
+	}
+	if !closed["secure.example.com"] || !closed["*.secure.example.com"] {
+		t.Errorf("Unexpected notifications: %v", closed)
+	}
+}
+
+func TestServer_Revocations(t *testing.T) {
+	env := newTestEnv(t)
+
+	list, _ := revocation.NewList(filepath.Join(t.TempDir(), "revoked.yaml"))
+	srv := NewServer(testToken, env.reg, env.cm, env.limiter)
+	srv.SetRevocations(list)
+	env.server = httptest.NewServer(srv.Handler())
+	t.Cleanup(env.server.Close)
+
+	// Raw token được hash, chỉ SHA-256 được lưu
+	if code := env.do(t, http.MethodPost, "/api/revocations", `{"token": "tok-1"}`, nil); code != http.StatusNoContent {
+		t.Fatalf("Expected 204, got %d", code)
+	}
+	if code := env.do(t, http.MethodPost, "/api/revocations", `{"agent_id": "agent-2"}`, nil); code != http.StatusNoContent {
+		t.Fatalf("Expected 204, got %d", code)
+	}
+	if code := env.do(t, http.MethodPost, "/api/revocations", `{"agent_id": "agent-3", "token": "tok-3"}`, nil); code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for multiple fields, got %d", code)
+	}
+	if code := env.do(t, http.MethodPost, "/api/revocations", `{"cert_fingerprint": "xyz"}`, nil); code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for invalid fingerprint, got %d", code)
+	}
+
+	var entries revocation.Entries
+	if code := env.do(t, http.MethodGet, "/api/revocations", "", &entries); code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", code)
+	}
+	if len(entries.Tokens) != 1 || entries.Tokens[0] != handshake.TokenHash("tok-1") || len(entries.Agents) != 1 {
+		t.Errorf("Unexpected entries: %+v", entries)
+	}
+
+	if code := env.do(t, http.MethodDelete, "/api/revocations/agents/agent-2", "", nil); code != http.StatusNoContent {
+		t.Errorf("Expected 204, got %d", code)
+	}
+	if code := env.do(t, http.MethodDelete, "/api/revocations/agents/agent-2", "", nil); code != http.StatusNotFound {
+		t.Errorf("Expected 404 for entry not revoked, got %d", code)
+	}
+	if code := env.do(t, http.MethodDelete, "/api/revocations/users/agent-2", "", nil); code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for unknown kind, got %d", code)
+	}
+
+	if code := env.do(t, http.MethodPost, "/api/revocations/reload", "", &entries); code != http.StatusOK || len(entries.Tokens) != 1 {
+		t.Errorf("Expected reload to return persisted entries, got %d: %+v", code, entries)
+	}
+
+	// Không có revocation list
+	disabled := newTestEnv(t)
+	if code := disabled.do(t, http.MethodGet, "/api/revocations", "", nil); code != http.StatusNotFound {
+		t.Errorf("Expected 404 without revocation list, got %d", code)
+	}
+}
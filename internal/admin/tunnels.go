@@ -0,0 +1,104 @@
+package admin
+
+import (
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/hydragon2m/tunnel-core/internal/control"
+	"github.com/hydragon2m/tunnel-core/internal/registry"
+)
+
+// TunnelInfo là thông tin tunnel trả về qua admin API
+type TunnelInfo struct {
+	Key           string            `json:"key"` // full_domain + path_prefix, dùng trong /api/tunnels/{key}
+	FullDomain    string            `json:"full_domain"`
+	PathPrefix    string            `json:"path_prefix,omitempty"`
+	StripPrefix   bool              `json:"strip_prefix,omitempty"`
+	Protocol      string            `json:"protocol"`
+	Port          int               `json:"port,omitempty"`
+	ConnectionID  string            `json:"connection_id"`
+	ConnectionIDs []string          `json:"connection_ids"`
+	AgentID       string            `json:"agent_id"`
+	CreatedAt     time.Time         `json:"created_at"`
+	LastAccess    time.Time         `json:"last_access"`
+	Metadata      map[string]string `json:"metadata,omitempty"`
+}
+
+func newTunnelInfo(t registry.Tunnel) TunnelInfo {
+	return TunnelInfo{
+		Key:           t.Key(),
+		FullDomain:    t.FullDomain,
+		PathPrefix:    t.PathPrefix,
+		StripPrefix:   t.StripPrefix,
+		Protocol:      t.Protocol,
+		Port:          t.Port,
+		ConnectionID:  t.ConnectionID,
+		ConnectionIDs: t.ConnectionIDs,
+		AgentID:       t.AgentID,
+		CreatedAt:     t.CreatedAt,
+		LastAccess:    t.LastAccess,
+		Metadata:      t.Metadata,
+	}
+}
+
+// listTunnels: GET /api/tunnels
+func (s *Server) listTunnels(w http.ResponseWriter, r *http.Request) {
+	tunnels := s.registry.SnapshotTunnels()
+	infos := make([]TunnelInfo, 0, len(tunnels))
+	for _, t := range tunnels {
+		infos = append(infos, newTunnelInfo(t))
+	}
+	writeJSON(w, http.StatusOK, infos)
+}
+
+// getTunnel: GET /api/tunnels/{domain...} (domain = key, có thể kèm path prefix)
+func (s *Server) getTunnel(w http.ResponseWriter, r *http.Request) {
+	tunnel, ok := s.registry.LookupTunnel(r.PathValue("domain"))
+	if !ok {
+		writeError(w, http.StatusNotFound, ErrTunnelNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, newTunnelInfo(tunnel))
+}
+
+// deleteTunnel: DELETE /api/tunnels/{domain...}
+// TCP tunnel đóng luôn public port; agent được báo qua MsgTunnelClosed
+func (s *Server) deleteTunnel(w http.ResponseWriter, r *http.Request) {
+	tunnel, ok := s.registry.LookupTunnel(r.PathValue("domain"))
+	if !ok {
+		writeError(w, http.StatusNotFound, ErrTunnelNotFound)
+		return
+	}
+
+	var err error
+	if tunnel.Protocol == registry.ProtocolTCP && s.tcp != nil {
+		// Listener tự unregister khỏi registry
+		err = s.tcp.CloseTunnel(tunnel.Port)
+	} else {
+		err = s.registry.UnregisterTunnel(tunnel.Key())
+	}
+	if err != nil {
+		// Tunnel vừa bị xóa bởi agent/connection close
+		writeError(w, http.StatusNotFound, ErrTunnelNotFound)
+		return
+	}
+
+	s.notifyTunnelClosed(tunnel, "closed by admin")
+
+	slog.Info("Admin: tunnel unregistered", "domain", tunnel.Key(), "agent_id", tunnel.AgentID)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// notifyTunnelClosed báo mọi connection đang phục vụ tunnel qua MsgTunnelClosed
+func (s *Server) notifyTunnelClosed(tunnel registry.Tunnel, reason string) {
+	for _, connID := range tunnel.ConnectionIDs {
+		c, ok := s.connManager.GetConnection(connID)
+		if !ok {
+			continue
+		}
+		if err := control.SendTunnelClosed(c, tunnel.FullDomain, tunnel.PathPrefix, reason); err != nil {
+			slog.Warn("Failed to notify agent of closed tunnel", "domain", tunnel.Key(), "conn_id", connID, "error", err)
+		}
+	}
+}
@@ -0,0 +1,228 @@
+// Package certs cấp và gia hạn TLS certificates cho public listener qua ACME:
+// wildcard cert cho base domain (DNS-01) và cert on-demand cho custom domains đã xác minh (HTTP-01/TLS-ALPN-01)
+package certs
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+const (
+	// DefaultDirectoryURL là ACME directory của Let's Encrypt (production)
+	DefaultDirectoryURL = autocert.DefaultACMEDirectory
+	// DefaultRenewBefore là thời gian trước khi hết hạn thì gia hạn cert
+	DefaultRenewBefore = 30 * 24 * time.Hour
+
+	// accountKeyName là cache key của ACME account key (cùng tên với autocert)
+	accountKeyName = "acme_account+key"
+	// wildcardSuffix là hậu tố cache key của wildcard cert (baseDomain + wildcardSuffix)
+	wildcardSuffix = "+wildcard"
+)
+
+// HostPolicy quyết định host có được cấp cert on-demand không (nil = cho phép)
+type HostPolicy func(ctx context.Context, host string) error
+
+// Config là config cho Manager
+type Config struct {
+	DirectoryURL string           // "" = DefaultDirectoryURL
+	Email        string           // Contact của ACME account (optional)
+	CacheDir     string           // Thư mục lưu account key và certs (bắt buộc)
+	BaseDomain   string           // Wildcard cert cấp cho *.BaseDomain và BaseDomain
+	DNSSolver    DNSSolver        // nil = không cấp wildcard cert
+	HostPolicy   HostPolicy       // nil = không cấp cert on-demand
+	RenewBefore  time.Duration    // 0 = DefaultRenewBefore
+	HTTPClient   *http.Client     // Client gọi ACME server (nil = http.DefaultClient)
+	Fallback     *tls.Certificate // Cert dùng khi không có cert ACME cho server name (optional)
+}
+
+// Manager chọn certificate theo SNI: wildcard cert cho host 1 label dưới base domain,
+// autocert (on-demand) cho host được HostPolicy cho phép, còn lại Fallback
+type Manager struct {
+	baseDomain  string
+	email       string
+	cache       autocert.Cache
+	client      *acme.Client
+	autocert    *autocert.Manager
+	policy      HostPolicy
+	solver      DNSSolver
+	renewBefore time.Duration
+	fallback    *tls.Certificate
+
+	mu       sync.RWMutex
+	wildcard *tls.Certificate
+}
+
+// NewManager tạo Manager, load account key (tạo mới nếu chưa có) và wildcard cert đã cache
+func NewManager(config Config) (*Manager, error) {
+	if config.CacheDir == "" {
+		return nil, ErrNoCacheDir
+	}
+
+	directoryURL := config.DirectoryURL
+	if directoryURL == "" {
+		directoryURL = DefaultDirectoryURL
+	}
+	renewBefore := config.RenewBefore
+	if renewBefore <= 0 {
+		renewBefore = DefaultRenewBefore
+	}
+
+	cache := autocert.DirCache(config.CacheDir)
+	key, err := loadAccountKey(context.Background(), cache)
+	if err != nil {
+		return nil, err
+	}
+
+	client := &acme.Client{
+		Key:          key,
+		DirectoryURL: directoryURL,
+		HTTPClient:   config.HTTPClient,
+		UserAgent:    "tunnel-core",
+	}
+
+	policy := config.HostPolicy
+	if policy == nil {
+		policy = func(ctx context.Context, host string) error {
+			return ErrHostNotAllowed
+		}
+	}
+
+	m := &Manager{
+		baseDomain: strings.ToLower(config.BaseDomain),
+		email:      config.Email,
+		cache:      cache,
+		client:     client,
+		autocert: &autocert.Manager{
+			Prompt:      autocert.AcceptTOS,
+			Cache:       cache,
+			HostPolicy:  autocert.HostPolicy(policy),
+			RenewBefore: renewBefore,
+			Client:      client,
+			Email:       config.Email,
+		},
+		policy:      policy,
+		solver:      config.DNSSolver,
+		renewBefore: renewBefore,
+		fallback:    config.Fallback,
+	}
+
+	if m.solver != nil {
+		if err := m.loadWildcard(context.Background()); err != nil && !errors.Is(err, autocert.ErrCacheMiss) {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// GetCertificate chọn certificate cho TLS handshake (dùng làm tls.Config.GetCertificate)
+func (m *Manager) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	name := strings.TrimSuffix(strings.ToLower(hello.ServerName), ".")
+
+	// TLS-ALPN-01 challenge luôn do autocert trả lời
+	if slices.Contains(hello.SupportedProtos, acme.ALPNProto) {
+		return m.autocert.GetCertificate(hello)
+	}
+
+	if m.coveredByWildcard(name) {
+		if cert := m.Wildcard(); cert != nil {
+			return cert, nil
+		}
+	}
+
+	// Policy được kiểm tra cả khi cert đã cache (autocert chỉ kiểm tra lúc cấp mới),
+	// domain bị revoke không còn được phục vụ bằng cert cũ
+	if name != "" {
+		err := m.policy(hello.Context(), name)
+		if err == nil {
+			var cert *tls.Certificate
+			if cert, err = m.autocert.GetCertificate(hello); err == nil {
+				return cert, nil
+			}
+		}
+		if m.fallback == nil {
+			return nil, err
+		}
+	}
+
+	if m.fallback != nil {
+		return m.fallback, nil
+	}
+	return nil, ErrNoCertificate
+}
+
+// TLSConfig trả về tls.Config cho public listener (GetCertificate + ALPN cho TLS-ALPN-01)
+func (m *Manager) TLSConfig() *tls.Config {
+	return &tls.Config{
+		GetCertificate: m.GetCertificate,
+		NextProtos:     []string{"http/1.1", acme.ALPNProto},
+		MinVersion:     tls.VersionTLS12,
+	}
+}
+
+// HTTPHandler trả lời HTTP-01 challenges, request khác chuyển cho fallback (nil = redirect sang HTTPS)
+// Chỉ khi handler này được dùng (listener port 80) thì autocert mới chọn HTTP-01
+func (m *Manager) HTTPHandler(fallback http.Handler) http.Handler {
+	return m.autocert.HTTPHandler(fallback)
+}
+
+// Wildcard trả về wildcard cert hiện tại (nil nếu chưa có)
+func (m *Manager) Wildcard() *tls.Certificate {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.wildcard
+}
+
+// coveredByWildcard kiểm tra name là base domain hoặc đúng 1 label dưới base domain
+func (m *Manager) coveredByWildcard(name string) bool {
+	if m.solver == nil || m.baseDomain == "" {
+		return false
+	}
+	if name == m.baseDomain {
+		return true
+	}
+	label, ok := strings.CutSuffix(name, "."+m.baseDomain)
+	return ok && label != "" && !strings.Contains(label, ".")
+}
+
+// loadAccountKey đọc ACME account key từ cache, chưa có thì tạo mới và lưu lại
+func loadAccountKey(ctx context.Context, cache autocert.Cache) (*ecdsa.PrivateKey, error) {
+	data, err := cache.Get(ctx, accountKeyName)
+	if err == nil {
+		block, _ := pem.Decode(data)
+		if block == nil || block.Type != "EC PRIVATE KEY" {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidCachedPEM, accountKeyName)
+		}
+		return x509.ParseECPrivateKey(block.Bytes)
+	}
+	if !errors.Is(err, autocert.ErrCacheMiss) {
+		return nil, err
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	if err := cache.Put(ctx, accountKeyName, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
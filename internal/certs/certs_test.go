@@ -0,0 +1,286 @@
+package certs
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// nopSolver là DNSSolver không làm gì (tests chỉ dùng wildcard cert đã cache)
+type nopSolver struct{}
+
+func (nopSolver) Present(ctx context.Context, name, value string) error { return nil }
+func (nopSolver) CleanUp(ctx context.Context, name, value string) error { return nil }
+
+// selfSigned tạo cert tự ký cho names, cấp 60 ngày trước và hết hạn sau validity
+func selfSigned(t *testing.T, validity time.Duration, names ...string) (*ecdsa.PrivateKey, [][]byte) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey failed: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: names[0]},
+		DNSNames:     names,
+		NotBefore:    time.Now().Add(-60 * 24 * time.Hour),
+		NotAfter:     time.Now().Add(validity),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("CreateCertificate failed: %v", err)
+	}
+	return key, [][]byte{der}
+}
+
+// cacheWildcard ghi wildcard cert tự ký vào cache dir như obtainWildcard
+func cacheWildcard(t *testing.T, dir, baseDomain string, validity time.Duration) {
+	t.Helper()
+
+	key, chain := selfSigned(t, validity, "*."+baseDomain, baseDomain)
+	data, err := encodeCertificate(key, chain)
+	if err != nil {
+		t.Fatalf("encodeCertificate failed: %v", err)
+	}
+	if err := autocert.DirCache(dir).Put(context.Background(), baseDomain+wildcardSuffix, data); err != nil {
+		t.Fatalf("cache Put failed: %v", err)
+	}
+}
+
+func TestManager_GetCertificate(t *testing.T) {
+	dir := t.TempDir()
+	cacheWildcard(t, dir, "tunnel.test", 90*24*time.Hour)
+
+	key, chain := selfSigned(t, time.Hour, "fallback.test")
+	fallback := &tls.Certificate{Certificate: chain, PrivateKey: key}
+
+	m, err := NewManager(Config{
+		CacheDir:   dir,
+		BaseDomain: "tunnel.test",
+		DNSSolver:  nopSolver{},
+		Fallback:   fallback,
+	})
+	if err != nil {
+		t.Fatalf("NewManager failed: %v", err)
+	}
+	wildcard := m.Wildcard()
+	if wildcard == nil {
+		t.Fatal("Expected cached wildcard certificate to be loaded")
+	}
+
+	tests := []struct {
+		serverName string
+		want       *tls.Certificate
+	}{
+		{"app.tunnel.test", wildcard},
+		{"App.Tunnel.Test.", wildcard},
+		{"tunnel.test", wildcard},
+		{"a.b.tunnel.test", fallback}, // Wildcard chỉ phủ 1 label
+		{"custom.example.com", fallback},
+		{"", fallback},
+	}
+	for _, tt := range tests {
+		cert, err := m.GetCertificate(&tls.ClientHelloInfo{ServerName: tt.serverName})
+		if err != nil || cert != tt.want {
+			t.Errorf("GetCertificate(%q): got %v, %v", tt.serverName, cert, err)
+		}
+	}
+}
+
+func TestManager_GetCertificateHostPolicy(t *testing.T) {
+	m, err := NewManager(Config{
+		CacheDir:   t.TempDir(),
+		BaseDomain: "tunnel.test",
+		HostPolicy: func(ctx context.Context, host string) error {
+			return ErrHostNotAllowed
+		},
+	})
+	if err != nil {
+		t.Fatalf("NewManager failed: %v", err)
+	}
+
+	// Không có DNSSolver: host dưới base domain cũng đi qua policy
+	for _, name := range []string{"app.tunnel.test", "custom.example.com"} {
+		if _, err := m.GetCertificate(&tls.ClientHelloInfo{ServerName: name}); !errors.Is(err, ErrHostNotAllowed) {
+			t.Errorf("GetCertificate(%q): expected ErrHostNotAllowed, got %v", name, err)
+		}
+	}
+	if _, err := m.GetCertificate(&tls.ClientHelloInfo{}); !errors.Is(err, ErrNoCertificate) {
+		t.Errorf("Expected ErrNoCertificate without SNI, got %v", err)
+	}
+}
+
+func TestManager_AccountKeyPersisted(t *testing.T) {
+	dir := t.TempDir()
+
+	if _, err := NewManager(Config{}); !errors.Is(err, ErrNoCacheDir) {
+		t.Fatalf("Expected ErrNoCacheDir, got %v", err)
+	}
+
+	first, err := NewManager(Config{CacheDir: dir})
+	if err != nil {
+		t.Fatalf("NewManager failed: %v", err)
+	}
+	second, err := NewManager(Config{CacheDir: dir})
+	if err != nil {
+		t.Fatalf("NewManager failed: %v", err)
+	}
+	if !first.client.Key.(*ecdsa.PrivateKey).Equal(second.client.Key) {
+		t.Error("Expected account key to be reused from cache")
+	}
+}
+
+func TestManager_UntilRenewal(t *testing.T) {
+	tests := []struct {
+		name     string
+		validity time.Duration
+		renew    bool
+	}{
+		{"fresh", 90 * 24 * time.Hour, false},
+		{"inside renew window", 10 * 24 * time.Hour, true},
+		{"expired", -time.Hour, true},
+	}
+	for _, tt := range tests {
+		dir := t.TempDir()
+		cacheWildcard(t, dir, "tunnel.test", tt.validity)
+
+		m, err := NewManager(Config{CacheDir: dir, BaseDomain: "tunnel.test", DNSSolver: nopSolver{}})
+		if err != nil {
+			t.Fatalf("%s: NewManager failed: %v", tt.name, err)
+		}
+		if got := m.untilRenewal() == 0; got != tt.renew {
+			t.Errorf("%s: expected renew=%v, until renewal %v", tt.name, tt.renew, m.untilRenewal())
+		}
+	}
+
+	// Chưa có cert → cấp ngay
+	m, _ := NewManager(Config{CacheDir: t.TempDir(), BaseDomain: "tunnel.test", DNSSolver: nopSolver{}})
+	if d := m.untilRenewal(); d != 0 {
+		t.Errorf("Expected immediate issuance without certificate, got %v", d)
+	}
+}
+
+func TestExecSolver(t *testing.T) {
+	dir := t.TempDir()
+	log := filepath.Join(dir, "calls.log")
+	script := filepath.Join(dir, "dns.sh")
+	body := "#!/bin/sh\n[ \"$3\" = fail ] && { echo provider error; exit 1; }\necho \"$@\" >> " + log + "\n"
+	if err := os.WriteFile(script, []byte(body), 0o755); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+
+	solver := ExecSolver{Command: script}
+	name := DNSChallengeRecord("tunnel.test")
+	if err := solver.Present(context.Background(), name, "value-1"); err != nil {
+		t.Fatalf("Present failed: %v", err)
+	}
+	if err := solver.CleanUp(context.Background(), name, "value-1"); err != nil {
+		t.Fatalf("CleanUp failed: %v", err)
+	}
+
+	calls, _ := os.ReadFile(log)
+	want := "present _acme-challenge.tunnel.test value-1\ncleanup _acme-challenge.tunnel.test value-1\n"
+	if string(calls) != want {
+		t.Errorf("Unexpected calls:\n%s", calls)
+	}
+
+	if err := solver.Present(context.Background(), name, "fail"); err == nil || !strings.Contains(err.Error(), "provider error") {
+		t.Errorf("Expected error with command output, got %v", err)
+	}
+}
+
+// challtestsrvSolver tạo TXT records qua management API của pebble-challtestsrv
+type challtestsrvSolver struct {
+	url string
+}
+
+func (s challtestsrvSolver) Present(ctx context.Context, name, value string) error {
+	return s.post(ctx, "/set-txt", map[string]string{"host": name + ".", "value": value})
+}
+
+func (s challtestsrvSolver) CleanUp(ctx context.Context, name, value string) error {
+	return s.post(ctx, "/clear-txt", map[string]string{"host": name + "."})
+}
+
+func (s challtestsrvSolver) post(ctx context.Context, path string, body map[string]string) error {
+	data, _ := json.Marshal(body)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url+path, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// TestManager_PebbleWildcard cấp wildcard cert từ Pebble (chạy khi có ACME test server):
+//
+//	pebble -dnsserver 127.0.0.1:8053 & pebble-challtestsrv &
+//	TUNNEL_TEST_ACME_DIRECTORY=https://localhost:14000/dir \
+//	TUNNEL_TEST_ACME_CHALLTESTSRV=http://localhost:8055 go test ./internal/certs -run Pebble
+func TestManager_PebbleWildcard(t *testing.T) {
+	directory := os.Getenv("TUNNEL_TEST_ACME_DIRECTORY")
+	challtestsrv := os.Getenv("TUNNEL_TEST_ACME_CHALLTESTSRV")
+	if directory == "" || challtestsrv == "" {
+		t.Skip("TUNNEL_TEST_ACME_DIRECTORY and TUNNEL_TEST_ACME_CHALLTESTSRV not set")
+	}
+
+	dir := t.TempDir()
+	config := Config{
+		DirectoryURL: directory,
+		CacheDir:     dir,
+		BaseDomain:   "tunnel.test",
+		DNSSolver:    challtestsrvSolver{url: challtestsrv},
+		// Pebble dùng CA tự ký cho ACME API
+		HTTPClient: &http.Client{Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+		}},
+	}
+	m, err := NewManager(config)
+	if err != nil {
+		t.Fatalf("NewManager failed: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+	if err := m.RenewWildcard(ctx); err != nil {
+		t.Fatalf("RenewWildcard failed: %v", err)
+	}
+
+	leaf := m.Wildcard().Leaf
+	if err := leaf.VerifyHostname("app.tunnel.test"); err != nil {
+		t.Errorf("Wildcard certificate does not cover app.tunnel.test: %v", err)
+	}
+	if err := leaf.VerifyHostname("tunnel.test"); err != nil {
+		t.Errorf("Wildcard certificate does not cover tunnel.test: %v", err)
+	}
+
+	// Restart: cert được load lại từ cache, không cấp mới
+	reloaded, err := NewManager(config)
+	if err != nil {
+		t.Fatalf("NewManager failed: %v", err)
+	}
+	if reloaded.Wildcard() == nil || !reloaded.Wildcard().Leaf.Equal(leaf) {
+		t.Error("Expected wildcard certificate to be reloaded from cache")
+	}
+}
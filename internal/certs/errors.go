@@ -0,0 +1,11 @@
+package certs
+
+import "errors"
+
+var (
+	ErrNoCacheDir       = errors.New("ACME cache directory required")
+	ErrHostNotAllowed   = errors.New("host not allowed for on-demand certificate")
+	ErrNoCertificate    = errors.New("no certificate for server name")
+	ErrNoDNSChallenge   = errors.New("ACME server offered no dns-01 challenge")
+	ErrInvalidCachedPEM = errors.New("invalid cached certificate")
+)
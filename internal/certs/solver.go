@@ -0,0 +1,41 @@
+package certs
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+)
+
+// DNSSolver tạo/xóa TXT record cho DNS-01 challenge
+// name là tên record không có dấu chấm cuối (vd. _acme-challenge.example.com); wildcard cert cần 2 giá trị
+// cùng lúc trên cùng 1 name nên Present phải thêm record, không ghi đè. Present chỉ nên trả về khi
+// record đã thấy được trên authoritative nameservers
+type DNSSolver interface {
+	Present(ctx context.Context, name, value string) error
+	CleanUp(ctx context.Context, name, value string) error
+}
+
+// ExecSolver gọi command ngoài để quản lý DNS: "<Command> present|cleanup <name> <value>"
+// Exit code khác 0 là lỗi, output của command được đưa vào error
+type ExecSolver struct {
+	Command string
+}
+
+// Present chạy "<Command> present <name> <value>"
+func (s ExecSolver) Present(ctx context.Context, name, value string) error {
+	return s.run(ctx, "present", name, value)
+}
+
+// CleanUp chạy "<Command> cleanup <name> <value>"
+func (s ExecSolver) CleanUp(ctx context.Context, name, value string) error {
+	return s.run(ctx, "cleanup", name, value)
+}
+
+func (s ExecSolver) run(ctx context.Context, action, name, value string) error {
+	out, err := exec.CommandContext(ctx, s.Command, action, name, value).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("dns solver %s %s: %w: %s", action, name, err, bytes.TrimSpace(out))
+	}
+	return nil
+}
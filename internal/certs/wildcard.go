@@ -0,0 +1,248 @@
+package certs
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"golang.org/x/crypto/acme"
+)
+
+const (
+	// dnsChallengePrefix là label đặt trước domain cho TXT record của DNS-01
+	dnsChallengePrefix = "_acme-challenge."
+	// retryInterval là thời gian chờ trước khi thử lại khi cấp wildcard cert lỗi
+	retryInterval = 10 * time.Minute
+	// checkInterval là thời gian tối đa giữa 2 lần kiểm tra hạn của wildcard cert
+	checkInterval = 12 * time.Hour
+)
+
+// DNSChallengeRecord trả về tên TXT record của DNS-01 challenge cho domain
+func DNSChallengeRecord(domain string) string {
+	return dnsChallengePrefix + domain
+}
+
+// Run cấp wildcard cert (nếu chưa có trong cache) và gia hạn trước khi hết hạn cho đến khi ctx bị hủy
+// Không có DNSSolver → return ngay
+func (m *Manager) Run(ctx context.Context) {
+	if m.solver == nil {
+		return
+	}
+
+	for {
+		wait := retryInterval
+		if err := m.RenewWildcard(ctx); err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			slog.Error("Failed to obtain wildcard certificate", "domain", m.baseDomain, "error", err, "retry_in", wait)
+		} else {
+			wait = max(m.untilRenewal(), time.Minute)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+// RenewWildcard cấp wildcard cert mới nếu chưa có hoặc đã đến hạn gia hạn
+func (m *Manager) RenewWildcard(ctx context.Context) error {
+	if m.untilRenewal() > 0 {
+		return nil
+	}
+	return m.obtainWildcard(ctx)
+}
+
+// untilRenewal trả về thời gian đến lần gia hạn kế tiếp (0 = cần cấp ngay), tối đa checkInterval
+// Cert có thời hạn ngắn hơn renewBefore được gia hạn khi còn 1/3 thời hạn
+func (m *Manager) untilRenewal() time.Duration {
+	cert := m.Wildcard()
+	if cert == nil {
+		return 0
+	}
+
+	leaf := cert.Leaf
+	before := min(m.renewBefore, leaf.NotAfter.Sub(leaf.NotBefore)/3)
+	return min(max(time.Until(leaf.NotAfter.Add(-before)), 0), checkInterval)
+}
+
+// obtainWildcard cấp cert cho *.baseDomain và baseDomain qua DNS-01, lưu vào cache
+func (m *Manager) obtainWildcard(ctx context.Context) error {
+	if err := m.register(ctx); err != nil {
+		return fmt.Errorf("acme register: %w", err)
+	}
+
+	domains := []string{"*." + m.baseDomain, m.baseDomain}
+	order, err := m.client.AuthorizeOrder(ctx, acme.DomainIDs(domains...))
+	if err != nil {
+		return fmt.Errorf("acme order: %w", err)
+	}
+	if err := m.authorizeDNS(ctx, order.AuthzURLs); err != nil {
+		return err
+	}
+	if order, err = m.client.WaitOrder(ctx, order.URI); err != nil {
+		return fmt.Errorf("acme order: %w", err)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{DNSNames: domains}, key)
+	if err != nil {
+		return err
+	}
+	chain, _, err := m.client.CreateOrderCert(ctx, order.FinalizeURL, csr, true)
+	if err != nil {
+		return fmt.Errorf("acme finalize: %w", err)
+	}
+
+	data, err := encodeCertificate(key, chain)
+	if err != nil {
+		return err
+	}
+	cert, err := parseCertificate(data)
+	if err != nil {
+		return err
+	}
+	if err := m.cache.Put(ctx, m.baseDomain+wildcardSuffix, data); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	m.wildcard = cert
+	m.mu.Unlock()
+
+	slog.Info("Obtained wildcard certificate", "domain", m.baseDomain, "expires", cert.Leaf.NotAfter)
+	return nil
+}
+
+// authorizeDNS trả lời dns-01 challenge của các authorizations đang chờ
+// Tất cả TXT records được tạo trước khi accept (wildcard và base domain dùng chung 1 record name)
+func (m *Manager) authorizeDNS(ctx context.Context, authzURLs []string) error {
+	var pending []*acme.Authorization
+	var challenges []*acme.Challenge
+	for _, url := range authzURLs {
+		authz, err := m.client.GetAuthorization(ctx, url)
+		if err != nil {
+			return fmt.Errorf("acme authorization: %w", err)
+		}
+		if authz.Status == acme.StatusValid {
+			continue
+		}
+
+		var challenge *acme.Challenge
+		for _, c := range authz.Challenges {
+			if c.Type == "dns-01" {
+				challenge = c
+				break
+			}
+		}
+		if challenge == nil {
+			return fmt.Errorf("%w for %s", ErrNoDNSChallenge, authz.Identifier.Value)
+		}
+		pending = append(pending, authz)
+		challenges = append(challenges, challenge)
+	}
+
+	for i, authz := range pending {
+		name := DNSChallengeRecord(authz.Identifier.Value)
+		value, err := m.client.DNS01ChallengeRecord(challenges[i].Token)
+		if err != nil {
+			return err
+		}
+		if err := m.solver.Present(ctx, name, value); err != nil {
+			return err
+		}
+		defer func() {
+			if err := m.solver.CleanUp(context.WithoutCancel(ctx), name, value); err != nil {
+				slog.Warn("Failed to clean up DNS challenge record", "name", name, "error", err)
+			}
+		}()
+	}
+
+	for i, authz := range pending {
+		if _, err := m.client.Accept(ctx, challenges[i]); err != nil {
+			return fmt.Errorf("acme accept %s: %w", authz.Identifier.Value, err)
+		}
+	}
+	for _, authz := range pending {
+		if _, err := m.client.WaitAuthorization(ctx, authz.URI); err != nil {
+			return fmt.Errorf("acme authorization %s: %w", authz.Identifier.Value, err)
+		}
+	}
+	return nil
+}
+
+// register tạo ACME account (account đã tồn tại không phải lỗi)
+func (m *Manager) register(ctx context.Context) error {
+	var contact []string
+	if m.email != "" {
+		contact = []string{"mailto:" + m.email}
+	}
+
+	_, err := m.client.Register(ctx, &acme.Account{Contact: contact}, acme.AcceptTOS)
+	var acmeErr *acme.Error
+	if err == nil || errors.Is(err, acme.ErrAccountAlreadyExists) ||
+		(errors.As(err, &acmeErr) && acmeErr.StatusCode == http.StatusConflict) {
+		return nil
+	}
+	return err
+}
+
+// loadWildcard đọc wildcard cert đã cache (autocert.ErrCacheMiss nếu chưa có)
+func (m *Manager) loadWildcard(ctx context.Context) error {
+	data, err := m.cache.Get(ctx, m.baseDomain+wildcardSuffix)
+	if err != nil {
+		return err
+	}
+	cert, err := parseCertificate(data)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	m.wildcard = cert
+	m.mu.Unlock()
+	return nil
+}
+
+// encodeCertificate ghép private key và chain thành PEM (cùng format với autocert cache)
+func encodeCertificate(key *ecdsa.PrivateKey, chain [][]byte) ([]byte, error) {
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	data := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})
+	for _, cert := range chain {
+		data = append(data, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert})...)
+	}
+	return data, nil
+}
+
+// parseCertificate parse PEM của encodeCertificate thành tls.Certificate có Leaf
+func parseCertificate(data []byte) (*tls.Certificate, error) {
+	cert, err := tls.X509KeyPair(data, data)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidCachedPEM, err)
+	}
+	if cert.Leaf == nil {
+		if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidCachedPEM, err)
+		}
+	}
+	return &cert, nil
+}
@@ -0,0 +1,725 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config là cấu hình server, tương ứng với config.example.yaml
+type Config struct {
+	Agent        ListenerConfig     `yaml:"agent"`
+	Public       ListenerConfig     `yaml:"public"`
+	ACME         ACMEConfig         `yaml:"acme"`
+	TCP          TCPConfig          `yaml:"tcp"`
+	BaseDomain   string             `yaml:"base_domain"`
+	Domains      DomainsConfig      `yaml:"custom_domains"`
+	Auth         AuthConfig         `yaml:"auth"`
+	Limits       LimitsConfig       `yaml:"limits"`
+	RateLimiting RateLimitingConfig `yaml:"rate_limiting"`
+	FlowControl  FlowControlConfig  `yaml:"flow_control"`
+	Ping         PingConfig         `yaml:"ping"`
+	Logging      LoggingConfig      `yaml:"logging"`
+	Metrics      MetricsConfig      `yaml:"metrics"`
+	Admin        AdminConfig        `yaml:"admin"`
+	Shutdown     ShutdownConfig     `yaml:"shutdown"`
+}
+
+// ListenerConfig là config cho agent/public listener
+type ListenerConfig struct {
+	Addr     string `yaml:"addr"`
+	TLS      bool   `yaml:"tls"`
+	CertFile string `yaml:"cert_file"`
+	KeyFile  string `yaml:"key_file"`
+}
+
+// ACMEConfig là config cấp TLS certificates tự động cho public listener qua ACME
+type ACMEConfig struct {
+	Enabled      bool   `yaml:"enabled"`
+	DirectoryURL string `yaml:"directory_url"`
+	Email        string `yaml:"email"`
+	CacheDir     string `yaml:"cache_dir"`
+	CAFile       string `yaml:"ca_file"`      // CA bổ sung để tin ACME server (vd. Pebble), rỗng = system roots
+	HTTPAddr     string `yaml:"http_addr"`    // Listener cho HTTP-01 (vd. ":80"), rỗng = chỉ TLS-ALPN-01
+	DNSCommand   string `yaml:"dns_command"`  // DNS-01 solver cho wildcard cert của base domain, rỗng = không cấp wildcard
+	RenewBefore  int    `yaml:"renew_before"` // days
+}
+
+// TCPConfig là config cho raw TCP tunnels
+type TCPConfig struct {
+	PortRange        string `yaml:"port_range"` // "start-end", rỗng = disabled
+	BindAddr         string `yaml:"bind_addr"`
+	MaxPortsPerAgent int    `yaml:"max_ports_per_agent"`
+	ReleaseGrace     int    `yaml:"release_grace"` // seconds
+}
+
+// DomainsConfig là config cho custom domains (ngoài base domain) do agents claim và xác minh
+type DomainsConfig struct {
+	Enabled          bool `yaml:"enabled"`
+	VerifyTimeout    int  `yaml:"verify_timeout"`     // seconds, cho mỗi lần xác minh DNS/HTTP
+	MaxPendingClaims int  `yaml:"max_pending_claims"` // claims đang chờ xác minh tối đa mỗi agent
+}
+
+// Auth backends
+const (
+	AuthBackendInsecure = "insecure" // Chấp nhận mọi token, token = agent ID (chỉ cho development)
+	AuthBackendStatic   = "static"
+	AuthBackendJWT      = "jwt"
+	AuthBackendWebhook  = "webhook"
+)
+
+// Auth modes: credentials agent phải xuất trình
+const (
+	AuthModeToken = "token" // Bearer token (client cert tùy chọn, fingerprint được ghi lại)
+	AuthModeCert  = "cert"  // Client certificate, agent ID lấy từ cert
+	AuthModeBoth  = "both"  // Cả 2, agent ID của token phải khớp với cert
+)
+
+// Nguồn agent ID trong client certificate
+const (
+	CertAgentIDCommonName = "common_name"
+	CertAgentIDDNSSAN     = "dns_san"
+	CertAgentIDURISAN     = "uri_san"
+	CertAgentIDEmailSAN   = "email_san"
+)
+
+// AuthConfig chọn backend xác thực token của agent
+type AuthConfig struct {
+	Backend    string               `yaml:"backend"`
+	Mode       string               `yaml:"mode"` // token | cert | both
+	ClientCert ClientCertAuthConfig `yaml:"client_cert"`
+	Revocation RevocationConfig     `yaml:"revocation"`
+	Static     StaticAuthConfig     `yaml:"static"`
+	JWT        JWTAuthConfig        `yaml:"jwt"`
+	Webhook    WebhookAuthConfig    `yaml:"webhook"`
+}
+
+// ClientCertAuthConfig là config xác thực agent bằng client certificate (mTLS) trên agent listener
+type ClientCertAuthConfig struct {
+	CAFile  string `yaml:"ca_file"`  // CA bundle (PEM) ký client certs, rỗng = không bật mTLS
+	CRLFile string `yaml:"crl_file"` // CRL (PEM/DER) của các CA, đọc lại khi file thay đổi; rỗng = không kiểm tra
+	AgentID string `yaml:"agent_id"` // common_name | dns_san | uri_san | email_san
+}
+
+// RevocationConfig là config của revocation list (tokens, agent IDs, client certs bị thu hồi)
+type RevocationConfig struct {
+	File          string `yaml:"file"`           // YAML, đọc lại khi thay đổi, thay đổi qua admin API được ghi vào; rỗng = chỉ trong bộ nhớ
+	CheckInterval int    `yaml:"check_interval"` // seconds, chu kỳ kiểm tra lại connections đang sống
+}
+
+// StaticAuthConfig là config cho static tokens file
+type StaticAuthConfig struct {
+	TokensFile string `yaml:"tokens_file"`
+}
+
+// JWTAuthConfig là config cho JWT ký bằng HMAC
+type JWTAuthConfig struct {
+	Secret        string `yaml:"secret"`
+	SecretFile    string `yaml:"secret_file"`
+	Issuer        string `yaml:"issuer"`
+	Audience      string `yaml:"audience"`
+	AgentIDClaim  string `yaml:"agent_id_claim"`
+	RequireExpiry bool   `yaml:"require_expiry"`
+	Leeway        int    `yaml:"leeway"` // seconds
+}
+
+// WebhookAuthConfig là config cho HTTP webhook
+type WebhookAuthConfig struct {
+	URL     string `yaml:"url"`
+	Secret  string `yaml:"secret"`
+	Timeout int    `yaml:"timeout"` // seconds
+}
+
+// LimitsConfig là giới hạn connections/streams toàn server
+type LimitsConfig struct {
+	MaxConnections   int `yaml:"max_connections"`
+	MaxStreams       int `yaml:"max_streams"`
+	HeartbeatTimeout int `yaml:"heartbeat_timeout"` // seconds
+	AuthTimeout      int `yaml:"auth_timeout"`      // seconds
+	WriteTimeout     int `yaml:"write_timeout"`     // seconds, cho mỗi frame ghi ra agent
+	SendQueueSize    int `yaml:"send_queue_size"`   // data frames chờ ghi tối đa mỗi connection
+
+	RequestIdleTimeout int `yaml:"request_idle_timeout"` // seconds không có data của public request trước khi trả 504
+	RequestTimeout     int `yaml:"request_timeout"`      // seconds tổng thời gian tối đa của public request, 0 = không giới hạn
+
+	Handshake HandshakeLimitsConfig `yaml:"handshake"`
+}
+
+// HandshakeLimitsConfig chống flood và brute-force trên agent listener trước khi agent xác thực
+type HandshakeLimitsConfig struct {
+	MaxPending  int `yaml:"max_pending"`  // handshakes đang chờ đồng thời, 0 = không giới hạn
+	PerIPRate   int `yaml:"per_ip_rate"`  // handshakes/giây mỗi source IP, 0 = không giới hạn
+	PerIPBurst  int `yaml:"per_ip_burst"` // 0 = bằng per_ip_rate
+	MaxFailures int `yaml:"max_failures"` // failures liên tiếp trước khi source IP bị khóa, 0 = không khóa
+	Lockout     int `yaml:"lockout"`      // seconds, nhân đôi mỗi lần bị khóa lại
+	MaxLockout  int `yaml:"max_lockout"`  // seconds, trần của lockout
+}
+
+// RateLimitingConfig là default limits cho agents/domains
+type RateLimitingConfig struct {
+	Enabled       bool              `yaml:"enabled"`
+	DefaultAgent  AgentLimitConfig  `yaml:"default_agent"`
+	DefaultDomain DomainLimitConfig `yaml:"default_domain"`
+}
+
+// AgentLimitConfig là limit mặc định cho mỗi agent
+type AgentLimitConfig struct {
+	MaxStreams   int   `yaml:"max_streams"`
+	MaxBandwidth int64 `yaml:"max_bandwidth"` // bytes/second, 0 = unlimited
+	RateLimit    int   `yaml:"rate_limit"`    // requests/second
+}
+
+// DomainLimitConfig là limit mặc định cho mỗi domain
+type DomainLimitConfig struct {
+	MaxStreams   int   `yaml:"max_streams"`
+	MaxBandwidth int64 `yaml:"max_bandwidth"` // bytes/second, 0 = unlimited
+	RateLimit    int   `yaml:"rate_limit"`    // requests/second
+}
+
+// FlowControlConfig là initial windows (bytes) cho agents hỗ trợ flow control
+type FlowControlConfig struct {
+	Enabled          bool `yaml:"enabled"`
+	StreamWindow     int  `yaml:"stream_window"`
+	ConnectionWindow int  `yaml:"connection_window"`
+}
+
+// PingConfig là config ping chủ động cho agents hỗ trợ ping
+type PingConfig struct {
+	Enabled   bool `yaml:"enabled"`
+	Interval  int  `yaml:"interval"`   // seconds
+	MaxMissed int  `yaml:"max_missed"` // ping liên tiếp không được ack trước khi đóng connection
+}
+
+// LoggingConfig là config cho logging
+type LoggingConfig struct {
+	Level      string `yaml:"level"`
+	File       string `yaml:"file"`
+	Structured bool   `yaml:"structured"`
+}
+
+// MetricsConfig là config cho metrics endpoint
+type MetricsConfig struct {
+	Enabled  bool   `yaml:"enabled"`
+	Endpoint string `yaml:"endpoint"`
+	Port     int    `yaml:"port"`
+}
+
+// AdminConfig là config cho admin HTTP API (listener riêng, bearer token)
+type AdminConfig struct {
+	Enabled   bool   `yaml:"enabled"`
+	Addr      string `yaml:"addr"`
+	Token     string `yaml:"token"`
+	TokenFile string `yaml:"token_file"`
+}
+
+// ShutdownConfig là config cho graceful shutdown
+type ShutdownConfig struct {
+	DrainTimeout int `yaml:"drain_timeout"` // seconds, chờ streams active kết thúc trước khi đóng cưỡng bức
+}
+
+// Default trả về config mặc định (giống giá trị mặc định của flags)
+func Default() *Config {
+	return &Config{
+		Agent: ListenerConfig{
+			Addr: ":8443",
+			TLS:  true,
+		},
+		Public: ListenerConfig{
+			Addr: ":8080",
+		},
+		ACME: ACMEConfig{
+			DirectoryURL: "https://acme-v02.api.letsencrypt.org/directory",
+			CacheDir:     "./certs/acme",
+			RenewBefore:  30,
+		},
+		TCP: TCPConfig{
+			MaxPortsPerAgent: 5,
+		},
+		BaseDomain: "localhost",
+		Domains: DomainsConfig{
+			VerifyTimeout:    10,
+			MaxPendingClaims: 10,
+		},
+		Auth: AuthConfig{
+			// Backend không có default: operator phải chọn tường minh (kể cả insecure)
+			Mode: AuthModeToken,
+			ClientCert: ClientCertAuthConfig{
+				AgentID: CertAgentIDCommonName,
+			},
+			Revocation: RevocationConfig{
+				CheckInterval: 30,
+			},
+			JWT: JWTAuthConfig{
+				AgentIDClaim:  "sub",
+				RequireExpiry: true,
+				Leeway:        30,
+			},
+			Webhook: WebhookAuthConfig{
+				Timeout: 5,
+			},
+		},
+		Limits: LimitsConfig{
+			MaxConnections:   1000,
+			MaxStreams:       10000,
+			HeartbeatTimeout: 30,
+			AuthTimeout:      10,
+			WriteTimeout:     10,
+			SendQueueSize:    1024,
+
+			RequestIdleTimeout: 30,
+			Handshake: HandshakeLimitsConfig{
+				MaxPending:  256,
+				PerIPRate:   5,
+				PerIPBurst:  20,
+				MaxFailures: 10,
+				Lockout:     30,
+				MaxLockout:  3600,
+			},
+		},
+		RateLimiting: RateLimitingConfig{
+			DefaultAgent: AgentLimitConfig{
+				MaxStreams: 100,
+				RateLimit:  100,
+			},
+			DefaultDomain: DomainLimitConfig{
+				MaxStreams: 50,
+				RateLimit:  50,
+			},
+		},
+		FlowControl: FlowControlConfig{
+			Enabled:          true,
+			StreamWindow:     256 * 1024,
+			ConnectionWindow: 1024 * 1024,
+		},
+		Ping: PingConfig{
+			Enabled:   true,
+			Interval:  5,
+			MaxMissed: 3,
+		},
+		Logging: LoggingConfig{
+			Level: "info",
+		},
+		Metrics: MetricsConfig{
+			Endpoint: "/metrics",
+			Port:     9090,
+		},
+		Admin: AdminConfig{
+			Addr: "127.0.0.1:9091",
+		},
+		Shutdown: ShutdownConfig{
+			DrainTimeout: 30,
+		},
+	}
+}
+
+// Load đọc config file và merge lên config mặc định
+// Key không tồn tại trong schema hoặc sai kiểu → error nêu rõ key
+func Load(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("config: %w", err)
+	}
+
+	cfg := Default()
+	if err := Parse(data, cfg); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// Parse decode YAML vào cfg (strict: unknown keys bị từ chối)
+func Parse(data []byte, cfg *Config) error {
+	if len(bytes.TrimSpace(data)) == 0 {
+		return nil
+	}
+
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return fmt.Errorf("config: %w", err)
+	}
+	if len(root.Content) == 0 {
+		return nil
+	}
+
+	return decodeNode(root.Content[0], cfg, "")
+}
+
+// ApplyEnv override config bằng environment variables
+// Tên biến: prefix + key path viết hoa, "." → "_" (vd. TUNNEL_AGENT_ADDR, TUNNEL_LIMITS_MAX_STREAMS)
+func (c *Config) ApplyEnv(prefix string, lookup func(string) (string, bool)) error {
+	for _, f := range fields(c) {
+		name := prefix + strings.ToUpper(strings.ReplaceAll(f.key, ".", "_"))
+		value, ok := lookup(name)
+		if !ok {
+			continue
+		}
+		if err := f.set(value); err != nil {
+			return &FieldError{Key: f.key, Source: name, Err: err}
+		}
+	}
+	return nil
+}
+
+// Set gán giá trị dạng string cho key (dùng cho flags override)
+func (c *Config) Set(key, value string) error {
+	for _, f := range fields(c) {
+		if f.key == key {
+			if err := f.set(value); err != nil {
+				return &FieldError{Key: key, Err: err}
+			}
+			return nil
+		}
+	}
+	return &FieldError{Key: key, Err: ErrUnknownKey}
+}
+
+// Validate kiểm tra giá trị config, error nêu rõ key sai
+func (c *Config) Validate() error {
+	for _, l := range []struct {
+		key string
+		cfg ListenerConfig
+	}{{"agent", c.Agent}, {"public", c.Public}} {
+		if l.cfg.Addr == "" {
+			return invalid(l.key+".addr", "must not be empty")
+		}
+		// Public listener dùng ACME: cert_file/key_file là fallback tùy chọn
+		if l.key == "public" && c.ACME.Enabled {
+			if (l.cfg.CertFile == "") != (l.cfg.KeyFile == "") {
+				return invalid("public.key_file", "cert_file and key_file must be set together")
+			}
+			continue
+		}
+		if l.cfg.TLS && l.cfg.CertFile == "" {
+			return invalid(l.key+".cert_file", "required when "+l.key+".tls is true")
+		}
+		if l.cfg.TLS && l.cfg.KeyFile == "" {
+			return invalid(l.key+".key_file", "required when "+l.key+".tls is true")
+		}
+	}
+
+	if c.ACME.Enabled {
+		if !c.Public.TLS {
+			return invalid("public.tls", "must be true when acme.enabled is true")
+		}
+		if !strings.HasPrefix(c.ACME.DirectoryURL, "https://") {
+			return invalid("acme.directory_url", "must be an https:// URL")
+		}
+		if c.ACME.CacheDir == "" {
+			return invalid("acme.cache_dir", "must not be empty when acme.enabled is true")
+		}
+		if c.ACME.RenewBefore <= 0 {
+			return invalid("acme.renew_before", "must be > 0")
+		}
+	}
+
+	if c.TCP.PortRange != "" {
+		if _, _, err := c.TCP.PortRangeBounds(); err != nil {
+			return invalid("tcp.port_range", err.Error())
+		}
+	}
+	if c.TCP.MaxPortsPerAgent < 0 {
+		return invalid("tcp.max_ports_per_agent", "must be >= 0")
+	}
+	if c.TCP.ReleaseGrace < 0 {
+		return invalid("tcp.release_grace", "must be >= 0")
+	}
+
+	if strings.TrimSpace(c.BaseDomain) == "" {
+		return invalid("base_domain", "must not be empty")
+	}
+
+	if c.Domains.Enabled && c.Domains.VerifyTimeout <= 0 {
+		return invalid("custom_domains.verify_timeout", "must be > 0 when custom_domains.enabled is true")
+	}
+	if c.Domains.Enabled && c.Domains.MaxPendingClaims <= 0 {
+		return invalid("custom_domains.max_pending_claims", "must be > 0 when custom_domains.enabled is true")
+	}
+
+	if err := c.Auth.validate(); err != nil {
+		return err
+	}
+	if c.Auth.ClientCert.CAFile != "" && !c.Agent.TLS {
+		return invalid("agent.tls", "must be true when auth.client_cert.ca_file is set")
+	}
+
+	for _, f := range []intField{
+		{"limits.max_connections", c.Limits.MaxConnections},
+		{"limits.max_streams", c.Limits.MaxStreams},
+		{"limits.heartbeat_timeout", c.Limits.HeartbeatTimeout},
+		{"limits.auth_timeout", c.Limits.AuthTimeout},
+		{"limits.write_timeout", c.Limits.WriteTimeout},
+		{"limits.send_queue_size", c.Limits.SendQueueSize},
+		{"limits.request_idle_timeout", c.Limits.RequestIdleTimeout},
+	} {
+		if f.value <= 0 {
+			return invalid(f.key, "must be > 0")
+		}
+	}
+
+	for _, f := range []intField{
+		{"limits.request_timeout", c.Limits.RequestTimeout},
+		{"limits.handshake.max_pending", c.Limits.Handshake.MaxPending},
+		{"limits.handshake.per_ip_rate", c.Limits.Handshake.PerIPRate},
+		{"limits.handshake.per_ip_burst", c.Limits.Handshake.PerIPBurst},
+		{"limits.handshake.max_failures", c.Limits.Handshake.MaxFailures},
+	} {
+		if f.value < 0 {
+			return invalid(f.key, "must be >= 0")
+		}
+	}
+	if c.Limits.Handshake.MaxFailures > 0 {
+		if c.Limits.Handshake.Lockout <= 0 {
+			return invalid("limits.handshake.lockout", "must be > 0 when limits.handshake.max_failures is set")
+		}
+		if c.Limits.Handshake.MaxLockout < c.Limits.Handshake.Lockout {
+			return invalid("limits.handshake.max_lockout", "must be >= limits.handshake.lockout")
+		}
+	}
+
+	if c.RateLimiting.Enabled {
+		for _, f := range []intField{
+			{"rate_limiting.default_agent.max_streams", c.RateLimiting.DefaultAgent.MaxStreams},
+			{"rate_limiting.default_agent.rate_limit", c.RateLimiting.DefaultAgent.RateLimit},
+			{"rate_limiting.default_domain.max_streams", c.RateLimiting.DefaultDomain.MaxStreams},
+			{"rate_limiting.default_domain.rate_limit", c.RateLimiting.DefaultDomain.RateLimit},
+		} {
+			if f.value <= 0 {
+				return invalid(f.key, "must be > 0 when rate_limiting.enabled is true")
+			}
+		}
+		if c.RateLimiting.DefaultAgent.MaxBandwidth < 0 {
+			return invalid("rate_limiting.default_agent.max_bandwidth", "must be >= 0")
+		}
+		if c.RateLimiting.DefaultDomain.MaxBandwidth < 0 {
+			return invalid("rate_limiting.default_domain.max_bandwidth", "must be >= 0")
+		}
+	}
+
+	if c.FlowControl.Enabled {
+		for _, f := range []intField{
+			{"flow_control.stream_window", c.FlowControl.StreamWindow},
+			{"flow_control.connection_window", c.FlowControl.ConnectionWindow},
+		} {
+			if f.value <= 0 || f.value > maxFlowWindow {
+				return invalid(f.key, fmt.Sprintf("must be between 1 and %d", maxFlowWindow))
+			}
+		}
+	}
+
+	if c.Ping.Enabled {
+		for _, f := range []intField{
+			{"ping.interval", c.Ping.Interval},
+			{"ping.max_missed", c.Ping.MaxMissed},
+		} {
+			if f.value <= 0 {
+				return invalid(f.key, "must be > 0 when ping.enabled is true")
+			}
+		}
+	}
+
+	switch c.Logging.Level {
+	case "debug", "info", "warn", "error":
+	default:
+		return invalid("logging.level", fmt.Sprintf("must be one of debug, info, warn, error (got %q)", c.Logging.Level))
+	}
+
+	if c.Metrics.Enabled {
+		if c.Metrics.Port <= 0 || c.Metrics.Port > 65535 {
+			return invalid("metrics.port", "must be between 1 and 65535")
+		}
+		if !strings.HasPrefix(c.Metrics.Endpoint, "/") {
+			return invalid("metrics.endpoint", "must start with /")
+		}
+	}
+
+	if c.Admin.Enabled {
+		if c.Admin.Addr == "" {
+			return invalid("admin.addr", "must not be empty when admin.enabled is true")
+		}
+		if c.Admin.Token == "" && c.Admin.TokenFile == "" {
+			return invalid("admin.token", "token or token_file required when admin.enabled is true")
+		}
+		if c.Admin.Token != "" && c.Admin.TokenFile != "" {
+			return invalid("admin.token_file", "set either token or token_file, not both")
+		}
+	}
+
+	if c.Shutdown.DrainTimeout < 0 {
+		return invalid("shutdown.drain_timeout", "must be >= 0")
+	}
+
+	return nil
+}
+
+// validate kiểm tra mode, client cert và config của backend được chọn
+func (a AuthConfig) validate() error {
+	switch a.Mode {
+	case AuthModeToken, AuthModeCert, AuthModeBoth:
+	default:
+		return invalid("auth.mode", fmt.Sprintf("must be one of token, cert, both (got %q)", a.Mode))
+	}
+	if a.Mode != AuthModeToken && a.ClientCert.CAFile == "" {
+		return invalid("auth.client_cert.ca_file", "required when auth.mode is "+a.Mode)
+	}
+	if a.ClientCert.CRLFile != "" && a.ClientCert.CAFile == "" {
+		return invalid("auth.client_cert.ca_file", "required when auth.client_cert.crl_file is set")
+	}
+	switch a.ClientCert.AgentID {
+	case CertAgentIDCommonName, CertAgentIDDNSSAN, CertAgentIDURISAN, CertAgentIDEmailSAN:
+	default:
+		return invalid("auth.client_cert.agent_id",
+			fmt.Sprintf("must be one of common_name, dns_san, uri_san, email_san (got %q)", a.ClientCert.AgentID))
+	}
+	if a.Revocation.CheckInterval <= 0 {
+		return invalid("auth.revocation.check_interval", "must be > 0")
+	}
+
+	switch a.Backend {
+	case "":
+		// Mode cert: agent ID lấy từ client certificate, không cần token
+		if a.Mode == AuthModeCert {
+			return nil
+		}
+		return invalid("auth.backend", "required: one of insecure, static, jwt, webhook")
+	case AuthBackendInsecure:
+	case AuthBackendStatic:
+		if a.Static.TokensFile == "" {
+			return invalid("auth.static.tokens_file", "required when auth.backend is static")
+		}
+	case AuthBackendJWT:
+		if a.JWT.Secret == "" && a.JWT.SecretFile == "" {
+			return invalid("auth.jwt.secret", "secret or secret_file required when auth.backend is jwt")
+		}
+		if a.JWT.Secret != "" && a.JWT.SecretFile != "" {
+			return invalid("auth.jwt.secret_file", "set either secret or secret_file, not both")
+		}
+		if a.JWT.AgentIDClaim == "" {
+			return invalid("auth.jwt.agent_id_claim", "must not be empty")
+		}
+		if a.JWT.Leeway < 0 {
+			return invalid("auth.jwt.leeway", "must be >= 0")
+		}
+	case AuthBackendWebhook:
+		if !strings.HasPrefix(a.Webhook.URL, "http://") && !strings.HasPrefix(a.Webhook.URL, "https://") {
+			return invalid("auth.webhook.url", "must be an http:// or https:// URL")
+		}
+		if a.Webhook.Timeout <= 0 {
+			return invalid("auth.webhook.timeout", "must be > 0")
+		}
+	default:
+		return invalid("auth.backend", fmt.Sprintf("must be one of insecure, static, jwt, webhook (got %q)", a.Backend))
+	}
+	return nil
+}
+
+// CheckIntervalDuration trả về auth.revocation.check_interval dạng time.Duration
+func (r RevocationConfig) CheckIntervalDuration() time.Duration {
+	return time.Duration(r.CheckInterval) * time.Second
+}
+
+// RenewBeforeDuration trả về acme.renew_before dạng time.Duration
+func (a ACMEConfig) RenewBeforeDuration() time.Duration {
+	return time.Duration(a.RenewBefore) * 24 * time.Hour
+}
+
+// VerifyTimeoutDuration trả về custom_domains.verify_timeout dạng time.Duration
+func (d DomainsConfig) VerifyTimeoutDuration() time.Duration {
+	return time.Duration(d.VerifyTimeout) * time.Second
+}
+
+// LeewayDuration trả về auth.jwt.leeway dạng time.Duration
+func (j JWTAuthConfig) LeewayDuration() time.Duration {
+	return time.Duration(j.Leeway) * time.Second
+}
+
+// TimeoutDuration trả về auth.webhook.timeout dạng time.Duration
+func (w WebhookAuthConfig) TimeoutDuration() time.Duration {
+	return time.Duration(w.Timeout) * time.Second
+}
+
+// PortRangeBounds parse tcp.port_range "start-end"
+func (t TCPConfig) PortRangeBounds() (start, end int, err error) {
+	parts := strings.SplitN(t.PortRange, "-", 2)
+	if len(parts) != 2 {
+		return 0, 0, fmt.Errorf("expected start-end, got %q", t.PortRange)
+	}
+	if start, err = strconv.Atoi(strings.TrimSpace(parts[0])); err != nil {
+		return 0, 0, fmt.Errorf("invalid start port %q", parts[0])
+	}
+	if end, err = strconv.Atoi(strings.TrimSpace(parts[1])); err != nil {
+		return 0, 0, fmt.Errorf("invalid end port %q", parts[1])
+	}
+	if start <= 0 || end > 65535 || start > end {
+		return 0, 0, fmt.Errorf("invalid range %d-%d", start, end)
+	}
+	return start, end, nil
+}
+
+// ReleaseGraceDuration trả về tcp.release_grace dạng time.Duration
+func (t TCPConfig) ReleaseGraceDuration() time.Duration {
+	return time.Duration(t.ReleaseGrace) * time.Second
+}
+
+// HeartbeatTimeoutDuration trả về limits.heartbeat_timeout dạng time.Duration
+func (l LimitsConfig) HeartbeatTimeoutDuration() time.Duration {
+	return time.Duration(l.HeartbeatTimeout) * time.Second
+}
+
+// LockoutDuration trả về limits.handshake.lockout dạng time.Duration
+func (h HandshakeLimitsConfig) LockoutDuration() time.Duration {
+	return time.Duration(h.Lockout) * time.Second
+}
+
+// MaxLockoutDuration trả về limits.handshake.max_lockout dạng time.Duration
+func (h HandshakeLimitsConfig) MaxLockoutDuration() time.Duration {
+	return time.Duration(h.MaxLockout) * time.Second
+}
+
+// AuthTimeoutDuration trả về limits.auth_timeout dạng time.Duration
+func (l LimitsConfig) AuthTimeoutDuration() time.Duration {
+	return time.Duration(l.AuthTimeout) * time.Second
+}
+
+// WriteTimeoutDuration trả về limits.write_timeout dạng time.Duration
+func (l LimitsConfig) WriteTimeoutDuration() time.Duration {
+	return time.Duration(l.WriteTimeout) * time.Second
+}
+
+// RequestIdleTimeoutDuration trả về limits.request_idle_timeout dạng time.Duration
+func (l LimitsConfig) RequestIdleTimeoutDuration() time.Duration {
+	return time.Duration(l.RequestIdleTimeout) * time.Second
+}
+
+// RequestTimeoutDuration trả về limits.request_timeout dạng time.Duration (0 = không giới hạn)
+func (l LimitsConfig) RequestTimeoutDuration() time.Duration {
+	return time.Duration(l.RequestTimeout) * time.Second
+}
+
+// DrainTimeoutDuration trả về shutdown.drain_timeout dạng time.Duration
+func (s ShutdownConfig) DrainTimeoutDuration() time.Duration {
+	return time.Duration(s.DrainTimeout) * time.Second
+}
+
+// maxFlowWindow là window tối đa của flow control (giống HTTP/2)
+const maxFlowWindow = 1<<31 - 1
+
+// intField là cặp key/value dùng khi validate
+type intField struct {
+	key   string
+	value int
+}
+
+// invalid tạo FieldError cho giá trị không hợp lệ
+func invalid(key, msg string) error {
+	return &FieldError{Key: key, Err: fmt.Errorf("%w: %s", ErrInvalidValue, msg)}
+}
+
+// IntervalDuration trả về ping.interval dạng time.Duration
+func (p PingConfig) IntervalDuration() time.Duration {
+	return time.Duration(p.Interval) * time.Second
+}
@@ -0,0 +1,218 @@
+package config
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestLoad_ExampleFile(t *testing.T) {
+	cfg, err := Load("../../config.example.yaml")
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+
+	if cfg.Agent.CertFile != "./certs/agent-cert.pem" {
+		t.Errorf("Agent.CertFile = %q", cfg.Agent.CertFile)
+	}
+	if cfg.Limits.MaxStreams != 10000 {
+		t.Errorf("Limits.MaxStreams = %d, want 10000", cfg.Limits.MaxStreams)
+	}
+	if !cfg.RateLimiting.Enabled || cfg.RateLimiting.DefaultAgent.MaxBandwidth != 10485760 {
+		t.Errorf("RateLimiting = %+v", cfg.RateLimiting)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Validate() error = %v", err)
+	}
+}
+
+func TestParse_Errors(t *testing.T) {
+	tests := []struct {
+		name    string
+		yaml    string
+		wantErr error
+		wantKey string
+	}{
+		{
+			name:    "unknown nested key",
+			yaml:    "limits:\n  max_stream: 10\n",
+			wantErr: ErrUnknownKey,
+			wantKey: "limits.max_stream",
+		},
+		{
+			name:    "unknown top-level key",
+			yaml:    "agnet:\n  addr: \":1\"\n",
+			wantErr: ErrUnknownKey,
+			wantKey: "agnet",
+		},
+		{
+			name:    "wrong type",
+			yaml:    "rate_limiting:\n  default_agent:\n    rate_limit: fast\n",
+			wantErr: ErrInvalidValue,
+			wantKey: "rate_limiting.default_agent.rate_limit",
+		},
+		{
+			name:    "section is not a mapping",
+			yaml:    "limits: 10\n",
+			wantErr: ErrInvalidValue,
+			wantKey: "limits",
+		},
+		{
+			name:    "duplicate key",
+			yaml:    "base_domain: a.com\nbase_domain: b.com\n",
+			wantErr: ErrDuplicateKey,
+			wantKey: "base_domain",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Parse([]byte(tt.yaml), Default())
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Parse() error = %v, want %v", err, tt.wantErr)
+			}
+			var fieldErr *FieldError
+			if !errors.As(err, &fieldErr) || fieldErr.Key != tt.wantKey {
+				t.Errorf("error key = %v, want %q", err, tt.wantKey)
+			}
+			if !strings.Contains(err.Error(), tt.wantKey) {
+				t.Errorf("error message %q does not name key %q", err, tt.wantKey)
+			}
+		})
+	}
+}
+
+func TestParse_KeepsDefaults(t *testing.T) {
+	cfg := Default()
+	if err := Parse([]byte("limits:\n  max_streams: 42\n"), cfg); err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if cfg.Limits.MaxStreams != 42 {
+		t.Errorf("MaxStreams = %d, want 42", cfg.Limits.MaxStreams)
+	}
+	if cfg.Limits.MaxConnections != 1000 {
+		t.Errorf("MaxConnections = %d, want default 1000", cfg.Limits.MaxConnections)
+	}
+}
+
+func TestConfig_ApplyEnv(t *testing.T) {
+	env := map[string]string{
+		"TUNNEL_BASE_DOMAIN": "tunnel.example.com",
+		"TUNNEL_AGENT_TLS":   "false",
+		"TUNNEL_RATE_LIMITING_DEFAULT_AGENT_MAX_BANDWIDTH": "2048",
+	}
+	lookup := func(name string) (string, bool) {
+		v, ok := env[name]
+		return v, ok
+	}
+
+	cfg := Default()
+	if err := cfg.ApplyEnv("TUNNEL_", lookup); err != nil {
+		t.Fatalf("ApplyEnv() error = %v", err)
+	}
+	if cfg.BaseDomain != "tunnel.example.com" || cfg.Agent.TLS || cfg.RateLimiting.DefaultAgent.MaxBandwidth != 2048 {
+		t.Errorf("config after env = %+v", cfg)
+	}
+
+	env["TUNNEL_LIMITS_MAX_STREAMS"] = "many"
+	err := cfg.ApplyEnv("TUNNEL_", lookup)
+	if !errors.Is(err, ErrInvalidValue) || !strings.Contains(err.Error(), "TUNNEL_LIMITS_MAX_STREAMS") {
+		t.Errorf("ApplyEnv() error = %v, want invalid value naming env var", err)
+	}
+}
+
+func TestConfig_Set(t *testing.T) {
+	cfg := Default()
+	if err := cfg.Set("tcp.port_range", "20000-20010"); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+	start, end, err := cfg.TCP.PortRangeBounds()
+	if err != nil || start != 20000 || end != 20010 {
+		t.Errorf("PortRangeBounds() = %d, %d, %v", start, end, err)
+	}
+
+	if err := cfg.Set("limits.nope", "1"); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("Set(unknown) error = %v, want ErrUnknownKey", err)
+	}
+}
+
+func TestConfig_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		modify  func(c *Config)
+		wantKey string
+	}{
+		{"tls without cert", func(c *Config) { c.Agent.CertFile = "" }, "agent.cert_file"},
+		{"bad port range", func(c *Config) { c.TCP.PortRange = "30000-20000" }, "tcp.port_range"},
+		{"zero max streams", func(c *Config) { c.Limits.MaxStreams = 0 }, "limits.max_streams"},
+		{"zero request idle timeout", func(c *Config) { c.Limits.RequestIdleTimeout = 0 }, "limits.request_idle_timeout"},
+		{"negative request timeout", func(c *Config) { c.Limits.RequestTimeout = -1 }, "limits.request_timeout"},
+		{"rate limit disabled per agent", func(c *Config) {
+			c.RateLimiting.Enabled = true
+			c.RateLimiting.DefaultAgent.RateLimit = 0
+		}, "rate_limiting.default_agent.rate_limit"},
+		{"bad log level", func(c *Config) { c.Logging.Level = "verbose" }, "logging.level"},
+		{"auth backend not set", func(c *Config) { c.Auth.Backend = "" }, "auth.backend"},
+		{"unknown auth backend", func(c *Config) { c.Auth.Backend = "ldap" }, "auth.backend"},
+		{"static without tokens file", func(c *Config) { c.Auth.Backend = AuthBackendStatic }, "auth.static.tokens_file"},
+		{"jwt without secret", func(c *Config) { c.Auth.Backend = AuthBackendJWT }, "auth.jwt.secret"},
+		{"webhook without url", func(c *Config) { c.Auth.Backend = AuthBackendWebhook }, "auth.webhook.url"},
+		{"unknown auth mode", func(c *Config) { c.Auth.Mode = "password" }, "auth.mode"},
+		{"cert mode without ca", func(c *Config) { c.Auth.Mode = AuthModeCert }, "auth.client_cert.ca_file"},
+		{"crl without ca", func(c *Config) { c.Auth.ClientCert.CRLFile = "ca.crl" }, "auth.client_cert.ca_file"},
+		{"unknown cert agent id source", func(c *Config) {
+			c.Auth.ClientCert.CAFile = "ca.pem"
+			c.Auth.ClientCert.AgentID = "serial"
+		}, "auth.client_cert.agent_id"},
+		{"negative handshake rate", func(c *Config) { c.Limits.Handshake.PerIPRate = -1 }, "limits.handshake.per_ip_rate"},
+		{"lockout without duration", func(c *Config) { c.Limits.Handshake.Lockout = 0 }, "limits.handshake.lockout"},
+		{"max lockout below lockout", func(c *Config) { c.Limits.Handshake.MaxLockout = 10 }, "limits.handshake.max_lockout"},
+		{"zero revocation check interval", func(c *Config) { c.Auth.Revocation.CheckInterval = 0 }, "auth.revocation.check_interval"},
+		{"client ca without agent tls", func(c *Config) {
+			c.Agent.TLS = false
+			c.Auth.ClientCert.CAFile = "ca.pem"
+		}, "agent.tls"},
+		{"bad metrics port", func(c *Config) {
+			c.Metrics.Enabled = true
+			c.Metrics.Port = 70000
+		}, "metrics.port"},
+		{"zero flow control window", func(c *Config) { c.FlowControl.StreamWindow = 0 }, "flow_control.stream_window"},
+		{"zero ping interval", func(c *Config) { c.Ping.Interval = 0 }, "ping.interval"},
+		{"zero domain verify timeout", func(c *Config) {
+			c.Domains.Enabled = true
+			c.Domains.VerifyTimeout = 0
+		}, "custom_domains.verify_timeout"},
+		{"zero max pending domain claims", func(c *Config) {
+			c.Domains.Enabled = true
+			c.Domains.MaxPendingClaims = 0
+		}, "custom_domains.max_pending_claims"},
+		{"acme without public tls", func(c *Config) { c.ACME.Enabled = true }, "public.tls"},
+		{"acme with plain http directory", func(c *Config) {
+			c.ACME.Enabled = true
+			c.Public.TLS = true
+			c.ACME.DirectoryURL = "http://localhost:14000/dir"
+		}, "acme.directory_url"},
+		{"admin without token", func(c *Config) { c.Admin.Enabled = true }, "admin.token"},
+		{"admin with token and token file", func(c *Config) {
+			c.Admin.Enabled = true
+			c.Admin.Token = "secret"
+			c.Admin.TokenFile = "token.txt"
+		}, "admin.token_file"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := Default()
+			cfg.Agent.CertFile = "cert.pem"
+			cfg.Agent.KeyFile = "key.pem"
+			cfg.Auth.Backend = AuthBackendInsecure
+			tt.modify(cfg)
+
+			err := cfg.Validate()
+			var fieldErr *FieldError
+			if !errors.As(err, &fieldErr) || fieldErr.Key != tt.wantKey {
+				t.Errorf("Validate() error = %v, want key %q", err, tt.wantKey)
+			}
+		})
+	}
+}
@@ -0,0 +1,128 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// decodeNode decode YAML mapping vào struct theo yaml tags
+// Khác yaml.Decoder.KnownFields: error chứa key path đầy đủ (vd. "limits.max_stream")
+func decodeNode(node *yaml.Node, out interface{}, path string) error {
+	v := reflect.ValueOf(out).Elem()
+
+	if node.Kind != yaml.MappingNode {
+		key := path
+		if key == "" {
+			key = "(root)"
+		}
+		return &FieldError{Key: key, Line: node.Line, Err: fmt.Errorf("%w: expected a mapping", ErrInvalidValue)}
+	}
+
+	seen := make(map[string]bool)
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		keyNode, valueNode := node.Content[i], node.Content[i+1]
+		key := joinKey(path, keyNode.Value)
+
+		if seen[keyNode.Value] {
+			return &FieldError{Key: key, Line: keyNode.Line, Err: ErrDuplicateKey}
+		}
+		seen[keyNode.Value] = true
+
+		field, ok := fieldByTag(v, keyNode.Value)
+		if !ok {
+			return &FieldError{Key: key, Line: keyNode.Line, Err: ErrUnknownKey}
+		}
+
+		if field.Kind() == reflect.Struct {
+			if err := decodeNode(valueNode, field.Addr().Interface(), key); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if valueNode.Kind != yaml.ScalarNode {
+			return &FieldError{Key: key, Line: valueNode.Line, Err: fmt.Errorf("%w: expected a scalar value", ErrInvalidValue)}
+		}
+		if err := valueNode.Decode(field.Addr().Interface()); err != nil {
+			return &FieldError{Key: key, Line: valueNode.Line, Err: fmt.Errorf("%w: %q is not a valid %s", ErrInvalidValue, valueNode.Value, field.Kind())}
+		}
+	}
+
+	return nil
+}
+
+// fieldByTag tìm struct field theo yaml tag
+func fieldByTag(v reflect.Value, tag string) (reflect.Value, bool) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		if yamlName(t.Field(i)) == tag {
+			return v.Field(i), true
+		}
+	}
+	return reflect.Value{}, false
+}
+
+// yamlName lấy tên key từ yaml tag
+func yamlName(f reflect.StructField) string {
+	return strings.Split(f.Tag.Get("yaml"), ",")[0]
+}
+
+// joinKey nối key path bằng "."
+func joinKey(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+// leafField là 1 key lá trong config (string/bool/int)
+type leafField struct {
+	key   string
+	value reflect.Value
+}
+
+// set parse string và gán vào field
+func (f leafField) set(s string) error {
+	switch f.value.Kind() {
+	case reflect.String:
+		f.value.SetString(s)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return fmt.Errorf("%w: %q is not a valid bool", ErrInvalidValue, s)
+		}
+		f.value.SetBool(b)
+	case reflect.Int, reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return fmt.Errorf("%w: %q is not a valid int", ErrInvalidValue, s)
+		}
+		f.value.SetInt(n)
+	default:
+		return fmt.Errorf("%w: unsupported type %s", ErrInvalidValue, f.value.Kind())
+	}
+	return nil
+}
+
+// fields liệt kê tất cả key lá của config theo thứ tự khai báo
+func fields(c *Config) []leafField {
+	var out []leafField
+	var walk func(v reflect.Value, path string)
+	walk = func(v reflect.Value, path string) {
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			key := joinKey(path, yamlName(t.Field(i)))
+			if v.Field(i).Kind() == reflect.Struct {
+				walk(v.Field(i), key)
+				continue
+			}
+			out = append(out, leafField{key: key, value: v.Field(i)})
+		}
+	}
+	walk(reflect.ValueOf(c).Elem(), "")
+	return out
+}
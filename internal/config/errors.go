@@ -0,0 +1,35 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrUnknownKey   = errors.New("unknown key")
+	ErrDuplicateKey = errors.New("duplicate key")
+	ErrInvalidValue = errors.New("invalid value")
+)
+
+// FieldError là lỗi gắn với 1 key trong config
+type FieldError struct {
+	Key    string // Key path, vd. "rate_limiting.default_agent.rate_limit"
+	Line   int    // Dòng trong file YAML (0 nếu không từ file)
+	Source string // Nguồn override (env var), rỗng nếu từ file
+	Err    error
+}
+
+func (e *FieldError) Error() string {
+	switch {
+	case e.Line > 0:
+		return fmt.Sprintf("config: %s (line %d): %v", e.Key, e.Line, e.Err)
+	case e.Source != "":
+		return fmt.Sprintf("config: %s (from %s): %v", e.Key, e.Source, e.Err)
+	default:
+		return fmt.Sprintf("config: %s: %v", e.Key, e.Err)
+	}
+}
+
+func (e *FieldError) Unwrap() error {
+	return e.Err
+}
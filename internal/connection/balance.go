@@ -0,0 +1,83 @@
+package connection
+
+import (
+	"errors"
+	"sort"
+	"time"
+)
+
+// GetConnectionsByAgentID lấy tất cả connections của agent, sort theo thời điểm kết nối
+func (m *Manager) GetConnectionsByAgentID(agentID string) []*Connection {
+	m.connsMu.RLock()
+	var conns []*Connection
+	for _, conn := range m.connections {
+		if conn.AgentID == agentID {
+			conns = append(conns, conn)
+		}
+	}
+	m.connsMu.RUnlock()
+
+	sort.Slice(conns, func(i, j int) bool { return conns[i].CreatedAt.Before(conns[j].CreatedAt) })
+	return conns
+}
+
+// PickConnection chọn connection còn mở có ít streams đang mở nhất trong connIDs
+// Bằng nhau → RTT thấp hơn (nếu cả 2 đã đo được), rồi đến connection đứng trước trong connIDs
+func (m *Manager) PickConnection(connIDs []string) (*Connection, bool) {
+	return m.pickConnection(connIDs, nil)
+}
+
+func (m *Manager) pickConnection(connIDs []string, skip map[*Connection]bool) (*Connection, bool) {
+	m.connsMu.RLock()
+	defer m.connsMu.RUnlock()
+
+	var (
+		best    *Connection
+		streams int
+		rtt     time.Duration
+	)
+	for _, id := range connIDs {
+		c, ok := m.connections[id]
+		if !ok || skip[c] || c.isClosed() {
+			continue
+		}
+		n, r := c.StreamCount(), c.RTT()
+		if best == nil || n < streams || (n == streams && r > 0 && rtt > 0 && r < rtt) {
+			best, streams, rtt = c, n, r
+		}
+	}
+	return best, best != nil
+}
+
+// OpenStream mở stream trên connection ít tải nhất trong connIDs
+// Connection vừa đóng (agent mất kết nối giữa chừng) được bỏ qua và thử connection kế tiếp
+// Trả ErrConnectionNotFound nếu không còn connection nào mở
+func (m *Manager) OpenStream(connIDs []string, payload []byte) (*Connection, *Stream, error) {
+	var tried map[*Connection]bool
+	for {
+		c, ok := m.pickConnection(connIDs, tried)
+		if !ok {
+			return nil, nil, ErrConnectionNotFound
+		}
+
+		stream, err := c.OpenStream(payload)
+		if err == nil {
+			return c, stream, nil
+		}
+		if !errors.Is(err, ErrConnectionClosed) {
+			return nil, nil, err
+		}
+
+		if tried == nil {
+			tried = make(map[*Connection]bool)
+		}
+		tried[c] = true
+	}
+}
+
+// isClosed cho biết connection đã đóng
+func (c *Connection) isClosed() bool {
+	c.closedMu.RLock()
+	defer c.closedMu.RUnlock()
+	return c.closed
+}
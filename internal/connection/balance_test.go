@@ -0,0 +1,68 @@
+package connection
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// registerPipe đăng ký connection trên net.Pipe, agent bỏ qua mọi frame nhận được
+func registerPipe(t *testing.T, cm *Manager, connID, agentID string) *Connection {
+	t.Helper()
+
+	server, agent := net.Pipe()
+	t.Cleanup(func() {
+		server.Close()
+		agent.Close()
+	})
+	go io.Copy(io.Discard, agent)
+
+	conn, err := cm.RegisterConnection(connID, agentID, &mockConn{conn: server}, nil)
+	if err != nil {
+		t.Fatalf("RegisterConnection failed: %v", err)
+	}
+	return conn
+}
+
+func TestManager_OpenStreamLeastLoaded(t *testing.T) {
+	cm := NewManager(10, 30*time.Second)
+	conn1 := registerPipe(t, cm, "conn-1", "agent-1")
+	conn2 := registerPipe(t, cm, "conn-2", "agent-1")
+	connIDs := []string{"conn-1", "conn-2"}
+
+	for i := 0; i < 4; i++ {
+		if _, _, err := cm.OpenStream(connIDs, nil); err != nil {
+			t.Fatalf("OpenStream failed: %v", err)
+		}
+	}
+	if conn1.StreamCount() != 2 || conn2.StreamCount() != 2 {
+		t.Errorf("Expected streams spread 2/2, got %d/%d", conn1.StreamCount(), conn2.StreamCount())
+	}
+
+	if got, _ := cm.GetConnectionByAgentID("agent-1"); got != conn1 {
+		t.Errorf("Expected first connection on tie, got %s", got.ID)
+	}
+	if conns := cm.GetConnectionsByAgentID("agent-1"); len(conns) != 2 {
+		t.Errorf("Expected 2 connections for agent, got %d", len(conns))
+	}
+}
+
+func TestManager_OpenStreamFailover(t *testing.T) {
+	cm := NewManager(10, 30*time.Second)
+	conn1 := registerPipe(t, cm, "conn-1", "agent-1")
+	conn2 := registerPipe(t, cm, "conn-2", "agent-1")
+
+	// conn-1 đã đóng nhưng chưa kịp xóa khỏi manager
+	conn1.Close()
+
+	conn, _, err := cm.OpenStream([]string{"conn-1", "conn-2"}, nil)
+	if err != nil || conn != conn2 {
+		t.Fatalf("Expected failover to conn-2, got %v, %v", conn, err)
+	}
+
+	conn2.Close()
+	if _, _, err := cm.OpenStream([]string{"conn-1", "conn-2"}, nil); err != ErrConnectionNotFound {
+		t.Errorf("Expected ErrConnectionNotFound, got %v", err)
+	}
+}
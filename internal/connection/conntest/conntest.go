@@ -0,0 +1,43 @@
+// Package conntest cung cấp agent connection giả trên net.Pipe cho tests của các package dùng connection.Manager
+package conntest
+
+import (
+	"net"
+	"testing"
+
+	"github.com/hydragon2m/tunnel-core/internal/connection"
+)
+
+// Conn implements connection.Conn trên net.Conn (giống mockConn trong tests của package connection)
+type Conn struct {
+	net.Conn
+}
+
+// RemoteAddr implements connection.Conn
+func (c *Conn) RemoteAddr() string {
+	return c.Conn.RemoteAddr().String()
+}
+
+// Register tạo net.Pipe, đăng ký phía server vào manager và trả về phía agent
+// Cả 2 đầu pipe được đóng khi test kết thúc
+func Register(t testing.TB, cm *connection.Manager, connID, agentID string) (*connection.Connection, net.Conn) {
+	t.Helper()
+	return RegisterWithMetadata(t, cm, connID, agentID, nil)
+}
+
+// RegisterWithMetadata giống Register, kèm metadata của handshake (vd. connection.MetadataFeatures)
+func RegisterWithMetadata(t testing.TB, cm *connection.Manager, connID, agentID string, metadata map[string]string) (*connection.Connection, net.Conn) {
+	t.Helper()
+
+	server, agent := net.Pipe()
+	t.Cleanup(func() {
+		server.Close()
+		agent.Close()
+	})
+
+	conn, err := cm.RegisterConnection(connID, agentID, &Conn{Conn: server}, metadata)
+	if err != nil {
+		t.Fatalf("RegisterConnection failed: %v", err)
+	}
+	return conn, agent
+}
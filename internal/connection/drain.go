@@ -0,0 +1,144 @@
+package connection
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// drainPollInterval là chu kỳ kiểm tra số streams còn active khi drain
+const drainPollInterval = 50 * time.Millisecond
+
+// DrainReport tổng kết quá trình drain
+type DrainReport struct {
+	Connections int                // Số connections lúc bắt đầu drain
+	Forced      []ForcedConnection // Connections bị đóng khi vẫn còn streams active
+}
+
+// ForcedStreams trả về tổng số streams bị cắt ngang
+func (r DrainReport) ForcedStreams() int {
+	total := 0
+	for _, f := range r.Forced {
+		total += f.Streams
+	}
+	return total
+}
+
+// ForcedConnection là connection bị đóng khi hết drain deadline
+type ForcedConnection struct {
+	ConnID  string
+	AgentID string
+	Streams int
+}
+
+// StartDrain chuyển manager sang trạng thái draining: từ chối connections mới
+func (m *Manager) StartDrain() {
+	m.connsMu.Lock()
+	defer m.connsMu.Unlock()
+	m.draining = true
+}
+
+// Draining kiểm tra manager có đang drain không
+func (m *Manager) Draining() bool {
+	m.connsMu.RLock()
+	defer m.connsMu.RUnlock()
+	return m.draining
+}
+
+// Connections trả về snapshot các connections đang active
+func (m *Manager) Connections() []*Connection {
+	m.connsMu.RLock()
+	defer m.connsMu.RUnlock()
+
+	conns := make([]*Connection, 0, len(m.connections))
+	for _, c := range m.connections {
+		conns = append(conns, c)
+	}
+	return conns
+}
+
+// ActiveStreams đếm tổng số streams đang mở trên tất cả connections
+func (m *Manager) ActiveStreams() int {
+	total := 0
+	for _, c := range m.Connections() {
+		total += c.StreamCount()
+	}
+	return total
+}
+
+// Drain thực hiện graceful shutdown cho agent connections:
+//  1. Từ chối connections mới
+//  2. Gọi notify song song cho từng connection (vd. gửi going-away control message)
+//  3. Chờ streams active kết thúc cho đến khi ctx hết hạn
+//  4. Đóng tất cả connections, ghi lại connections còn streams bị cắt ngang
+func (m *Manager) Drain(ctx context.Context, notify func(c *Connection) error) DrainReport {
+	m.StartDrain()
+
+	conns := m.Connections()
+	report := DrainReport{Connections: len(conns)}
+
+	if notify != nil {
+		notifyAll(ctx, conns, notify)
+	}
+
+	ticker := time.NewTicker(drainPollInterval)
+	defer ticker.Stop()
+
+	for m.ActiveStreams() > 0 {
+		select {
+		case <-ctx.Done():
+			goto closeAll
+		case <-ticker.C:
+		}
+	}
+
+closeAll:
+	for _, c := range m.Connections() {
+		if n := c.StreamCount(); n > 0 {
+			report.Forced = append(report.Forced, ForcedConnection{
+				ConnID:  c.ID,
+				AgentID: c.AgentID,
+				Streams: n,
+			})
+		}
+		m.CloseConnection(c.ID)
+	}
+
+	return report
+}
+
+// notifyAll gọi notify song song, return khi tất cả xong hoặc ctx hết hạn
+// Agent không đọc không chặn được drain; notify còn kẹt sau ctx sẽ kết thúc khi
+// connection bị đóng hoặc hết write timeout
+func notifyAll(ctx context.Context, conns []*Connection, notify func(c *Connection) error) {
+	var wg sync.WaitGroup
+	for _, c := range conns {
+		wg.Add(1)
+		go func(c *Connection) {
+			defer wg.Done()
+			if ctx.Err() != nil {
+				return
+			}
+			// Lỗi gửi notice không chặn drain, connection sẽ bị đóng ở cuối
+			_ = notify(c)
+		}(c)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+	}
+}
+
+// StreamCount trả về số streams đang mở trên connection
+func (c *Connection) StreamCount() int {
+	c.streamsMu.RLock()
+	defer c.streamsMu.RUnlock()
+	return len(c.streams)
+}
@@ -0,0 +1,176 @@
+package connection
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	v1 "github.com/hydragon2m/tunnel-protocol/go/v1"
+)
+
+// drainSetup tạo manager với 1 connection trên net.Pipe
+func drainSetup(t *testing.T) (*Manager, *Connection) {
+	t.Helper()
+
+	cm := NewManager(10, 30*time.Second)
+	server, agent := net.Pipe()
+	t.Cleanup(func() {
+		server.Close()
+		agent.Close()
+	})
+
+	conn, err := cm.RegisterConnection("conn-1", "agent-1", &mockConn{conn: server}, nil)
+	if err != nil {
+		t.Fatalf("RegisterConnection failed: %v", err)
+	}
+	return cm, conn
+}
+
+func TestManager_DrainWaitsForStreams(t *testing.T) {
+	cm, conn := drainSetup(t)
+	conn.createStream(1)
+
+	var closedConns []string
+	cm.SetOnConnectionClosed(func(connID string) {
+		closedConns = append(closedConns, connID)
+	})
+
+	// Stream kết thúc trước deadline
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		conn.closeStream(1)
+	}()
+
+	notified := 0
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	report := cm.Drain(ctx, func(c *Connection) error {
+		notified++
+		return nil
+	})
+
+	if notified != 1 {
+		t.Errorf("notify called %d times, want 1", notified)
+	}
+	if report.Connections != 1 || len(report.Forced) != 0 {
+		t.Errorf("report = %+v, want 1 connection, none forced", report)
+	}
+	if ctx.Err() != nil {
+		t.Error("Drain should return as soon as streams finish")
+	}
+	if len(closedConns) != 1 || closedConns[0] != "conn-1" {
+		t.Errorf("closed connections = %v", closedConns)
+	}
+	if _, exists := cm.GetConnection("conn-1"); exists {
+		t.Error("connection should be removed after drain")
+	}
+}
+
+func TestManager_DrainForcesAfterDeadline(t *testing.T) {
+	cm, conn := drainSetup(t)
+	stream := conn.createStream(1)
+	conn.createStream(2)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	report := cm.Drain(ctx, nil)
+
+	if len(report.Forced) != 1 || report.ForcedStreams() != 2 {
+		t.Fatalf("report = %+v, want 1 forced connection with 2 streams", report)
+	}
+	if f := report.Forced[0]; f.ConnID != "conn-1" || f.AgentID != "agent-1" {
+		t.Errorf("forced = %+v", f)
+	}
+
+	select {
+	case <-stream.CloseCh():
+	default:
+		t.Error("active stream should be closed")
+	}
+}
+
+func TestManager_RejectsConnectionsWhileDraining(t *testing.T) {
+	cm := NewManager(10, 30*time.Second)
+	cm.StartDrain()
+
+	server, agent := net.Pipe()
+	defer server.Close()
+	defer agent.Close()
+
+	_, err := cm.RegisterConnection("conn-1", "agent-1", &mockConn{conn: server}, nil)
+	if !errors.Is(err, ErrServerDraining) {
+		t.Errorf("RegisterConnection error = %v, want ErrServerDraining", err)
+	}
+}
+
+func TestManager_DrainNotifyDoesNotBlockOnStuckAgent(t *testing.T) {
+	cm := NewManager(10, 30*time.Second)
+
+	// Agent 1 không bao giờ đọc, agent 2 đọc bình thường
+	stuckServer, stuckAgent := net.Pipe()
+	okServer, okAgent := net.Pipe()
+	t.Cleanup(func() {
+		stuckServer.Close()
+		stuckAgent.Close()
+		okServer.Close()
+		okAgent.Close()
+	})
+	if _, err := cm.RegisterConnection("conn-stuck", "agent-1", &mockConn{conn: stuckServer}, nil); err != nil {
+		t.Fatalf("RegisterConnection failed: %v", err)
+	}
+	if _, err := cm.RegisterConnection("conn-ok", "agent-2", &mockConn{conn: okServer}, nil); err != nil {
+		t.Fatalf("RegisterConnection failed: %v", err)
+	}
+
+	received := make(chan error, 1)
+	go func() {
+		_, err := v1.Decode(okAgent)
+		received <- err
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	cm.Drain(ctx, func(c *Connection) error {
+		return c.SendFrame(&v1.Frame{
+			Version:  v1.Version,
+			Type:     v1.FrameData,
+			StreamID: 0,
+			Payload:  []byte(`{"type":"going_away"}`),
+		})
+	})
+
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Drain took %v, want bounded by ctx deadline", elapsed)
+	}
+	select {
+	case err := <-received:
+		if err != nil {
+			t.Errorf("Expected reading agent to get notice, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Error("Reading agent should be notified despite the stuck agent")
+	}
+}
+
+func TestManager_DrainSkipsNotifyAfterDeadline(t *testing.T) {
+	cm, _ := drainSetup(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	notified := false
+	cm.Drain(ctx, func(c *Connection) error {
+		notified = true
+		return nil
+	})
+
+	if notified {
+		t.Error("notify should be skipped when ctx is already done")
+	}
+}
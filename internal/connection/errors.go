@@ -8,6 +8,8 @@ var (
 	ErrConnectionNotFound    = errors.New("connection not found")
 	ErrConnectionClosed      = errors.New("connection closed")
 	ErrConnectionClosedByAgent = errors.New("connection closed by agent")
+	ErrServerDraining          = errors.New("server is draining")
+	ErrSendQueueFull           = errors.New("send queue full")
 	
 	ErrStreamExists    = errors.New("stream already exists")
 	ErrStreamNotFound  = errors.New("stream not found")
@@ -15,5 +17,8 @@ var (
 	
 	ErrInvalidControlFrame = errors.New("invalid control frame")
 	ErrInvalidStreamFrame  = errors.New("invalid stream frame")
+
+	ErrInvalidWindowUpdate  = errors.New("invalid window update")
+	ErrFlowControlViolation = errors.New("flow control window exceeded")
 )
 
@@ -0,0 +1,42 @@
+package connection
+
+import "encoding/json"
+
+// MetadataFeatures là key metadata chứa features đã thỏa thuận lúc handshake
+// (JSON array do server ghi, agent không tự khai được)
+const MetadataFeatures = "features"
+
+// Capabilities agent khai báo trong AuthRequest (ngoài CapabilityFlowControl và CapabilityPing)
+const (
+	CapabilityTCPTunnels     = "tcp_tunnels"     // register_tcp_tunnel
+	CapabilityTLSPassthrough = "tls_passthrough" // register_tunnel với passthrough
+	CapabilityCustomDomains  = "custom_domains"  // claim_domain, verify_domain, register_tunnel với custom domain
+)
+
+// ParseFeatures trả về features đã thỏa thuận trong metadata (nil nếu không có)
+func ParseFeatures(metadata map[string]string) []string {
+	raw, ok := metadata[MetadataFeatures]
+	if !ok {
+		return nil
+	}
+	var features []string
+	if err := json.Unmarshal([]byte(raw), &features); err != nil {
+		return nil
+	}
+	return features
+}
+
+// hasFeature kiểm tra feature đã được thỏa thuận lúc handshake
+func hasFeature(metadata map[string]string, feature string) bool {
+	for _, f := range ParseFeatures(metadata) {
+		if f == feature {
+			return true
+		}
+	}
+	return false
+}
+
+// HasFeature kiểm tra agent và server đã thỏa thuận feature lúc handshake
+func (c *Connection) HasFeature(feature string) bool {
+	return hasFeature(c.Metadata, feature)
+}
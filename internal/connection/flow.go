@@ -0,0 +1,307 @@
+package connection
+
+import (
+	"encoding/binary"
+	"sync"
+
+	v1 "github.com/hydragon2m/tunnel-protocol/go/v1"
+)
+
+// CapabilityFlowControl là capability agent khai báo trong AuthRequest để bật flow control
+const CapabilityFlowControl = "flow_control"
+
+// FlagWindowUpdate đánh dấu FrameData là WINDOW_UPDATE (chỉ khi flow control đã bật)
+// Payload 4 bytes big-endian = số bytes credit cộng thêm; StreamID 0 = window của connection
+const FlagWindowUpdate = v1.FlagAck
+
+const (
+	// DefaultStreamWindow là initial window mặc định của mỗi stream
+	DefaultStreamWindow = 256 * 1024
+	// DefaultConnectionWindow là initial window mặc định của connection
+	DefaultConnectionWindow = 1024 * 1024
+	// MaxWindow là window tối đa (giống HTTP/2), WINDOW_UPDATE vượt quá là lỗi
+	MaxWindow = 1<<31 - 1
+
+	windowUpdateSize = 4
+
+	// legacyStreamBuffer là số frames buffer mỗi stream cho agent không flow control
+	// Buffer đầy → frame loop chờ (hành vi cũ, chặn cả connection)
+	legacyStreamBuffer = 10
+)
+
+// FlowControl là initial windows (bytes) cho cả 2 chiều, gửi cho agent trong auth response
+// (config["flow_control"]). Mỗi bên trả credit bằng WINDOW_UPDATE khi đã tiêu thụ data
+type FlowControl struct {
+	StreamWindow     uint32 `json:"stream_window"`
+	ConnectionWindow uint32 `json:"connection_window"`
+}
+
+// SetFlowControl bật flow control cho agents đã thỏa thuận CapabilityFlowControl (zero value = tắt)
+// Chỉ áp dụng cho connections đăng ký sau khi set
+func (m *Manager) SetFlowControl(fc FlowControl) {
+	m.connsMu.Lock()
+	defer m.connsMu.Unlock()
+	m.flowControl = fc
+}
+
+// FlowControlFor trả về windows sẽ áp dụng cho agent với metadata từ handshake
+// ok = false nếu server tắt flow control hoặc agent không hỗ trợ
+func (m *Manager) FlowControlFor(metadata map[string]string) (FlowControl, bool) {
+	m.connsMu.RLock()
+	defer m.connsMu.RUnlock()
+	return m.flowControlFor(metadata)
+}
+
+func (m *Manager) flowControlFor(metadata map[string]string) (FlowControl, bool) {
+	fc := m.flowControl
+	if fc.StreamWindow == 0 || fc.ConnectionWindow == 0 {
+		return FlowControl{}, false
+	}
+	return fc, hasFeature(metadata, CapabilityFlowControl)
+}
+
+// FlowControlEnabled cho biết connection có dùng flow control không
+func (c *Connection) FlowControlEnabled() bool {
+	return c.flow != nil
+}
+
+// flowWindow là send window (credit còn lại) của stream hoặc connection
+type flowWindow struct {
+	mu      sync.Mutex
+	credit  int64
+	changed chan struct{} // đóng khi credit tăng, thay mới sau mỗi lần đóng
+}
+
+func newFlowWindow(initial uint32) *flowWindow {
+	return &flowWindow{
+		credit:  int64(initial),
+		changed: make(chan struct{}),
+	}
+}
+
+// add cộng credit từ WINDOW_UPDATE, trả false nếu window vượt MaxWindow
+func (w *flowWindow) add(n int64) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.credit+n > MaxWindow {
+		return false
+	}
+	w.credit += n
+	close(w.changed)
+	w.changed = make(chan struct{})
+	return true
+}
+
+// take lấy tối đa max bytes credit, chờ đến khi credit > 0 hoặc stream/connection đóng
+func (w *flowWindow) take(max int, streamClosed, connDone <-chan struct{}) (int, error) {
+	for {
+		w.mu.Lock()
+		if w.credit > 0 {
+			n := int64(max)
+			if n > w.credit {
+				n = w.credit
+			}
+			w.credit -= n
+			w.mu.Unlock()
+			return int(n), nil
+		}
+		changed := w.changed
+		w.mu.Unlock()
+
+		select {
+		case <-changed:
+		case <-streamClosed:
+			return 0, ErrStreamClosed
+		case <-connDone:
+			return 0, ErrConnectionClosed
+		}
+	}
+}
+
+// acquireSendCredit chờ credit để gửi tối đa want bytes trên stream
+// Trả về số bytes được gửi (<= want); không flow control → want
+func (c *Connection) acquireSendCredit(stream *Stream, want int) (int, error) {
+	if c.flow == nil || want == 0 {
+		return want, nil
+	}
+
+	n, err := stream.sendWindow.take(want, stream.closeCh, c.ctx.Done())
+	if err != nil {
+		return 0, err
+	}
+	granted, err := c.sendWindow.take(n, stream.closeCh, c.ctx.Done())
+	if err != nil {
+		stream.sendWindow.add(int64(n))
+		return 0, err
+	}
+	if granted < n {
+		stream.sendWindow.add(int64(n - granted))
+	}
+	return granted, nil
+}
+
+// handleWindowUpdate xử lý WINDOW_UPDATE từ agent
+// Payload sai là lỗi protocol (đóng connection); window stream vượt MaxWindow → reset stream
+func (c *Connection) handleWindowUpdate(frame *v1.Frame) error {
+	if len(frame.Payload) != windowUpdateSize {
+		return ErrInvalidWindowUpdate
+	}
+	increment := int64(binary.BigEndian.Uint32(frame.Payload))
+	if increment == 0 {
+		return ErrInvalidWindowUpdate
+	}
+
+	if frame.StreamID == v1.StreamIDControl {
+		if !c.sendWindow.add(increment) {
+			return ErrFlowControlViolation
+		}
+		return nil
+	}
+
+	stream, ok := c.GetStream(frame.StreamID)
+	if !ok {
+		return nil // Stream đã đóng, bỏ update đến muộn
+	}
+	if !stream.sendWindow.add(increment) {
+		c.ResetStream(frame.StreamID)
+	}
+	return nil
+}
+
+// receiveConnectionData tính payload của stream frame vào receive window của connection
+// Credit được trả ngay khi nhận (window của stream đã giới hạn buffer) nên stream chậm
+// không giữ credit của connection. Chỉ gọi từ frame loop
+func (c *Connection) receiveConnectionData(n int) error {
+	if c.flow == nil || n == 0 {
+		return nil
+	}
+	if c.recvUnacked+uint32(n) > c.flow.ConnectionWindow {
+		return ErrFlowControlViolation
+	}
+	c.recvUnacked += uint32(n)
+	if c.recvUnacked >= c.flow.ConnectionWindow/2 {
+		increment := c.recvUnacked
+		c.recvUnacked = 0
+		c.sendWindowUpdate(v1.StreamIDControl, increment)
+	}
+	return nil
+}
+
+// sendWindowUpdate gửi WINDOW_UPDATE cho agent qua control queue, không chờ ghi
+// (frame loop không bị chặn bởi socket ghi chậm)
+func (c *Connection) sendWindowUpdate(streamID, increment uint32) {
+	payload := make([]byte, windowUpdateSize)
+	binary.BigEndian.PutUint32(payload, increment)
+	c.sendFrameAsync(&v1.Frame{
+		Version:  v1.Version,
+		Type:     v1.FrameData,
+		Flags:    FlagWindowUpdate,
+		StreamID: streamID,
+		Payload:  payload,
+	})
+}
+
+// deliver đẩy payload vào receive buffer của stream
+// Flow control: không bao giờ chờ, agent gửi vượt window → reset stream
+// Agent cũ: chờ khi buffer đầy (chặn frame loop như trước)
+func (c *Connection) deliver(stream *Stream, payload []byte) error {
+	if len(payload) == 0 {
+		return nil
+	}
+
+	if c.flow != nil {
+		if !stream.push(payload, c.flow.StreamWindow) {
+			c.ResetStream(stream.ID)
+		}
+		return nil
+	}
+
+	for !stream.pushLegacy(payload) {
+		select {
+		case <-stream.inSpace:
+		case <-stream.closeCh:
+			return nil // Stream vừa bị server đóng
+		case <-c.ctx.Done():
+			return c.ctx.Err()
+		}
+	}
+	return nil
+}
+
+// push thêm payload khi còn trong receive window, trả false nếu agent gửi vượt window
+func (s *Stream) push(payload []byte, window uint32) bool {
+	s.inMu.Lock()
+	if s.inOutstanding+uint32(len(payload)) > window {
+		s.inMu.Unlock()
+		return false
+	}
+	s.inOutstanding += uint32(len(payload))
+	s.inBuf = append(s.inBuf, payload)
+	s.inMu.Unlock()
+
+	signal(s.inReady)
+	return true
+}
+
+// pushLegacy thêm payload nếu buffer chưa đầy legacyStreamBuffer frames
+func (s *Stream) pushLegacy(payload []byte) bool {
+	s.inMu.Lock()
+	if len(s.inBuf) >= legacyStreamBuffer {
+		s.inMu.Unlock()
+		return false
+	}
+	s.inBuf = append(s.inBuf, payload)
+	s.inMu.Unlock()
+
+	signal(s.inReady)
+	return true
+}
+
+// pop lấy payload kế tiếp trong receive buffer
+// Flow control: trả credit cho agent khi đã tiêu thụ >= nửa window
+func (s *Stream) pop() ([]byte, bool) {
+	s.inMu.Lock()
+	if len(s.inBuf) == 0 {
+		s.inMu.Unlock()
+		return nil, false
+	}
+	data := s.inBuf[0]
+	s.inBuf[0] = nil
+	s.inBuf = s.inBuf[1:]
+
+	var increment uint32
+	if flow := s.conn.flow; flow != nil {
+		s.inConsumed += uint32(len(data))
+		if s.inConsumed >= flow.StreamWindow/2 {
+			increment = s.inConsumed
+			s.inOutstanding -= increment
+			s.inConsumed = 0
+		}
+	}
+	s.inMu.Unlock()
+
+	signal(s.inSpace)
+	if increment > 0 && !s.closed() {
+		s.conn.sendWindowUpdate(s.ID, increment)
+	}
+	return data, true
+}
+
+// closed cho biết stream đã đóng (closeCh đã close)
+func (s *Stream) closed() bool {
+	select {
+	case <-s.closeCh:
+		return true
+	default:
+		return false
+	}
+}
+
+// signal báo hiệu không chặn qua channel cap 1
+func signal(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,220 @@
+package connection
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	v1 "github.com/hydragon2m/tunnel-protocol/go/v1"
+)
+
+// flowSetup đăng ký connection của agent có CapabilityFlowControl, trả về phía agent
+func flowSetup(t *testing.T, fc FlowControl) (*Connection, net.Conn) {
+	t.Helper()
+
+	cm := NewManager(10, 30*time.Second)
+	cm.SetFlowControl(fc)
+
+	server, agent := net.Pipe()
+	t.Cleanup(func() {
+		server.Close()
+		agent.Close()
+	})
+
+	metadata := map[string]string{MetadataFeatures: `["flow_control"]`}
+	conn, err := cm.RegisterConnection("conn-1", "agent-1", &mockConn{conn: server}, metadata)
+	if err != nil {
+		t.Fatalf("RegisterConnection failed: %v", err)
+	}
+	if !conn.FlowControlEnabled() {
+		t.Fatal("Expected flow control to be enabled")
+	}
+	return conn, agent
+}
+
+func dataFrame(streamID uint32, flags uint8, payload []byte) *v1.Frame {
+	return &v1.Frame{
+		Version:  v1.Version,
+		Type:     v1.FrameData,
+		Flags:    flags,
+		StreamID: streamID,
+		Payload:  payload,
+	}
+}
+
+func windowUpdate(streamID, increment uint32) *v1.Frame {
+	payload := make([]byte, 4)
+	binary.BigEndian.PutUint32(payload, increment)
+	return dataFrame(streamID, FlagWindowUpdate, payload)
+}
+
+// decodeFrame đọc frame kế tiếp từ phía agent
+func decodeFrame(t *testing.T, agent net.Conn) *v1.Frame {
+	t.Helper()
+	agent.SetReadDeadline(time.Now().Add(2 * time.Second))
+	frame, err := v1.Decode(agent)
+	if err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+	return frame
+}
+
+func TestManager_FlowControlNegotiation(t *testing.T) {
+	cm := NewManager(10, 30*time.Second)
+	withCapability := map[string]string{MetadataFeatures: `["flow_control"]`}
+
+	if _, ok := cm.FlowControlFor(withCapability); ok {
+		t.Error("Expected flow control off when not configured")
+	}
+
+	cm.SetFlowControl(FlowControl{StreamWindow: DefaultStreamWindow, ConnectionWindow: DefaultConnectionWindow})
+	if _, ok := cm.FlowControlFor(map[string]string{MetadataFeatures: `["compression"]`}); ok {
+		t.Error("Expected flow control off for agent without capability")
+	}
+	fc, ok := cm.FlowControlFor(withCapability)
+	if !ok || fc.StreamWindow != DefaultStreamWindow || fc.ConnectionWindow != DefaultConnectionWindow {
+		t.Errorf("Unexpected negotiation result: %+v %v", fc, ok)
+	}
+}
+
+func TestConnection_SlowStreamDoesNotBlockOthers(t *testing.T) {
+	conn, agent := flowSetup(t, FlowControl{StreamWindow: 64 * 1024, ConnectionWindow: 1 << 30})
+	go io.Copy(io.Discard, agent) // Bỏ qua OpenStream frames
+
+	slow, _ := conn.OpenStream(nil)
+	fast, _ := conn.OpenStream(nil)
+
+	// Nhiều frames hơn buffer cũ (10 frames) cho stream không ai đọc
+	for i := 0; i < 50; i++ {
+		if err := v1.Encode(agent, dataFrame(slow.ID, v1.FlagNone, []byte("x"))); err != nil {
+			t.Fatalf("Encode failed: %v", err)
+		}
+	}
+
+	sent := make(chan error, 1)
+	go func() {
+		sent <- v1.Encode(agent, dataFrame(fast.ID, v1.FlagEndStream, []byte("hello")))
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	data, err := io.ReadAll(NewStreamReader(ctx, fast))
+	if err != nil || string(data) != "hello" {
+		t.Fatalf("Expected fast stream data, got %q, %v", data, err)
+	}
+	if err := <-sent; err != nil {
+		t.Fatalf("Encode failed: %v", err)
+	}
+}
+
+func TestConnection_WindowUpdateAfterRead(t *testing.T) {
+	conn, agent := flowSetup(t, FlowControl{StreamWindow: 8, ConnectionWindow: 1 << 20})
+
+	opened := make(chan *Stream, 1)
+	go func() {
+		stream, _ := conn.OpenStream(nil)
+		opened <- stream
+	}()
+	decodeFrame(t, agent) // OpenStream
+	stream := <-opened
+
+	go v1.Encode(agent, dataFrame(stream.ID, v1.FlagNone, []byte("12345678")))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	reader := NewStreamReader(ctx, stream)
+	buf := make([]byte, 8)
+	go io.ReadFull(reader, buf)
+
+	frame := decodeFrame(t, agent)
+	if frame.Flags != FlagWindowUpdate || frame.StreamID != stream.ID {
+		t.Fatalf("Expected stream window update, got type=%d flags=%d stream=%d", frame.Type, frame.Flags, frame.StreamID)
+	}
+	if inc := binary.BigEndian.Uint32(frame.Payload); inc != 8 {
+		t.Errorf("Expected increment 8, got %d", inc)
+	}
+}
+
+func TestConnection_WindowExceededResetsStream(t *testing.T) {
+	conn, agent := flowSetup(t, FlowControl{StreamWindow: 4, ConnectionWindow: 1 << 20})
+
+	opened := make(chan *Stream, 1)
+	go func() {
+		stream, _ := conn.OpenStream(nil)
+		opened <- stream
+	}()
+	decodeFrame(t, agent)
+	stream := <-opened
+
+	go v1.Encode(agent, dataFrame(stream.ID, v1.FlagNone, []byte("too much")))
+
+	frame := decodeFrame(t, agent)
+	if frame.Type != v1.FrameClose || frame.StreamID != stream.ID {
+		t.Fatalf("Expected FrameClose for stream, got type=%d stream=%d", frame.Type, frame.StreamID)
+	}
+	if _, ok := conn.GetStream(stream.ID); ok {
+		t.Error("Expected stream to be removed")
+	}
+}
+
+func TestStreamWriter_WaitsForCredit(t *testing.T) {
+	conn, agent := flowSetup(t, FlowControl{StreamWindow: 4, ConnectionWindow: 1 << 20})
+
+	opened := make(chan *Stream, 1)
+	go func() {
+		stream, _ := conn.OpenStream(nil)
+		opened <- stream
+	}()
+	decodeFrame(t, agent)
+	stream := <-opened
+
+	written := make(chan error, 1)
+	go func() {
+		_, err := NewStreamWriter(conn, stream.ID).Write([]byte("abcdefgh"))
+		written <- err
+	}()
+
+	if frame := decodeFrame(t, agent); string(frame.Payload) != "abcd" {
+		t.Fatalf("Expected first 4 bytes, got %q", frame.Payload)
+	}
+	select {
+	case <-written:
+		t.Fatal("Write should wait for window update")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	go v1.Encode(agent, windowUpdate(stream.ID, 4))
+	if frame := decodeFrame(t, agent); string(frame.Payload) != "efgh" {
+		t.Fatalf("Expected remaining bytes, got %q", frame.Payload)
+	}
+	if err := <-written; err != nil {
+		t.Errorf("Write failed: %v", err)
+	}
+}
+
+func TestStreamWriter_UnblocksOnStreamClose(t *testing.T) {
+	conn, agent := flowSetup(t, FlowControl{StreamWindow: 4, ConnectionWindow: 4})
+	go io.Copy(io.Discard, agent)
+
+	stream, _ := conn.OpenStream(nil)
+	written := make(chan error, 1)
+	go func() {
+		_, err := NewStreamWriter(conn, stream.ID).Write([]byte("abcdefgh"))
+		written <- err
+	}()
+
+	time.Sleep(20 * time.Millisecond)
+	conn.CloseStream(stream.ID)
+
+	select {
+	case err := <-written:
+		if err != ErrStreamClosed {
+			t.Errorf("Expected ErrStreamClosed, got %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Write still blocked after stream close")
+	}
+}
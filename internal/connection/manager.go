@@ -2,9 +2,13 @@ package connection
 
 import (
 	"context"
+	"errors"
+	"os"
+	"sort"
 	"sync"
 	"time"
 
+	"github.com/hydragon2m/tunnel-core/internal/metrics"
 	v1 "github.com/hydragon2m/tunnel-protocol/go/v1"
 )
 
@@ -27,6 +31,22 @@ type Connection struct {
 	cancel   context.CancelFunc
 	closed   bool
 	closedMu sync.RWMutex
+
+	// Outbound: frames chỉ được ghi bởi writeLoop
+	queue        *sendQueue
+	writeTimeout time.Duration
+
+	// Flow control (nil = agent không hỗ trợ)
+	flow        *FlowControl
+	sendWindow  *flowWindow // credit gửi cho agent của cả connection
+	recvUnacked uint32      // bytes đã nhận chưa trả credit, chỉ dùng trong frame loop
+
+	// Ping chủ động (nil = agent không hỗ trợ)
+	ping   *PingPolicy
+	pinger pinger
+
+	manager *Manager
+	metrics *metrics.Metrics
 }
 
 // Conn là interface cho network connection với timeout support
@@ -46,10 +66,20 @@ type Stream struct {
 	CreatedAt time.Time
 	Metadata  map[string]string
 
-	// Data channels
-	dataIn  chan []byte
-	dataOut chan []byte
-	closeCh chan struct{}
+	// Receive buffer (agent → server), đọc qua StreamReader
+	inMu          sync.Mutex
+	inBuf         [][]byte
+	inReady       chan struct{} // signal khi có data mới
+	inSpace       chan struct{} // signal khi buffer vừa được đọc bớt
+	inOutstanding uint32        // bytes đã nhận chưa trả credit (flow control)
+	inConsumed    uint32        // bytes đã đọc chưa gửi WINDOW_UPDATE (flow control)
+
+	sendWindow *flowWindow // credit gửi cho agent, nil = không flow control
+	closeCh    chan struct{}
+	resetCh    chan struct{} // đóng khi stream bị hủy giữa chừng (reset, connection đóng)
+	resetOnce  sync.Once
+	conn       *Connection
+	shaper     Shaper // nil = không giới hạn bandwidth
 
 	mu sync.RWMutex
 }
@@ -65,6 +95,24 @@ const (
 	StreamStateError
 )
 
+// String trả về tên state (cho admin/log)
+func (s StreamState) String() string {
+	switch s {
+	case StreamStateInit:
+		return "init"
+	case StreamStateOpen:
+		return "open"
+	case StreamStateData:
+		return "data"
+	case StreamStateClosed:
+		return "closed"
+	case StreamStateError:
+		return "error"
+	default:
+		return "unknown"
+	}
+}
+
 // Manager quản lý tất cả connections từ agents
 type Manager struct {
 	connections map[string]*Connection // agentID -> Connection
@@ -73,11 +121,19 @@ type Manager struct {
 	// Config
 	maxConnections   int
 	heartbeatTimeout time.Duration
+	draining         bool
 
 	// Callbacks
 	onConnectionClosed func(connID string)
 	onStreamCreated    func(connID string, streamID uint32)
 	onStreamClosed     func(connID string, streamID uint32)
+	onControlMessage   func(c *Connection, frame *v1.Frame) error
+
+	writeTimeout  time.Duration
+	sendQueueSize int
+	flowControl   FlowControl
+	pingPolicy    PingPolicy
+	metrics       *metrics.Metrics
 }
 
 // NewManager tạo Connection Manager mới
@@ -86,6 +142,8 @@ func NewManager(maxConnections int, heartbeatTimeout time.Duration) *Manager {
 		connections:      make(map[string]*Connection),
 		maxConnections:   maxConnections,
 		heartbeatTimeout: heartbeatTimeout,
+		writeTimeout:     DefaultWriteTimeout,
+		sendQueueSize:    DefaultSendQueueSize,
 	}
 }
 
@@ -94,6 +152,11 @@ func (m *Manager) RegisterConnection(connID, agentID string, conn Conn, metadata
 	m.connsMu.Lock()
 	defer m.connsMu.Unlock()
 
+	// Server đang shutdown
+	if m.draining {
+		return nil, ErrServerDraining
+	}
+
 	// Check max connections
 	if len(m.connections) >= m.maxConnections {
 		return nil, ErrMaxConnections
@@ -117,12 +180,24 @@ func (m *Manager) RegisterConnection(connID, agentID string, conn Conn, metadata
 		nextStreamID:  1, // Start from 1, 0 is for control
 		ctx:           ctx,
 		cancel:        cancel,
+		queue:         newSendQueue(m.sendQueueSize),
+		writeTimeout:  m.writeTimeout,
+		manager:       m,
+		metrics:       m.metrics,
+	}
+	if fc, ok := m.flowControlFor(metadata); ok {
+		c.flow = &fc
+		c.sendWindow = newFlowWindow(fc.ConnectionWindow)
+	}
+	if p, ok := m.pingPolicyFor(metadata); ok {
+		c.ping = &p
 	}
 
 	m.connections[connID] = c
 
-	// Start connection handler
+	// Start connection handler and writer
 	go m.handleConnection(c)
+	go c.writeLoop()
 
 	return c, nil
 }
@@ -136,17 +211,14 @@ func (m *Manager) GetConnection(connID string) (*Connection, bool) {
 	return conn, ok
 }
 
-// GetConnectionByAgentID lấy connection theo agent ID
+// GetConnectionByAgentID lấy connection ít tải nhất của agent (agent có thể giữ nhiều connections)
 func (m *Manager) GetConnectionByAgentID(agentID string) (*Connection, bool) {
-	m.connsMu.RLock()
-	defer m.connsMu.RUnlock()
-
-	for _, conn := range m.connections {
-		if conn.AgentID == agentID {
-			return conn, true
-		}
+	conns := m.GetConnectionsByAgentID(agentID)
+	connIDs := make([]string, 0, len(conns))
+	for _, conn := range conns {
+		connIDs = append(connIDs, conn.ID)
 	}
-	return nil, false
+	return m.PickConnection(connIDs)
 }
 
 // SetOnConnectionClosed set callback khi connection đóng
@@ -170,6 +242,22 @@ func (m *Manager) SetOnStreamClosed(callback func(connID string, streamID uint32
 	m.onStreamClosed = callback
 }
 
+// SetOnControlMessage set handler cho control messages (FrameData trên StreamID 0)
+// Handler tự gửi response qua c.SendFrame; trả error sẽ đóng connection
+func (m *Manager) SetOnControlMessage(handler func(c *Connection, frame *v1.Frame) error) {
+	m.connsMu.Lock()
+	defer m.connsMu.Unlock()
+	m.onControlMessage = handler
+}
+
+// SetMetrics set metrics cho bytes in/out và heartbeat timeouts (nil = tắt)
+// Chỉ áp dụng cho connections đăng ký sau khi set
+func (m *Manager) SetMetrics(mt *metrics.Metrics) {
+	m.connsMu.Lock()
+	defer m.connsMu.Unlock()
+	m.metrics = mt
+}
+
 // CloseConnection đóng connection và cleanup
 func (m *Manager) CloseConnection(connID string) error {
 	m.connsMu.Lock()
@@ -177,6 +265,7 @@ func (m *Manager) CloseConnection(connID string) error {
 	if exists {
 		delete(m.connections, connID)
 	}
+	onClosed := m.onConnectionClosed
 	m.connsMu.Unlock()
 
 	if !exists {
@@ -185,21 +274,32 @@ func (m *Manager) CloseConnection(connID string) error {
 
 	conn.Close()
 
-	if m.onConnectionClosed != nil {
-		m.onConnectionClosed(connID)
+	if onClosed != nil {
+		onClosed(connID)
 	}
 
 	return nil
 }
 
 // handleConnection xử lý frames từ connection
+// Khi loop kết thúc (agent đóng socket, heartbeat timeout, protocol error) connection được
+// xóa khỏi manager và onConnectionClosed được gọi để dọn tunnels/ports
 func (m *Manager) handleConnection(c *Connection) {
-	defer c.Close()
+	defer m.CloseConnection(c.ID)
 
 	// Heartbeat checker
 	ticker := time.NewTicker(m.heartbeatTimeout / 2)
 	defer ticker.Stop()
 
+	// Ping chủ động: ping đầu tiên gửi ngay để có RTT sớm
+	var pingC <-chan time.Time
+	if c.ping != nil {
+		pingTicker := time.NewTicker(c.ping.Interval)
+		defer pingTicker.Stop()
+		pingC = pingTicker.C
+		c.sendPing()
+	}
+
 	// Frame reading goroutine
 	frameCh := make(chan *v1.Frame, 10)
 	errCh := make(chan error, 1)
@@ -215,6 +315,7 @@ func (m *Manager) handleConnection(c *Connection) {
 				errCh <- err
 				return
 			}
+			c.metrics.AgentBytesReceived(c.AgentID, len(frame.Payload))
 
 			select {
 			case frameCh <- frame:
@@ -231,10 +332,17 @@ func (m *Manager) handleConnection(c *Connection) {
 
 		case <-ticker.C:
 			// Check heartbeat timeout
-			if time.Since(c.LastHeartbeat) > m.heartbeatTimeout {
+			if time.Since(c.LastHeartbeatTime()) > m.heartbeatTimeout {
+				c.metrics.HeartbeatTimeout()
 				return // Connection timeout
 			}
 
+		case <-pingC:
+			if !c.sendPing() {
+				c.metrics.HeartbeatTimeout()
+				return // Missed quá MaxMissed ping
+			}
+
 		case frame := <-frameCh:
 			// Handle frame
 			if err := m.handleFrame(c, frame); err != nil {
@@ -242,8 +350,10 @@ func (m *Manager) handleConnection(c *Connection) {
 			}
 
 		case err := <-errCh:
-			// Connection error
-			_ = err
+			// Connection error; read deadline hết hạn = không nhận được gì trong heartbeatTimeout
+			if errors.Is(err, os.ErrDeadlineExceeded) {
+				c.metrics.HeartbeatTimeout()
+			}
 			return
 		}
 	}
@@ -270,8 +380,22 @@ func (m *Manager) handleControlFrame(c *Connection, frame *v1.Frame) error {
 
 	case v1.FrameHeartbeat:
 		c.updateHeartbeat()
+		if c.ping != nil && frame.IsAck() {
+			c.handlePong(frame)
+		}
 		return nil
 
+	case v1.FrameData:
+		c.updateHeartbeat()
+		if c.flow != nil && frame.Flags&FlagWindowUpdate != 0 {
+			return c.handleWindowUpdate(frame)
+		}
+		// Control message (register/unregister tunnel, ...)
+		if m.onControlMessage == nil {
+			return ErrInvalidControlFrame
+		}
+		return m.onControlMessage(c, frame)
+
 	case v1.FrameClose:
 		// Agent muốn close connection
 		return ErrConnectionClosedByAgent
@@ -299,23 +423,28 @@ func (m *Manager) handleStreamFrame(c *Connection, frame *v1.Frame) error {
 		}
 
 	case v1.FrameData:
+		if c.flow != nil && frame.Flags&FlagWindowUpdate != 0 {
+			return c.handleWindowUpdate(frame)
+		}
+		// Tính vào window của connection kể cả khi stream đã đóng (agent đã trừ credit)
+		if err := c.receiveConnectionData(len(frame.Payload)); err != nil {
+			return err
+		}
 		if !exists {
+			if c.allocated(frame.StreamID) {
+				return nil // Stream đã bị server đóng (CloseStream), bỏ frame đến muộn
+			}
 			return ErrStreamNotFound
 		}
 		// Forward data to stream
-		select {
-		case stream.dataIn <- frame.Payload:
-		case <-stream.closeCh:
-			return ErrStreamClosed
-		case <-c.ctx.Done():
-			return c.ctx.Err()
+		if err := c.deliver(stream, frame.Payload); err != nil {
+			return err
 		}
 
 		// Check EndStream flag
 		if frame.IsEndStream() {
 			stream.setState(StreamStateClosed)
-			c.closeStream(frame.StreamID)
-			if m.onStreamClosed != nil {
+			if c.closeStream(frame.StreamID) && m.onStreamClosed != nil {
 				m.onStreamClosed(c.ID, frame.StreamID)
 			}
 		}
@@ -324,9 +453,11 @@ func (m *Manager) handleStreamFrame(c *Connection, frame *v1.Frame) error {
 		if !exists {
 			return nil // Already closed
 		}
+		if frame.IsError() {
+			stream.abort()
+		}
 		stream.setState(StreamStateClosed)
-		c.closeStream(frame.StreamID)
-		if m.onStreamClosed != nil {
+		if c.closeStream(frame.StreamID) && m.onStreamClosed != nil {
 			m.onStreamClosed(c.ID, frame.StreamID)
 		}
 
@@ -347,27 +478,98 @@ func (c *Connection) createStream(streamID uint32) *Stream {
 		State:     StreamStateInit,
 		CreatedAt: time.Now(),
 		Metadata:  make(map[string]string),
-		dataIn:    make(chan []byte, 10),
-		dataOut:   make(chan []byte, 10),
+		inReady:   make(chan struct{}, 1),
+		inSpace:   make(chan struct{}, 1),
 		closeCh:   make(chan struct{}),
+		resetCh:   make(chan struct{}),
+		conn:      c,
+	}
+	if c.flow != nil {
+		stream.sendWindow = newFlowWindow(c.flow.StreamWindow)
 	}
 
 	c.streams[streamID] = stream
 	return stream
 }
 
-// closeStream đóng stream và cleanup
-func (c *Connection) closeStream(streamID uint32) {
+// closeStream đóng stream và cleanup, trả về false nếu stream đã đóng trước đó
+func (c *Connection) closeStream(streamID uint32) bool {
 	c.streamsMu.Lock()
 	defer c.streamsMu.Unlock()
 
 	stream, exists := c.streams[streamID]
 	if !exists {
-		return
+		return false
 	}
 
 	close(stream.closeCh)
 	delete(c.streams, streamID)
+	return true
+}
+
+// CloseStream đóng stream phía server khi đã xong việc: xóa stream khỏi connection và
+// gửi FrameClose để agent giải phóng phía của nó
+// No-op nếu stream đã đóng (vd. agent đã gửi EndStream hoặc FrameClose)
+func (c *Connection) CloseStream(streamID uint32) error {
+	return c.terminateStream(streamID, v1.FlagNone)
+}
+
+// ResetStream hủy stream giữa chừng (client ngắt kết nối, timeout, lỗi): giống CloseStream
+// nhưng FrameClose mang FlagError để agent hủy ngay request upstream thay vì chờ hoàn tất
+func (c *Connection) ResetStream(streamID uint32) error {
+	return c.terminateStream(streamID, v1.FlagError)
+}
+
+func (c *Connection) terminateStream(streamID uint32, flags uint8) error {
+	stream, ok := c.GetStream(streamID)
+	if !ok {
+		return nil
+	}
+	if flags&v1.FlagError != 0 {
+		stream.abort()
+	}
+	if !c.closeStream(streamID) {
+		return nil
+	}
+	if c.manager != nil && c.manager.onStreamClosed != nil {
+		c.manager.onStreamClosed(c.ID, streamID)
+	}
+
+	return c.SendFrame(&v1.Frame{
+		Version:  v1.Version,
+		Type:     v1.FrameClose,
+		Flags:    flags,
+		StreamID: streamID,
+	})
+}
+
+// allocated kiểm tra stream ID đã từng được server cấp phát (stream đã mở trước đó)
+func (c *Connection) allocated(streamID uint32) bool {
+	c.streamsMu.RLock()
+	defer c.streamsMu.RUnlock()
+	return streamID < c.nextStreamID
+}
+
+// OpenStream cấp phát stream ID mới, tạo stream phía server và gửi FrameOpenStream đến agent
+func (c *Connection) OpenStream(payload []byte) (*Stream, error) {
+	streamID := c.AllocateStreamID()
+	stream := c.createStream(streamID)
+
+	openFrame := &v1.Frame{
+		Version:  v1.Version,
+		Type:     v1.FrameOpenStream,
+		Flags:    v1.FlagNone,
+		StreamID: streamID,
+		Payload:  payload,
+	}
+
+	if err := c.SendFrame(openFrame); err != nil {
+		c.closeStream(streamID)
+		return nil, err
+	}
+
+	stream.setState(StreamStateOpen)
+	return stream, nil
 }
 
 // GetStream lấy stream theo ID
@@ -389,7 +591,8 @@ func (c *Connection) AllocateStreamID() uint32 {
 	return streamID
 }
 
-// SendFrame gửi frame đến agent
+// SendFrame đưa frame vào outbound queue và chờ writer goroutine ghi xong
+// Trả ErrSendQueueFull ngay khi queue đầy (caller tự quyết định retry hoặc đóng stream)
 func (c *Connection) SendFrame(frame *v1.Frame) error {
 	c.closedMu.RLock()
 	if c.closed {
@@ -398,7 +601,18 @@ func (c *Connection) SendFrame(frame *v1.Frame) error {
 	}
 	c.closedMu.RUnlock()
 
-	return v1.Encode(c.Conn, frame)
+	done := make(chan error, 1)
+	if err := c.queue.push(&outFrame{frame: frame, done: done}); err != nil {
+		return err
+	}
+	// Luôn có kết quả: writeLoop trả lỗi ghi, hoặc queue.close trả ErrConnectionClosed khi
+	// connection đóng. Không chọn ctx.Done ở đây vì frame có thể vừa ghi xong trước khi đóng
+	return <-done
+}
+
+// sendFrameAsync đưa frame vào outbound queue không chờ ghi (lỗi bỏ qua)
+func (c *Connection) sendFrameAsync(frame *v1.Frame) {
+	c.queue.push(&outFrame{frame: frame})
 }
 
 // Close đóng connection
@@ -413,12 +627,18 @@ func (c *Connection) Close() error {
 
 	c.cancel()
 
-	// Close all streams
-	c.streamsMu.Lock()
-	for streamID := range c.streams {
-		c.closeStream(streamID)
+	// Close all streams (copy trước vì closeStream tự lock streamsMu)
+	c.streamsMu.RLock()
+	streams := make([]*Stream, 0, len(c.streams))
+	for _, stream := range c.streams {
+		streams = append(streams, stream)
+	}
+	c.streamsMu.RUnlock()
+
+	for _, stream := range streams {
+		stream.abort()
+		c.closeStream(stream.ID)
 	}
-	c.streamsMu.Unlock()
 
 	return c.Conn.Close()
 }
@@ -430,6 +650,26 @@ func (c *Connection) updateHeartbeat() {
 	c.LastHeartbeat = time.Now()
 }
 
+// LastHeartbeatTime trả về thời điểm nhận heartbeat gần nhất
+func (c *Connection) LastHeartbeatTime() time.Time {
+	c.closedMu.RLock()
+	defer c.closedMu.RUnlock()
+	return c.LastHeartbeat
+}
+
+// Streams trả về snapshot các streams đang mở, sort theo ID
+func (c *Connection) Streams() []*Stream {
+	c.streamsMu.RLock()
+	streams := make([]*Stream, 0, len(c.streams))
+	for _, s := range c.streams {
+		streams = append(streams, s)
+	}
+	c.streamsMu.RUnlock()
+
+	sort.Slice(streams, func(i, j int) bool { return streams[i].ID < streams[j].ID })
+	return streams
+}
+
 // setState set state của stream
 func (s *Stream) setState(state StreamState) {
 	s.mu.Lock()
@@ -437,6 +677,51 @@ func (s *Stream) setState(state StreamState) {
 	s.State = state
 }
 
+// Shaper giới hạn bandwidth data của stream (vd. *quota.Shaper), chờ thay vì drop
+type Shaper interface {
+	WaitInbound(ctx context.Context, n int) error  // agent → server
+	WaitOutbound(ctx context.Context, n int) error // server → agent
+}
+
+// SetShaper gắn bandwidth shaper cho data của stream (StreamReader/StreamWriter)
+func (s *Stream) SetShaper(shaper Shaper) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.shaper = shaper
+}
+
+func (s *Stream) getShaper() Shaper {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.shaper
+}
+
+// abort đánh dấu stream bị hủy giữa chừng (gọi trước khi đóng closeCh)
+func (s *Stream) abort() {
+	s.resetOnce.Do(func() { close(s.resetCh) })
+}
+
+// waitShaper chờ bandwidth qua wait (WaitInbound/WaitOutbound), dừng với ErrStreamClosed
+// khi done đóng (stream đóng/bị reset) thay vì chờ hết rồi mới phát hiện
+func waitShaper(ctx context.Context, done <-chan struct{}, wait func(context.Context, int) error, n int) error {
+	select {
+	case <-done:
+		return ErrStreamClosed
+	default:
+	}
+
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+	go func() {
+		select {
+		case <-done:
+			cancel(ErrStreamClosed)
+		case <-ctx.Done():
+		}
+	}()
+	return wait(ctx, n)
+}
+
 // GetState lấy state của stream
 func (s *Stream) GetState() StreamState {
 	s.mu.RLock()
@@ -444,11 +729,6 @@ func (s *Stream) GetState() StreamState {
 	return s.State
 }
 
-// DataIn returns the data input channel
-func (s *Stream) DataIn() <-chan []byte {
-	return s.dataIn
-}
-
 // CloseCh returns the close channel
 func (s *Stream) CloseCh() <-chan struct{} {
 	return s.closeCh
@@ -2,10 +2,12 @@ package connection
 
 import (
 	"net"
+	"strings"
 	"sync"
 	"testing"
 	"time"
 
+	"github.com/hydragon2m/tunnel-core/internal/metrics"
 	v1 "github.com/hydragon2m/tunnel-protocol/go/v1"
 )
 
@@ -397,3 +399,78 @@ func TestConnection_SendFrame(t *testing.T) {
 	}
 }
 
+
+func TestConnectionManager_AgentDisconnect(t *testing.T) {
+	cm := NewManager(100, 30*time.Second)
+
+	closed := make(chan string, 1)
+	cm.SetOnConnectionClosed(func(connID string) {
+		closed <- connID
+	})
+
+	conn1, conn2 := net.Pipe()
+	defer conn1.Close()
+
+	if _, err := cm.RegisterConnection("conn-1", "agent-1", &mockConn{conn: conn1}, nil); err != nil {
+		t.Fatalf("RegisterConnection failed: %v", err)
+	}
+
+	// Agent đóng socket (không gửi FrameClose)
+	conn2.Close()
+
+	select {
+	case connID := <-closed:
+		if connID != "conn-1" {
+			t.Errorf("Expected callback for 'conn-1', got '%s'", connID)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected onConnectionClosed after agent disconnect")
+	}
+
+	if _, ok := cm.GetConnection("conn-1"); ok {
+		t.Error("Expected connection to be removed after agent disconnect")
+	}
+}
+
+func TestConnectionManager_Metrics(t *testing.T) {
+	m := metrics.New()
+	cm := NewManager(10, 100*time.Millisecond)
+	cm.SetMetrics(m)
+
+	server, agent := net.Pipe()
+	defer server.Close()
+	defer agent.Close()
+
+	conn, err := cm.RegisterConnection("conn-1", "agent-1", &mockConn{conn: server}, nil)
+	if err != nil {
+		t.Fatalf("RegisterConnection failed: %v", err)
+	}
+
+	go func() {
+		// Agent đọc 1 frame, gửi 1 frame rồi im lặng đến khi heartbeat timeout
+		v1.Decode(agent)
+		v1.Encode(agent, &v1.Frame{Version: v1.Version, Type: v1.FrameHeartbeat, Payload: []byte("ping")})
+	}()
+
+	if err := conn.SendFrame(&v1.Frame{Version: v1.Version, Type: v1.FrameData, StreamID: 0, Payload: []byte("hello")}); err != nil {
+		t.Fatalf("SendFrame failed: %v", err)
+	}
+
+	select {
+	case <-conn.Context().Done():
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected connection to close on heartbeat timeout")
+	}
+
+	var b strings.Builder
+	m.Registry().WriteTo(&b)
+	for _, line := range []string{
+		`tunnel_agent_sent_bytes_total{agent_id="agent-1"} 5`,
+		`tunnel_agent_received_bytes_total{agent_id="agent-1"} 4`,
+		"tunnel_heartbeat_timeouts_total 1",
+	} {
+		if !strings.Contains(b.String(), line+"\n") {
+			t.Errorf("Expected %q in metrics:\n%s", line, b.String())
+		}
+	}
+}
@@ -0,0 +1,152 @@
+package connection
+
+import (
+	"encoding/binary"
+	"sync"
+	"time"
+
+	v1 "github.com/hydragon2m/tunnel-protocol/go/v1"
+)
+
+// CapabilityPing là capability agent khai báo trong AuthRequest khi trả lời ping của server
+// Ping = FrameHeartbeat (StreamID 0) với payload 8 bytes sequence big-endian;
+// agent trả lời FrameHeartbeat với FlagAck và payload giữ nguyên
+const CapabilityPing = "ping"
+
+const (
+	// DefaultPingInterval là khoảng cách mặc định giữa 2 ping
+	DefaultPingInterval = 5 * time.Second
+	// DefaultPingMaxMissed là số ping liên tiếp không được ack mặc định trước khi đóng connection
+	DefaultPingMaxMissed = 3
+
+	pingPayloadSize = 8
+)
+
+// PingPolicy là cấu hình ping chủ động của server (zero value = tắt)
+type PingPolicy struct {
+	Interval  time.Duration // khoảng cách giữa 2 ping, ping chưa được ack khi đến lượt kế tiếp = missed
+	MaxMissed int           // số ping missed liên tiếp trước khi đóng connection
+}
+
+// SetPingPolicy bật ping cho agents đã thỏa thuận CapabilityPing
+// Chỉ áp dụng cho connections đăng ký sau khi set
+func (m *Manager) SetPingPolicy(p PingPolicy) {
+	m.connsMu.Lock()
+	defer m.connsMu.Unlock()
+	m.pingPolicy = p
+}
+
+// PingPolicyFor trả về ping policy sẽ áp dụng cho agent với metadata từ handshake
+// ok = false nếu server tắt ping hoặc agent không hỗ trợ (chỉ dùng heartbeat timeout thụ động)
+func (m *Manager) PingPolicyFor(metadata map[string]string) (PingPolicy, bool) {
+	m.connsMu.RLock()
+	defer m.connsMu.RUnlock()
+	return m.pingPolicyFor(metadata)
+}
+
+func (m *Manager) pingPolicyFor(metadata map[string]string) (PingPolicy, bool) {
+	p := m.pingPolicy
+	if p.Interval <= 0 || p.MaxMissed <= 0 {
+		return PingPolicy{}, false
+	}
+	return p, hasFeature(metadata, CapabilityPing)
+}
+
+// pinger là trạng thái ping và RTT đo được của connection
+type pinger struct {
+	mu      sync.Mutex
+	seq     uint64
+	sentAt  time.Time
+	pending bool // ping hiện tại chưa được ack
+	missed  int
+	rtt     time.Duration // smoothed RTT
+	jitter  time.Duration // độ lệch trung bình của RTT
+}
+
+// sendPing gửi ping mới qua control queue (không chờ sau data frames)
+// Trả false khi đã missed đủ MaxMissed ping liên tiếp (caller đóng connection)
+func (c *Connection) sendPing() bool {
+	p := &c.pinger
+	p.mu.Lock()
+	if p.pending {
+		p.missed++
+		if p.missed >= c.ping.MaxMissed {
+			p.mu.Unlock()
+			return false
+		}
+	}
+	p.seq++
+	p.sentAt = time.Now()
+	p.pending = true
+	seq := p.seq
+	p.mu.Unlock()
+
+	payload := make([]byte, pingPayloadSize)
+	binary.BigEndian.PutUint64(payload, seq)
+	c.sendFrameAsync(&v1.Frame{
+		Version:  v1.Version,
+		Type:     v1.FrameHeartbeat,
+		Flags:    v1.FlagNone,
+		StreamID: v1.StreamIDControl,
+		Payload:  payload,
+	})
+	return true
+}
+
+// handlePong xử lý ack của ping, cập nhật RTT/jitter theo RFC 6298 (SRTT/RTTVAR)
+// Ack đến muộn (ping cũ) hoặc payload lạ được bỏ qua
+func (c *Connection) handlePong(frame *v1.Frame) {
+	if len(frame.Payload) != pingPayloadSize {
+		return
+	}
+	seq := binary.BigEndian.Uint64(frame.Payload)
+
+	p := &c.pinger
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !p.pending || seq != p.seq {
+		return
+	}
+	sample := time.Since(p.sentAt)
+	p.pending = false
+	p.missed = 0
+
+	if p.rtt == 0 {
+		p.rtt = sample
+		p.jitter = sample / 2
+		return
+	}
+	diff := p.rtt - sample
+	if diff < 0 {
+		diff = -diff
+	}
+	p.jitter = (3*p.jitter + diff) / 4
+	p.rtt = (7*p.rtt + sample) / 8
+}
+
+// RTT trả về round-trip time đã làm mượt (0 = chưa đo được, agent không hỗ trợ ping)
+func (c *Connection) RTT() time.Duration {
+	c.pinger.mu.Lock()
+	defer c.pinger.mu.Unlock()
+	return c.pinger.rtt
+}
+
+// Jitter trả về độ dao động trung bình của RTT (0 = chưa đo được)
+func (c *Connection) Jitter() time.Duration {
+	c.pinger.mu.Lock()
+	defer c.pinger.mu.Unlock()
+	return c.pinger.jitter
+}
+
+// MissedPings trả về số ping liên tiếp chưa được ack
+func (c *Connection) MissedPings() int {
+	c.pinger.mu.Lock()
+	defer c.pinger.mu.Unlock()
+	return c.pinger.missed
+}
+
+// PingEnabled cho biết server có ping connection không
+func (c *Connection) PingEnabled() bool {
+	return c.ping != nil
+}
@@ -0,0 +1,95 @@
+package connection
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	v1 "github.com/hydragon2m/tunnel-protocol/go/v1"
+)
+
+// pingSetup đăng ký connection của agent có CapabilityPing, trả về phía agent
+func pingSetup(t *testing.T, policy PingPolicy, capabilities string) (*Manager, *Connection, net.Conn) {
+	t.Helper()
+
+	cm := NewManager(10, 30*time.Second)
+	cm.SetPingPolicy(policy)
+
+	server, agent := net.Pipe()
+	t.Cleanup(func() {
+		server.Close()
+		agent.Close()
+	})
+
+	metadata := map[string]string{MetadataFeatures: capabilities}
+	conn, err := cm.RegisterConnection("conn-1", "agent-1", &mockConn{conn: server}, metadata)
+	if err != nil {
+		t.Fatalf("RegisterConnection failed: %v", err)
+	}
+	return cm, conn, agent
+}
+
+func TestConnection_PingMeasuresRTT(t *testing.T) {
+	_, conn, agent := pingSetup(t, PingPolicy{Interval: 20 * time.Millisecond, MaxMissed: 3}, `["ping"]`)
+
+	// Agent ack 3 ping đầu tiên
+	for i := 0; i < 3; i++ {
+		ping := decodeFrame(t, agent)
+		if ping.Type != v1.FrameHeartbeat || ping.IsAck() || len(ping.Payload) != pingPayloadSize {
+			t.Fatalf("Expected ping frame, got type=%d flags=%d len=%d", ping.Type, ping.Flags, len(ping.Payload))
+		}
+		time.Sleep(5 * time.Millisecond)
+		ping.Flags = v1.FlagAck
+		if err := v1.Encode(agent, ping); err != nil {
+			t.Fatalf("Encode failed: %v", err)
+		}
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for conn.RTT() == 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if rtt := conn.RTT(); rtt < 5*time.Millisecond || rtt > time.Second {
+		t.Errorf("Unexpected RTT %v", rtt)
+	}
+	if conn.MissedPings() != 0 {
+		t.Errorf("Expected no missed pings, got %d", conn.MissedPings())
+	}
+}
+
+func TestConnection_MissedPingsCloseConnection(t *testing.T) {
+	cm, conn, agent := pingSetup(t, PingPolicy{Interval: 20 * time.Millisecond, MaxMissed: 2}, `["ping"]`)
+
+	// Agent nhận nhưng không ack
+	go func() {
+		for {
+			if _, err := v1.Decode(agent); err != nil {
+				return
+			}
+		}
+	}()
+
+	select {
+	case <-conn.Context().Done():
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected connection to be closed after missed pings")
+	}
+	if _, ok := cm.GetConnection("conn-1"); ok {
+		t.Error("Expected connection to be removed")
+	}
+}
+
+func TestConnection_NoPingWithoutCapability(t *testing.T) {
+	_, conn, agent := pingSetup(t, PingPolicy{Interval: 10 * time.Millisecond, MaxMissed: 1}, `["flow_control"]`)
+	if conn.PingEnabled() {
+		t.Fatal("Expected ping disabled for agent without capability")
+	}
+
+	agent.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	if frame, err := v1.Decode(agent); err == nil {
+		t.Errorf("Expected no frames, got type=%d", frame.Type)
+	}
+	if conn.Context().Err() != nil {
+		t.Error("Expected legacy connection to stay open")
+	}
+}
@@ -0,0 +1,31 @@
+package connection
+
+import (
+	"context"
+	"io"
+)
+
+// Pipe copy bytes 2 chiều giữa rw và stream, return khi phía agent kết thúc
+// EOF từ rw → gửi EndStream cho agent; lỗi đọc rw (vd. client RST) → reset stream
+// (FrameClose + FlagError) và dừng chiều agent → rw ngay. Caller đóng rw sau khi Pipe return
+func Pipe(ctx context.Context, rw io.ReadWriter, conn *Connection, stream *Stream) {
+	writer := NewStreamWriter(conn, stream.ID)
+
+	// agent → rw
+	agentDone := make(chan struct{})
+	go func() {
+		defer close(agentDone)
+		io.Copy(rw, NewStreamReader(ctx, stream))
+	}()
+
+	// rw → agent (kết thúc khi caller đóng rw)
+	go func() {
+		if _, err := io.Copy(writer, rw); err != nil {
+			conn.ResetStream(stream.ID)
+			return
+		}
+		writer.CloseWrite()
+	}()
+
+	<-agentDone
+}
@@ -0,0 +1,65 @@
+package connection
+
+import (
+	"context"
+	"io"
+)
+
+// StreamReader đọc data frames của stream như 1 io.Reader liên tục
+// Trả io.EOF khi stream đóng (sau khi đã đọc hết data còn trong buffer)
+type StreamReader struct {
+	ctx    context.Context
+	stream *Stream
+	buf    []byte
+}
+
+// NewStreamReader tạo reader cho stream, dừng khi ctx bị cancel
+func NewStreamReader(ctx context.Context, stream *Stream) *StreamReader {
+	return &StreamReader{
+		ctx:    ctx,
+		stream: stream,
+	}
+}
+
+// Read implements io.Reader
+// Stream có Shaper: chờ bandwidth trước khi trả data (agent bị chậm lại qua flow control)
+func (r *StreamReader) Read(p []byte) (int, error) {
+	for len(r.buf) == 0 {
+		data, err := r.next()
+		if err != nil {
+			return 0, err
+		}
+		if shaper := r.stream.getShaper(); shaper != nil {
+			// Stream kết thúc bình thường vẫn bị giới hạn; stream bị reset/connection đóng → dừng chờ ngay
+			if err := waitShaper(r.ctx, r.stream.resetCh, shaper.WaitInbound, len(data)); err != nil {
+				return 0, err
+			}
+		}
+		r.buf = data
+	}
+
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+// next lấy payload kế tiếp, ưu tiên data đã buffer trước khi báo EOF
+func (r *StreamReader) next() ([]byte, error) {
+	for {
+		if data, ok := r.stream.pop(); ok {
+			return data, nil
+		}
+
+		select {
+		case <-r.stream.inReady:
+		case <-r.stream.closeCh:
+			// Frame cuối (EndStream) được đẩy vào buffer trước khi closeCh đóng
+			if data, ok := r.stream.pop(); ok {
+				return data, nil
+			}
+			return nil, io.EOF
+		case <-r.ctx.Done():
+			return nil, context.Cause(r.ctx)
+		}
+	}
+}
@@ -0,0 +1,195 @@
+package connection
+
+import (
+	"bufio"
+	"fmt"
+	"sync"
+	"time"
+
+	v1 "github.com/hydragon2m/tunnel-protocol/go/v1"
+)
+
+const (
+	// DefaultWriteTimeout là deadline mặc định cho mỗi lần ghi frame ra agent
+	DefaultWriteTimeout = 10 * time.Second
+	// DefaultSendQueueSize là số data frames tối đa chờ ghi trên 1 connection
+	DefaultSendQueueSize = 1024
+
+	writeBufferSize = 64 * 1024
+)
+
+// outFrame là frame chờ writer goroutine ghi ra agent
+type outFrame struct {
+	frame *v1.Frame
+	done  chan error // nil = không chờ kết quả (vd. WINDOW_UPDATE)
+}
+
+// sendQueue là outbound queue của connection
+// Control frames (StreamID 0, WINDOW_UPDATE) luôn được ghi trước; data frames round-robin
+// giữa các streams, mỗi lượt 1 frame, thứ tự frames trong cùng stream giữ nguyên
+type sendQueue struct {
+	mu      sync.Mutex
+	control []*outFrame
+	streams map[uint32][]*outFrame
+	order   []uint32 // streams đang có frames chờ, theo lượt round-robin
+	queued  int      // số data frames đang chờ
+	limit   int
+	closed  bool
+	ready   chan struct{} // signal khi có frame mới
+}
+
+func newSendQueue(limit int) *sendQueue {
+	return &sendQueue{
+		streams: make(map[uint32][]*outFrame),
+		limit:   limit,
+		ready:   make(chan struct{}, 1),
+	}
+}
+
+// isControl cho biết frame được ưu tiên (không chờ sau data, không tính vào limit)
+func isControl(frame *v1.Frame) bool {
+	return frame.StreamID == v1.StreamIDControl ||
+		(frame.Type == v1.FrameData && frame.Flags&FlagWindowUpdate != 0 && len(frame.Payload) == windowUpdateSize)
+}
+
+// push thêm frame vào queue, trả ErrSendQueueFull khi data frames đã đầy
+func (q *sendQueue) push(f *outFrame) error {
+	q.mu.Lock()
+	if q.closed {
+		q.mu.Unlock()
+		return ErrConnectionClosed
+	}
+
+	if isControl(f.frame) {
+		q.control = append(q.control, f)
+	} else {
+		if q.limit > 0 && q.queued >= q.limit {
+			q.mu.Unlock()
+			return ErrSendQueueFull
+		}
+		id := f.frame.StreamID
+		if len(q.streams[id]) == 0 {
+			q.order = append(q.order, id)
+		}
+		q.streams[id] = append(q.streams[id], f)
+		q.queued++
+	}
+	q.mu.Unlock()
+
+	signal(q.ready)
+	return nil
+}
+
+// pop lấy frame kế tiếp cần ghi, nil nếu queue rỗng
+func (q *sendQueue) pop() *outFrame {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if len(q.control) > 0 {
+		f := q.control[0]
+		q.control[0] = nil
+		q.control = q.control[1:]
+		return f
+	}
+	if len(q.order) == 0 {
+		return nil
+	}
+
+	id := q.order[0]
+	q.order = q.order[1:]
+	frames := q.streams[id]
+	f := frames[0]
+	frames[0] = nil
+	if frames = frames[1:]; len(frames) > 0 {
+		q.streams[id] = frames
+		q.order = append(q.order, id) // Stream còn frames → xuống cuối lượt
+	} else {
+		delete(q.streams, id)
+	}
+	q.queued--
+	return f
+}
+
+// close từ chối frames mới và báo lỗi cho các frames còn chờ
+func (q *sendQueue) close(err error) {
+	q.mu.Lock()
+	q.closed = true
+	pending := q.control
+	for _, id := range q.order {
+		pending = append(pending, q.streams[id]...)
+	}
+	q.control, q.order, q.queued = nil, nil, 0
+	q.streams = make(map[uint32][]*outFrame)
+	q.mu.Unlock()
+
+	for _, f := range pending {
+		if f.done != nil {
+			f.done <- err
+		}
+	}
+}
+
+// writeLoop là writer goroutine duy nhất ghi frames ra agent
+// Lỗi ghi (kể cả hết write deadline) đóng connection; frame không hợp lệ chỉ trả lỗi cho caller
+func (c *Connection) writeLoop() {
+	defer c.queue.close(ErrConnectionClosed)
+
+	w := bufio.NewWriterSize(c.Conn, writeBufferSize)
+	for {
+		f := c.queue.pop()
+		if f == nil {
+			select {
+			case <-c.queue.ready:
+				continue
+			case <-c.ctx.Done():
+				return
+			}
+		}
+
+		err := c.writeFrame(w, f.frame)
+		_, invalid := v1.IsProtocolError(err)
+		if err != nil && !invalid {
+			// Caller thấy ErrConnectionClosed, vẫn giữ lỗi gốc (vd. os.ErrDeadlineExceeded)
+			err = fmt.Errorf("%w: %w", ErrConnectionClosed, err)
+		}
+		if f.done != nil {
+			f.done <- err
+		}
+		if err != nil && !invalid {
+			c.Close()
+			return
+		}
+	}
+}
+
+// writeFrame encode frame vào buffer rồi flush 1 lần, tránh frame bị ghi từng mảnh
+func (c *Connection) writeFrame(w *bufio.Writer, frame *v1.Frame) error {
+	if c.writeTimeout > 0 {
+		c.Conn.SetWriteDeadline(time.Now().Add(c.writeTimeout))
+	}
+	// Encode validate frame trước khi ghi byte nào nên lỗi validate không làm bẩn buffer
+	if err := v1.Encode(w, frame); err != nil {
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	c.metrics.AgentBytesSent(c.AgentID, len(frame.Payload))
+	return nil
+}
+
+// SetWriteTimeout set deadline cho mỗi lần ghi frame (0 = không giới hạn)
+// Chỉ áp dụng cho connections đăng ký sau khi set
+func (m *Manager) SetWriteTimeout(d time.Duration) {
+	m.connsMu.Lock()
+	defer m.connsMu.Unlock()
+	m.writeTimeout = d
+}
+
+// SetSendQueueSize set số data frames tối đa chờ ghi trên mỗi connection (0 = không giới hạn)
+// Chỉ áp dụng cho connections đăng ký sau khi set
+func (m *Manager) SetSendQueueSize(n int) {
+	m.connsMu.Lock()
+	defer m.connsMu.Unlock()
+	m.sendQueueSize = n
+}
@@ -0,0 +1,135 @@
+package connection
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	v1 "github.com/hydragon2m/tunnel-protocol/go/v1"
+)
+
+func TestSendQueue_ControlFirstAndRoundRobin(t *testing.T) {
+	q := newSendQueue(0)
+	for _, f := range []*v1.Frame{
+		dataFrame(1, v1.FlagNone, []byte("1a")),
+		dataFrame(1, v1.FlagNone, []byte("1b")),
+		dataFrame(1, v1.FlagEndStream, []byte("1c")),
+		dataFrame(2, v1.FlagNone, []byte("2a")),
+		dataFrame(v1.StreamIDControl, v1.FlagNone, []byte("ctl")),
+		windowUpdate(3, 100),
+	} {
+		if err := q.push(&outFrame{frame: f}); err != nil {
+			t.Fatalf("push failed: %v", err)
+		}
+	}
+
+	var got []string
+	for f := q.pop(); f != nil; f = q.pop() {
+		if f.frame.Flags == FlagWindowUpdate {
+			got = append(got, fmt.Sprintf("wu%d", f.frame.StreamID))
+			continue
+		}
+		got = append(got, string(f.frame.Payload))
+	}
+
+	want := []string{"ctl", "wu3", "1a", "2a", "1b", "1c"}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("Write order = %v, want %v", got, want)
+	}
+}
+
+func TestSendQueue_FullReturnsBackpressure(t *testing.T) {
+	q := newSendQueue(2)
+	q.push(&outFrame{frame: dataFrame(1, v1.FlagNone, []byte("a"))})
+	q.push(&outFrame{frame: dataFrame(2, v1.FlagNone, []byte("b"))})
+
+	if err := q.push(&outFrame{frame: dataFrame(1, v1.FlagNone, []byte("c"))}); err != ErrSendQueueFull {
+		t.Errorf("Expected ErrSendQueueFull, got %v", err)
+	}
+	// Control frames không bị giới hạn
+	if err := q.push(&outFrame{frame: dataFrame(v1.StreamIDControl, v1.FlagNone, nil)}); err != nil {
+		t.Errorf("Control frame should bypass the limit, got %v", err)
+	}
+
+	q.pop()
+	q.pop()
+	if err := q.push(&outFrame{frame: dataFrame(1, v1.FlagNone, []byte("c"))}); err != nil {
+		t.Errorf("Expected room after pop, got %v", err)
+	}
+}
+
+func TestConnection_ConcurrentSendFrameNoInterleaving(t *testing.T) {
+	cm := NewManager(10, 30*time.Second)
+	server, agent := net.Pipe()
+	defer server.Close()
+	defer agent.Close()
+
+	conn, err := cm.RegisterConnection("conn-1", "agent-1", &mockConn{conn: server}, nil)
+	if err != nil {
+		t.Fatalf("RegisterConnection failed: %v", err)
+	}
+
+	const senders, perSender = 20, 50
+	var wg sync.WaitGroup
+	for i := 1; i <= senders; i++ {
+		wg.Add(1)
+		go func(streamID uint32) {
+			defer wg.Done()
+			for j := 0; j < perSender; j++ {
+				payload := []byte(fmt.Sprintf("stream-%d-frame-%d", streamID, j))
+				if err := conn.SendFrame(dataFrame(streamID, v1.FlagNone, payload)); err != nil {
+					t.Errorf("SendFrame failed: %v", err)
+					return
+				}
+			}
+		}(uint32(i))
+	}
+
+	next := make(map[uint32]int)
+	for n := 0; n < senders*perSender; n++ {
+		frame := decodeFrame(t, agent)
+		want := fmt.Sprintf("stream-%d-frame-%d", frame.StreamID, next[frame.StreamID])
+		if string(frame.Payload) != want {
+			t.Fatalf("Frame %d payload = %q, want %q", n, frame.Payload, want)
+		}
+		next[frame.StreamID]++
+	}
+	wg.Wait()
+}
+
+func TestConnection_WriteTimeoutClosesConnection(t *testing.T) {
+	cm := NewManager(10, 30*time.Second)
+	cm.SetWriteTimeout(50 * time.Millisecond)
+	server, agent := net.Pipe()
+	defer server.Close()
+	defer agent.Close()
+
+	closed := make(chan string, 1)
+	cm.SetOnConnectionClosed(func(connID string) { closed <- connID })
+	conn, err := cm.RegisterConnection("conn-1", "agent-1", &mockConn{conn: server}, nil)
+	if err != nil {
+		t.Fatalf("RegisterConnection failed: %v", err)
+	}
+
+	// Agent không đọc
+	err = conn.SendFrame(dataFrame(1, v1.FlagNone, []byte("stuck")))
+	if !errors.Is(err, os.ErrDeadlineExceeded) || !errors.Is(err, ErrConnectionClosed) {
+		t.Fatalf("Expected write deadline error, got %v", err)
+	}
+
+	select {
+	case id := <-closed:
+		if id != "conn-1" {
+			t.Errorf("Unexpected closed connection %q", id)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected connection to be closed after write timeout")
+	}
+	if err := conn.SendFrame(dataFrame(1, v1.FlagNone, nil)); err != ErrConnectionClosed {
+		t.Errorf("Expected ErrConnectionClosed after close, got %v", err)
+	}
+}
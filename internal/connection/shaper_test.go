@@ -0,0 +1,122 @@
+package connection
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	v1 "github.com/hydragon2m/tunnel-protocol/go/v1"
+)
+
+// blockingShaper chặn mọi lần chờ bandwidth đến khi ctx bị cancel (bandwidth cạn)
+type blockingShaper struct {
+	waiting chan struct{}
+}
+
+func (s *blockingShaper) WaitInbound(ctx context.Context, n int) error  { return s.wait(ctx) }
+func (s *blockingShaper) WaitOutbound(ctx context.Context, n int) error { return s.wait(ctx) }
+
+func (s *blockingShaper) wait(ctx context.Context) error {
+	s.waiting <- struct{}{}
+	<-ctx.Done()
+	return context.Cause(ctx)
+}
+
+// shaperSetup mở stream có blockingShaper, trả về phía agent (đã đọc FrameOpenStream)
+func shaperSetup(t *testing.T) (*Connection, *Stream, *blockingShaper, net.Conn) {
+	t.Helper()
+
+	cm := NewManager(10, 30*time.Second)
+	server, agent := net.Pipe()
+	t.Cleanup(func() {
+		server.Close()
+		agent.Close()
+	})
+	conn, err := cm.RegisterConnection("conn-1", "agent-1", &mockConn{conn: server}, nil)
+	if err != nil {
+		t.Fatalf("RegisterConnection failed: %v", err)
+	}
+
+	opened := make(chan *Stream, 1)
+	go func() {
+		stream, _ := conn.OpenStream(nil)
+		opened <- stream
+	}()
+	decodeFrame(t, agent)
+	stream := <-opened
+
+	shaper := &blockingShaper{waiting: make(chan struct{}, 1)}
+	stream.SetShaper(shaper)
+	return conn, stream, shaper, agent
+}
+
+func TestStreamWriter_ResetWhileThrottled(t *testing.T) {
+	conn, stream, shaper, agent := shaperSetup(t)
+
+	written := make(chan error, 1)
+	go func() {
+		_, err := NewStreamWriter(conn, stream.ID).Write([]byte("request body"))
+		written <- err
+	}()
+	<-shaper.waiting
+
+	// Request bị hủy trong lúc chờ bandwidth → router reset stream
+	frames := make(chan *v1.Frame, 4)
+	go func() {
+		for {
+			frame, err := v1.Decode(agent)
+			if err != nil {
+				return
+			}
+			frames <- frame
+		}
+	}()
+	conn.ResetStream(stream.ID)
+
+	select {
+	case err := <-written:
+		if !errors.Is(err, ErrStreamClosed) {
+			t.Errorf("Expected ErrStreamClosed, got %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Write still blocked after stream reset")
+	}
+
+	if frame := <-frames; frame.Type != v1.FrameClose {
+		t.Fatalf("Expected FrameClose, got type %d", frame.Type)
+	}
+	select {
+	case frame := <-frames:
+		t.Errorf("Unexpected frame after reset: type %d", frame.Type)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestStreamReader_ResetWhileThrottled(t *testing.T) {
+	conn, stream, shaper, agent := shaperSetup(t)
+	go v1.Encode(agent, dataFrame(stream.ID, v1.FlagNone, []byte("response")))
+
+	read := make(chan error, 1)
+	go func() {
+		_, err := NewStreamReader(context.Background(), stream).Read(make([]byte, 16))
+		read <- err
+	}()
+	<-shaper.waiting
+
+	// Agent hủy stream giữa chừng
+	go v1.Encode(agent, &v1.Frame{Version: v1.Version, Type: v1.FrameClose, Flags: v1.FlagError, StreamID: stream.ID})
+
+	select {
+	case err := <-read:
+		if !errors.Is(err, ErrStreamClosed) {
+			t.Errorf("Expected ErrStreamClosed, got %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Read still blocked after stream reset")
+	}
+	if _, ok := conn.GetStream(stream.ID); ok {
+		t.Error("Expected stream to be removed")
+	}
+}
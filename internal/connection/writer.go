@@ -0,0 +1,80 @@
+package connection
+
+import (
+	v1 "github.com/hydragon2m/tunnel-protocol/go/v1"
+)
+
+// MaxDataFrameSize là payload tối đa của 1 FrameData do StreamWriter gửi
+const MaxDataFrameSize = 32 * 1024
+
+// StreamWriter gửi bytes đến agent dưới dạng FrameData trên 1 stream
+type StreamWriter struct {
+	conn     *Connection
+	streamID uint32
+}
+
+// NewStreamWriter tạo writer cho stream trên connection
+func NewStreamWriter(conn *Connection, streamID uint32) *StreamWriter {
+	return &StreamWriter{
+		conn:     conn,
+		streamID: streamID,
+	}
+}
+
+// Write implements io.Writer, tách p thành các frame tối đa MaxDataFrameSize
+// Agent có flow control: chờ credit của stream và connection trước mỗi frame
+// Stream có Shaper: chờ bandwidth trước mỗi frame, trả ErrStreamClosed nếu stream đóng trong lúc chờ
+func (w *StreamWriter) Write(p []byte) (int, error) {
+	stream, ok := w.conn.GetStream(w.streamID)
+	if !ok && w.conn.flow != nil {
+		return 0, ErrStreamClosed
+	}
+	var shaper Shaper
+	if stream != nil {
+		shaper = stream.getShaper()
+	}
+
+	written := 0
+	for written < len(p) {
+		n := len(p) - written
+		if n > MaxDataFrameSize {
+			n = MaxDataFrameSize
+		}
+		if stream != nil {
+			var err error
+			if n, err = w.conn.acquireSendCredit(stream, n); err != nil {
+				return written, err
+			}
+		}
+		if shaper != nil {
+			// Stream đóng trong lúc chờ (request bị hủy, reset) → không gửi data cho stream đã đóng
+			if err := waitShaper(w.conn.ctx, stream.closeCh, shaper.WaitOutbound, n); err != nil {
+				return written, err
+			}
+		}
+		end := written + n
+
+		frame := &v1.Frame{
+			Version:  v1.Version,
+			Type:     v1.FrameData,
+			Flags:    v1.FlagNone,
+			StreamID: w.streamID,
+			Payload:  append([]byte(nil), p[written:end]...),
+		}
+		if err := w.conn.SendFrame(frame); err != nil {
+			return written, err
+		}
+		written = end
+	}
+	return written, nil
+}
+
+// CloseWrite gửi FrameData với FlagEndStream (half-close phía server)
+func (w *StreamWriter) CloseWrite() error {
+	return w.conn.SendFrame(&v1.Frame{
+		Version:  v1.Version,
+		Type:     v1.FrameData,
+		Flags:    v1.FlagEndStream,
+		StreamID: w.streamID,
+	})
+}
@@ -0,0 +1,97 @@
+package control
+
+import (
+	"errors"
+
+	"github.com/hydragon2m/tunnel-core/internal/domains"
+	"github.com/hydragon2m/tunnel-core/internal/listener"
+	"github.com/hydragon2m/tunnel-core/internal/registry"
+)
+
+var (
+	ErrInvalidMessage        = errors.New("invalid control message")
+	ErrUnknownMessageType    = errors.New("unknown control message type")
+	ErrAmbiguousTunnelSpec   = errors.New("exactly one of subdomain, domain or random is required")
+	ErrTCPTunnelsDisabled    = errors.New("TCP tunnels are disabled")
+	ErrCustomDomainsDisabled = errors.New("custom domains are disabled")
+	ErrPassthroughDisabled   = errors.New("TLS passthrough is disabled")
+	ErrFeatureNotNegotiated  = errors.New("feature not negotiated in handshake")
+)
+
+// Error codes gửi qua wire trong Response.ErrorCode
+const (
+	CodeInvalidMessage          = "invalid_message"
+	CodeUnknownMessageType      = "unknown_message_type"
+	CodeInvalidSubdomain        = "invalid_subdomain"
+	CodeInvalidPathPrefix       = "invalid_path_prefix"
+	CodeDomainMismatch          = "domain_mismatch"
+	CodeDomainAlreadyRegistered = "domain_already_registered"
+	CodeTunnelNotFound          = "tunnel_not_found"
+	CodeTCPDisabled             = "tcp_disabled"
+	CodePortOutOfRange          = "port_out_of_range"
+	CodePortUnavailable         = "port_unavailable"
+	CodeNoPortsAvailable        = "no_ports_available"
+	CodePortQuotaExceeded       = "port_quota_exceeded"
+	CodeCustomDomainsDisabled   = "custom_domains_disabled"
+	CodeInvalidDomain           = "invalid_domain"
+	CodeDomainTaken             = "domain_taken"
+	CodeClaimNotFound           = "domain_claim_not_found"
+	CodeVerificationFailed      = "domain_verification_failed"
+	CodeDomainNotVerified       = "domain_not_verified"
+	CodeTooManyClaims           = "too_many_domain_claims"
+	CodePassthroughDisabled     = "passthrough_disabled"
+	CodeFeatureNotNegotiated    = "feature_not_negotiated"
+	CodeInternal                = "internal_error"
+)
+
+// errorCodes map error → code (thứ tự không quan trọng, so sánh bằng errors.Is)
+var errorCodes = []struct {
+	err  error
+	code string
+}{
+	{ErrInvalidMessage, CodeInvalidMessage},
+	{ErrAmbiguousTunnelSpec, CodeInvalidMessage},
+	{ErrUnknownMessageType, CodeUnknownMessageType},
+	{registry.ErrInvalidSubdomain, CodeInvalidSubdomain},
+	{registry.ErrInvalidPathPrefix, CodeInvalidPathPrefix},
+	{registry.ErrDomainMismatch, CodeDomainMismatch},
+	{registry.ErrDomainAlreadyRegistered, CodeDomainAlreadyRegistered},
+	{registry.ErrTunnelNotFound, CodeTunnelNotFound},
+	{registry.ErrTunnelNotOwned, CodeTunnelNotFound},
+	{ErrTCPTunnelsDisabled, CodeTCPDisabled},
+	{listener.ErrPortOutOfRange, CodePortOutOfRange},
+	{listener.ErrPortUnavailable, CodePortUnavailable},
+	{listener.ErrNoPortsAvailable, CodeNoPortsAvailable},
+	{listener.ErrPortQuotaExceeded, CodePortQuotaExceeded},
+	{ErrCustomDomainsDisabled, CodeCustomDomainsDisabled},
+	{domains.ErrInvalidDomain, CodeInvalidDomain},
+	{domains.ErrReservedDomain, CodeInvalidDomain},
+	{domains.ErrInvalidMethod, CodeInvalidMessage},
+	{domains.ErrDomainTaken, CodeDomainTaken},
+	{domains.ErrClaimNotFound, CodeClaimNotFound},
+	{domains.ErrVerificationFailed, CodeVerificationFailed},
+	{domains.ErrDomainNotVerified, CodeDomainNotVerified},
+	{domains.ErrTooManyClaims, CodeTooManyClaims},
+	{ErrPassthroughDisabled, CodePassthroughDisabled},
+	{ErrFeatureNotNegotiated, CodeFeatureNotNegotiated},
+}
+
+// ErrorCode trả về wire code cho error
+func ErrorCode(err error) string {
+	for _, e := range errorCodes {
+		if errors.Is(err, e.err) {
+			return e.code
+		}
+	}
+	return CodeInternal
+}
+
+// ErrorFromCode map wire code về error (phía agent dùng để so sánh với errors.Is)
+func ErrorFromCode(code string) error {
+	for _, e := range errorCodes {
+		if e.code == code {
+			return e.err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,351 @@
+package control
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/hydragon2m/tunnel-core/internal/connection"
+	"github.com/hydragon2m/tunnel-core/internal/domains"
+	"github.com/hydragon2m/tunnel-core/internal/listener"
+	"github.com/hydragon2m/tunnel-core/internal/registry"
+	"github.com/hydragon2m/tunnel-protocol/go/v1"
+)
+
+// Handler xử lý control messages từ agent đã authenticate
+type Handler struct {
+	registry    *registry.Registry
+	tcp         *listener.TCPListener // nil = TCP tunnels disabled
+	domains     *domains.Manager      // nil = custom domains disabled
+	passthrough bool                  // TLS passthrough tunnels (public listener tách connections theo SNI)
+}
+
+// NewHandler tạo control Handler mới
+func NewHandler(reg *registry.Registry) *Handler {
+	return &Handler{
+		registry: reg,
+	}
+}
+
+// SetTCPListener bật raw TCP tunnels qua MsgRegisterTCPTunnel
+func (h *Handler) SetTCPListener(tcp *listener.TCPListener) {
+	h.tcp = tcp
+}
+
+// SetDomains bật custom domains (claim/verify và register tunnel cho domain đã xác minh)
+func (h *Handler) SetDomains(d *domains.Manager) {
+	h.domains = d
+}
+
+// SetPassthrough bật TLS passthrough tunnels (register_tunnel với passthrough)
+// Chỉ bật khi public listener đã SetPassthrough
+func (h *Handler) SetPassthrough(enabled bool) {
+	h.passthrough = enabled
+}
+
+// HandleFrame xử lý 1 control frame, dùng làm callback cho Manager.SetOnControlMessage
+// Lỗi nghiệp vụ được trả về agent qua Response; chỉ lỗi ghi frame mới trả error (đóng connection)
+func (h *Handler) HandleFrame(c *connection.Connection, frame *v1.Frame) error {
+	var req Request
+	if err := json.Unmarshal(frame.Payload, &req); err != nil || req.Type == "" {
+		return h.sendError(c, &req, ErrInvalidMessage)
+	}
+
+	var (
+		result interface{}
+		err    error
+	)
+
+	switch req.Type {
+	case MsgRegisterTunnel:
+		result, err = h.handleRegisterTunnel(c, req.Payload)
+	case MsgRegisterTCPTunnel:
+		result, err = h.handleRegisterTCPTunnel(c, req.Payload)
+	case MsgUnregisterTunnel:
+		result, err = h.handleUnregisterTunnel(c, req.Payload)
+	case MsgClaimDomain:
+		result, err = h.handleClaimDomain(c, req.Payload)
+	case MsgVerifyDomain:
+		// Xác minh gọi DNS/HTTP ra ngoài nên chạy riêng, response được gửi khi xong
+		if err = h.handleVerifyDomain(c, &req); err == nil {
+			return nil
+		}
+	default:
+		err = ErrUnknownMessageType
+	}
+
+	if err != nil {
+		return h.sendError(c, &req, err)
+	}
+	return h.sendSuccess(c, &req, result)
+}
+
+// handleRegisterTunnel đăng ký tunnel cho connection
+func (h *Handler) handleRegisterTunnel(c *connection.Connection, payload json.RawMessage) (*RegisterTunnelResponse, error) {
+	var req RegisterTunnelRequest
+	if err := json.Unmarshal(payload, &req); err != nil {
+		return nil, ErrInvalidMessage
+	}
+
+	// Đúng 1 trong 3 cách chọn domain
+	specs := 0
+	for _, set := range []bool{req.Subdomain != "", req.Domain != "", req.Random} {
+		if set {
+			specs++
+		}
+	}
+	if specs != 1 {
+		return nil, ErrAmbiguousTunnelSpec
+	}
+
+	var (
+		tunnel *registry.Tunnel
+		err    error
+	)
+	route := registry.Route{
+		Wildcard:    req.Wildcard,
+		PathPrefix:  req.PathPrefix,
+		StripPrefix: req.StripPrefix,
+		Passthrough: req.Passthrough,
+	}
+	if req.Passthrough {
+		if !h.passthrough {
+			return nil, ErrPassthroughDisabled
+		}
+		if err := requireFeature(c, connection.CapabilityTLSPassthrough); err != nil {
+			return nil, err
+		}
+		if req.PathPrefix != "" || req.StripPrefix {
+			return nil, ErrInvalidMessage
+		}
+	}
+
+	switch {
+	case req.Random:
+		if route != (registry.Route{}) {
+			return nil, ErrInvalidMessage
+		}
+		tunnel, err = h.registry.RegisterRandomTunnel(c.ID, c.AgentID, req.Metadata)
+	case req.Subdomain != "":
+		if err := registry.ValidateSubdomain(req.Subdomain); err != nil {
+			return nil, err
+		}
+		tunnel, err = h.registry.RegisterTunnelRoute(req.Subdomain, route, c.ID, c.AgentID, req.Metadata)
+	default:
+		// Domain đầy đủ dạng subdomain.baseDomain, hoặc custom domain agent đã xác minh
+		subdomain := strings.TrimSuffix(req.Domain, "."+h.registry.GetBaseDomain())
+		if subdomain == req.Domain {
+			tunnel, err = h.registerCustomTunnel(c, &req, route)
+			break
+		}
+		if err := registry.ValidateSubdomain(subdomain); err != nil {
+			return nil, err
+		}
+		tunnel, err = h.registry.RegisterTunnelRoute(subdomain, route, c.ID, c.AgentID, req.Metadata)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &RegisterTunnelResponse{
+		FullDomain: tunnel.FullDomain,
+		Subdomain:  tunnel.Subdomain,
+		PathPrefix: tunnel.PathPrefix,
+		Protocol:   tunnel.Protocol,
+	}, nil
+}
+
+// registerCustomTunnel đăng ký tunnel cho custom domain, agent phải sở hữu domain đã xác minh
+func (h *Handler) registerCustomTunnel(c *connection.Connection, req *RegisterTunnelRequest, route registry.Route) (*registry.Tunnel, error) {
+	if h.domains == nil {
+		return nil, registry.ErrDomainMismatch
+	}
+	if err := requireFeature(c, connection.CapabilityCustomDomains); err != nil {
+		return nil, err
+	}
+
+	claim, ok := h.domains.Lookup(req.Domain)
+	if !ok || claim.AgentID != c.AgentID {
+		return nil, domains.ErrDomainNotVerified
+	}
+	return h.registry.RegisterCustomTunnel(claim.Domain, route, c.ID, c.AgentID, req.Metadata)
+}
+
+// handleRegisterTCPTunnel mở public TCP port cho connection
+func (h *Handler) handleRegisterTCPTunnel(c *connection.Connection, payload json.RawMessage) (*RegisterTCPTunnelResponse, error) {
+	if h.tcp == nil {
+		return nil, ErrTCPTunnelsDisabled
+	}
+	if err := requireFeature(c, connection.CapabilityTCPTunnels); err != nil {
+		return nil, err
+	}
+
+	var req RegisterTCPTunnelRequest
+	if err := json.Unmarshal(payload, &req); err != nil || req.Port < 0 {
+		return nil, ErrInvalidMessage
+	}
+
+	tunnel, err := h.tcp.OpenTunnel(c.ID, c.AgentID, req.Port, req.Metadata)
+	if err != nil {
+		return nil, err
+	}
+
+	return &RegisterTCPTunnelResponse{
+		FullDomain: tunnel.FullDomain,
+		Port:       tunnel.Port,
+	}, nil
+}
+
+// handleUnregisterTunnel xóa tunnel, chỉ cho phép connection sở hữu tunnel
+func (h *Handler) handleUnregisterTunnel(c *connection.Connection, payload json.RawMessage) (interface{}, error) {
+	var req UnregisterTunnelRequest
+	if err := json.Unmarshal(payload, &req); err != nil || req.FullDomain == "" {
+		return nil, ErrInvalidMessage
+	}
+
+	pathPrefix, err := registry.NormalizePathPrefix(req.PathPrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	var owned *registry.Tunnel
+	for _, tunnel := range h.registry.GetConnectionTunnels(c.ID) {
+		if tunnel.FullDomain == req.FullDomain && tunnel.PathPrefix == pathPrefix {
+			owned = tunnel
+			break
+		}
+	}
+	if owned == nil {
+		return nil, registry.ErrTunnelNotOwned
+	}
+
+	// TCP tunnel: đóng public port (listener tự unregister khỏi registry)
+	if owned.Protocol == registry.ProtocolTCP && h.tcp != nil {
+		return nil, h.tcp.CloseTunnel(owned.Port)
+	}
+
+	if err := h.registry.UnregisterTunnel(owned.Key()); err != nil {
+		return nil, err
+	}
+	return nil, nil
+}
+
+// handleClaimDomain tạo claim cho custom domain, trả challenge agent cần publish
+func (h *Handler) handleClaimDomain(c *connection.Connection, payload json.RawMessage) (*DomainClaimResponse, error) {
+	if h.domains == nil {
+		return nil, ErrCustomDomainsDisabled
+	}
+	if err := requireFeature(c, connection.CapabilityCustomDomains); err != nil {
+		return nil, err
+	}
+
+	var req ClaimDomainRequest
+	if err := json.Unmarshal(payload, &req); err != nil || req.Domain == "" {
+		return nil, ErrInvalidMessage
+	}
+
+	claim, err := h.domains.Claim(req.Domain, c.AgentID, req.Method)
+	if err != nil {
+		return nil, err
+	}
+	return newDomainClaimResponse(claim), nil
+}
+
+// handleVerifyDomain xác minh claim trong goroutine riêng (không chặn frame loop của connection)
+// Chỉ trả error khi request không hợp lệ; kết quả xác minh được gửi bằng response riêng
+func (h *Handler) handleVerifyDomain(c *connection.Connection, req *Request) error {
+	if h.domains == nil {
+		return ErrCustomDomainsDisabled
+	}
+	if err := requireFeature(c, connection.CapabilityCustomDomains); err != nil {
+		return err
+	}
+
+	var payload VerifyDomainRequest
+	if err := json.Unmarshal(req.Payload, &payload); err != nil || payload.Domain == "" {
+		return ErrInvalidMessage
+	}
+
+	go func() {
+		claim, err := h.domains.Verify(c.Context(), payload.Domain, c.AgentID)
+		if err != nil {
+			h.sendError(c, req, err)
+			return
+		}
+		h.sendSuccess(c, req, newDomainClaimResponse(claim))
+	}()
+	return nil
+}
+
+// requireFeature kiểm tra agent đã thỏa thuận feature lúc handshake
+func requireFeature(c *connection.Connection, feature string) error {
+	if !c.HasFeature(feature) {
+		return fmt.Errorf("%w: %s", ErrFeatureNotNegotiated, feature)
+	}
+	return nil
+}
+
+// newDomainClaimResponse build response từ claim, chỉ kèm thông tin challenge của method đã chọn
+func newDomainClaimResponse(claim domains.Claim) *DomainClaimResponse {
+	resp := &DomainClaimResponse{
+		Domain: claim.Domain,
+		Method: claim.Method,
+		Status: claim.Status,
+		Token:  claim.Token,
+	}
+	switch claim.Method {
+	case domains.MethodDNS:
+		resp.RecordName = claim.RecordName()
+	case domains.MethodHTTP:
+		resp.URL = claim.URL()
+	}
+	return resp
+}
+
+// sendSuccess gửi success response
+func (h *Handler) sendSuccess(c *connection.Connection, req *Request, result interface{}) error {
+	resp := Response{
+		Type:      req.Type,
+		RequestID: req.RequestID,
+		Success:   true,
+	}
+
+	if result != nil {
+		payload, err := json.Marshal(result)
+		if err != nil {
+			return h.sendError(c, req, err)
+		}
+		resp.Payload = payload
+	}
+
+	return h.send(c, &resp, v1.FlagAck)
+}
+
+// sendError gửi error response với error code
+func (h *Handler) sendError(c *connection.Connection, req *Request, err error) error {
+	resp := Response{
+		Type:      req.Type,
+		RequestID: req.RequestID,
+		Success:   false,
+		ErrorCode: ErrorCode(err),
+		Error:     err.Error(),
+	}
+
+	return h.send(c, &resp, v1.FlagAck|v1.FlagError)
+}
+
+// send encode response và gửi qua control stream
+func (h *Handler) send(c *connection.Connection, resp *Response, flags uint8) error {
+	payload, err := json.Marshal(resp)
+	if err != nil {
+		return err
+	}
+
+	return c.SendFrame(&v1.Frame{
+		Version:  v1.Version,
+		Type:     v1.FrameData,
+		Flags:    flags,
+		StreamID: v1.StreamIDControl,
+		Payload:  payload,
+	})
+}
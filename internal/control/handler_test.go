@@ -0,0 +1,379 @@
+package control
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/hydragon2m/tunnel-core/internal/connection"
+	"github.com/hydragon2m/tunnel-core/internal/connection/conntest"
+	"github.com/hydragon2m/tunnel-core/internal/domains"
+	"github.com/hydragon2m/tunnel-core/internal/registry"
+	v1 "github.com/hydragon2m/tunnel-protocol/go/v1"
+)
+
+// setup tạo manager + registry với 1 connection, trả về phía agent của pipe
+func setup(t *testing.T, connID, agentID string) (*registry.Registry, *connection.Manager, net.Conn) {
+	t.Helper()
+
+	reg := registry.NewRegistry("localhost")
+	cm := connection.NewManager(10, 30*time.Second)
+	cm.SetOnControlMessage(NewHandler(reg).HandleFrame)
+
+	_, agent := register(t, cm, connID, agentID)
+	return reg, cm, agent
+}
+
+// register đăng ký connection của agent đã thỏa thuận mọi feature
+func register(t *testing.T, cm *connection.Manager, connID, agentID string) (*connection.Connection, net.Conn) {
+	t.Helper()
+
+	return conntest.RegisterWithMetadata(t, cm, connID, agentID, map[string]string{
+		connection.MetadataFeatures: `["custom_domains","tcp_tunnels","tls_passthrough"]`,
+	})
+}
+
+// roundTrip gửi control request từ agent và đọc response
+func roundTrip(t *testing.T, agent net.Conn, msgType, requestID string, payload interface{}) (*v1.Frame, *Response) {
+	t.Helper()
+
+	raw, err := json.Marshal(payload)
+	if err != nil {
+		t.Fatalf("marshal payload: %v", err)
+	}
+	body, _ := json.Marshal(Request{Type: msgType, RequestID: requestID, Payload: raw})
+
+	agent.SetDeadline(time.Now().Add(2 * time.Second))
+	err = v1.Encode(agent, &v1.Frame{
+		Version:  v1.Version,
+		Type:     v1.FrameData,
+		StreamID: v1.StreamIDControl,
+		Payload:  body,
+	})
+	if err != nil {
+		t.Fatalf("Encode failed: %v", err)
+	}
+
+	frame, err := v1.Decode(agent)
+	if err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+
+	var resp Response
+	if err := json.Unmarshal(frame.Payload, &resp); err != nil {
+		t.Fatalf("invalid response payload: %v", err)
+	}
+	return frame, &resp
+}
+
+func TestHandler_RegisterSubdomain(t *testing.T) {
+	reg, _, agent := setup(t, "conn-1", "agent-1")
+
+	frame, resp := roundTrip(t, agent, MsgRegisterTunnel, "req-1", RegisterTunnelRequest{Subdomain: "app"})
+	if !resp.Success || frame.IsError() {
+		t.Fatalf("Expected success, got %+v", resp)
+	}
+	if resp.RequestID != "req-1" {
+		t.Errorf("Expected request ID 'req-1', got '%s'", resp.RequestID)
+	}
+
+	var result RegisterTunnelResponse
+	if err := json.Unmarshal(resp.Payload, &result); err != nil {
+		t.Fatalf("invalid result: %v", err)
+	}
+	if result.FullDomain != "app.localhost" {
+		t.Errorf("Expected full domain 'app.localhost', got '%s'", result.FullDomain)
+	}
+
+	tunnel, ok := reg.GetTunnel("app.localhost")
+	if !ok {
+		t.Fatal("Expected tunnel to exist")
+	}
+	if tunnel.ConnectionID != "conn-1" || tunnel.AgentID != "agent-1" {
+		t.Errorf("Unexpected tunnel owner: %s/%s", tunnel.ConnectionID, tunnel.AgentID)
+	}
+}
+
+func TestHandler_RegisterRandomAndDomain(t *testing.T) {
+	_, _, agent := setup(t, "conn-1", "agent-1")
+
+	_, resp := roundTrip(t, agent, MsgRegisterTunnel, "", RegisterTunnelRequest{Random: true})
+	if !resp.Success {
+		t.Fatalf("Expected random register success, got %+v", resp)
+	}
+
+	_, resp = roundTrip(t, agent, MsgRegisterTunnel, "", RegisterTunnelRequest{Domain: "api.localhost"})
+	if !resp.Success {
+		t.Fatalf("Expected domain register success, got %+v", resp)
+	}
+
+	_, resp = roundTrip(t, agent, MsgRegisterTunnel, "", RegisterTunnelRequest{Domain: "example.com"})
+	if resp.Success || !errors.Is(ErrorFromCode(resp.ErrorCode), registry.ErrDomainMismatch) {
+		t.Errorf("Expected domain mismatch, got %+v", resp)
+	}
+}
+
+func TestHandler_RegisterErrors(t *testing.T) {
+	reg, _, agent := setup(t, "conn-1", "agent-1")
+	reg.RegisterTunnel("", "taken", "conn-2", "agent-2", nil)
+
+	frame, resp := roundTrip(t, agent, MsgRegisterTunnel, "", RegisterTunnelRequest{Subdomain: "taken"})
+	if resp.Success || !frame.IsError() {
+		t.Fatal("Expected error response for taken subdomain")
+	}
+	if !errors.Is(ErrorFromCode(resp.ErrorCode), registry.ErrDomainAlreadyRegistered) {
+		t.Errorf("Expected ErrDomainAlreadyRegistered, got code '%s'", resp.ErrorCode)
+	}
+
+	_, resp = roundTrip(t, agent, MsgRegisterTunnel, "", RegisterTunnelRequest{Subdomain: "Bad_Name"})
+	if resp.ErrorCode != CodeInvalidSubdomain {
+		t.Errorf("Expected invalid subdomain, got '%s'", resp.ErrorCode)
+	}
+
+	_, resp = roundTrip(t, agent, MsgRegisterTunnel, "", RegisterTunnelRequest{Subdomain: "a", Random: true})
+	if resp.ErrorCode != CodeInvalidMessage {
+		t.Errorf("Expected invalid message, got '%s'", resp.ErrorCode)
+	}
+
+	_, resp = roundTrip(t, agent, "bogus", "", nil)
+	if resp.ErrorCode != CodeUnknownMessageType {
+		t.Errorf("Expected unknown message type, got '%s'", resp.ErrorCode)
+	}
+}
+
+func TestHandler_Unregister(t *testing.T) {
+	reg, _, agent := setup(t, "conn-1", "agent-1")
+	reg.RegisterTunnel("", "other", "conn-2", "agent-2", nil)
+
+	roundTrip(t, agent, MsgRegisterTunnel, "", RegisterTunnelRequest{Subdomain: "mine"})
+
+	// Không được xóa tunnel của connection khác
+	_, resp := roundTrip(t, agent, MsgUnregisterTunnel, "", UnregisterTunnelRequest{FullDomain: "other.localhost"})
+	if resp.Success || resp.ErrorCode != CodeTunnelNotFound {
+		t.Errorf("Expected tunnel not found, got %+v", resp)
+	}
+	if _, ok := reg.GetTunnel("other.localhost"); !ok {
+		t.Error("Expected other tunnel to still exist")
+	}
+
+	_, resp = roundTrip(t, agent, MsgUnregisterTunnel, "", UnregisterTunnelRequest{FullDomain: "mine.localhost"})
+	if !resp.Success {
+		t.Fatalf("Expected unregister success, got %+v", resp)
+	}
+	if _, ok := reg.GetTunnel("mine.localhost"); ok {
+		t.Error("Expected tunnel to be unregistered")
+	}
+}
+
+func TestHandler_ReRegisterAfterAgentDrop(t *testing.T) {
+	reg, cm, agent := setup(t, "conn-1", "agent-1")
+	cm.SetOnConnectionClosed(reg.UnregisterConnectionTunnels)
+
+	if _, resp := roundTrip(t, agent, MsgRegisterTunnel, "", RegisterTunnelRequest{Subdomain: "app"}); !resp.Success {
+		t.Fatalf("Expected register success, got %+v", resp)
+	}
+
+	// Agent rớt mạng, không unregister
+	agent.Close()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		if _, ok := reg.GetTunnel("app.localhost"); !ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Expected tunnel to be unregistered after agent drop")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// Agent reconnect với connection mới và lấy lại subdomain
+	_, reconnected := register(t, cm, "conn-2", "agent-1")
+
+	if _, resp := roundTrip(t, reconnected, MsgRegisterTunnel, "", RegisterTunnelRequest{Subdomain: "app"}); !resp.Success {
+		t.Errorf("Expected re-register after reconnect to succeed, got %+v", resp)
+	}
+}
+
+// txtResolver là domains.Resolver giả với TXT records cố định
+type txtResolver map[string][]string
+
+func (r txtResolver) LookupTXT(ctx context.Context, name string) ([]string, error) {
+	return r[name], nil
+}
+
+func TestHandler_CustomDomain(t *testing.T) {
+	reg := registry.NewRegistry("localhost")
+	cm := connection.NewManager(10, 30*time.Second)
+	resolver := txtResolver{}
+	handler := NewHandler(reg)
+	handler.SetDomains(domains.NewManager("localhost", domains.Config{Resolver: resolver}))
+	cm.SetOnControlMessage(handler.HandleFrame)
+
+	_, agent := register(t, cm, "conn-1", "agent-1")
+	_, other := register(t, cm, "conn-2", "agent-2")
+
+	_, resp := roundTrip(t, agent, MsgRegisterTunnel, "", RegisterTunnelRequest{Domain: "app.example.com"})
+	if resp.ErrorCode != CodeDomainNotVerified {
+		t.Fatalf("Expected domain not verified before claim, got %+v", resp)
+	}
+
+	_, resp = roundTrip(t, agent, MsgClaimDomain, "", ClaimDomainRequest{Domain: "app.example.com", Method: domains.MethodDNS})
+	if !resp.Success {
+		t.Fatalf("Expected claim success, got %+v", resp)
+	}
+	var challenge DomainClaimResponse
+	json.Unmarshal(resp.Payload, &challenge)
+	if challenge.Status != domains.StatusPending || challenge.RecordName != "_tunnel-challenge.app.example.com" || challenge.Token == "" {
+		t.Fatalf("Unexpected challenge: %+v", challenge)
+	}
+
+	_, resp = roundTrip(t, agent, MsgVerifyDomain, "v-1", VerifyDomainRequest{Domain: "app.example.com"})
+	if resp.ErrorCode != CodeVerificationFailed || resp.RequestID != "v-1" {
+		t.Fatalf("Expected verification failure without TXT record, got %+v", resp)
+	}
+
+	resolver[challenge.RecordName] = []string{challenge.Token}
+	_, resp = roundTrip(t, agent, MsgVerifyDomain, "v-2", VerifyDomainRequest{Domain: "app.example.com"})
+	if !resp.Success || resp.RequestID != "v-2" {
+		t.Fatalf("Expected verification success, got %+v", resp)
+	}
+
+	_, resp = roundTrip(t, agent, MsgRegisterTunnel, "", RegisterTunnelRequest{Domain: "app.example.com"})
+	if !resp.Success {
+		t.Fatalf("Expected custom domain register success, got %+v", resp)
+	}
+	tunnel, ok := reg.GetTunnel("app.example.com")
+	if !ok || tunnel.AgentID != "agent-1" || tunnel.Protocol != registry.ProtocolHTTP {
+		t.Fatalf("Unexpected tunnel: %+v", tunnel)
+	}
+
+	// Agent khác không dùng được domain đã xác minh
+	_, resp = roundTrip(t, other, MsgRegisterTunnel, "", RegisterTunnelRequest{Domain: "app.example.com"})
+	if resp.ErrorCode != CodeDomainNotVerified {
+		t.Errorf("Expected domain not verified for other agent, got %+v", resp)
+	}
+	_, resp = roundTrip(t, other, MsgClaimDomain, "", ClaimDomainRequest{Domain: "app.example.com", Method: domains.MethodDNS})
+	if resp.ErrorCode != CodeDomainTaken {
+		t.Errorf("Expected domain taken, got %+v", resp)
+	}
+}
+
+func TestHandler_CustomDomainsDisabled(t *testing.T) {
+	_, _, agent := setup(t, "conn-1", "agent-1")
+
+	_, resp := roundTrip(t, agent, MsgClaimDomain, "", ClaimDomainRequest{Domain: "app.example.com", Method: domains.MethodDNS})
+	if resp.ErrorCode != CodeCustomDomainsDisabled {
+		t.Errorf("Expected custom domains disabled, got %+v", resp)
+	}
+}
+
+func TestHandler_RegisterRoutes(t *testing.T) {
+	reg, _, agent := setup(t, "conn-1", "agent-1")
+
+	_, resp := roundTrip(t, agent, MsgRegisterTunnel, "", RegisterTunnelRequest{Subdomain: "app", Wildcard: true})
+	var result RegisterTunnelResponse
+	json.Unmarshal(resp.Payload, &result)
+	if !resp.Success || result.FullDomain != "*.app.localhost" {
+		t.Fatalf("Expected wildcard register success, got %+v %+v", resp, result)
+	}
+
+	_, resp = roundTrip(t, agent, MsgRegisterTunnel, "", RegisterTunnelRequest{Domain: "app.localhost", PathPrefix: "/api/", StripPrefix: true})
+	result = RegisterTunnelResponse{}
+	json.Unmarshal(resp.Payload, &result)
+	if !resp.Success || result.FullDomain != "app.localhost" || result.PathPrefix != "/api" {
+		t.Fatalf("Expected path prefix register success, got %+v %+v", resp, result)
+	}
+	if tunnel, ok := reg.Resolve("app.localhost", "/api/x"); !ok || !tunnel.StripPrefix {
+		t.Errorf("Expected /api route with strip prefix, got %+v", tunnel)
+	}
+
+	_, resp = roundTrip(t, agent, MsgRegisterTunnel, "", RegisterTunnelRequest{Subdomain: "app", PathPrefix: "/a/../b"})
+	if resp.ErrorCode != CodeInvalidPathPrefix {
+		t.Errorf("Expected invalid path prefix, got %+v", resp)
+	}
+	_, resp = roundTrip(t, agent, MsgRegisterTunnel, "", RegisterTunnelRequest{Random: true, Wildcard: true})
+	if resp.ErrorCode != CodeInvalidMessage {
+		t.Errorf("Expected invalid message for random wildcard, got %+v", resp)
+	}
+
+	_, resp = roundTrip(t, agent, MsgUnregisterTunnel, "", UnregisterTunnelRequest{FullDomain: "app.localhost", PathPrefix: "/api"})
+	if !resp.Success {
+		t.Fatalf("Expected unregister success, got %+v", resp)
+	}
+	if _, ok := reg.GetTunnel("app.localhost/api"); ok {
+		t.Error("Expected path prefix route to be unregistered")
+	}
+	if _, ok := reg.GetTunnel("*.app.localhost"); !ok {
+		t.Error("Expected wildcard tunnel to remain")
+	}
+}
+
+func TestHandler_RegisterPassthrough(t *testing.T) {
+	reg := registry.NewRegistry("localhost")
+	cm := connection.NewManager(10, 30*time.Second)
+	handler := NewHandler(reg)
+	cm.SetOnControlMessage(handler.HandleFrame)
+	_, agent := register(t, cm, "conn-1", "agent-1")
+
+	_, resp := roundTrip(t, agent, MsgRegisterTunnel, "", RegisterTunnelRequest{Subdomain: "secure", Passthrough: true})
+	if resp.ErrorCode != CodePassthroughDisabled {
+		t.Fatalf("Expected passthrough_disabled, got %+v", resp)
+	}
+
+	handler.SetPassthrough(true)
+	_, resp = roundTrip(t, agent, MsgRegisterTunnel, "", RegisterTunnelRequest{Subdomain: "secure", Passthrough: true, PathPrefix: "/api"})
+	if resp.ErrorCode != CodeInvalidMessage {
+		t.Errorf("Expected invalid message for passthrough with path prefix, got %+v", resp)
+	}
+
+	_, resp = roundTrip(t, agent, MsgRegisterTunnel, "", RegisterTunnelRequest{Subdomain: "secure", Passthrough: true})
+	var result RegisterTunnelResponse
+	json.Unmarshal(resp.Payload, &result)
+	if !resp.Success || result.FullDomain != "secure.localhost" || result.Protocol != registry.ProtocolTLS {
+		t.Fatalf("Expected passthrough register success, got %+v %+v", resp, result)
+	}
+	if _, ok := reg.ResolveSNI("secure.localhost"); !ok {
+		t.Error("Expected passthrough tunnel to resolve by SNI")
+	}
+
+	_, resp = roundTrip(t, agent, MsgRegisterTunnel, "", RegisterTunnelRequest{Subdomain: "secure"})
+	if resp.ErrorCode != CodeDomainAlreadyRegistered {
+		t.Errorf("Expected HTTP tunnel on passthrough host to be rejected, got %+v", resp)
+	}
+}
+
+func TestHandler_FeatureNotNegotiated(t *testing.T) {
+	reg := registry.NewRegistry("localhost")
+	cm := connection.NewManager(10, 30*time.Second)
+	handler := NewHandler(reg)
+	handler.SetPassthrough(true)
+	handler.SetDomains(domains.NewManager("localhost", domains.Config{Resolver: txtResolver{}}))
+	cm.SetOnControlMessage(handler.HandleFrame)
+
+	_, agent := conntest.RegisterWithMetadata(t, cm, "conn-1", "agent-1", map[string]string{
+		connection.MetadataFeatures: `["tls_passthrough"]`,
+	})
+	_, legacy := conntest.Register(t, cm, "conn-2", "agent-2")
+
+	// Feature server bật nhưng agent không thỏa thuận
+	_, resp := roundTrip(t, agent, MsgClaimDomain, "", ClaimDomainRequest{Domain: "example.com"})
+	if !errors.Is(ErrorFromCode(resp.ErrorCode), ErrFeatureNotNegotiated) {
+		t.Errorf("Expected feature_not_negotiated for claim_domain, got %+v", resp)
+	}
+	_, resp = roundTrip(t, legacy, MsgRegisterTunnel, "", RegisterTunnelRequest{Subdomain: "legacy", Passthrough: true})
+	if resp.ErrorCode != CodeFeatureNotNegotiated {
+		t.Errorf("Expected feature_not_negotiated for passthrough, got %+v", resp)
+	}
+
+	// Feature đã thỏa thuận và tunnels thường không cần feature
+	if _, resp = roundTrip(t, agent, MsgRegisterTunnel, "", RegisterTunnelRequest{Subdomain: "secure", Passthrough: true}); !resp.Success {
+		t.Errorf("Expected passthrough register success, got %+v", resp)
+	}
+	if _, resp = roundTrip(t, legacy, MsgRegisterTunnel, "", RegisterTunnelRequest{Subdomain: "app"}); !resp.Success {
+		t.Errorf("Expected HTTP register success, got %+v", resp)
+	}
+}
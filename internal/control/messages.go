@@ -0,0 +1,118 @@
+package control
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Control message types (FrameData trên StreamID 0)
+const (
+	MsgRegisterTunnel    = "register_tunnel"
+	MsgRegisterTCPTunnel = "register_tcp_tunnel"
+	MsgUnregisterTunnel  = "unregister_tunnel"
+	MsgClaimDomain       = "claim_domain"
+	MsgVerifyDomain      = "verify_domain"
+
+	// Server → agent notifications (không có FlagAck, agent không cần trả lời)
+	MsgGoingAway    = "going_away"
+	MsgTunnelClosed = "tunnel_closed"
+)
+
+// Request là envelope của control message từ agent
+type Request struct {
+	Type      string          `json:"type"`
+	RequestID string          `json:"request_id,omitempty"`
+	Payload   json.RawMessage `json:"payload,omitempty"`
+}
+
+// Response là envelope của control response từ server
+// Gửi bằng FrameData (StreamID 0) với FlagAck, thêm FlagError nếu thất bại
+type Response struct {
+	Type      string          `json:"type"`
+	RequestID string          `json:"request_id,omitempty"`
+	Success   bool            `json:"success"`
+	Payload   json.RawMessage `json:"payload,omitempty"`
+	ErrorCode string          `json:"error_code,omitempty"`
+	Error     string          `json:"error,omitempty"`
+}
+
+// RegisterTunnelRequest là payload của MsgRegisterTunnel
+// Chọn 1 trong: Subdomain, Domain (custom domain) hoặc Random
+// Wildcard/PathPrefix/StripPrefix/Passthrough chỉ dùng với Subdomain hoặc Domain
+type RegisterTunnelRequest struct {
+	Subdomain   string            `json:"subdomain,omitempty"`
+	Domain      string            `json:"domain,omitempty"`
+	Random      bool              `json:"random,omitempty"`
+	Wildcard    bool              `json:"wildcard,omitempty"`     // Phục vụ *.domain thay vì domain
+	PathPrefix  string            `json:"path_prefix,omitempty"`  // Chỉ phục vụ path bắt đầu bằng prefix
+	StripPrefix bool              `json:"strip_prefix,omitempty"` // Bỏ PathPrefix trước khi gửi đến agent
+	Passthrough bool              `json:"passthrough,omitempty"`  // TLS passthrough theo SNI, agent tự terminate TLS
+	Metadata    map[string]string `json:"metadata,omitempty"`
+}
+
+// RegisterTunnelResponse là payload trả về khi register thành công
+// FullDomain + PathPrefix xác định tunnel khi unregister
+type RegisterTunnelResponse struct {
+	FullDomain string `json:"full_domain"`
+	Subdomain  string `json:"subdomain,omitempty"`
+	PathPrefix string `json:"path_prefix,omitempty"`
+	Protocol   string `json:"protocol"` // "http" hoặc "tls" (passthrough)
+}
+
+// RegisterTCPTunnelRequest là payload của MsgRegisterTCPTunnel
+// Port = 0 → server tự cấp phát port trong range
+type RegisterTCPTunnelRequest struct {
+	Port     int               `json:"port,omitempty"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+// RegisterTCPTunnelResponse là payload trả về khi mở TCP tunnel thành công
+type RegisterTCPTunnelResponse struct {
+	FullDomain string `json:"full_domain"` // baseDomain:port, dùng cho unregister
+	Port       int    `json:"port"`
+}
+
+// UnregisterTunnelRequest là payload của MsgUnregisterTunnel
+type UnregisterTunnelRequest struct {
+	FullDomain string `json:"full_domain"`
+	PathPrefix string `json:"path_prefix,omitempty"`
+}
+
+// ClaimDomainRequest là payload của MsgClaimDomain
+// Method: "dns" (TXT record) hoặc "http" (origin của domain trả token tại HTTPChallengePath)
+type ClaimDomainRequest struct {
+	Domain string `json:"domain"`
+	Method string `json:"method"`
+}
+
+// VerifyDomainRequest là payload của MsgVerifyDomain
+type VerifyDomainRequest struct {
+	Domain string `json:"domain"`
+}
+
+// DomainClaimResponse là trạng thái claim trả về cho MsgClaimDomain/MsgVerifyDomain
+// Chưa xác minh: agent publish Token tại RecordName (dns) hoặc để URL trả về Token (http)
+type DomainClaimResponse struct {
+	Domain     string `json:"domain"`
+	Method     string `json:"method"`
+	Status     string `json:"status"`
+	Token      string `json:"token"`
+	RecordName string `json:"record_name,omitempty"`
+	URL        string `json:"url,omitempty"`
+}
+
+// GoingAwayMessage là payload của MsgGoingAway: server sắp shutdown
+// Agent nên ngừng register tunnel mới, hoàn tất streams đang chạy trước Deadline
+// rồi reconnect (tới instance khác) sau khi connection bị đóng
+type GoingAwayMessage struct {
+	Reason   string    `json:"reason"`
+	Deadline time.Time `json:"deadline"` // Connection bị đóng cưỡng bức sau thời điểm này
+}
+
+// TunnelClosedMessage là payload của MsgTunnelClosed: server đã đóng tunnel của agent
+// (vd. admin unregister), agent không nên nhận thêm traffic cho FullDomain
+type TunnelClosedMessage struct {
+	FullDomain string `json:"full_domain"`
+	PathPrefix string `json:"path_prefix,omitempty"`
+	Reason     string `json:"reason"`
+}
@@ -0,0 +1,50 @@
+package control
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/hydragon2m/tunnel-core/internal/connection"
+	"github.com/hydragon2m/tunnel-protocol/go/v1"
+)
+
+// SendGoingAway báo agent server đang shutdown, connection sẽ bị đóng sau deadline
+func SendGoingAway(c *connection.Connection, reason string, deadline time.Time) error {
+	return notify(c, MsgGoingAway, GoingAwayMessage{
+		Reason:   reason,
+		Deadline: deadline,
+	})
+}
+
+// SendTunnelClosed báo agent tunnel (fullDomain + pathPrefix) đã bị server đóng
+func SendTunnelClosed(c *connection.Connection, fullDomain, pathPrefix, reason string) error {
+	return notify(c, MsgTunnelClosed, TunnelClosedMessage{
+		FullDomain: fullDomain,
+		PathPrefix: pathPrefix,
+		Reason:     reason,
+	})
+}
+
+// notify gửi notification (Request envelope, không FlagAck) qua control stream
+func notify(c *connection.Connection, msgType string, payload interface{}) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	envelope, err := json.Marshal(Request{
+		Type:    msgType,
+		Payload: data,
+	})
+	if err != nil {
+		return err
+	}
+
+	return c.SendFrame(&v1.Frame{
+		Version:  v1.Version,
+		Type:     v1.FrameData,
+		Flags:    v1.FlagNone,
+		StreamID: v1.StreamIDControl,
+		Payload:  envelope,
+	})
+}
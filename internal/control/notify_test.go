@@ -0,0 +1,50 @@
+package control
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	v1 "github.com/hydragon2m/tunnel-protocol/go/v1"
+)
+
+func TestSendGoingAway(t *testing.T) {
+	_, cm, agent := setup(t, "conn-1", "agent-1")
+
+	c, _ := cm.GetConnection("conn-1")
+	deadline := time.Now().Add(30 * time.Second).Truncate(time.Second)
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- SendGoingAway(c, "server shutting down", deadline)
+	}()
+
+	agent.SetDeadline(time.Now().Add(2 * time.Second))
+	frame, err := v1.Decode(agent)
+	if err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+	if err := <-errCh; err != nil {
+		t.Fatalf("SendGoingAway failed: %v", err)
+	}
+
+	if frame.Type != v1.FrameData || frame.StreamID != v1.StreamIDControl || frame.Flags&v1.FlagAck != 0 {
+		t.Errorf("unexpected frame: type=%v stream=%d flags=%d", frame.Type, frame.StreamID, frame.Flags)
+	}
+
+	var req Request
+	if err := json.Unmarshal(frame.Payload, &req); err != nil {
+		t.Fatalf("invalid envelope: %v", err)
+	}
+	if req.Type != MsgGoingAway {
+		t.Errorf("Type = %q, want %q", req.Type, MsgGoingAway)
+	}
+
+	var msg GoingAwayMessage
+	if err := json.Unmarshal(req.Payload, &msg); err != nil {
+		t.Fatalf("invalid payload: %v", err)
+	}
+	if msg.Reason != "server shutting down" || !msg.Deadline.Equal(deadline) {
+		t.Errorf("payload = %+v", msg)
+	}
+}
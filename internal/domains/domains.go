@@ -0,0 +1,346 @@
+// Package domains quản lý custom domains của agents: claim, xác minh ownership (DNS TXT hoặc HTTP token) và revoke
+package domains
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hydragon2m/tunnel-core/internal/registry"
+)
+
+// Verification methods
+const (
+	MethodDNS  = "dns"  // TXT record tại DNSChallengePrefix + domain chứa token
+	MethodHTTP = "http" // GET http://domain + HTTPChallengePath + token trả về token
+)
+
+// Claim statuses
+const (
+	StatusPending  = "pending"
+	StatusVerified = "verified"
+)
+
+const (
+	// DNSChallengePrefix là label đặt trước domain cho TXT record xác minh
+	DNSChallengePrefix = "_tunnel-challenge."
+	// HTTPChallengePath là path public listener trả token của claim đang chờ xác minh
+	HTTPChallengePath = "/.well-known/tunnel-challenge/"
+
+	// DefaultVerifyTimeout là timeout mặc định của 1 lần xác minh
+	DefaultVerifyTimeout = 10 * time.Second
+	// DefaultMaxPendingClaims là số claims đang chờ xác minh tối đa mặc định của mỗi agent
+	DefaultMaxPendingClaims = 10
+
+	// tokenBytes là số byte random của token (hex → 2x ký tự)
+	tokenBytes = 16
+	// maxDomainLength theo RFC 1035
+	maxDomainLength = 253
+	// maxChallengeBodySize giới hạn body đọc khi xác minh qua HTTP
+	maxChallengeBodySize = 1024
+)
+
+// Resolver tra TXT records (net.Resolver thỏa interface này, tests thay bằng fake)
+type Resolver interface {
+	LookupTXT(ctx context.Context, name string) ([]string, error)
+}
+
+// Config là config cho Manager
+type Config struct {
+	Resolver   Resolver      // nil = net.DefaultResolver
+	Client     *http.Client  // nil = client chỉ kết nối tới địa chỉ public, với Timeout
+	Timeout    time.Duration // Timeout mỗi lần xác minh (0 = DefaultVerifyTimeout)
+	MaxPending int           // Số claims đang chờ tối đa mỗi agent (0 = DefaultMaxPendingClaims)
+}
+
+// Claim là yêu cầu sở hữu custom domain của agent
+type Claim struct {
+	Domain     string
+	AgentID    string
+	Method     string
+	Token      string
+	Status     string
+	CreatedAt  time.Time
+	VerifiedAt time.Time // zero khi chưa xác minh
+}
+
+// RecordName trả về tên TXT record cần tạo (MethodDNS)
+func (c Claim) RecordName() string {
+	return DNSChallengePrefix + c.Domain
+}
+
+// URL trả về URL server gọi khi xác minh (MethodHTTP)
+func (c Claim) URL() string {
+	return "http://" + c.Domain + HTTPChallengePath + c.Token
+}
+
+// Manager lưu claims và xác minh ownership
+// Mỗi domain có tối đa 1 claim đã xác minh; nhiều agents có thể cùng chờ xác minh 1 domain,
+// agent xác minh trước thắng và các claims đang chờ khác bị xóa
+type Manager struct {
+	mu       sync.RWMutex
+	pending  map[string]map[string]*Claim // domain -> agentID -> claim
+	verified map[string]*Claim            // domain -> claim
+	counts   map[string]int               // agentID -> số claims đang chờ
+
+	maxPending int
+
+	baseDomain string
+	resolver   Resolver
+	client     *http.Client
+	timeout    time.Duration
+}
+
+// NewManager tạo Manager, domain thuộc baseDomain không được claim
+func NewManager(baseDomain string, config Config) *Manager {
+	timeout := config.Timeout
+	if timeout <= 0 {
+		timeout = DefaultVerifyTimeout
+	}
+
+	resolver := config.Resolver
+	if resolver == nil {
+		resolver = defaultResolver
+	}
+
+	client := config.Client
+	if client == nil {
+		client = newHTTPClient(timeout)
+	}
+
+	maxPending := config.MaxPending
+	if maxPending <= 0 {
+		maxPending = DefaultMaxPendingClaims
+	}
+
+	return &Manager{
+		pending:    make(map[string]map[string]*Claim),
+		verified:   make(map[string]*Claim),
+		counts:     make(map[string]int),
+		maxPending: maxPending,
+		baseDomain: strings.ToLower(baseDomain),
+		resolver:   resolver,
+		client:     client,
+		timeout:    timeout,
+	}
+}
+
+// Claim tạo (hoặc trả lại) claim đang chờ xác minh của agent cho domain
+// Claim lại cùng domain giữ nguyên token, chỉ đổi method; mỗi agent có tối đa maxPending claims đang chờ
+func (m *Manager) Claim(domain, agentID, method string) (Claim, error) {
+	domain, err := m.normalize(domain)
+	if err != nil {
+		return Claim{}, err
+	}
+	if method != MethodDNS && method != MethodHTTP {
+		return Claim{}, ErrInvalidMethod
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if claim, ok := m.verified[domain]; ok {
+		if claim.AgentID != agentID {
+			return Claim{}, ErrDomainTaken
+		}
+		return *claim, nil
+	}
+
+	if claim, ok := m.pending[domain][agentID]; ok {
+		claim.Method = method
+		return *claim, nil
+	}
+	if m.counts[agentID] >= m.maxPending {
+		return Claim{}, ErrTooManyClaims
+	}
+
+	token, err := newToken()
+	if err != nil {
+		return Claim{}, err
+	}
+	claim := &Claim{
+		Domain:    domain,
+		AgentID:   agentID,
+		Method:    method,
+		Token:     token,
+		Status:    StatusPending,
+		CreatedAt: time.Now(),
+	}
+	if m.pending[domain] == nil {
+		m.pending[domain] = make(map[string]*Claim)
+	}
+	m.pending[domain][agentID] = claim
+	m.counts[agentID]++
+	return *claim, nil
+}
+
+// Verify kiểm tra challenge của claim đang chờ, thành công → domain thuộc về agent
+// Network I/O (DNS/HTTP) chạy ngoài lock, tối đa timeout của Manager
+func (m *Manager) Verify(ctx context.Context, domain, agentID string) (Claim, error) {
+	domain, err := m.normalize(domain)
+	if err != nil {
+		return Claim{}, err
+	}
+
+	m.mu.RLock()
+	if claim, ok := m.verified[domain]; ok {
+		m.mu.RUnlock()
+		if claim.AgentID != agentID {
+			return Claim{}, ErrDomainTaken
+		}
+		return *claim, nil
+	}
+	pending, ok := m.pending[domain][agentID]
+	var claim Claim
+	if ok {
+		claim = *pending
+	}
+	m.mu.RUnlock()
+	if !ok {
+		return Claim{}, ErrClaimNotFound
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, m.timeout)
+	defer cancel()
+	if err := m.check(ctx, claim); err != nil {
+		return Claim{}, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// Claim có thể vừa bị revoke hoặc agent khác xác minh trước
+	if existing, ok := m.verified[domain]; ok && existing.AgentID != agentID {
+		return Claim{}, ErrDomainTaken
+	}
+	if current, ok := m.pending[domain][agentID]; !ok || current.Token != claim.Token {
+		return Claim{}, ErrClaimNotFound
+	}
+
+	claim.Status = StatusVerified
+	claim.VerifiedAt = time.Now()
+	m.verified[domain] = &claim
+	m.deletePendingLocked(domain)
+	return claim, nil
+}
+
+// Revoke xóa domain (claim đã xác minh và các claims đang chờ)
+// Caller chịu trách nhiệm đóng tunnel đang dùng domain
+func (m *Manager) Revoke(domain string) error {
+	domain = normalizeName(domain)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	_, verified := m.verified[domain]
+	_, pending := m.pending[domain]
+	if !verified && !pending {
+		return ErrClaimNotFound
+	}
+
+	delete(m.verified, domain)
+	m.deletePendingLocked(domain)
+	return nil
+}
+
+// deletePendingLocked xóa mọi claims đang chờ của domain, caller giữ m.mu
+func (m *Manager) deletePendingLocked(domain string) {
+	for agentID := range m.pending[domain] {
+		if m.counts[agentID]--; m.counts[agentID] <= 0 {
+			delete(m.counts, agentID)
+		}
+	}
+	delete(m.pending, domain)
+}
+
+// IsVerified kiểm tra domain đã được xác minh cho agent
+func (m *Manager) IsVerified(domain, agentID string) bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	claim, ok := m.verified[normalizeName(domain)]
+	return ok && claim.AgentID == agentID
+}
+
+// Lookup lấy claim đã xác minh của domain
+func (m *Manager) Lookup(domain string) (Claim, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	claim, ok := m.verified[normalizeName(domain)]
+	if !ok {
+		return Claim{}, false
+	}
+	return *claim, true
+}
+
+// Claims trả về bản copy của tất cả claims, sort theo domain rồi agent
+func (m *Manager) Claims() []Claim {
+	m.mu.RLock()
+	claims := make([]Claim, 0, len(m.verified)+len(m.pending))
+	for _, claim := range m.verified {
+		claims = append(claims, *claim)
+	}
+	for _, byAgent := range m.pending {
+		for _, claim := range byAgent {
+			claims = append(claims, *claim)
+		}
+	}
+	m.mu.RUnlock()
+
+	sort.Slice(claims, func(i, j int) bool {
+		if claims[i].Domain != claims[j].Domain {
+			return claims[i].Domain < claims[j].Domain
+		}
+		return claims[i].AgentID < claims[j].AgentID
+	})
+	return claims
+}
+
+// normalize chuẩn hóa và validate domain có thể claim
+func (m *Manager) normalize(domain string) (string, error) {
+	domain = normalizeName(domain)
+	if err := ValidateDomain(domain); err != nil {
+		return "", err
+	}
+	if domain == m.baseDomain || strings.HasSuffix(domain, "."+m.baseDomain) {
+		return "", ErrReservedDomain
+	}
+	return domain, nil
+}
+
+// normalizeName chuyển lowercase và bỏ dấu chấm cuối (FQDN)
+func normalizeName(domain string) string {
+	return strings.TrimSuffix(strings.ToLower(strings.TrimSpace(domain)), ".")
+}
+
+// ValidateDomain kiểm tra domain có ít nhất 2 labels, mỗi label là DNS label hợp lệ
+func ValidateDomain(domain string) error {
+	if len(domain) > maxDomainLength {
+		return ErrInvalidDomain
+	}
+	labels := strings.Split(domain, ".")
+	if len(labels) < 2 {
+		return ErrInvalidDomain
+	}
+	for _, label := range labels {
+		if registry.ValidateSubdomain(label) != nil {
+			return ErrInvalidDomain
+		}
+	}
+	return nil
+}
+
+// newToken sinh token random dạng hex
+func newToken() (string, error) {
+	b := make([]byte, tokenBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,234 @@
+package domains
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeResolver trả TXT records từ map thay vì DNS thật
+type fakeResolver struct {
+	mu      sync.Mutex
+	records map[string][]string
+}
+
+func (f *fakeResolver) LookupTXT(ctx context.Context, name string) ([]string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	records, ok := f.records[name]
+	if !ok {
+		return nil, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+	}
+	return records, nil
+}
+
+func (f *fakeResolver) set(name string, records ...string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.records[name] = records
+}
+
+func newTestManager() (*Manager, *fakeResolver) {
+	resolver := &fakeResolver{records: make(map[string][]string)}
+	return NewManager("tunnel.test", Config{Resolver: resolver}), resolver
+}
+
+func TestManager_ClaimValidation(t *testing.T) {
+	m, _ := newTestManager()
+
+	tests := []struct {
+		domain string
+		method string
+		err    error
+	}{
+		{"localhost", MethodDNS, ErrInvalidDomain},
+		{"bad_label.example.com", MethodDNS, ErrInvalidDomain},
+		{"tunnel.test", MethodDNS, ErrReservedDomain},
+		{"app.tunnel.test", MethodDNS, ErrReservedDomain},
+		{"app.example.com", "email", ErrInvalidMethod},
+	}
+	for _, tt := range tests {
+		if _, err := m.Claim(tt.domain, "agent-1", tt.method); !errors.Is(err, tt.err) {
+			t.Errorf("Claim(%q, %q): expected %v, got %v", tt.domain, tt.method, tt.err, err)
+		}
+	}
+
+	claim, err := m.Claim("App.Example.com.", "agent-1", MethodDNS)
+	if err != nil {
+		t.Fatalf("Claim failed: %v", err)
+	}
+	if claim.Domain != "app.example.com" || claim.Status != StatusPending || claim.Token == "" {
+		t.Errorf("Unexpected claim: %+v", claim)
+	}
+
+	// Claim lại giữ token, chỉ đổi method
+	again, err := m.Claim("app.example.com", "agent-1", MethodHTTP)
+	if err != nil || again.Token != claim.Token || again.Method != MethodHTTP {
+		t.Errorf("Expected same token with new method, got %+v, %v", again, err)
+	}
+}
+
+func TestManager_VerifyDNS(t *testing.T) {
+	m, resolver := newTestManager()
+
+	claim, err := m.Claim("app.example.com", "agent-1", MethodDNS)
+	if err != nil {
+		t.Fatalf("Claim failed: %v", err)
+	}
+	if _, err := m.Claim("app.example.com", "agent-2", MethodDNS); err != nil {
+		t.Fatalf("Competing pending claim failed: %v", err)
+	}
+
+	if _, err := m.Verify(context.Background(), "app.example.com", "agent-1"); !errors.Is(err, ErrVerificationFailed) {
+		t.Fatalf("Expected ErrVerificationFailed without record, got %v", err)
+	}
+	resolver.set(claim.RecordName(), "unrelated", "wrong-token")
+	if _, err := m.Verify(context.Background(), "app.example.com", "agent-1"); !errors.Is(err, ErrVerificationFailed) {
+		t.Fatalf("Expected ErrVerificationFailed with wrong token, got %v", err)
+	}
+
+	resolver.set(claim.RecordName(), "unrelated", claim.Token)
+	verified, err := m.Verify(context.Background(), "app.example.com", "agent-1")
+	if err != nil {
+		t.Fatalf("Verify failed: %v", err)
+	}
+	if verified.Status != StatusVerified || verified.VerifiedAt.IsZero() {
+		t.Errorf("Unexpected verified claim: %+v", verified)
+	}
+	if !m.IsVerified("app.example.com", "agent-1") || m.IsVerified("app.example.com", "agent-2") {
+		t.Error("Expected domain verified for agent-1 only")
+	}
+
+	// Claim đang chờ của agent khác bị xóa, không claim lại được
+	if _, err := m.Verify(context.Background(), "app.example.com", "agent-2"); !errors.Is(err, ErrDomainTaken) {
+		t.Errorf("Expected ErrDomainTaken for agent-2, got %v", err)
+	}
+	if _, err := m.Claim("app.example.com", "agent-2", MethodDNS); !errors.Is(err, ErrDomainTaken) {
+		t.Errorf("Expected ErrDomainTaken on new claim, got %v", err)
+	}
+	if claims := m.Claims(); len(claims) != 1 {
+		t.Errorf("Expected 1 claim left, got %+v", claims)
+	}
+}
+
+func TestManager_VerifyHTTP(t *testing.T) {
+	m, _ := newTestManager()
+	claim, err := m.Claim("app.example.com", "agent-1", MethodHTTP)
+	if err != nil {
+		t.Fatalf("Claim failed: %v", err)
+	}
+
+	// Origin của domain (do agent-1 quản lý) chỉ trả token của agent-1
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != HTTPChallengePath+claim.Token {
+			http.NotFound(w, req)
+			return
+		}
+		w.Write([]byte(claim.Token))
+	}))
+	defer origin.Close()
+
+	// Mọi domain "resolve" về origin
+	m.client = &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return net.Dial(network, origin.Listener.Addr().String())
+		},
+	}}
+
+	// Agent khác claim cùng domain không xác minh được: origin không trả token của nó
+	if _, err := m.Claim("app.example.com", "agent-2", MethodHTTP); err != nil {
+		t.Fatalf("Claim failed: %v", err)
+	}
+	if _, err := m.Verify(context.Background(), "app.example.com", "agent-2"); !errors.Is(err, ErrVerificationFailed) {
+		t.Fatalf("Expected verification of agent-2 to fail, got %v", err)
+	}
+
+	if _, err := m.Verify(context.Background(), "app.example.com", "agent-1"); err != nil {
+		t.Fatalf("Verify failed: %v", err)
+	}
+	if !m.IsVerified("app.example.com", "agent-1") {
+		t.Error("Expected domain verified for agent-1")
+	}
+}
+
+func TestManager_VerifyHTTPRejectsPrivateAddress(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		t.Error("Unexpected request to loopback address")
+	}))
+	defer server.Close()
+
+	// Client mặc định không kết nối tới mạng nội bộ (domain trỏ về 127.0.0.1, 10.x, 169.254.x, ...)
+	_, err := newHTTPClient(time.Second).Get(server.URL + HTTPChallengePath + "token")
+	if !errors.Is(err, ErrPrivateAddress) {
+		t.Errorf("Expected ErrPrivateAddress, got %v", err)
+	}
+
+	for _, ip := range []string{"127.0.0.1", "::1", "10.1.2.3", "192.168.1.1", "169.254.169.254", "fe80::1", "0.0.0.0"} {
+		if isPublicIP(net.ParseIP(ip)) {
+			t.Errorf("Expected %s to be rejected", ip)
+		}
+	}
+	if !isPublicIP(net.ParseIP("93.184.216.34")) {
+		t.Error("Expected public address to be allowed")
+	}
+}
+
+func TestManager_MaxPendingClaims(t *testing.T) {
+	m := NewManager("tunnel.test", Config{Resolver: &fakeResolver{records: make(map[string][]string)}, MaxPending: 2})
+
+	for _, domain := range []string{"a.example.com", "b.example.com"} {
+		if _, err := m.Claim(domain, "agent-1", MethodDNS); err != nil {
+			t.Fatalf("Claim %s failed: %v", domain, err)
+		}
+	}
+	if _, err := m.Claim("c.example.com", "agent-1", MethodDNS); !errors.Is(err, ErrTooManyClaims) {
+		t.Fatalf("Expected ErrTooManyClaims, got %v", err)
+	}
+
+	// Claim lại domain đang chờ không tính thêm; agent khác có giới hạn riêng
+	if _, err := m.Claim("a.example.com", "agent-1", MethodHTTP); err != nil {
+		t.Errorf("Re-claim failed: %v", err)
+	}
+	if _, err := m.Claim("c.example.com", "agent-2", MethodDNS); err != nil {
+		t.Errorf("Claim of agent-2 failed: %v", err)
+	}
+
+	// Revoke trả lại slot
+	if err := m.Revoke("a.example.com"); err != nil {
+		t.Fatalf("Revoke failed: %v", err)
+	}
+	if _, err := m.Claim("c.example.com", "agent-1", MethodDNS); err != nil {
+		t.Errorf("Claim after revoke failed: %v", err)
+	}
+}
+
+func TestManager_Revoke(t *testing.T) {
+	m, resolver := newTestManager()
+
+	claim, _ := m.Claim("app.example.com", "agent-1", MethodDNS)
+	resolver.set(claim.RecordName(), claim.Token)
+	if _, err := m.Verify(context.Background(), "app.example.com", "agent-1"); err != nil {
+		t.Fatalf("Verify failed: %v", err)
+	}
+
+	if err := m.Revoke("app.example.com"); err != nil {
+		t.Fatalf("Revoke failed: %v", err)
+	}
+	if m.IsVerified("app.example.com", "agent-1") {
+		t.Error("Expected domain not verified after revoke")
+	}
+	if err := m.Revoke("app.example.com"); !errors.Is(err, ErrClaimNotFound) {
+		t.Errorf("Expected ErrClaimNotFound, got %v", err)
+	}
+
+	// Domain revoke xong có thể được agent khác claim
+	if _, err := m.Claim("app.example.com", "agent-2", MethodDNS); err != nil {
+		t.Errorf("Claim after revoke failed: %v", err)
+	}
+}
@@ -0,0 +1,15 @@
+package domains
+
+import "errors"
+
+var (
+	ErrInvalidDomain      = errors.New("invalid domain")
+	ErrReservedDomain     = errors.New("domain belongs to the server base domain")
+	ErrInvalidMethod      = errors.New("invalid verification method")
+	ErrDomainTaken        = errors.New("domain already verified by another agent")
+	ErrClaimNotFound      = errors.New("domain claim not found")
+	ErrVerificationFailed = errors.New("domain verification failed")
+	ErrDomainNotVerified  = errors.New("domain not verified")
+	ErrTooManyClaims      = errors.New("too many pending domain claims")
+	ErrPrivateAddress     = errors.New("domain resolves to a non-public address")
+)
@@ -0,0 +1,97 @@
+package domains
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// defaultResolver là resolver hệ thống, dùng khi Config.Resolver nil
+var defaultResolver Resolver = net.DefaultResolver
+
+// check xác minh challenge của claim theo method
+func (m *Manager) check(ctx context.Context, claim Claim) error {
+	switch claim.Method {
+	case MethodDNS:
+		return m.checkDNS(ctx, claim)
+	case MethodHTTP:
+		return m.checkHTTP(ctx, claim)
+	default:
+		return ErrInvalidMethod
+	}
+}
+
+// checkDNS tìm token trong TXT records tại RecordName
+func (m *Manager) checkDNS(ctx context.Context, claim Claim) error {
+	records, err := m.resolver.LookupTXT(ctx, claim.RecordName())
+	if err != nil {
+		return fmt.Errorf("%w: TXT lookup %s: %v", ErrVerificationFailed, claim.RecordName(), err)
+	}
+	for _, record := range records {
+		if strings.TrimSpace(record) == claim.Token {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: TXT record %s does not contain the token", ErrVerificationFailed, claim.RecordName())
+}
+
+// checkHTTP gọi URL của claim, body phải đúng bằng token
+// Token phải do origin của domain trả về: server không tự trả challenge (mọi agent đều route qua server),
+// nên domain chỉ trỏ về public listener thì xác minh HTTP thất bại
+func (m *Manager) checkHTTP(ctx context.Context, claim Claim) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, claim.URL(), nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("%w: GET %s: %v", ErrVerificationFailed, claim.URL(), err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxChallengeBodySize))
+	if err != nil {
+		return fmt.Errorf("%w: GET %s: %v", ErrVerificationFailed, claim.URL(), err)
+	}
+	if resp.StatusCode != http.StatusOK || strings.TrimSpace(string(body)) != claim.Token {
+		return fmt.Errorf("%w: GET %s returned status %d without the token", ErrVerificationFailed, claim.URL(), resp.StatusCode)
+	}
+	return nil
+}
+
+// newHTTPClient tạo client xác minh HTTP chỉ được kết nối tới địa chỉ public
+// Kiểm tra tại lúc dial (sau khi resolve, cả khi redirect) để domain không thể trỏ server vào mạng nội bộ
+func newHTTPClient(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{
+		Timeout: timeout,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || !isPublicIP(ip) {
+				return fmt.Errorf("%w: %s", ErrPrivateAddress, host)
+			}
+			return nil
+		},
+	}
+	return &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			DialContext:       dialer.DialContext,
+			DisableKeepAlives: true,
+		},
+	}
+}
+
+// isPublicIP kiểm tra ip không phải loopback, private, link-local, multicast hay unspecified
+func isPublicIP(ip net.IP) bool {
+	return !(ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified())
+}
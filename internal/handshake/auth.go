@@ -1,27 +1,62 @@
 package handshake
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
 	"time"
 
+	"github.com/hydragon2m/tunnel-core/internal/connection"
+	"github.com/hydragon2m/tunnel-core/internal/metrics"
 	"github.com/hydragon2m/tunnel-protocol/go/v1"
 )
 
+// AuthMode chọn credentials agent phải xuất trình
+type AuthMode string
+
+const (
+	AuthModeToken AuthMode = "token" // Bearer token, client cert tùy chọn
+	AuthModeCert  AuthMode = "cert"  // Client certificate, agent ID lấy từ cert
+	AuthModeBoth  AuthMode = "both"  // Cả 2, agent ID của token phải khớp với cert
+)
+
+// Metadata do server ghi (giá trị client tự khai bị bỏ), dùng để đối chiếu revocation list
+const (
+	MetadataTokenHash       = "token_sha256"     // SHA-256 (hex) của token đã xác thực
+	MetadataCertFingerprint = "cert_fingerprint" // SHA-256 (hex) của client certificate
+)
+
+// RevocationChecker kiểm tra credentials của agent đã bị thu hồi (vd. revocation.List)
+type RevocationChecker interface {
+	Revoked(agentID string, metadata map[string]string) bool
+}
+
 // Authenticator xử lý authentication handshake với agent
 type Authenticator struct {
 	// Token validator
-	validateToken func(token string) (agentID string, err error)
+	validator TokenValidator
 	
 	// Config
 	authTimeout time.Duration
+	mode        AuthMode
+	revocations RevocationChecker
+	features    map[string]bool // Features server hỗ trợ, dùng khi thỏa thuận với agent
+
+	metrics *metrics.Metrics
 }
 
 // AuthRequest là payload của FrameAuth từ agent
 type AuthRequest struct {
 	Token      string            `json:"token"`
 	AgentID    string            `json:"agent_id,omitempty"`
-	Version    string            `json:"version,omitempty"`
-	Capabilities []string        `json:"capabilities,omitempty"`
+	Version    string            `json:"version,omitempty"` // Version của agent software
+	ProtocolVersion int          `json:"protocol_version,omitempty"` // 0 = agent cũ (trước khi có negotiation)
+	Capabilities []string        `json:"capabilities,omitempty"` // Features agent hỗ trợ, dùng nếu server cũng hỗ trợ
+	Requires   []string          `json:"requires,omitempty"` // Features bắt buộc, server không hỗ trợ → từ chối
 	Metadata   map[string]string `json:"metadata,omitempty"`
 }
 
@@ -35,16 +70,69 @@ type AuthResponse struct {
 }
 
 // NewAuthenticator tạo Authenticator mới
-func NewAuthenticator(validateToken func(token string) (agentID string, err error), authTimeout time.Duration) *Authenticator {
+// authTimeout giới hạn thời gian validate token (vd. gọi webhook)
+func NewAuthenticator(validator TokenValidator, authTimeout time.Duration) *Authenticator {
 	return &Authenticator{
-		validateToken: validateToken,
-		authTimeout:   authTimeout,
+		validator:   validator,
+		authTimeout: authTimeout,
+		mode:        AuthModeToken,
+	}
+}
+
+// SetMode chọn credentials bắt buộc (mặc định AuthModeToken)
+func (a *Authenticator) SetMode(mode AuthMode) {
+	a.mode = mode
+}
+
+// SetRevocations từ chối agent có token, agent ID hoặc cert đã bị thu hồi (nil = tắt)
+func (a *Authenticator) SetRevocations(revocations RevocationChecker) {
+	a.revocations = revocations
+}
+
+// SetFeatures set features server hỗ trợ (connection.Capability*), mặc định không có feature nào
+// Features đã thỏa thuận với agent được ghi vào metadata[connection.MetadataFeatures]
+func (a *Authenticator) SetFeatures(features []string) {
+	a.features = make(map[string]bool, len(features))
+	for _, f := range features {
+		a.features[f] = true
+	}
+}
+
+// SetMetrics set metrics cho auth failures (nil = tắt)
+func (a *Authenticator) SetMetrics(m *metrics.Metrics) {
+	a.metrics = m
+}
+
+// authFailureReason map lỗi auth → label cho metrics
+func authFailureReason(err error) string {
+	switch {
+	case errors.Is(err, ErrTokenExpired):
+		return metrics.AuthReasonTokenExpired
+	case errors.Is(err, ErrCredentialsRevoked):
+		return metrics.AuthReasonRevoked
+	case IsNegotiationError(err):
+		return metrics.AuthReasonIncompatible
+	case isClientCertError(err):
+		return metrics.AuthReasonInvalidCert
+	case IsAuthError(err):
+		return metrics.AuthReasonInvalidToken
+	case errors.Is(err, ErrInvalidFrameType), errors.Is(err, ErrAuthMustBeControlFrame), errors.Is(err, ErrInvalidAuthPayload):
+		return metrics.AuthReasonBadFrame
+	default:
+		return metrics.AuthReasonValidatorUnavailable
 	}
 }
 
 // HandleAuth xử lý FrameAuth từ agent
+// clientCert là danh tính từ client certificate đã verify (nil nếu agent không dùng mTLS)
 // Returns: agentID, metadata, error
-func (a *Authenticator) HandleAuth(frame *v1.Frame) (agentID string, metadata map[string]string, err error) {
+func (a *Authenticator) HandleAuth(frame *v1.Frame, clientCert *ClientCert) (agentID string, metadata map[string]string, err error) {
+	defer func() {
+		if err != nil {
+			a.metrics.AuthFailed(authFailureReason(err))
+		}
+	}()
+
 	// Validate frame type
 	if frame.Type != v1.FrameAuth {
 		return "", nil, ErrInvalidFrameType
@@ -61,18 +149,31 @@ func (a *Authenticator) HandleAuth(frame *v1.Frame) (agentID string, metadata ma
 		return "", nil, ErrInvalidAuthPayload
 	}
 	
-	// Validate token
-	if a.validateToken == nil {
-		return "", nil, ErrNoTokenValidator
+	// Client certificate (mode cert/both)
+	if a.mode != AuthModeToken {
+		if clientCert == nil {
+			return "", nil, ErrClientCertRequired
+		}
+		if clientCert.AgentID == "" {
+			return "", nil, ErrClientCertNoAgentID
+		}
 	}
 	
-	validatedAgentID, err := a.validateToken(req.Token)
-	if err != nil {
-		return "", nil, err
+	// Validate token (mode cert: agent ID lấy từ cert, token bị bỏ qua)
+	identity := &Identity{}
+	if a.mode == AuthModeCert {
+		identity.AgentID = clientCert.AgentID
+	} else {
+		if identity, err = a.validateToken(req.Token); err != nil {
+			return "", nil, err
+		}
+		if a.mode == AuthModeBoth && identity.AgentID != clientCert.AgentID {
+			return "", nil, fmt.Errorf("%w: token %q, certificate %q", ErrAgentIDMismatch, identity.AgentID, clientCert.AgentID)
+		}
 	}
 	
 	// Use validated agent ID (server is source of truth)
-	agentID = validatedAgentID
+	agentID = identity.AgentID
 	
 	// Build metadata
 	metadata = make(map[string]string)
@@ -94,9 +195,67 @@ func (a *Authenticator) HandleAuth(frame *v1.Frame) (agentID string, metadata ma
 		metadata[k] = v
 	}
 	
+	// Server-side attributes từ validator ghi đè metadata của client
+	for k, v := range identity.Attributes {
+		metadata[k] = v
+	}
+	
+	// Hash của token và fingerprint của client cert (cả mode token nếu agent gửi cert), không nhận giá trị từ client
+	delete(metadata, MetadataTokenHash)
+	delete(metadata, MetadataCertFingerprint)
+	if a.mode != AuthModeCert {
+		metadata[MetadataTokenHash] = TokenHash(req.Token)
+	}
+	if clientCert != nil {
+		metadata[MetadataCertFingerprint] = clientCert.Fingerprint
+	}
+	
+	if a.revocations != nil && a.revocations.Revoked(agentID, metadata) {
+		return "", nil, fmt.Errorf("%w: agent %q", ErrCredentialsRevoked, agentID)
+	}
+	
+	// Protocol version và features đã thỏa thuận: chỉ server ghi, các thành phần khác đọc qua connection.HasFeature
+	delete(metadata, connection.MetadataFeatures)
+	delete(metadata, MetadataProtocolVersion)
+	version, features, err := a.negotiate(&req)
+	if err != nil {
+		return "", nil, err
+	}
+	metadata[MetadataProtocolVersion] = strconv.Itoa(version)
+	metadata[connection.MetadataFeatures] = encodeFeatures(features)
+	
 	return agentID, metadata, nil
 }
 
+// validateToken xác thực token qua validator (giới hạn bởi authTimeout)
+func (a *Authenticator) validateToken(token string) (*Identity, error) {
+	if a.validator == nil {
+		return nil, ErrNoTokenValidator
+	}
+
+	ctx := context.Background()
+	if a.authTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, a.authTimeout)
+		defer cancel()
+	}
+
+	identity, err := a.validator.ValidateToken(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+	if identity == nil || identity.AgentID == "" {
+		return nil, ErrInvalidToken
+	}
+	return identity, nil
+}
+
+// TokenHash trả về SHA-256 (hex) của token, dạng token được lưu trong metadata và revocation list
+func TokenHash(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
 // CreateAuthResponse tạo FrameAuth response để gửi cho agent
 func (a *Authenticator) CreateAuthResponse(success bool, agentID string, config map[string]interface{}, errMsg string) (*v1.Frame, error) {
 	resp := AuthResponse{
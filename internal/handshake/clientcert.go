@@ -0,0 +1,274 @@
+package handshake
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// Nguồn agent ID trong client certificate
+const (
+	CertAgentIDCommonName = "common_name" // Subject CN
+	CertAgentIDDNSSAN     = "dns_san"     // DNS SAN đầu tiên
+	CertAgentIDURISAN     = "uri_san"     // URI SAN đầu tiên (vd. spiffe://...)
+	CertAgentIDEmailSAN   = "email_san"   // Email SAN đầu tiên
+)
+
+// ClientCert là danh tính agent lấy từ client certificate đã được TLS verify
+type ClientCert struct {
+	AgentID     string // Rỗng nếu cert không có field được chọn làm agent ID
+	Fingerprint string // SHA-256 (hex) của leaf certificate
+	Subject     string
+}
+
+// ClientCertConfig là config cho ClientCertVerifier
+type ClientCertConfig struct {
+	CAFile      string // CA bundle (PEM) ký client certs
+	CRLFile     string // CRL (PEM hoặc DER), rỗng = không kiểm tra thu hồi
+	AgentIDFrom string // CertAgentID*, rỗng = CertAgentIDCommonName
+}
+
+// ClientCertVerifier verify client certificates của agent theo CA bundle và CRL,
+// và lấy agent ID từ subject/SAN
+type ClientCertVerifier struct {
+	pool        *x509.CertPool
+	cas         []*x509.Certificate
+	crlFile     string
+	agentIDFrom string
+
+	mu         sync.Mutex
+	crls       []*crlEntry
+	crlModTime time.Time
+}
+
+// crlEntry là CRL đã đọc cùng kết quả kiểm tra chữ ký theo từng issuer cert (raw DER → hợp lệ)
+type crlEntry struct {
+	list *x509.RevocationList
+
+	mu      sync.Mutex
+	signers map[string]bool
+}
+
+// signedBy kiểm tra CRL được ký bởi issuer, kết quả được cache theo issuer
+func (c *crlEntry) signedBy(issuer *x509.Certificate) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	ok, checked := c.signers[string(issuer.Raw)]
+	if !checked {
+		ok = bytes.Equal(issuer.RawSubject, c.list.RawIssuer) && c.list.CheckSignatureFrom(issuer) == nil
+		c.signers[string(issuer.Raw)] = ok
+	}
+	return ok
+}
+
+// NewClientCertVerifier đọc CA bundle và CRL
+func NewClientCertVerifier(config ClientCertConfig) (*ClientCertVerifier, error) {
+	data, err := os.ReadFile(config.CAFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read client CA file: %w", err)
+	}
+
+	v := &ClientCertVerifier{
+		pool:        x509.NewCertPool(),
+		crlFile:     config.CRLFile,
+		agentIDFrom: config.AgentIDFrom,
+	}
+	if v.agentIDFrom == "" {
+		v.agentIDFrom = CertAgentIDCommonName
+	}
+
+	for block, rest := pem.Decode(data); block != nil; block, rest = pem.Decode(rest) {
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		ca, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse client CA certificate: %w", err)
+		}
+		v.pool.AddCert(ca)
+		v.cas = append(v.cas, ca)
+	}
+	if len(v.cas) == 0 {
+		return nil, ErrNoClientCAs
+	}
+
+	if v.crlFile != "" {
+		if err := v.reloadCRL(); err != nil {
+			return nil, err
+		}
+	}
+	return v, nil
+}
+
+// ConfigureTLS bật client certificate trên TLS config của agent listener
+// required = handshake thất bại nếu agent không gửi cert, ngược lại cert là tùy chọn (vẫn được verify nếu có)
+func (v *ClientCertVerifier) ConfigureTLS(config *tls.Config, required bool) {
+	config.ClientCAs = v.pool
+	config.ClientAuth = tls.VerifyClientCertIfGiven
+	if required {
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	config.VerifyPeerCertificate = v.verifyPeerCertificate
+}
+
+// Identify trả về danh tính từ client certificate của connection (nil nếu agent không gửi cert)
+func (v *ClientCertVerifier) Identify(state tls.ConnectionState) *ClientCert {
+	if len(state.VerifiedChains) == 0 || len(state.VerifiedChains[0]) == 0 {
+		return nil
+	}
+	leaf := state.VerifiedChains[0][0]
+
+	sum := sha256.Sum256(leaf.Raw)
+	return &ClientCert{
+		AgentID:     certAgentID(leaf, v.agentIDFrom),
+		Fingerprint: hex.EncodeToString(sum[:]),
+		Subject:     leaf.Subject.String(),
+	}
+}
+
+// certAgentID lấy agent ID từ field được chọn của cert
+func certAgentID(cert *x509.Certificate, from string) string {
+	switch from {
+	case CertAgentIDDNSSAN:
+		if len(cert.DNSNames) > 0 {
+			return cert.DNSNames[0]
+		}
+	case CertAgentIDURISAN:
+		if len(cert.URIs) > 0 {
+			return cert.URIs[0].String()
+		}
+	case CertAgentIDEmailSAN:
+		if len(cert.EmailAddresses) > 0 {
+			return cert.EmailAddresses[0]
+		}
+	default:
+		return cert.Subject.CommonName
+	}
+	return ""
+}
+
+// verifyPeerCertificate từ chối chain có cert bị thu hồi (chạy sau khi crypto/tls verify chain với CA bundle)
+func (v *ClientCertVerifier) verifyPeerCertificate(_ [][]byte, verifiedChains [][]*x509.Certificate) error {
+	if v.crlFile == "" {
+		return nil
+	}
+	crls := v.currentCRLs()
+
+	for _, chain := range verifiedChains {
+		// Bỏ qua root (cert cuối của chain); issuer của mỗi cert là cert kế tiếp trong chain
+		for i, cert := range chain[:len(chain)-1] {
+			if revoked(cert, chain[i+1], crls) {
+				return fmt.Errorf("%w: serial %s (%s)", ErrClientCertRevoked, cert.SerialNumber, cert.Subject)
+			}
+		}
+	}
+	return nil
+}
+
+// revoked kiểm tra cert có trong CRL của issuer (CRL có chữ ký không khớp issuer bị bỏ qua)
+func revoked(cert, issuer *x509.Certificate, crls []*crlEntry) bool {
+	for _, crl := range crls {
+		if !bytes.Equal(crl.list.RawIssuer, cert.RawIssuer) || !crl.signedBy(issuer) {
+			continue
+		}
+		for _, entry := range crl.list.RevokedCertificateEntries {
+			if entry.SerialNumber.Cmp(cert.SerialNumber) == 0 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// currentCRLs trả về CRLs, đọc lại file nếu đã thay đổi
+// Đọc lỗi (vd. file đang được ghi) → giữ CRLs cũ
+func (v *ClientCertVerifier) currentCRLs() []*crlEntry {
+	if info, err := os.Stat(v.crlFile); err == nil {
+		v.mu.Lock()
+		changed := !info.ModTime().Equal(v.crlModTime)
+		v.mu.Unlock()
+		if changed {
+			if err := v.reloadCRL(); err != nil {
+				slog.Warn("Failed to reload CRL, keeping previous one", "file", v.crlFile, "error", err)
+			}
+		}
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.crls
+}
+
+// reloadCRL đọc CRL file (1 hoặc nhiều PEM "X509 CRL" blocks, hoặc 1 DER)
+// CRL của CA trong bundle được kiểm tra chữ ký ngay; CRL của intermediate CA được kiểm tra khi dùng,
+// với issuer lấy từ chain đã verify
+func (v *ClientCertVerifier) reloadCRL() error {
+	info, err := os.Stat(v.crlFile)
+	if err != nil {
+		return fmt.Errorf("failed to read CRL file: %w", err)
+	}
+	data, err := os.ReadFile(v.crlFile)
+	if err != nil {
+		return fmt.Errorf("failed to read CRL file: %w", err)
+	}
+
+	var ders [][]byte
+	for block, rest := pem.Decode(data); block != nil; block, rest = pem.Decode(rest) {
+		if block.Type == "X509 CRL" {
+			ders = append(ders, block.Bytes)
+		}
+	}
+	if len(ders) == 0 {
+		ders = [][]byte{data}
+	}
+
+	crls := make([]*crlEntry, 0, len(ders))
+	for _, der := range ders {
+		list, err := x509.ParseRevocationList(der)
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidCRL, err)
+		}
+		crl := &crlEntry{list: list, signers: make(map[string]bool)}
+		if err := v.checkCRLSignature(crl); err != nil {
+			return err
+		}
+		if !list.NextUpdate.IsZero() && time.Now().After(list.NextUpdate) {
+			slog.Warn("CRL is past its next update", "file", v.crlFile, "issuer", list.Issuer, "next_update", list.NextUpdate)
+		}
+		crls = append(crls, crl)
+	}
+
+	v.mu.Lock()
+	v.crls = crls
+	v.crlModTime = info.ModTime()
+	v.mu.Unlock()
+	return nil
+}
+
+// checkCRLSignature kiểm tra CRL có issuer là CA trong bundle được ký bởi CA đó
+// Issuer không có trong bundle (intermediate CA) → chưa kiểm tra được, bỏ qua
+func (v *ClientCertVerifier) checkCRLSignature(crl *crlEntry) error {
+	inBundle := false
+	for _, ca := range v.cas {
+		if !bytes.Equal(ca.RawSubject, crl.list.RawIssuer) {
+			continue
+		}
+		if crl.signedBy(ca) {
+			return nil
+		}
+		inBundle = true
+	}
+	if inBundle {
+		return fmt.Errorf("%w: signature of %s does not match the client CA", ErrInvalidCRL, crl.list.Issuer)
+	}
+	return nil
+}
@@ -0,0 +1,385 @@
+package handshake
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	v1 "github.com/hydragon2m/tunnel-protocol/go/v1"
+)
+
+// testCA là CA tự ký dùng để cấp client/server certs trong tests
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey failed: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "agents CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("CreateCertificate failed: %v", err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return &testCA{cert: cert, key: key}
+}
+
+// intermediate cấp intermediate CA (subject CN = name) ký bởi ca
+func (ca *testCA) intermediate(t *testing.T, name string) *testCA {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey failed: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(50),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatalf("CreateCertificate failed: %v", err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return &testCA{cert: cert, key: key}
+}
+
+// issue cấp cert cho template (serial do caller chọn)
+func (ca *testCA) issue(t *testing.T, template *x509.Certificate) tls.Certificate {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey failed: %v", err)
+	}
+	template.NotBefore = time.Now().Add(-time.Hour)
+	template.NotAfter = time.Now().Add(time.Hour)
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatalf("CreateCertificate failed: %v", err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+// writeCRL ghi CRL (PEM) thu hồi các serials vào path
+func (ca *testCA) writeCRL(t *testing.T, path string, number int64, serials ...int64) {
+	t.Helper()
+
+	var entries []x509.RevocationListEntry
+	for _, serial := range serials {
+		entries = append(entries, x509.RevocationListEntry{SerialNumber: big.NewInt(serial), RevocationTime: time.Now()})
+	}
+	der, err := x509.CreateRevocationList(rand.Reader, &x509.RevocationList{
+		Number:                    big.NewInt(number),
+		ThisUpdate:                time.Now().Add(-time.Minute),
+		NextUpdate:                time.Now().Add(time.Hour),
+		RevokedCertificateEntries: entries,
+	}, ca.cert, ca.key)
+	if err != nil {
+		t.Fatalf("CreateRevocationList failed: %v", err)
+	}
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "X509 CRL", Bytes: der}), 0o600); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+}
+
+// handshakeWith chạy TLS handshake giữa server (verifier) và client (cert, nil = không gửi cert)
+func handshakeWith(t *testing.T, ca *testCA, v *ClientCertVerifier, required bool, cert *tls.Certificate) (*ClientCert, error) {
+	t.Helper()
+
+	serverCert := ca.issue(t, &x509.Certificate{SerialNumber: big.NewInt(100), DNSNames: []string{"tunnel.test"}})
+	config := &tls.Config{Certificates: []tls.Certificate{serverCert}}
+	v.ConfigureTLS(config, required)
+
+	// TCP loopback thay vì net.Pipe: cả 2 phía có thể ghi cùng lúc (alert của server và Finished của client)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	defer ln.Close()
+	clientConn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer clientConn.Close()
+	serverConn, err := ln.Accept()
+	if err != nil {
+		t.Fatalf("Accept failed: %v", err)
+	}
+	defer serverConn.Close()
+
+	clientConfig := &tls.Config{InsecureSkipVerify: true}
+	if cert != nil {
+		clientConfig.Certificates = []tls.Certificate{*cert}
+	}
+	client := tls.Client(clientConn, clientConfig)
+	go func() {
+		client.Handshake()
+		// TLS 1.3: client chỉ biết cert bị từ chối khi đọc alert
+		client.Read(make([]byte, 1))
+	}()
+
+	server := tls.Server(serverConn, config)
+	if err := server.Handshake(); err != nil {
+		return nil, err
+	}
+	return v.Identify(server.ConnectionState()), nil
+}
+
+func TestClientCertVerifier(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCA(t)
+	caFile := filepath.Join(dir, "ca.pem")
+	os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.cert.Raw}), 0o600)
+	crlFile := filepath.Join(dir, "ca.crl")
+	ca.writeCRL(t, crlFile, 1, 3)
+
+	v, err := NewClientCertVerifier(ClientCertConfig{CAFile: caFile, CRLFile: crlFile, AgentIDFrom: CertAgentIDURISAN})
+	if err != nil {
+		t.Fatalf("NewClientCertVerifier failed: %v", err)
+	}
+
+	agent := ca.issue(t, &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "agent-1"},
+		URIs:         []*url.URL{{Scheme: "spiffe", Host: "tunnel.test", Path: "/agent/agent-1"}},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+	identity, err := handshakeWith(t, ca, v, true, &agent)
+	if err != nil {
+		t.Fatalf("Handshake with valid cert failed: %v", err)
+	}
+	if identity == nil || identity.AgentID != "spiffe://tunnel.test/agent/agent-1" || len(identity.Fingerprint) != 64 {
+		t.Errorf("Unexpected identity: %+v", identity)
+	}
+
+	// Cert bị thu hồi
+	revokedCert := ca.issue(t, &x509.Certificate{
+		SerialNumber: big.NewInt(3),
+		Subject:      pkix.Name{CommonName: "agent-2"},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+	if _, err := handshakeWith(t, ca, v, true, &revokedCert); !errors.Is(err, ErrClientCertRevoked) {
+		t.Errorf("Expected ErrClientCertRevoked, got %v", err)
+	}
+
+	// CRL mới (file thay đổi) được đọc lại: agent-1 bị thu hồi
+	ca.writeCRL(t, crlFile, 2, 2, 3)
+	future := time.Now().Add(time.Minute)
+	os.Chtimes(crlFile, future, future)
+	if _, err := handshakeWith(t, ca, v, true, &agent); !errors.Is(err, ErrClientCertRevoked) {
+		t.Errorf("Expected ErrClientCertRevoked after CRL reload, got %v", err)
+	}
+
+	// Cert của CA khác
+	other := newTestCA(t).issue(t, &x509.Certificate{
+		SerialNumber: big.NewInt(4),
+		Subject:      pkix.Name{CommonName: "agent-1"},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+	if _, err := handshakeWith(t, ca, v, true, &other); err == nil {
+		t.Error("Expected handshake to fail for cert from unknown CA")
+	}
+
+	// Cert tùy chọn: không gửi cert vẫn handshake được, không có danh tính
+	if identity, err := handshakeWith(t, ca, v, false, nil); err != nil || identity != nil {
+		t.Errorf("Expected optional cert handshake without identity, got %+v, %v", identity, err)
+	}
+	if _, err := handshakeWith(t, ca, v, true, nil); err == nil {
+		t.Error("Expected handshake to fail without cert when required")
+	}
+}
+
+func TestNewClientCertVerifier_InvalidCRL(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCA(t)
+	caFile := filepath.Join(dir, "ca.pem")
+	os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.cert.Raw}), 0o600)
+
+	// CRL mang tên CA trong bundle nhưng ký bởi key khác
+	crlFile := filepath.Join(dir, "other.crl")
+	newTestCA(t).writeCRL(t, crlFile, 1)
+	if _, err := NewClientCertVerifier(ClientCertConfig{CAFile: caFile, CRLFile: crlFile}); !errors.Is(err, ErrInvalidCRL) {
+		t.Errorf("Expected ErrInvalidCRL, got %v", err)
+	}
+
+	if _, err := NewClientCertVerifier(ClientCertConfig{CAFile: crlFile}); !errors.Is(err, ErrNoClientCAs) {
+		t.Errorf("Expected ErrNoClientCAs, got %v", err)
+	}
+}
+
+func TestClientCertVerifier_IntermediateCRL(t *testing.T) {
+	dir := t.TempDir()
+	root := newTestCA(t)
+	caFile := filepath.Join(dir, "ca.pem")
+	os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: root.cert.Raw}), 0o600)
+
+	// Bundle chỉ có root; agent certs do intermediate cấp và CRL do intermediate ký
+	intermediate := root.intermediate(t, "agents intermediate CA")
+	crlFile := filepath.Join(dir, "intermediate.crl")
+	intermediate.writeCRL(t, crlFile, 1, 11)
+
+	v, err := NewClientCertVerifier(ClientCertConfig{CAFile: caFile, CRLFile: crlFile})
+	if err != nil {
+		t.Fatalf("NewClientCertVerifier failed: %v", err)
+	}
+
+	issue := func(serial int64) *tls.Certificate {
+		cert := intermediate.issue(t, &x509.Certificate{
+			SerialNumber: big.NewInt(serial),
+			Subject:      pkix.Name{CommonName: "agent-1"},
+			ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		})
+		cert.Certificate = append(cert.Certificate, intermediate.cert.Raw)
+		return &cert
+	}
+	valid, revokedCert := issue(10), issue(11)
+
+	if identity, err := handshakeWith(t, root, v, true, valid); err != nil || identity == nil || identity.AgentID != "agent-1" {
+		t.Fatalf("Handshake with valid cert failed: %+v, %v", identity, err)
+	}
+	if _, err := handshakeWith(t, root, v, true, revokedCert); !errors.Is(err, ErrClientCertRevoked) {
+		t.Errorf("Expected ErrClientCertRevoked, got %v", err)
+	}
+
+	// CRL giả cùng tên intermediate nhưng ký bởi key khác: không được dùng để thu hồi
+	newTestCA(t).intermediate(t, "agents intermediate CA").writeCRL(t, crlFile, 2, 10)
+	future := time.Now().Add(time.Minute)
+	os.Chtimes(crlFile, future, future)
+	if _, err := handshakeWith(t, root, v, true, valid); err != nil {
+		t.Errorf("Expected forged CRL to be ignored, got %v", err)
+	}
+}
+
+// authFrame tạo FrameAuth với token
+func authFrame(token string, metadata map[string]string) *v1.Frame {
+	payload, _ := json.Marshal(AuthRequest{Token: token, Metadata: metadata})
+	return &v1.Frame{
+		Version:  v1.Version,
+		Type:     v1.FrameAuth,
+		StreamID: v1.StreamIDControl,
+		Payload:  payload,
+	}
+}
+
+func TestAuthenticator_Modes(t *testing.T) {
+	validator, _ := NewStaticValidator(map[string]StaticToken{
+		"tok-1": {AgentID: "agent-1"},
+	})
+	cert := &ClientCert{AgentID: "agent-1", Fingerprint: "abc"}
+	otherCert := &ClientCert{AgentID: "agent-2", Fingerprint: "def"}
+
+	tests := []struct {
+		name    string
+		mode    AuthMode
+		token   string
+		cert    *ClientCert
+		wantErr error
+	}{
+		{"token only", AuthModeToken, "tok-1", nil, nil},
+		{"token with optional cert", AuthModeToken, "tok-1", otherCert, nil},
+		{"cert only", AuthModeCert, "", cert, nil},
+		{"cert mode without cert", AuthModeCert, "tok-1", nil, ErrClientCertRequired},
+		{"cert without agent id", AuthModeCert, "", &ClientCert{Fingerprint: "abc"}, ErrClientCertNoAgentID},
+		{"both", AuthModeBoth, "tok-1", cert, nil},
+		{"both with bad token", AuthModeBoth, "tok-x", cert, ErrInvalidToken},
+		{"both with mismatched cert", AuthModeBoth, "tok-1", otherCert, ErrAgentIDMismatch},
+	}
+	for _, tt := range tests {
+		a := NewAuthenticator(validator, time.Second)
+		a.SetMode(tt.mode)
+
+		// Client không thể tự khai cert_fingerprint
+		agentID, metadata, err := a.HandleAuth(authFrame(tt.token, map[string]string{"cert_fingerprint": "spoofed"}), tt.cert)
+		if !errors.Is(err, tt.wantErr) {
+			t.Errorf("%s: expected error %v, got %v", tt.name, tt.wantErr, err)
+			continue
+		}
+		if err != nil {
+			if !IsAuthError(err) {
+				t.Errorf("%s: expected auth error, got %v", tt.name, err)
+			}
+			continue
+		}
+
+		// Mode token: agent ID từ token kể cả khi cert có agent ID khác
+		if agentID != "agent-1" {
+			t.Errorf("%s: expected agent ID agent-1, got %q", tt.name, agentID)
+		}
+		wantFingerprint := ""
+		if tt.cert != nil {
+			wantFingerprint = tt.cert.Fingerprint
+		}
+		if metadata["cert_fingerprint"] != wantFingerprint {
+			t.Errorf("%s: expected cert_fingerprint %q, got %q", tt.name, wantFingerprint, metadata["cert_fingerprint"])
+		}
+	}
+}
+
+// revokedSet là RevocationChecker giả: thu hồi theo agent ID hoặc hash của token
+type revokedSet map[string]bool
+
+func (r revokedSet) Revoked(agentID string, metadata map[string]string) bool {
+	return r[agentID] || r[metadata[MetadataTokenHash]]
+}
+
+func TestAuthenticator_Revocations(t *testing.T) {
+	validator, _ := NewStaticValidator(map[string]StaticToken{
+		"tok-1": {AgentID: "agent-1"},
+		"tok-2": {AgentID: "agent-2"},
+		"tok-3": {AgentID: "agent-3"},
+	})
+	a := NewAuthenticator(validator, time.Second)
+	a.SetRevocations(revokedSet{"agent-2": true, TokenHash("tok-3"): true})
+
+	// Client không thể tự khai token_sha256
+	_, metadata, err := a.HandleAuth(authFrame("tok-1", map[string]string{MetadataTokenHash: TokenHash("tok-3")}), nil)
+	if err != nil {
+		t.Fatalf("HandleAuth failed: %v", err)
+	}
+	if metadata[MetadataTokenHash] != TokenHash("tok-1") {
+		t.Errorf("Expected server-side token hash, got %q", metadata[MetadataTokenHash])
+	}
+
+	for _, token := range []string{"tok-2", "tok-3"} {
+		_, _, err := a.HandleAuth(authFrame(token, nil), nil)
+		if !errors.Is(err, ErrCredentialsRevoked) || !IsAuthError(err) {
+			t.Errorf("%s: expected ErrCredentialsRevoked, got %v", token, err)
+		}
+		if msg := AgentErrorMessage(err); msg != "credentials revoked" {
+			t.Errorf("%s: unexpected agent message %q", token, msg)
+		}
+	}
+}
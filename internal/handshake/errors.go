@@ -10,5 +10,18 @@ var (
 	ErrInvalidToken             = errors.New("invalid token")
 	ErrTokenExpired             = errors.New("token expired")
 	ErrUnauthorized             = errors.New("unauthorized")
+	ErrValidatorUnavailable     = errors.New("token validator unavailable")
+	ErrClientCertRequired       = errors.New("client certificate required")
+	ErrClientCertNoAgentID      = errors.New("client certificate has no agent ID")
+	ErrClientCertRevoked        = errors.New("client certificate revoked")
+	ErrAgentIDMismatch          = errors.New("token agent ID does not match client certificate")
+	ErrNoClientCAs              = errors.New("no CA certificates in client CA file")
+	ErrInvalidCRL               = errors.New("invalid CRL")
+	ErrCredentialsRevoked       = errors.New("credentials revoked")
+	ErrTooManyPendingHandshakes = errors.New("too many pending handshakes")
+	ErrHandshakeRateLimited     = errors.New("handshake rate limit exceeded")
+	ErrSourceLockedOut          = errors.New("source locked out after repeated authentication failures")
+	ErrUnsupportedProtocolVersion = errors.New("unsupported protocol version")
+	ErrIncompatibleCapabilities = errors.New("incompatible capabilities")
 )
 
@@ -0,0 +1,216 @@
+package handshake
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/hydragon2m/tunnel-core/internal/metrics"
+	"github.com/hydragon2m/tunnel-core/internal/quota"
+)
+
+const (
+	// guardPruneInterval là chu kỳ dọn state của source IPs không còn hoạt động
+	guardPruneInterval = time.Minute
+	// guardIdleTTL là thời gian tối thiểu giữ state của source IP sau lần thấy cuối
+	guardIdleTTL = 10 * time.Minute
+	// maxLockoutDoublings chặn tràn số khi không có MaxLockout
+	maxLockoutDoublings = 20
+)
+
+// GuardConfig là config chống flood/brute-force trước khi agent xác thực xong
+type GuardConfig struct {
+	MaxPending  int           // Handshakes đang chờ đồng thời toàn server, 0 = không giới hạn
+	PerIPRate   int           // Handshakes/giây mỗi source IP, 0 = không giới hạn
+	PerIPBurst  int           // Burst của PerIPRate, 0 = bằng PerIPRate
+	MaxFailures int           // Failures liên tiếp trước khi source IP bị khóa, 0 = không khóa
+	Lockout     time.Duration // Thời gian khóa lần đầu, nhân đôi mỗi lần bị khóa lại
+	MaxLockout  time.Duration // Trần của thời gian khóa, 0 = không giới hạn
+}
+
+// Guard giới hạn handshakes chưa xác thực: số handshake đang chờ, tốc độ mỗi source IP
+// và khóa source IP (exponential backoff) sau nhiều lần xác thực thất bại liên tiếp
+type Guard struct {
+	config GuardConfig
+
+	mu        sync.Mutex
+	pending   int
+	sources   map[string]*sourceState
+	lastPrune time.Time
+
+	metrics *metrics.Metrics
+}
+
+// sourceState là state của 1 source IP
+type sourceState struct {
+	bucket      *quota.TokenBucket // nil = không giới hạn tốc độ
+	failures    int                // Failures liên tiếp kể từ lần thành công/bị khóa gần nhất
+	lockouts    int                // Số lần bị khóa liên tiếp (reset khi xác thực thành công)
+	lockedUntil time.Time
+	lastSeen    time.Time
+}
+
+// NewGuard tạo Guard
+func NewGuard(config GuardConfig) *Guard {
+	if config.PerIPBurst <= 0 {
+		config.PerIPBurst = config.PerIPRate
+	}
+	return &Guard{
+		config:    config,
+		sources:   make(map[string]*sourceState),
+		lastPrune: time.Now(),
+	}
+}
+
+// SetMetrics set metrics cho handshakes bị từ chối (nil = tắt)
+func (g *Guard) SetMetrics(m *metrics.Metrics) {
+	g.metrics = m
+}
+
+// Pending trả về số handshakes đang chờ
+func (g *Guard) Pending() int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.pending
+}
+
+// sourceIP lấy IP từ remote address ("host:port")
+func sourceIP(remoteAddr string) string {
+	if host, _, err := net.SplitHostPort(remoteAddr); err == nil {
+		return host
+	}
+	return remoteAddr
+}
+
+// Admit kiểm tra connection mới từ remoteAddr trước khi bắt đầu handshake
+// Được nhận → caller phải gọi release khi handshake kết thúc (thành công hay thất bại)
+func (g *Guard) Admit(remoteAddr string) (release func(), err error) {
+	ip := sourceIP(remoteAddr)
+	now := time.Now()
+
+	g.mu.Lock()
+	defer func() {
+		g.mu.Unlock()
+		if err != nil {
+			g.metrics.HandshakeRejected(guardRejectReason(err))
+		}
+	}()
+
+	if now.Sub(g.lastPrune) >= guardPruneInterval {
+		g.prune(now)
+	}
+
+	src := g.source(ip, now)
+	if now.Before(src.lockedUntil) {
+		return nil, fmt.Errorf("%w: %s until %s", ErrSourceLockedOut, ip, src.lockedUntil.Format(time.RFC3339))
+	}
+	if g.config.MaxPending > 0 && g.pending >= g.config.MaxPending {
+		return nil, ErrTooManyPendingHandshakes
+	}
+	if src.bucket != nil && !src.bucket.Allow() {
+		return nil, fmt.Errorf("%w: %s", ErrHandshakeRateLimited, ip)
+	}
+
+	g.pending++
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			g.mu.Lock()
+			g.pending--
+			g.mu.Unlock()
+		})
+	}, nil
+}
+
+// source trả về state của ip, tạo mới nếu chưa có (caller giữ g.mu)
+func (g *Guard) source(ip string, now time.Time) *sourceState {
+	src, ok := g.sources[ip]
+	if !ok {
+		src = &sourceState{}
+		if g.config.PerIPRate > 0 {
+			src.bucket = quota.NewTokenBucket(g.config.PerIPBurst, g.config.PerIPRate)
+		}
+		g.sources[ip] = src
+	}
+	src.lastSeen = now
+	return src
+}
+
+// Failure ghi nhận handshake thất bại (TLS lỗi, frame lỗi, token sai, timeout, ...)
+// Lỗi phía server (validator unavailable) và agent không tương thích (credentials đúng) không bị tính
+// Đủ MaxFailures liên tiếp → khóa source IP, thời gian khóa nhân đôi mỗi lần
+func (g *Guard) Failure(remoteAddr string, err error) {
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		g.metrics.HandshakeRejected(metrics.HandshakeReasonTimeout)
+	}
+	if g.config.MaxFailures <= 0 || errors.Is(err, ErrValidatorUnavailable) || errors.Is(err, ErrNoTokenValidator) ||
+		IsNegotiationError(err) {
+		return
+	}
+	ip := sourceIP(remoteAddr)
+	now := time.Now()
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	src := g.source(ip, now)
+	src.failures++
+	if src.failures < g.config.MaxFailures {
+		return
+	}
+
+	lockout := g.config.Lockout
+	for i := 0; i < src.lockouts && i < maxLockoutDoublings; i++ {
+		lockout *= 2
+	}
+	if g.config.MaxLockout > 0 && lockout > g.config.MaxLockout {
+		lockout = g.config.MaxLockout
+	}
+	src.failures = 0
+	src.lockouts++
+	src.lockedUntil = now.Add(lockout)
+	slog.Warn("Source locked out after repeated handshake failures", "ip", ip, "lockout", lockout, "lockouts", src.lockouts)
+}
+
+// Success xóa failures và số lần bị khóa của source IP
+func (g *Guard) Success(remoteAddr string) {
+	ip := sourceIP(remoteAddr)
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if src, ok := g.sources[ip]; ok {
+		src.failures = 0
+		src.lockouts = 0
+	}
+}
+
+// prune xóa state của source IPs không bị khóa và không thấy trong idle TTL (caller giữ g.mu)
+func (g *Guard) prune(now time.Time) {
+	ttl := guardIdleTTL
+	if g.config.MaxLockout > ttl {
+		ttl = g.config.MaxLockout
+	}
+	for ip, src := range g.sources {
+		if now.After(src.lockedUntil) && now.Sub(src.lastSeen) > ttl {
+			delete(g.sources, ip)
+		}
+	}
+	g.lastPrune = now
+}
+
+// guardRejectReason map lỗi của Admit → label cho metrics
+func guardRejectReason(err error) string {
+	switch {
+	case errors.Is(err, ErrSourceLockedOut):
+		return metrics.HandshakeReasonLockedOut
+	case errors.Is(err, ErrTooManyPendingHandshakes):
+		return metrics.HandshakeReasonTooManyPending
+	default:
+		return metrics.HandshakeReasonRateLimited
+	}
+}
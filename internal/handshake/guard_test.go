@@ -0,0 +1,106 @@
+package handshake
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestGuard_MaxPending(t *testing.T) {
+	g := NewGuard(GuardConfig{MaxPending: 2})
+
+	release1, err := g.Admit("10.0.0.1:1000")
+	if err != nil {
+		t.Fatalf("Admit failed: %v", err)
+	}
+	if _, err := g.Admit("10.0.0.2:1000"); err != nil {
+		t.Fatalf("Admit failed: %v", err)
+	}
+	if _, err := g.Admit("10.0.0.3:1000"); !errors.Is(err, ErrTooManyPendingHandshakes) {
+		t.Errorf("Expected ErrTooManyPendingHandshakes, got %v", err)
+	}
+
+	// release nhiều lần chỉ trả 1 slot
+	release1()
+	release1()
+	if g.Pending() != 1 {
+		t.Errorf("Expected 1 pending handshake, got %d", g.Pending())
+	}
+	if _, err := g.Admit("10.0.0.3:1000"); err != nil {
+		t.Errorf("Expected slot to be free after release, got %v", err)
+	}
+}
+
+func TestGuard_PerIPRate(t *testing.T) {
+	g := NewGuard(GuardConfig{PerIPRate: 1, PerIPBurst: 2})
+
+	for i := 0; i < 2; i++ {
+		release, err := g.Admit("10.0.0.1:1000")
+		if err != nil {
+			t.Fatalf("Admit %d failed: %v", i, err)
+		}
+		release()
+	}
+	if _, err := g.Admit("10.0.0.1:2000"); !errors.Is(err, ErrHandshakeRateLimited) {
+		t.Errorf("Expected ErrHandshakeRateLimited, got %v", err)
+	}
+	// IP khác có bucket riêng
+	if _, err := g.Admit("10.0.0.2:1000"); err != nil {
+		t.Errorf("Expected other IP to be admitted, got %v", err)
+	}
+}
+
+func TestGuard_Lockout(t *testing.T) {
+	g := NewGuard(GuardConfig{MaxFailures: 2, Lockout: 50 * time.Millisecond, MaxLockout: 150 * time.Millisecond})
+	addr := "10.0.0.1:1000"
+
+	lockFor := func() time.Duration {
+		g.Failure(addr, ErrInvalidToken)
+		g.Failure(addr, ErrInvalidToken)
+		return time.Until(g.sources["10.0.0.1"].lockedUntil)
+	}
+
+	if d := lockFor(); d <= 0 || d > 50*time.Millisecond {
+		t.Fatalf("Expected first lockout of 50ms, got %v", d)
+	}
+	if _, err := g.Admit("10.0.0.1:2000"); !errors.Is(err, ErrSourceLockedOut) {
+		t.Errorf("Expected ErrSourceLockedOut, got %v", err)
+	}
+	if _, err := g.Admit("10.0.0.2:1000"); err != nil {
+		t.Errorf("Expected other IP to be admitted, got %v", err)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	if _, err := g.Admit(addr); err != nil {
+		t.Fatalf("Expected admission after lockout expired, got %v", err)
+	}
+
+	// Lần khóa tiếp theo nhân đôi, có trần MaxLockout
+	if d := lockFor(); d <= 50*time.Millisecond || d > 100*time.Millisecond {
+		t.Errorf("Expected second lockout of 100ms, got %v", d)
+	}
+	if d := lockFor(); d <= 100*time.Millisecond || d > 150*time.Millisecond {
+		t.Errorf("Expected lockout capped at 150ms, got %v", d)
+	}
+
+	// Xác thực thành công reset failures và số lần bị khóa
+	g.Success(addr)
+	g.sources["10.0.0.1"].lockedUntil = time.Time{}
+	g.Failure(addr, ErrInvalidToken)
+	if _, err := g.Admit(addr); err != nil {
+		t.Errorf("Expected single failure after success not to lock, got %v", err)
+	}
+	if d := lockFor(); d > 50*time.Millisecond {
+		t.Errorf("Expected lockout to restart at 50ms after success, got %v", d)
+	}
+
+	// Lỗi phía server không tính cho agent
+	other := "10.0.0.9:1000"
+	for i := 0; i < 3; i++ {
+		g.Failure(other, fmt.Errorf("%w: webhook returned status 503", ErrValidatorUnavailable))
+	}
+	if _, err := g.Admit(other); err != nil {
+		t.Errorf("Expected validator errors not to lock out, got %v", err)
+	}
+}
@@ -0,0 +1,186 @@
+package handshake
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"strings"
+	"time"
+)
+
+// JWTConfig là config cho JWTValidator
+type JWTConfig struct {
+	Secret        []byte        // HMAC secret (HS256/HS384/HS512)
+	Issuer        string        // Claim "iss" bắt buộc (rỗng = không kiểm tra)
+	Audience      string        // Claim "aud" bắt buộc chứa giá trị này (rỗng = không kiểm tra)
+	AgentIDClaim  string        // Claim chứa agent ID (mặc định "sub")
+	RequireExpiry bool          // Từ chối token không có "exp"
+	Leeway        time.Duration // Cho phép lệch đồng hồ khi kiểm tra exp/nbf
+}
+
+// JWTValidator xác thực JWT ký bằng HMAC
+// Agent ID lấy từ AgentIDClaim, các claim dạng string khác thành attributes
+type JWTValidator struct {
+	config JWTConfig
+	now    func() time.Time
+}
+
+// jwtAlgorithms là các thuật toán HMAC được chấp nhận
+// "none" và thuật toán asymmetric bị từ chối để tránh algorithm confusion
+var jwtAlgorithms = map[string]func() hash.Hash{
+	"HS256": sha256.New,
+	"HS384": sha512.New384,
+	"HS512": sha512.New,
+}
+
+// jwtRegisteredClaims không đưa vào attributes
+var jwtRegisteredClaims = map[string]bool{
+	"iss": true, "sub": true, "aud": true, "exp": true, "nbf": true, "iat": true, "jti": true,
+}
+
+// NewJWTValidator tạo JWTValidator mới
+func NewJWTValidator(config JWTConfig) (*JWTValidator, error) {
+	if len(config.Secret) == 0 {
+		return nil, errors.New("jwt: secret required")
+	}
+	if config.AgentIDClaim == "" {
+		config.AgentIDClaim = "sub"
+	}
+	return &JWTValidator{
+		config: config,
+		now:    time.Now,
+	}, nil
+}
+
+// ValidateToken implements TokenValidator
+func (v *JWTValidator) ValidateToken(_ context.Context, token string) (*Identity, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("%w: malformed JWT", ErrInvalidToken)
+	}
+
+	// Header
+	var header struct {
+		Alg string `json:"alg"`
+	}
+	if err := decodeJWTSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("%w: invalid JWT header", ErrInvalidToken)
+	}
+	newHash, ok := jwtAlgorithms[header.Alg]
+	if !ok {
+		return nil, fmt.Errorf("%w: unsupported JWT algorithm %q", ErrInvalidToken, header.Alg)
+	}
+
+	// Signature
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid JWT signature encoding", ErrInvalidToken)
+	}
+	mac := hmac.New(newHash, v.config.Secret)
+	mac.Write([]byte(parts[0] + "." + parts[1]))
+	if !hmac.Equal(signature, mac.Sum(nil)) {
+		return nil, fmt.Errorf("%w: JWT signature mismatch", ErrInvalidToken)
+	}
+
+	// Claims (chỉ parse sau khi signature hợp lệ)
+	var claims map[string]interface{}
+	if err := decodeJWTSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("%w: invalid JWT claims", ErrInvalidToken)
+	}
+	if err := v.validateClaims(claims); err != nil {
+		return nil, err
+	}
+
+	agentID, _ := claims[v.config.AgentIDClaim].(string)
+	if agentID == "" {
+		return nil, fmt.Errorf("%w: missing %q claim", ErrInvalidToken, v.config.AgentIDClaim)
+	}
+
+	attributes := make(map[string]string)
+	for name, value := range claims {
+		if s, ok := value.(string); ok && !jwtRegisteredClaims[name] && name != v.config.AgentIDClaim {
+			attributes[name] = s
+		}
+	}
+
+	return &Identity{AgentID: agentID, Attributes: attributes}, nil
+}
+
+// validateClaims kiểm tra exp/nbf/iss/aud
+func (v *JWTValidator) validateClaims(claims map[string]interface{}) error {
+	now := v.now()
+
+	exp, hasExp, err := numericDate(claims, "exp")
+	if err != nil {
+		return err
+	}
+	if !hasExp && v.config.RequireExpiry {
+		return fmt.Errorf("%w: missing exp claim", ErrInvalidToken)
+	}
+	if hasExp && !now.Before(exp.Add(v.config.Leeway)) {
+		return ErrTokenExpired
+	}
+
+	nbf, hasNbf, err := numericDate(claims, "nbf")
+	if err != nil {
+		return err
+	}
+	if hasNbf && now.Add(v.config.Leeway).Before(nbf) {
+		return fmt.Errorf("%w: token not valid yet", ErrInvalidToken)
+	}
+
+	if v.config.Issuer != "" {
+		if iss, _ := claims["iss"].(string); iss != v.config.Issuer {
+			return fmt.Errorf("%w: unexpected issuer", ErrInvalidToken)
+		}
+	}
+
+	if v.config.Audience != "" && !hasAudience(claims["aud"], v.config.Audience) {
+		return fmt.Errorf("%w: unexpected audience", ErrInvalidToken)
+	}
+
+	return nil
+}
+
+// numericDate đọc claim dạng NumericDate (seconds since epoch)
+func numericDate(claims map[string]interface{}, name string) (time.Time, bool, error) {
+	raw, exists := claims[name]
+	if !exists {
+		return time.Time{}, false, nil
+	}
+	seconds, ok := raw.(float64)
+	if !ok {
+		return time.Time{}, false, fmt.Errorf("%w: %s claim must be a number", ErrInvalidToken, name)
+	}
+	return time.Unix(int64(seconds), 0), true, nil
+}
+
+// hasAudience kiểm tra claim "aud" (string hoặc array) có chứa audience
+func hasAudience(aud interface{}, audience string) bool {
+	switch v := aud.(type) {
+	case string:
+		return v == audience
+	case []interface{}:
+		for _, item := range v {
+			if s, ok := item.(string); ok && s == audience {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// decodeJWTSegment decode 1 phần base64url của JWT
+func decodeJWTSegment(segment string, out interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, out)
+}
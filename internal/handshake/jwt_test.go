@@ -0,0 +1,169 @@
+package handshake
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+)
+
+var testSecret = []byte("test-secret")
+
+// signJWT tạo JWT HS256 từ header alg và claims
+func signJWT(t *testing.T, alg string, secret []byte, claims map[string]interface{}) string {
+	t.Helper()
+
+	header, _ := json.Marshal(map[string]string{"alg": alg, "typ": "JWT"})
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatalf("marshal claims: %v", err)
+	}
+
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(signingInput))
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// newTestJWTValidator tạo validator với đồng hồ cố định
+func newTestJWTValidator(t *testing.T, config JWTConfig) (*JWTValidator, time.Time) {
+	t.Helper()
+
+	config.Secret = testSecret
+	v, err := NewJWTValidator(config)
+	if err != nil {
+		t.Fatalf("NewJWTValidator failed: %v", err)
+	}
+	now := time.Unix(1_700_000_000, 0)
+	v.now = func() time.Time { return now }
+	return v, now
+}
+
+func TestJWTValidator_ValidToken(t *testing.T) {
+	v, now := newTestJWTValidator(t, JWTConfig{RequireExpiry: true})
+
+	token := signJWT(t, "HS256", testSecret, map[string]interface{}{
+		"sub":   "agent-1",
+		"exp":   now.Add(time.Hour).Unix(),
+		"plan":  "pro",
+		"seats": 3,
+	})
+
+	identity, err := v.ValidateToken(context.Background(), token)
+	if err != nil {
+		t.Fatalf("ValidateToken failed: %v", err)
+	}
+	if identity.AgentID != "agent-1" {
+		t.Errorf("Expected agent ID 'agent-1', got '%s'", identity.AgentID)
+	}
+	if identity.Attributes["plan"] != "pro" {
+		t.Errorf("Expected attribute plan=pro, got %v", identity.Attributes)
+	}
+	if _, ok := identity.Attributes["exp"]; ok {
+		t.Error("Expected registered claims to be excluded from attributes")
+	}
+	if _, ok := identity.Attributes["seats"]; ok {
+		t.Error("Expected non-string claims to be excluded from attributes")
+	}
+}
+
+func TestJWTValidator_CustomAgentIDClaim(t *testing.T) {
+	v, now := newTestJWTValidator(t, JWTConfig{AgentIDClaim: "agent_id"})
+
+	token := signJWT(t, "HS256", testSecret, map[string]interface{}{
+		"sub":      "user-9",
+		"agent_id": "agent-7",
+		"exp":      now.Add(time.Hour).Unix(),
+	})
+
+	identity, err := v.ValidateToken(context.Background(), token)
+	if err != nil {
+		t.Fatalf("ValidateToken failed: %v", err)
+	}
+	if identity.AgentID != "agent-7" {
+		t.Errorf("Expected agent ID 'agent-7', got '%s'", identity.AgentID)
+	}
+}
+
+func TestJWTValidator_Rejects(t *testing.T) {
+	v, now := newTestJWTValidator(t, JWTConfig{
+		Issuer:        "tunnel-auth",
+		Audience:      "tunnel-core",
+		RequireExpiry: true,
+		Leeway:        30 * time.Second,
+	})
+
+	valid := func() map[string]interface{} {
+		return map[string]interface{}{
+			"sub": "agent-1",
+			"iss": "tunnel-auth",
+			"aud": []string{"other", "tunnel-core"},
+			"exp": now.Add(time.Hour).Unix(),
+		}
+	}
+	with := func(key string, value interface{}) map[string]interface{} {
+		claims := valid()
+		if value == nil {
+			delete(claims, key)
+		} else {
+			claims[key] = value
+		}
+		return claims
+	}
+
+	// Token "alg: none" không có chữ ký
+	noneHeader := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none","typ":"JWT"}`))
+	noneClaims, _ := json.Marshal(valid())
+	noneToken := noneHeader + "." + base64.RawURLEncoding.EncodeToString(noneClaims) + "."
+
+	tests := []struct {
+		name    string
+		token   string
+		wantErr error
+	}{
+		{"wrong signature", signJWT(t, "HS256", []byte("other-secret"), valid()), ErrInvalidToken},
+		{"alg none", noneToken, ErrInvalidToken},
+		{"unsupported alg", signJWT(t, "RS256", testSecret, valid()), ErrInvalidToken},
+		{"malformed", "not-a-jwt", ErrInvalidToken},
+		{"expired", signJWT(t, "HS256", testSecret, with("exp", now.Add(-time.Minute).Unix())), ErrTokenExpired},
+		{"missing exp", signJWT(t, "HS256", testSecret, with("exp", nil)), ErrInvalidToken},
+		{"not valid yet", signJWT(t, "HS256", testSecret, with("nbf", now.Add(time.Minute).Unix())), ErrInvalidToken},
+		{"wrong issuer", signJWT(t, "HS256", testSecret, with("iss", "someone-else")), ErrInvalidToken},
+		{"wrong audience", signJWT(t, "HS256", testSecret, with("aud", "other")), ErrInvalidToken},
+		{"missing subject", signJWT(t, "HS256", testSecret, with("sub", nil)), ErrInvalidToken},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := v.ValidateToken(context.Background(), tt.token)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("ValidateToken() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestJWTValidator_Leeway(t *testing.T) {
+	v, now := newTestJWTValidator(t, JWTConfig{Leeway: 30 * time.Second})
+
+	// Hết hạn 10s trước nhưng vẫn trong leeway
+	token := signJWT(t, "HS256", testSecret, map[string]interface{}{
+		"sub": "agent-1",
+		"exp": now.Add(-10 * time.Second).Unix(),
+		"nbf": now.Add(10 * time.Second).Unix(),
+	})
+
+	if _, err := v.ValidateToken(context.Background(), token); err != nil {
+		t.Errorf("Expected token within leeway to be accepted, got %v", err)
+	}
+}
+
+func TestNewJWTValidator_RequiresSecret(t *testing.T) {
+	if _, err := NewJWTValidator(JWTConfig{}); err == nil {
+		t.Error("Expected error without secret")
+	}
+}
@@ -0,0 +1,95 @@
+package handshake
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/hydragon2m/tunnel-core/internal/connection"
+	v1 "github.com/hydragon2m/tunnel-protocol/go/v1"
+)
+
+// MetadataProtocolVersion là key metadata chứa protocol version đã thỏa thuận lúc handshake
+const MetadataProtocolVersion = "protocol_version"
+
+// MinProtocolVersion là protocol version thấp nhất server hỗ trợ (cao nhất là v1.Version)
+const MinProtocolVersion = 1
+
+// legacyFeatures là features agent cũ (không gửi protocol_version) được dùng mà không cần khai báo,
+// giữ hành vi trước khi có negotiation. Features thay đổi wire format (flow control, ping) luôn phải khai báo
+var legacyFeatures = []string{
+	connection.CapabilityTCPTunnels,
+	connection.CapabilityTLSPassthrough,
+	connection.CapabilityCustomDomains,
+}
+
+// negotiate so protocol version và capabilities của agent với server
+// Trả về protocol version và features đã thỏa thuận (sorted)
+func (a *Authenticator) negotiate(req *AuthRequest) (int, []string, error) {
+	version := req.ProtocolVersion
+	if version == 0 {
+		version = MinProtocolVersion
+	}
+	if version < MinProtocolVersion || version > int(v1.Version) {
+		return 0, nil, fmt.Errorf("%w: %d (server supports %d-%d)", ErrUnsupportedProtocolVersion, req.ProtocolVersion, MinProtocolVersion, v1.Version)
+	}
+
+	declared := make(map[string]bool)
+	for _, c := range req.Capabilities {
+		declared[c] = true
+	}
+	if req.ProtocolVersion == 0 {
+		for _, f := range legacyFeatures {
+			declared[f] = true
+		}
+	}
+
+	var missing []string
+	for _, c := range req.Requires {
+		declared[c] = true
+		if !a.features[c] {
+			missing = append(missing, c)
+		}
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return 0, nil, fmt.Errorf("%w: server does not support %s", ErrIncompatibleCapabilities, strings.Join(missing, ", "))
+	}
+
+	agreed := make([]string, 0, len(declared))
+	for c := range declared {
+		if a.features[c] {
+			agreed = append(agreed, c)
+		}
+	}
+	sort.Strings(agreed)
+	return version, agreed, nil
+}
+
+// IsNegotiationError kiểm tra error là do agent không tương thích với server (version, capabilities)
+func IsNegotiationError(err error) bool {
+	return errors.Is(err, ErrUnsupportedProtocolVersion) || errors.Is(err, ErrIncompatibleCapabilities)
+}
+
+// NegotiatedConfig trả về protocol version và features đã thỏa thuận trong metadata (từ HandleAuth),
+// dùng làm phần chung của AuthResponse.Config
+func NegotiatedConfig(metadata map[string]string) map[string]interface{} {
+	version, _ := strconv.Atoi(metadata[MetadataProtocolVersion])
+	features := connection.ParseFeatures(metadata)
+	if features == nil {
+		features = []string{}
+	}
+	return map[string]interface{}{
+		"protocol_version": version,
+		"features":         features,
+	}
+}
+
+// encodeFeatures encode features thành giá trị của metadata[connection.MetadataFeatures]
+func encodeFeatures(features []string) string {
+	data, _ := json.Marshal(features)
+	return string(data)
+}
@@ -0,0 +1,109 @@
+package handshake
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/hydragon2m/tunnel-core/internal/connection"
+	v1 "github.com/hydragon2m/tunnel-protocol/go/v1"
+)
+
+func TestAuthenticator_Negotiate(t *testing.T) {
+	validator, _ := NewStaticValidator(map[string]StaticToken{
+		"tok-1": {AgentID: "agent-1"},
+	})
+	a := NewAuthenticator(validator, time.Second)
+	a.SetFeatures([]string{connection.CapabilityFlowControl, connection.CapabilityTCPTunnels, connection.CapabilityCustomDomains})
+
+	tests := []struct {
+		name     string
+		req      AuthRequest
+		want     []string
+		wantErr  error
+		wantText string
+	}{
+		{
+			name: "legacy agent gets service features",
+			req:  AuthRequest{Capabilities: []string{"flow_control", "ping"}},
+			want: []string{"custom_domains", "flow_control", "tcp_tunnels"},
+		},
+		{
+			name: "declared capabilities only",
+			req:  AuthRequest{ProtocolVersion: 1, Capabilities: []string{"tcp_tunnels", "ping", "compression"}},
+			want: []string{"tcp_tunnels"},
+		},
+		{
+			name: "no capabilities",
+			req:  AuthRequest{ProtocolVersion: 1},
+			want: []string{},
+		},
+		{
+			name: "required and supported",
+			req:  AuthRequest{ProtocolVersion: 1, Requires: []string{"flow_control"}},
+			want: []string{"flow_control"},
+		},
+		{
+			name:     "required but unsupported",
+			req:      AuthRequest{ProtocolVersion: 1, Requires: []string{"tls_passthrough", "compression", "flow_control"}},
+			wantErr:  ErrIncompatibleCapabilities,
+			wantText: "incompatible capabilities: server does not support compression, tls_passthrough",
+		},
+		{
+			name:     "protocol version too new",
+			req:      AuthRequest{ProtocolVersion: int(v1.Version) + 1},
+			wantErr:  ErrUnsupportedProtocolVersion,
+			wantText: "unsupported protocol version: 2 (server supports 1-1)",
+		},
+		{
+			name:    "negative protocol version",
+			req:     AuthRequest{ProtocolVersion: -1},
+			wantErr: ErrUnsupportedProtocolVersion,
+		},
+	}
+	for _, tt := range tests {
+		tt.req.Token = "tok-1"
+		// Client không thể tự khai features/protocol_version qua metadata
+		tt.req.Metadata = map[string]string{connection.MetadataFeatures: `["tls_passthrough"]`, MetadataProtocolVersion: "9"}
+		payload, _ := json.Marshal(tt.req)
+		frame := &v1.Frame{Version: v1.Version, Type: v1.FrameAuth, StreamID: v1.StreamIDControl, Payload: payload}
+
+		_, metadata, err := a.HandleAuth(frame, nil)
+		if !errors.Is(err, tt.wantErr) {
+			t.Errorf("%s: expected error %v, got %v", tt.name, tt.wantErr, err)
+			continue
+		}
+		if err != nil {
+			if !IsNegotiationError(err) || IsAuthError(err) {
+				t.Errorf("%s: expected negotiation error, got %v", tt.name, err)
+			}
+			if tt.wantText != "" && AgentErrorMessage(err) != tt.wantText {
+				t.Errorf("%s: unexpected agent message %q", tt.name, AgentErrorMessage(err))
+			}
+			continue
+		}
+
+		config := NegotiatedConfig(metadata)
+		if config["protocol_version"] != 1 {
+			t.Errorf("%s: expected protocol version 1, got %v", tt.name, config["protocol_version"])
+		}
+		if got := config["features"]; !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: expected features %v, got %v", tt.name, tt.want, got)
+		}
+	}
+}
+
+func TestAuthenticator_NegotiateWithoutFeatures(t *testing.T) {
+	a := NewAuthenticator(InsecureValidator{}, time.Second)
+
+	payload, _ := json.Marshal(AuthRequest{Token: "agent-1", Capabilities: []string{"flow_control"}})
+	_, metadata, err := a.HandleAuth(&v1.Frame{Version: v1.Version, Type: v1.FrameAuth, StreamID: v1.StreamIDControl, Payload: payload}, nil)
+	if err != nil {
+		t.Fatalf("HandleAuth failed: %v", err)
+	}
+	if features := connection.ParseFeatures(metadata); len(features) != 0 {
+		t.Errorf("Expected no features, got %v", features)
+	}
+}
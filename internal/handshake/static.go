@@ -0,0 +1,91 @@
+package handshake
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"os"
+
+	"gopkg.in/yaml.v3"
+)
+
+// StaticToken là 1 entry trong tokens file
+type StaticToken struct {
+	AgentID    string            `yaml:"agent_id"`
+	Attributes map[string]string `yaml:"attributes"`
+}
+
+// staticTokensFile là format của tokens file:
+//
+//	tokens:
+//	  "tok-abc123":
+//	    agent_id: "agent-1"
+//	    attributes:
+//	      plan: "pro"
+type staticTokensFile struct {
+	Tokens map[string]StaticToken `yaml:"tokens"`
+}
+
+// StaticValidator xác thực token theo danh sách cố định (token → agent ID + attributes)
+// Token được lưu dạng SHA-256 để lookup không phụ thuộc nội dung token
+type StaticValidator struct {
+	tokens map[[sha256.Size]byte]StaticToken
+}
+
+// NewStaticValidator tạo StaticValidator từ map token → StaticToken
+func NewStaticValidator(tokens map[string]StaticToken) (*StaticValidator, error) {
+	v := &StaticValidator{
+		tokens: make(map[[sha256.Size]byte]StaticToken, len(tokens)),
+	}
+	for token, entry := range tokens {
+		if token == "" {
+			return nil, fmt.Errorf("static token: empty token")
+		}
+		if entry.AgentID == "" {
+			return nil, fmt.Errorf("static token: agent_id required (token %s...)", tokenPrefix(token))
+		}
+		v.tokens[sha256.Sum256([]byte(token))] = entry
+	}
+	return v, nil
+}
+
+// LoadStaticValidator đọc tokens file (YAML)
+func LoadStaticValidator(path string) (*StaticValidator, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read tokens file: %w", err)
+	}
+
+	var file staticTokensFile
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse tokens file %s: %w", path, err)
+	}
+
+	return NewStaticValidator(file.Tokens)
+}
+
+// ValidateToken implements TokenValidator
+func (v *StaticValidator) ValidateToken(_ context.Context, token string) (*Identity, error) {
+	if token == "" {
+		return nil, ErrInvalidToken
+	}
+
+	entry, ok := v.tokens[sha256.Sum256([]byte(token))]
+	if !ok {
+		return nil, ErrInvalidToken
+	}
+
+	attributes := make(map[string]string, len(entry.Attributes))
+	for k, val := range entry.Attributes {
+		attributes[k] = val
+	}
+	return &Identity{AgentID: entry.AgentID, Attributes: attributes}, nil
+}
+
+// tokenPrefix trả về vài ký tự đầu của token cho error message (không lộ toàn bộ token)
+func tokenPrefix(token string) string {
+	if len(token) <= 4 {
+		return ""
+	}
+	return token[:4]
+}
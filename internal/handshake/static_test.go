@@ -0,0 +1,84 @@
+package handshake
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeTokensFile ghi tokens file tạm cho test
+func writeTokensFile(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "tokens.yaml")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("write tokens file: %v", err)
+	}
+	return path
+}
+
+func TestLoadStaticValidator(t *testing.T) {
+	path := writeTokensFile(t, `
+tokens:
+  "tok-abc123":
+    agent_id: "agent-1"
+    attributes:
+      plan: "pro"
+  "tok-def456":
+    agent_id: "agent-2"
+`)
+
+	v, err := LoadStaticValidator(path)
+	if err != nil {
+		t.Fatalf("LoadStaticValidator failed: %v", err)
+	}
+
+	identity, err := v.ValidateToken(context.Background(), "tok-abc123")
+	if err != nil {
+		t.Fatalf("ValidateToken failed: %v", err)
+	}
+	if identity.AgentID != "agent-1" || identity.Attributes["plan"] != "pro" {
+		t.Errorf("Unexpected identity: %+v", identity)
+	}
+
+	// Attributes trả về là bản copy
+	identity.Attributes["plan"] = "free"
+	again, _ := v.ValidateToken(context.Background(), "tok-abc123")
+	if again.Attributes["plan"] != "pro" {
+		t.Error("Expected attributes to be copied per validation")
+	}
+
+	identity, err = v.ValidateToken(context.Background(), "tok-def456")
+	if err != nil || identity.AgentID != "agent-2" {
+		t.Errorf("Expected agent-2, got %+v (%v)", identity, err)
+	}
+
+	for _, token := range []string{"", "tok-unknown"} {
+		if _, err := v.ValidateToken(context.Background(), token); !errors.Is(err, ErrInvalidToken) {
+			t.Errorf("ValidateToken(%q) error = %v, want ErrInvalidToken", token, err)
+		}
+	}
+}
+
+func TestLoadStaticValidator_Errors(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+	}{
+		{"missing agent id", "tokens:\n  \"tok-abc123\":\n    attributes: {plan: pro}\n"},
+		{"invalid yaml", "tokens: [\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := LoadStaticValidator(writeTokensFile(t, tt.content)); err == nil {
+				t.Error("Expected error")
+			}
+		})
+	}
+
+	if _, err := LoadStaticValidator(filepath.Join(t.TempDir(), "missing.yaml")); err == nil {
+		t.Error("Expected error for missing file")
+	}
+}
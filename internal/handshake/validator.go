@@ -0,0 +1,66 @@
+package handshake
+
+import (
+	"context"
+	"errors"
+)
+
+// TokenValidator xác thực token agent gửi trong FrameAuth
+// Trả ErrInvalidToken/ErrTokenExpired nếu token không hợp lệ, error khác nếu backend lỗi
+type TokenValidator interface {
+	ValidateToken(ctx context.Context, token string) (*Identity, error)
+}
+
+// Identity là kết quả xác thực token
+type Identity struct {
+	AgentID    string
+	Attributes map[string]string // Thuộc tính server-side (plan, owner, ...), merge vào connection metadata
+}
+
+// InsecureValidator chấp nhận mọi token khác rỗng và dùng token làm agent ID
+// Chỉ dùng cho development
+type InsecureValidator struct{}
+
+// ValidateToken implements TokenValidator
+func (InsecureValidator) ValidateToken(_ context.Context, token string) (*Identity, error) {
+	if token == "" {
+		return nil, ErrInvalidToken
+	}
+	return &Identity{AgentID: token}, nil
+}
+
+// IsAuthError kiểm tra error là lỗi credentials (agent cần token/cert khác)
+// thay vì lỗi hạ tầng của backend (agent nên thử lại)
+func IsAuthError(err error) bool {
+	return errors.Is(err, ErrInvalidToken) || errors.Is(err, ErrTokenExpired) || errors.Is(err, ErrUnauthorized) ||
+		errors.Is(err, ErrCredentialsRevoked) || isClientCertError(err)
+}
+
+// isClientCertError kiểm tra error là lỗi client certificate
+func isClientCertError(err error) bool {
+	return errors.Is(err, ErrClientCertRequired) || errors.Is(err, ErrClientCertNoAgentID) ||
+		errors.Is(err, ErrClientCertRevoked) || errors.Is(err, ErrAgentIDMismatch)
+}
+
+// AgentErrorMessage trả về error message an toàn để gửi cho agent chưa xác thực
+// Chi tiết (lý do JWT bị từ chối, URL/lỗi dial của webhook, ...) chỉ được log phía server
+func AgentErrorMessage(err error) string {
+	switch {
+	case errors.Is(err, ErrTokenExpired):
+		return ErrTokenExpired.Error()
+	case errors.Is(err, ErrClientCertRequired):
+		return ErrClientCertRequired.Error()
+	case errors.Is(err, ErrCredentialsRevoked):
+		return ErrCredentialsRevoked.Error()
+	case isClientCertError(err):
+		return "invalid client certificate"
+	case IsNegotiationError(err):
+		return err.Error()
+	case IsAuthError(err):
+		return ErrInvalidToken.Error()
+	case errors.Is(err, ErrInvalidFrameType), errors.Is(err, ErrAuthMustBeControlFrame), errors.Is(err, ErrInvalidAuthPayload):
+		return err.Error()
+	default:
+		return ErrValidatorUnavailable.Error() + ", retry later"
+	}
+}
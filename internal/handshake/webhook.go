@@ -0,0 +1,116 @@
+package handshake
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// maxWebhookResponseSize giới hạn kích thước response từ webhook
+const maxWebhookResponseSize = 64 * 1024
+
+// WebhookConfig là config cho WebhookValidator
+type WebhookConfig struct {
+	URL     string
+	Secret  string        // Gửi kèm "Authorization: Bearer <secret>" (rỗng = không gửi)
+	Timeout time.Duration // Timeout mỗi request (ngoài auth timeout của handshake)
+	Client  *http.Client  // nil = http.Client mặc định với Timeout
+}
+
+// WebhookRequest là body server POST đến webhook
+type WebhookRequest struct {
+	Token string `json:"token"`
+}
+
+// WebhookResponse là body webhook trả về
+//
+//	200 + {"agent_id": "...", "attributes": {...}} → token hợp lệ
+//	401/403 + {"error": "token_expired"}          → ErrTokenExpired
+//	401/403                                       → ErrInvalidToken
+//	status khác                                   → ErrValidatorUnavailable
+type WebhookResponse struct {
+	AgentID    string            `json:"agent_id"`
+	Attributes map[string]string `json:"attributes,omitempty"`
+	Error      string            `json:"error,omitempty"`
+}
+
+// WebhookErrorTokenExpired là giá trị WebhookResponse.Error cho token hết hạn
+const WebhookErrorTokenExpired = "token_expired"
+
+// WebhookValidator ủy quyền xác thực token cho HTTP endpoint bên ngoài
+type WebhookValidator struct {
+	config WebhookConfig
+	client *http.Client
+}
+
+// NewWebhookValidator tạo WebhookValidator mới
+func NewWebhookValidator(config WebhookConfig) (*WebhookValidator, error) {
+	if config.URL == "" {
+		return nil, errors.New("webhook: url required")
+	}
+
+	client := config.Client
+	if client == nil {
+		client = &http.Client{Timeout: config.Timeout}
+	}
+
+	return &WebhookValidator{
+		config: config,
+		client: client,
+	}, nil
+}
+
+// ValidateToken implements TokenValidator
+func (v *WebhookValidator) ValidateToken(ctx context.Context, token string) (*Identity, error) {
+	if token == "" {
+		return nil, ErrInvalidToken
+	}
+
+	body, err := json.Marshal(WebhookRequest{Token: token})
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, v.config.URL, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrValidatorUnavailable, err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if v.config.Secret != "" {
+		req.Header.Set("Authorization", "Bearer "+v.config.Secret)
+	}
+
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrValidatorUnavailable, err)
+	}
+	defer resp.Body.Close()
+
+	var result WebhookResponse
+	decodeErr := json.NewDecoder(io.LimitReader(resp.Body, maxWebhookResponseSize)).Decode(&result)
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		if decodeErr != nil {
+			return nil, fmt.Errorf("%w: invalid webhook response: %v", ErrValidatorUnavailable, decodeErr)
+		}
+		if result.AgentID == "" {
+			return nil, fmt.Errorf("%w: webhook response missing agent_id", ErrValidatorUnavailable)
+		}
+		return &Identity{AgentID: result.AgentID, Attributes: result.Attributes}, nil
+
+	case http.StatusUnauthorized, http.StatusForbidden:
+		if result.Error == WebhookErrorTokenExpired {
+			return nil, ErrTokenExpired
+		}
+		return nil, ErrInvalidToken
+
+	default:
+		return nil, fmt.Errorf("%w: webhook returned status %d", ErrValidatorUnavailable, resp.StatusCode)
+	}
+}
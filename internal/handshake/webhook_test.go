@@ -0,0 +1,120 @@
+package handshake
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newWebhookStub tạo webhook server giả: token → (status, response)
+func newWebhookStub(t *testing.T, handler func(token string) (int, WebhookResponse)) *httptest.Server {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.Header.Get("Authorization") != "Bearer hook-secret" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		var req WebhookRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		status, resp := handler(req.Token)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(resp)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestWebhookValidator(t *testing.T) {
+	server := newWebhookStub(t, func(token string) (int, WebhookResponse) {
+		switch token {
+		case "good":
+			return http.StatusOK, WebhookResponse{AgentID: "agent-1", Attributes: map[string]string{"plan": "pro"}}
+		case "expired":
+			return http.StatusUnauthorized, WebhookResponse{Error: WebhookErrorTokenExpired}
+		case "forbidden":
+			return http.StatusForbidden, WebhookResponse{}
+		case "no-agent":
+			return http.StatusOK, WebhookResponse{}
+		default:
+			return http.StatusInternalServerError, WebhookResponse{}
+		}
+	})
+
+	v, err := NewWebhookValidator(WebhookConfig{URL: server.URL, Secret: "hook-secret", Timeout: time.Second})
+	if err != nil {
+		t.Fatalf("NewWebhookValidator failed: %v", err)
+	}
+
+	identity, err := v.ValidateToken(context.Background(), "good")
+	if err != nil {
+		t.Fatalf("ValidateToken failed: %v", err)
+	}
+	if identity.AgentID != "agent-1" || identity.Attributes["plan"] != "pro" {
+		t.Errorf("Unexpected identity: %+v", identity)
+	}
+
+	tests := []struct {
+		token   string
+		wantErr error
+	}{
+		{"expired", ErrTokenExpired},
+		{"forbidden", ErrInvalidToken},
+		{"", ErrInvalidToken},
+		{"no-agent", ErrValidatorUnavailable},
+		{"boom", ErrValidatorUnavailable},
+	}
+	for _, tt := range tests {
+		if _, err := v.ValidateToken(context.Background(), tt.token); !errors.Is(err, tt.wantErr) {
+			t.Errorf("ValidateToken(%q) error = %v, want %v", tt.token, err, tt.wantErr)
+		}
+	}
+}
+
+func TestWebhookValidator_Unreachable(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	url := server.URL
+	server.Close()
+
+	v, _ := NewWebhookValidator(WebhookConfig{URL: url, Timeout: time.Second})
+
+	_, err := v.ValidateToken(context.Background(), "good")
+	if !errors.Is(err, ErrValidatorUnavailable) {
+		t.Fatalf("Expected ErrValidatorUnavailable, got %v", err)
+	}
+	if IsAuthError(err) {
+		t.Error("Backend failure must not be reported as a token error")
+	}
+
+	// Chi tiết (URL nội bộ, lỗi dial) không được gửi cho agent
+	if msg := AgentErrorMessage(err); strings.Contains(msg, url) || strings.Contains(msg, "dial") {
+		t.Errorf("Agent error message leaks backend details: %q", msg)
+	}
+}
+
+func TestWebhookValidator_ContextTimeout(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+
+	v, _ := NewWebhookValidator(WebhookConfig{URL: server.URL, Timeout: 5 * time.Second})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	if _, err := v.ValidateToken(ctx, "good"); !errors.Is(err, ErrValidatorUnavailable) {
+		t.Errorf("Expected ErrValidatorUnavailable on timeout, got %v", err)
+	}
+}
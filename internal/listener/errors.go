@@ -0,0 +1,13 @@
+package listener
+
+import "errors"
+
+var (
+	ErrInvalidPortRange  = errors.New("invalid TCP port range")
+	ErrPortOutOfRange    = errors.New("requested port out of range")
+	ErrPortUnavailable   = errors.New("port unavailable")
+	ErrNoPortsAvailable  = errors.New("no TCP ports available")
+	ErrPortQuotaExceeded = errors.New("agent TCP port quota exceeded")
+	ErrTCPTunnelNotFound = errors.New("TCP tunnel not found")
+	ErrTCPListenerClosed = errors.New("TCP listener closed")
+)
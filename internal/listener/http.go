@@ -6,61 +6,110 @@ import (
 	"fmt"
 	"net"
 	"net/http"
+	"slices"
 	"time"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
 )
 
 // HTTPListener là HTTP/HTTPS server nhận requests từ public
 type HTTPListener struct {
-	server   *http.Server
-	listener net.Listener
-	handler  http.Handler
+	server    *http.Server
+	listener  net.Listener
+	raw       net.Listener // TCP listener bên dưới TLS
+	tlsConfig *tls.Config
+	handler   http.Handler
 }
 
 // NewHTTPListener tạo HTTP listener mới
 func NewHTTPListener(addr string, useTLS bool, certFile, keyFile string, handler http.Handler) (*HTTPListener, error) {
-	// Create HTTP server
-	server := &http.Server{
-		Addr:         addr,
-		Handler:      handler,
-		ReadTimeout:  15 * time.Second,
-		WriteTimeout: 15 * time.Second,
-		IdleTimeout:  60 * time.Second,
+	if !useTLS {
+		return NewHTTPListenerWithTLS(addr, nil, handler)
 	}
 
-	var listener net.Listener
-	var err error
+	if certFile == "" || keyFile == "" {
+		return nil, fmt.Errorf("TLS certificate and key files required when TLS is enabled")
+	}
 
-	if useTLS {
-		if certFile == "" || keyFile == "" {
-			return nil, fmt.Errorf("TLS certificate and key files required when TLS is enabled")
-		}
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load TLS certificate: %w", err)
+	}
 
-		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
-		if err != nil {
-			return nil, fmt.Errorf("failed to load TLS certificate: %w", err)
-		}
+	config := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
 
-		config := &tls.Config{
-			Certificates: []tls.Certificate{cert},
-			MinVersion:   tls.VersionTLS12,
-		}
+	return NewHTTPListenerWithTLS(addr, config, handler)
+}
 
-		listener, err = tls.Listen("tcp", addr, config)
+// NewHTTPListenerWithTLS tạo HTTP listener với tls.Config có sẵn (vd. GetCertificate của ACME), nil = plain HTTP
+func NewHTTPListenerWithTLS(addr string, tlsConfig *tls.Config, handler http.Handler) (*HTTPListener, error) {
+	// Create HTTP server
+	// Không set ReadTimeout/WriteTimeout: body được stream và có thể lớn,
+	// router tự áp idle timeout cho từng request
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadHeaderTimeout: 15 * time.Second,
+		IdleTimeout:       60 * time.Second,
+	}
+
+	// HTTP/2: qua ALPN "h2" khi có TLS, h2c (prior knowledge hoặc Upgrade: h2c) khi plain HTTP
+	// ConfigureServer để h2c connections cũng được graceful shutdown cùng server
+	h2s := &http2.Server{IdleTimeout: server.IdleTimeout}
+	if err := http2.ConfigureServer(server, h2s); err != nil {
+		return nil, fmt.Errorf("failed to configure HTTP/2: %w", err)
+	}
+	if tlsConfig != nil {
+		tlsConfig = withHTTP2(tlsConfig)
 	} else {
-		listener, err = net.Listen("tcp", addr)
+		server.Handler = h2c.NewHandler(handler, h2s)
 	}
 
+	raw, err := net.Listen("tcp", addr)
 	if err != nil {
 		return nil, fmt.Errorf("failed to listen on %s: %w", addr, err)
 	}
 
+	listener := raw
+	if tlsConfig != nil {
+		listener = tls.NewListener(raw, tlsConfig)
+	}
+
 	return &HTTPListener{
-		server:   server,
-		listener: listener,
-		handler:  handler,
+		server:    server,
+		listener:  listener,
+		raw:       raw,
+		tlsConfig: tlsConfig,
+		handler:   handler,
 	}, nil
 }
 
+// withHTTP2 trả về bản copy của config có "h2" đứng đầu NextProtos (client chọn HTTP/2 qua ALPN)
+func withHTTP2(config *tls.Config) *tls.Config {
+	config = config.Clone()
+	if !slices.Contains(config.NextProtos, http2.NextProtoTLS) {
+		config.NextProtos = append([]string{http2.NextProtoTLS}, config.NextProtos...)
+	}
+	if !slices.Contains(config.NextProtos, "http/1.1") {
+		config.NextProtos = append(config.NextProtos, "http/1.1")
+	}
+	return config
+}
+
+// SetPassthrough bật TLS passthrough theo SNI trên listener này, gọi trước Start
+// Connections có SNI của passthrough tunnel được pipe đến agent, còn lại đi qua TLS/HTTP như bình thường
+func (l *HTTPListener) SetPassthrough(p *Passthrough) {
+	listener := p.Listen(l.raw)
+	if l.tlsConfig != nil {
+		listener = tls.NewListener(listener, l.tlsConfig)
+	}
+	l.listener = listener
+}
+
 // Start starts the HTTP server
 func (l *HTTPListener) Start() error {
 	return l.server.Serve(l.listener)
@@ -87,6 +136,11 @@ func (l *HTTPListener) StartWithContext(ctx context.Context) error {
 	}
 }
 
+// Shutdown ngừng nhận requests mới và chờ requests đang xử lý kết thúc (tối đa đến khi ctx hết hạn)
+func (l *HTTPListener) Shutdown(ctx context.Context) error {
+	return l.server.Shutdown(ctx)
+}
+
 // Close closes the listener
 func (l *HTTPListener) Close() error {
 	if l.listener != nil {
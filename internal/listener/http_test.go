@@ -0,0 +1,93 @@
+package listener
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/http2"
+)
+
+// protoHandler trả về protocol của request
+var protoHandler = http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+	io.WriteString(w, req.Proto)
+})
+
+// getProto gửi GET đến url và trả về body (protocol server nhận được)
+func getProto(t *testing.T, client *http.Client, url string) string {
+	t.Helper()
+
+	resp, err := client.Get(url)
+	if err != nil {
+		t.Fatalf("GET %s failed: %v", url, err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	return string(body)
+}
+
+func TestHTTPListener_HTTP2OverTLS(t *testing.T) {
+	// Config không khai báo NextProtos: listener tự thêm "h2"
+	l, err := NewHTTPListenerWithTLS("127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{testCertificate(t, "app.localhost")},
+	}, protoHandler)
+	if err != nil {
+		t.Fatalf("NewHTTPListenerWithTLS failed: %v", err)
+	}
+	go l.Start()
+	defer l.Close()
+	url := "https://" + l.Addr().String() + "/"
+
+	h2 := &http.Client{
+		Timeout:   2 * time.Second,
+		Transport: &http2.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}},
+	}
+	if proto := getProto(t, h2, url); proto != "HTTP/2.0" {
+		t.Errorf("Expected HTTP/2.0 via ALPN, got %q", proto)
+	}
+
+	// Client chỉ hỗ trợ HTTP/1.1 vẫn dùng được
+	h1 := &http.Client{
+		Timeout: 2 * time.Second,
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: true, NextProtos: []string{"http/1.1"}},
+		},
+	}
+	if proto := getProto(t, h1, url); proto != "HTTP/1.1" {
+		t.Errorf("Expected HTTP/1.1, got %q", proto)
+	}
+}
+
+func TestHTTPListener_H2C(t *testing.T) {
+	l, err := NewHTTPListenerWithTLS("127.0.0.1:0", nil, protoHandler)
+	if err != nil {
+		t.Fatalf("NewHTTPListenerWithTLS failed: %v", err)
+	}
+	go l.Start()
+	defer l.Close()
+	url := "http://" + l.Addr().String() + "/"
+
+	// h2c prior knowledge
+	h2c := &http.Client{
+		Timeout: 2 * time.Second,
+		Transport: &http2.Transport{
+			AllowHTTP: true,
+			DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+				return (&net.Dialer{}).DialContext(ctx, network, addr)
+			},
+		},
+	}
+	if proto := getProto(t, h2c, url); proto != "HTTP/2.0" {
+		t.Errorf("Expected HTTP/2.0 via h2c, got %q", proto)
+	}
+
+	client := &http.Client{Timeout: 2 * time.Second}
+	if proto := getProto(t, client, url); !strings.HasPrefix(proto, "HTTP/1.") {
+		t.Errorf("Expected HTTP/1.x, got %q", proto)
+	}
+}
@@ -0,0 +1,226 @@
+package listener
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hydragon2m/tunnel-core/internal/connection"
+	"github.com/hydragon2m/tunnel-core/internal/metrics"
+	"github.com/hydragon2m/tunnel-core/internal/quota"
+	"github.com/hydragon2m/tunnel-core/internal/registry"
+)
+
+// DefaultHelloTimeout là thời gian tối đa chờ ClientHello của public connection
+const DefaultHelloTimeout = 10 * time.Second
+
+// errHelloRead dừng handshake giả ngay sau khi đọc được ClientHello
+var errHelloRead = errors.New("client hello read")
+
+// PassthroughStreamHeader là payload của FrameOpenStream cho TLS passthrough stream
+// Agent nhận nguyên bytes TLS (bắt đầu bằng ClientHello) và tự terminate TLS
+type PassthroughStreamHeader struct {
+	Protocol   string `json:"protocol"`    // registry.ProtocolTLS
+	ServerName string `json:"server_name"` // SNI của client
+	FullDomain string `json:"full_domain"` // Tunnel được chọn ("*." đầu nếu wildcard)
+	RemoteAddr string `json:"remote_addr"`
+}
+
+// Passthrough tách TLS connections theo SNI trên listener dùng chung:
+// SNI của passthrough tunnel → pipe bytes mã hóa đến agent, còn lại → server terminate TLS như bình thường
+type Passthrough struct {
+	registry     *registry.Registry
+	connManager  *connection.Manager
+	limiter      *quota.Limiter
+	metrics      *metrics.Metrics
+	helloTimeout time.Duration
+}
+
+// NewPassthrough tạo Passthrough mới
+func NewPassthrough(reg *registry.Registry, connManager *connection.Manager, limiter *quota.Limiter) *Passthrough {
+	return &Passthrough{
+		registry:     reg,
+		connManager:  connManager,
+		limiter:      limiter,
+		helloTimeout: DefaultHelloTimeout,
+	}
+}
+
+// SetMetrics set metrics cho passthrough streams (nil = tắt)
+func (p *Passthrough) SetMetrics(m *metrics.Metrics) {
+	p.metrics = m
+}
+
+// Listen bọc listener TCP: connections không thuộc passthrough tunnel được trả qua Accept
+// (bytes đã đọc để lấy SNI được đọc lại), caller bọc tiếp bằng tls.NewListener
+func (p *Passthrough) Listen(ln net.Listener) net.Listener {
+	l := &sniListener{
+		Listener: ln,
+		p:        p,
+		conns:    make(chan net.Conn),
+		errs:     make(chan error),
+		done:     make(chan struct{}),
+	}
+	go l.acceptLoop()
+	return l
+}
+
+// sniListener là net.Listener chỉ trả về connections cần terminate TLS
+type sniListener struct {
+	net.Listener
+	p         *Passthrough
+	conns     chan net.Conn
+	errs      chan error
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// Accept trả về connection kế tiếp cần terminate TLS
+func (l *sniListener) Accept() (net.Conn, error) {
+	select {
+	case c := <-l.conns:
+		return c, nil
+	case err := <-l.errs:
+		return nil, err
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+// Close đóng listener, Accept đang chờ trả về net.ErrClosed
+func (l *sniListener) Close() error {
+	l.closeOnce.Do(func() {
+		close(l.done)
+	})
+	return l.Listener.Close()
+}
+
+// acceptLoop nhận connections và đọc ClientHello trong goroutine riêng (client chậm không chặn Accept)
+func (l *sniListener) acceptLoop() {
+	for {
+		c, err := l.Listener.Accept()
+		if err != nil {
+			select {
+			case l.errs <- err:
+			case <-l.done:
+				return
+			}
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			continue
+		}
+		go l.route(c)
+	}
+}
+
+// route chọn đích cho connection theo SNI
+func (l *sniListener) route(c net.Conn) {
+	c.SetReadDeadline(time.Now().Add(l.p.helloTimeout))
+	serverName, hello := readServerName(c)
+	c.SetReadDeadline(time.Time{})
+
+	conn := &replayConn{Conn: c, buf: bytes.NewReader(hello)}
+	if serverName != "" {
+		if tunnel, ok := l.p.registry.ResolveSNI(serverName); ok {
+			l.p.serve(tunnel, serverName, conn)
+			return
+		}
+	}
+
+	select {
+	case l.conns <- conn:
+	case <-l.done:
+		c.Close()
+	}
+}
+
+// serve pipe bytes TLS của public connection qua stream mới trên connection của agent
+func (p *Passthrough) serve(tunnel *registry.Tunnel, serverName string, c net.Conn) {
+	defer c.Close()
+
+	if p.limiter != nil {
+		if err := p.limiter.AcquireStream(tunnel.AgentID, tunnel.FullDomain); err != nil {
+			return
+		}
+		defer p.limiter.ReleaseStream(tunnel.AgentID, tunnel.FullDomain)
+	}
+
+	header, err := json.Marshal(PassthroughStreamHeader{
+		Protocol:   registry.ProtocolTLS,
+		ServerName: serverName,
+		FullDomain: tunnel.FullDomain,
+		RemoteAddr: c.RemoteAddr().String(),
+	})
+	if err != nil {
+		return
+	}
+
+	// Stream trên connection ít tải nhất của agent, failover nếu connection vừa đóng
+	conn, stream, err := p.connManager.OpenStream(p.registry.GetTunnelConnections(tunnel.Key()), header)
+	if err != nil {
+		return
+	}
+	defer conn.CloseStream(stream.ID)
+	if p.limiter != nil {
+		stream.SetShaper(p.limiter.Shaper(tunnel.AgentID, tunnel.FullDomain))
+	}
+	p.metrics.StreamOpened(tunnel.FullDomain)
+	defer p.metrics.StreamClosed(tunnel.FullDomain)
+
+	ctx, cancel := context.WithCancel(conn.Context())
+	defer cancel()
+
+	connection.Pipe(ctx, c, conn, stream)
+}
+
+// readServerName đọc ClientHello bằng handshake giả của crypto/tls và trả về SNI (lowercase)
+// cùng toàn bộ bytes đã đọc; SNI rỗng nếu không phải TLS hoặc client không gửi SNI
+func readServerName(c net.Conn) (string, []byte) {
+	var (
+		read       bytes.Buffer
+		serverName string
+	)
+	tls.Server(helloConn{r: io.TeeReader(c, &read)}, &tls.Config{
+		GetConfigForClient: func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+			serverName = hello.ServerName
+			return nil, errHelloRead
+		},
+	}).Handshake()
+
+	return strings.TrimSuffix(strings.ToLower(serverName), "."), read.Bytes()
+}
+
+// helloConn là net.Conn chỉ đọc cho handshake giả (ghi bị bỏ qua, không gửi alert cho client)
+type helloConn struct {
+	r io.Reader
+}
+
+func (c helloConn) Read(b []byte) (int, error)         { return c.r.Read(b) }
+func (c helloConn) Write(b []byte) (int, error)        { return 0, io.ErrClosedPipe }
+func (c helloConn) Close() error                       { return nil }
+func (c helloConn) SetDeadline(t time.Time) error      { return nil }
+func (c helloConn) SetReadDeadline(t time.Time) error  { return nil }
+func (c helloConn) SetWriteDeadline(t time.Time) error { return nil }
+func (c helloConn) LocalAddr() net.Addr                { return nil }
+func (c helloConn) RemoteAddr() net.Addr               { return nil }
+
+// replayConn đọc lại bytes đã peek trước khi đọc tiếp từ connection
+type replayConn struct {
+	net.Conn
+	buf *bytes.Reader
+}
+
+func (c *replayConn) Read(b []byte) (int, error) {
+	if c.buf.Len() > 0 {
+		return c.buf.Read(b)
+	}
+	return c.Conn.Read(b)
+}
@@ -0,0 +1,174 @@
+package listener
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/hydragon2m/tunnel-core/internal/connection"
+	"github.com/hydragon2m/tunnel-core/internal/connection/conntest"
+	"github.com/hydragon2m/tunnel-core/internal/registry"
+	v1 "github.com/hydragon2m/tunnel-protocol/go/v1"
+)
+
+// testCertificate tạo cert tự ký cho host
+func testCertificate(t *testing.T, host string) tls.Certificate {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey failed: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		DNSNames:     []string{host},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("CreateCertificate failed: %v", err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func TestHTTPListener_Passthrough(t *testing.T) {
+	reg := registry.NewRegistry("localhost")
+	cm := connection.NewManager(10, 30*time.Second)
+	_, agent := conntest.Register(t, cm, "conn-1", "agent-1")
+
+	if _, err := reg.RegisterTunnelRoute("secure", registry.Route{Passthrough: true}, "conn-1", "agent-1", nil); err != nil {
+		t.Fatalf("Register passthrough tunnel failed: %v", err)
+	}
+
+	// Listener terminate TLS cho host khác bằng cert của server
+	handler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		io.WriteString(w, "terminated "+req.Host)
+	})
+	l, err := NewHTTPListenerWithTLS("127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{testCertificate(t, "web.localhost")},
+	}, handler)
+	if err != nil {
+		t.Fatalf("NewHTTPListenerWithTLS failed: %v", err)
+	}
+	l.SetPassthrough(NewPassthrough(reg, cm, nil))
+	go l.Start()
+	defer l.Close()
+
+	// Agent: nhận OpenStream + bytes TLS chưa giải mã, đóng stream
+	headerCh := make(chan PassthroughStreamHeader, 1)
+	helloCh := make(chan []byte, 1)
+	go func() {
+		open, err := v1.Decode(agent)
+		if err != nil || open.Type != v1.FrameOpenStream {
+			return
+		}
+		var header PassthroughStreamHeader
+		json.Unmarshal(open.Payload, &header)
+		headerCh <- header
+
+		data, err := v1.Decode(agent)
+		if err != nil {
+			return
+		}
+		helloCh <- data.Payload
+		v1.Encode(agent, &v1.Frame{
+			Version:  v1.Version,
+			Type:     v1.FrameData,
+			Flags:    v1.FlagEndStream,
+			StreamID: open.StreamID,
+		})
+	}()
+
+	addr := l.Addr().String()
+	client := tls.Client(mustDial(t, addr), &tls.Config{ServerName: "Secure.localhost", InsecureSkipVerify: true})
+	client.SetDeadline(time.Now().Add(2 * time.Second))
+	if err := client.Handshake(); err == nil {
+		t.Error("Expected handshake to fail: agent closed the stream without answering")
+	}
+	client.Close()
+
+	select {
+	case header := <-headerCh:
+		if header.Protocol != registry.ProtocolTLS || header.ServerName != "secure.localhost" || header.FullDomain != "secure.localhost" {
+			t.Errorf("Unexpected stream header: %+v", header)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Agent did not receive passthrough stream")
+	}
+	hello := <-helloCh
+	if len(hello) == 0 || hello[0] != 0x16 || !bytes.Contains(hello, []byte("Secure.localhost")) {
+		t.Errorf("Expected raw ClientHello forwarded to agent, got %d bytes", len(hello))
+	}
+
+	// Host không phải passthrough: server terminate TLS trên cùng listener
+	httpClient := &http.Client{
+		Timeout: 2 * time.Second,
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{ServerName: "web.localhost", InsecureSkipVerify: true},
+		},
+	}
+	resp, err := httpClient.Get("https://" + addr + "/")
+	if err != nil {
+		t.Fatalf("GET terminated host failed: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != "terminated "+addr {
+		t.Errorf("Unexpected body %q", body)
+	}
+}
+
+func TestReadServerName(t *testing.T) {
+	server, client := netPipe(t)
+	go tls.Client(client, &tls.Config{ServerName: "App.Example.com"}).Handshake()
+
+	name, hello := readServerName(server)
+	if name != "app.example.com" {
+		t.Errorf("Expected SNI app.example.com, got %q", name)
+	}
+	if len(hello) == 0 || hello[0] != 0x16 {
+		t.Errorf("Expected ClientHello record to be returned for replay, got %d bytes", len(hello))
+	}
+
+	// Không phải TLS: không có SNI, bytes đã đọc vẫn được trả về
+	server, client = netPipe(t)
+	go func() {
+		client.Write([]byte("GET / HTTP/1.1\r\nHost: x\r\n\r\n"))
+		client.Close()
+	}()
+	if name, read := readServerName(server); name != "" || !bytes.HasPrefix(read, []byte("GET")) {
+		t.Errorf("Expected no SNI for plain HTTP, got %q / %q", name, read)
+	}
+}
+
+// mustDial mở TCP connection đến addr
+func mustDial(t *testing.T, addr string) net.Conn {
+	t.Helper()
+	c, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	return c
+}
+
+// netPipe tạo net.Pipe, cả 2 đầu được đóng khi test kết thúc
+func netPipe(t *testing.T) (net.Conn, net.Conn) {
+	t.Helper()
+	server, client := net.Pipe()
+	t.Cleanup(func() {
+		server.Close()
+		client.Close()
+	})
+	return server, client
+}
@@ -1,4 +1,377 @@
 package listener
 
-// TCP listener sẽ được implement sau
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"strconv"
+	"sync"
+	"time"
 
+	"github.com/hydragon2m/tunnel-core/internal/connection"
+	"github.com/hydragon2m/tunnel-core/internal/metrics"
+	"github.com/hydragon2m/tunnel-core/internal/quota"
+	"github.com/hydragon2m/tunnel-core/internal/registry"
+)
+
+// TCPConfig là config cho raw TCP tunnels
+type TCPConfig struct {
+	BindAddr         string        // Host để bind public ports (rỗng = mọi interface)
+	PortRangeStart   int           // Port đầu tiên được cấp phát
+	PortRangeEnd     int           // Port cuối cùng được cấp phát (inclusive)
+	MaxPortsPerAgent int           // Số port tối đa mỗi agent (0 = không giới hạn)
+	ReleaseGrace     time.Duration // Giữ port cho agent sau khi disconnect (0 = release ngay)
+}
+
+// TCPStreamHeader là payload của FrameOpenStream cho TCP stream
+// Agent dùng Port để biết stream thuộc TCP tunnel nào
+type TCPStreamHeader struct {
+	Protocol   string `json:"protocol"`
+	Port       int    `json:"port"`
+	RemoteAddr string `json:"remote_addr"`
+}
+
+// TCPListener quản lý public TCP ports, mỗi port map đến 1 TCP tunnel
+type TCPListener struct {
+	config      TCPConfig
+	registry    *registry.Registry
+	connManager *connection.Manager
+	limiter     *quota.Limiter
+	metrics     *metrics.Metrics
+
+	mu         sync.Mutex
+	tunnels    map[int]*tcpTunnel     // port -> tunnel
+	agentPorts map[string]int         // agentID -> số port đang giữ
+	reserved   map[int]tcpReservation // port -> reservation sau disconnect
+	nextPort   int
+	closed     bool
+}
+
+// tcpTunnel là 1 public port đang listen
+type tcpTunnel struct {
+	tunnel   *registry.Tunnel
+	listener net.Listener
+	conns    map[net.Conn]struct{}
+	connsMu  sync.Mutex
+}
+
+// tcpReservation giữ port cho agent trong grace period
+type tcpReservation struct {
+	agentID string
+	until   time.Time
+}
+
+// NewTCPListener tạo TCP listener mới
+func NewTCPListener(config TCPConfig, reg *registry.Registry, connManager *connection.Manager, limiter *quota.Limiter) (*TCPListener, error) {
+	if config.PortRangeStart <= 0 || config.PortRangeEnd > 65535 || config.PortRangeStart > config.PortRangeEnd {
+		return nil, ErrInvalidPortRange
+	}
+
+	return &TCPListener{
+		config:      config,
+		registry:    reg,
+		connManager: connManager,
+		limiter:     limiter,
+		tunnels:     make(map[int]*tcpTunnel),
+		agentPorts:  make(map[string]int),
+		reserved:    make(map[int]tcpReservation),
+		nextPort:    config.PortRangeStart,
+	}, nil
+}
+
+// SetMetrics set metrics cho streams (nil = tắt), gọi trước khi mở tunnels
+func (l *TCPListener) SetMetrics(m *metrics.Metrics) {
+	l.metrics = m
+}
+
+// OpenTunnel mở public port cho connection và đăng ký TCP tunnel
+// port = 0 → tự cấp phát port trong range; port agent đã mở trên connection khác → attach connection
+func (l *TCPListener) OpenTunnel(connID, agentID string, port int, metadata map[string]string) (*registry.Tunnel, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.closed {
+		return nil, ErrTCPListenerClosed
+	}
+
+	// Không tính thêm vào quota ports của agent
+	if t, exists := l.tunnels[port]; exists && t.tunnel.AgentID == agentID {
+		return l.registry.RegisterTCPTunnel(port, connID, agentID, metadata)
+	}
+
+	if l.config.MaxPortsPerAgent > 0 && l.agentPorts[agentID] >= l.config.MaxPortsPerAgent {
+		return nil, ErrPortQuotaExceeded
+	}
+
+	var (
+		ln  net.Listener
+		err error
+	)
+	if port != 0 {
+		ln, err = l.listenRequestedLocked(agentID, port)
+	} else {
+		port, ln, err = l.listenNextLocked(agentID)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	tunnel, err := l.registry.RegisterTCPTunnel(port, connID, agentID, metadata)
+	if err != nil {
+		ln.Close()
+		return nil, err
+	}
+
+	t := &tcpTunnel{
+		tunnel:   tunnel,
+		listener: ln,
+		conns:    make(map[net.Conn]struct{}),
+	}
+	l.tunnels[port] = t
+	l.agentPorts[agentID]++
+	delete(l.reserved, port)
+
+	go l.acceptLoop(t)
+
+	return tunnel, nil
+}
+
+// CloseTunnel đóng public port và unregister TCP tunnel
+func (l *TCPListener) CloseTunnel(port int) error {
+	l.mu.Lock()
+	t, exists := l.tunnels[port]
+	if exists {
+		l.removeLocked(port, t, false)
+	}
+	l.mu.Unlock()
+
+	if !exists {
+		return ErrTCPTunnelNotFound
+	}
+
+	t.close()
+	return nil
+}
+
+// ReleaseConnection gỡ connection khỏi TCP tunnels của nó (gọi khi connection đóng)
+// Port chỉ đóng khi agent không còn connection nào phục vụ; port được giữ cho agent
+// trong ReleaseGrace nếu có cấu hình
+func (l *TCPListener) ReleaseConnection(connID string) {
+	l.mu.Lock()
+	var released []*tcpTunnel
+	for port, t := range l.tunnels {
+		remaining, err := l.registry.DetachConnection(t.tunnel.FullDomain, connID)
+		if err != nil || remaining > 0 {
+			continue // Không thuộc connection, hoặc agent còn connection khác
+		}
+		l.removeLocked(port, t, true)
+		released = append(released, t)
+	}
+	l.mu.Unlock()
+
+	for _, t := range released {
+		t.close()
+	}
+}
+
+// StopAccepting ngừng nhận public connections và từ chối tunnel mới
+// Connections đang mở vẫn chạy cho đến khi Close
+func (l *TCPListener) StopAccepting() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.closed = true
+	for _, t := range l.tunnels {
+		t.listener.Close()
+	}
+}
+
+// Close đóng tất cả public ports
+func (l *TCPListener) Close() error {
+	l.mu.Lock()
+	l.closed = true
+	tunnels := make([]*tcpTunnel, 0, len(l.tunnels))
+	for port, t := range l.tunnels {
+		l.removeLocked(port, t, false)
+		tunnels = append(tunnels, t)
+	}
+	l.mu.Unlock()
+
+	for _, t := range tunnels {
+		t.close()
+	}
+	return nil
+}
+
+// listenRequestedLocked listen trên port agent yêu cầu
+func (l *TCPListener) listenRequestedLocked(agentID string, port int) (net.Listener, error) {
+	if port < l.config.PortRangeStart || port > l.config.PortRangeEnd {
+		return nil, ErrPortOutOfRange
+	}
+	if _, used := l.tunnels[port]; used || l.reservedForOtherLocked(port, agentID) {
+		return nil, ErrPortUnavailable
+	}
+
+	ln, err := l.listen(port)
+	if err != nil {
+		return nil, ErrPortUnavailable
+	}
+	return ln, nil
+}
+
+// listenNextLocked tìm port trống tiếp theo trong range (round-robin)
+// Ưu tiên port đang được giữ cho chính agent này
+func (l *TCPListener) listenNextLocked(agentID string) (int, net.Listener, error) {
+	for port, r := range l.reserved {
+		if r.agentID == agentID && time.Now().Before(r.until) {
+			if ln, err := l.listen(port); err == nil {
+				return port, ln, nil
+			}
+		}
+	}
+
+	size := l.config.PortRangeEnd - l.config.PortRangeStart + 1
+	for i := 0; i < size; i++ {
+		port := l.nextPort
+		l.nextPort++
+		if l.nextPort > l.config.PortRangeEnd {
+			l.nextPort = l.config.PortRangeStart
+		}
+
+		if _, used := l.tunnels[port]; used || l.reservedForOtherLocked(port, agentID) {
+			continue
+		}
+
+		if ln, err := l.listen(port); err == nil {
+			return port, ln, nil
+		}
+	}
+
+	return 0, nil, ErrNoPortsAvailable
+}
+
+// reservedForOtherLocked kiểm tra port có đang được giữ cho agent khác không
+func (l *TCPListener) reservedForOtherLocked(port int, agentID string) bool {
+	r, exists := l.reserved[port]
+	if !exists {
+		return false
+	}
+	if time.Now().After(r.until) {
+		delete(l.reserved, port)
+		return false
+	}
+	return r.agentID != agentID
+}
+
+// removeLocked xóa tunnel khỏi tracking và registry
+func (l *TCPListener) removeLocked(port int, t *tcpTunnel, reserve bool) {
+	delete(l.tunnels, port)
+
+	agentID := t.tunnel.AgentID
+	if l.agentPorts[agentID] <= 1 {
+		delete(l.agentPorts, agentID)
+	} else {
+		l.agentPorts[agentID]--
+	}
+
+	if reserve && l.config.ReleaseGrace > 0 {
+		l.reserved[port] = tcpReservation{
+			agentID: agentID,
+			until:   time.Now().Add(l.config.ReleaseGrace),
+		}
+	}
+
+	_ = l.registry.UnregisterTunnel(t.tunnel.FullDomain)
+}
+
+// listen mở TCP listener trên port
+func (l *TCPListener) listen(port int) (net.Listener, error) {
+	return net.Listen("tcp", net.JoinHostPort(l.config.BindAddr, strconv.Itoa(port)))
+}
+
+// acceptLoop nhận public connections cho 1 tunnel
+func (l *TCPListener) acceptLoop(t *tcpTunnel) {
+	for {
+		c, err := t.listener.Accept()
+		if err != nil {
+			return
+		}
+		go l.handleConn(t, c)
+	}
+}
+
+// handleConn pipe 1 public TCP connection qua stream mới trên connection của agent
+func (l *TCPListener) handleConn(t *tcpTunnel, c net.Conn) {
+	defer c.Close()
+
+	if !t.track(c) {
+		return
+	}
+	defer t.untrack(c)
+
+	if l.limiter != nil {
+		if err := l.limiter.AcquireStream(t.tunnel.AgentID, t.tunnel.FullDomain); err != nil {
+			return
+		}
+		defer l.limiter.ReleaseStream(t.tunnel.AgentID, t.tunnel.FullDomain)
+	}
+
+	header, err := json.Marshal(TCPStreamHeader{
+		Protocol:   registry.ProtocolTCP,
+		Port:       t.tunnel.Port,
+		RemoteAddr: c.RemoteAddr().String(),
+	})
+	if err != nil {
+		return
+	}
+
+	// Stream trên connection ít tải nhất của agent, failover nếu connection vừa đóng
+	connIDs := l.registry.GetTunnelConnections(t.tunnel.FullDomain)
+	conn, stream, err := l.connManager.OpenStream(connIDs, header)
+	if err != nil {
+		return
+	}
+	defer conn.CloseStream(stream.ID)
+	if l.limiter != nil {
+		stream.SetShaper(l.limiter.Shaper(t.tunnel.AgentID, t.tunnel.FullDomain))
+	}
+	l.metrics.StreamOpened(t.tunnel.FullDomain)
+	defer l.metrics.StreamClosed(t.tunnel.FullDomain)
+
+	ctx, cancel := context.WithCancel(conn.Context())
+	defer cancel()
+
+	connection.Pipe(ctx, c, conn, stream)
+}
+
+// track thêm socket vào tunnel để đóng khi tunnel đóng
+func (t *tcpTunnel) track(c net.Conn) bool {
+	t.connsMu.Lock()
+	defer t.connsMu.Unlock()
+	if t.conns == nil {
+		return false
+	}
+	t.conns[c] = struct{}{}
+	return true
+}
+
+// untrack xóa socket khỏi tunnel
+func (t *tcpTunnel) untrack(c net.Conn) {
+	t.connsMu.Lock()
+	defer t.connsMu.Unlock()
+	delete(t.conns, c)
+}
+
+// close đóng listener và tất cả sockets đang mở
+func (t *tcpTunnel) close() {
+	t.listener.Close()
+
+	t.connsMu.Lock()
+	conns := t.conns
+	t.conns = nil
+	t.connsMu.Unlock()
+
+	for c := range conns {
+		c.Close()
+	}
+}
@@ -0,0 +1,291 @@
+package listener
+
+import (
+	"encoding/json"
+	"io"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/hydragon2m/tunnel-core/internal/connection"
+	"github.com/hydragon2m/tunnel-core/internal/connection/conntest"
+	"github.com/hydragon2m/tunnel-core/internal/registry"
+	v1 "github.com/hydragon2m/tunnel-protocol/go/v1"
+)
+
+// newTestTCPListener tạo TCPListener với port range nhỏ và 1 agent connection
+func newTestTCPListener(t *testing.T, config TCPConfig) (*TCPListener, *registry.Registry, net.Conn) {
+	t.Helper()
+
+	if config.PortRangeStart == 0 {
+		start := freePort(t)
+		config.PortRangeStart = start
+		config.PortRangeEnd = start + 4
+	}
+	config.BindAddr = "127.0.0.1"
+
+	reg := registry.NewRegistry("localhost")
+	cm := connection.NewManager(10, 30*time.Second)
+
+	_, agent := conntest.Register(t, cm, "conn-1", "agent-1")
+
+	l, err := NewTCPListener(config, reg, cm, nil)
+	if err != nil {
+		t.Fatalf("NewTCPListener failed: %v", err)
+	}
+
+	t.Cleanup(func() {
+		l.Close()
+	})
+
+	return l, reg, agent
+}
+
+// freePort tìm 1 port trống để làm điểm bắt đầu của range
+func freePort(t *testing.T) int {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer ln.Close()
+	return ln.Addr().(*net.TCPAddr).Port
+}
+
+func TestNewTCPListener_InvalidRange(t *testing.T) {
+	if _, err := NewTCPListener(TCPConfig{PortRangeStart: 2000, PortRangeEnd: 1000}, nil, nil, nil); err != ErrInvalidPortRange {
+		t.Errorf("Expected ErrInvalidPortRange, got %v", err)
+	}
+}
+
+func TestTCPListener_PipesBothWays(t *testing.T) {
+	l, reg, agent := newTestTCPListener(t, TCPConfig{})
+
+	tunnel, err := l.OpenTunnel("conn-1", "agent-1", 0, nil)
+	if err != nil {
+		t.Fatalf("OpenTunnel failed: %v", err)
+	}
+	if tunnel.Protocol != registry.ProtocolTCP {
+		t.Errorf("Expected TCP tunnel, got '%s'", tunnel.Protocol)
+	}
+	if _, ok := reg.GetTunnel(tunnel.FullDomain); !ok {
+		t.Error("Expected TCP tunnel in registry")
+	}
+
+	// Agent: đọc OpenStream + data, trả "pong" rồi EndStream
+	headerCh := make(chan TCPStreamHeader, 1)
+	go func() {
+		open, err := v1.Decode(agent)
+		if err != nil || open.Type != v1.FrameOpenStream {
+			return
+		}
+		var header TCPStreamHeader
+		json.Unmarshal(open.Payload, &header)
+		headerCh <- header
+
+		if data, err := v1.Decode(agent); err != nil || string(data.Payload) != "ping" {
+			return
+		}
+		v1.Encode(agent, &v1.Frame{
+			Version:  v1.Version,
+			Type:     v1.FrameData,
+			Flags:    v1.FlagEndStream,
+			StreamID: open.StreamID,
+			Payload:  []byte("pong"),
+		})
+	}()
+
+	client, err := net.Dial("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(tunnel.Port)))
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer client.Close()
+	client.SetDeadline(time.Now().Add(2 * time.Second))
+
+	client.Write([]byte("ping"))
+
+	reply, err := io.ReadAll(client)
+	if err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+	if string(reply) != "pong" {
+		t.Errorf("Expected 'pong', got '%s'", reply)
+	}
+
+	header := <-headerCh
+	if header.Protocol != registry.ProtocolTCP || header.Port != tunnel.Port {
+		t.Errorf("Unexpected stream header: %+v", header)
+	}
+}
+
+func TestTCPListener_RequestedPortAndQuota(t *testing.T) {
+	l, _, _ := newTestTCPListener(t, TCPConfig{MaxPortsPerAgent: 1})
+
+	if _, err := l.OpenTunnel("conn-1", "agent-1", l.config.PortRangeEnd+1, nil); err != ErrPortOutOfRange {
+		t.Errorf("Expected ErrPortOutOfRange, got %v", err)
+	}
+
+	port := l.config.PortRangeStart + 2
+	tunnel, err := l.OpenTunnel("conn-1", "agent-1", port, nil)
+	if err != nil {
+		t.Fatalf("OpenTunnel failed: %v", err)
+	}
+	if tunnel.Port != port {
+		t.Errorf("Expected port %d, got %d", port, tunnel.Port)
+	}
+
+	if _, err := l.OpenTunnel("conn-1", "agent-1", 0, nil); err != ErrPortQuotaExceeded {
+		t.Errorf("Expected ErrPortQuotaExceeded, got %v", err)
+	}
+
+	if _, err := l.OpenTunnel("conn-2", "agent-2", port, nil); err != ErrPortUnavailable {
+		t.Errorf("Expected ErrPortUnavailable, got %v", err)
+	}
+}
+
+func TestTCPListener_ReleaseConnection(t *testing.T) {
+	l, reg, _ := newTestTCPListener(t, TCPConfig{ReleaseGrace: time.Minute})
+
+	tunnel, err := l.OpenTunnel("conn-1", "agent-1", 0, nil)
+	if err != nil {
+		t.Fatalf("OpenTunnel failed: %v", err)
+	}
+
+	l.ReleaseConnection("conn-1")
+
+	if _, ok := reg.GetTunnel(tunnel.FullDomain); ok {
+		t.Error("Expected TCP tunnel to be unregistered")
+	}
+	if _, err := net.Dial("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(tunnel.Port))); err == nil {
+		t.Error("Expected public port to be closed")
+	}
+
+	// Port được giữ cho agent-1 trong grace period
+	if _, err := l.OpenTunnel("conn-2", "agent-2", tunnel.Port, nil); err != ErrPortUnavailable {
+		t.Errorf("Expected ErrPortUnavailable for other agent, got %v", err)
+	}
+
+	reopened, err := l.OpenTunnel("conn-3", "agent-1", 0, nil)
+	if err != nil {
+		t.Fatalf("OpenTunnel after reconnect failed: %v", err)
+	}
+	if reopened.Port != tunnel.Port {
+		t.Errorf("Expected reserved port %d, got %d", tunnel.Port, reopened.Port)
+	}
+}
+
+func TestTCPListener_AgentDisconnectReleasesPort(t *testing.T) {
+	l, reg, agent := newTestTCPListener(t, TCPConfig{})
+	l.connManager.SetOnConnectionClosed(l.ReleaseConnection)
+
+	tunnel, err := l.OpenTunnel("conn-1", "agent-1", 0, nil)
+	if err != nil {
+		t.Fatalf("OpenTunnel failed: %v", err)
+	}
+
+	// Agent rớt mạng (không unregister, không FrameClose)
+	agent.Close()
+
+	addr := net.JoinHostPort("127.0.0.1", strconv.Itoa(tunnel.Port))
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		c, err := net.Dial("tcp", addr)
+		if err != nil {
+			break
+		}
+		c.Close()
+		if time.Now().After(deadline) {
+			t.Fatal("Expected public port to be closed after agent disconnect")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if _, ok := reg.GetTunnel(tunnel.FullDomain); ok {
+		t.Error("Expected TCP tunnel to be unregistered")
+	}
+	if _, ok := l.connManager.GetConnection("conn-1"); ok {
+		t.Error("Expected connection to be removed from manager")
+	}
+}
+
+func TestTCPListener_ClientResetClosesStream(t *testing.T) {
+	l, _, agent := newTestTCPListener(t, TCPConfig{})
+
+	tunnel, err := l.OpenTunnel("conn-1", "agent-1", 0, nil)
+	if err != nil {
+		t.Fatalf("OpenTunnel failed: %v", err)
+	}
+
+	// Agent mở stream nhưng không bao giờ trả data hay đóng stream
+	opened := make(chan struct{})
+	closed := make(chan uint32, 1)
+	go func() {
+		for {
+			frame, err := v1.Decode(agent)
+			if err != nil {
+				return
+			}
+			switch frame.Type {
+			case v1.FrameOpenStream:
+				close(opened)
+			case v1.FrameClose:
+				closed <- frame.StreamID
+				return
+			}
+		}
+	}()
+
+	client, err := net.Dial("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(tunnel.Port)))
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	<-opened
+
+	// RST thay vì FIN
+	client.(*net.TCPConn).SetLinger(0)
+	client.Close()
+
+	select {
+	case <-closed:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected FrameClose after client reset")
+	}
+
+	conn, _ := l.connManager.GetConnection("conn-1")
+	deadline := time.Now().Add(time.Second)
+	for conn.StreamCount() != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected stream to be removed after client reset")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestTCPListener_AttachAgentConnection(t *testing.T) {
+	l, reg, _ := newTestTCPListener(t, TCPConfig{MaxPortsPerAgent: 1})
+
+	tunnel, err := l.OpenTunnel("conn-1", "agent-1", 0, nil)
+	if err != nil {
+		t.Fatalf("OpenTunnel failed: %v", err)
+	}
+	// Connection thứ 2 của agent attach vào port đã mở, không tốn quota
+	if _, err := l.OpenTunnel("conn-2", "agent-1", tunnel.Port, nil); err != nil {
+		t.Fatalf("Expected attach to succeed, got %v", err)
+	}
+
+	l.ReleaseConnection("conn-1")
+	if ids := reg.GetTunnelConnections(tunnel.FullDomain); len(ids) != 1 || ids[0] != "conn-2" {
+		t.Fatalf("Expected tunnel kept for conn-2, got %v", ids)
+	}
+	c, err := net.Dial("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(tunnel.Port)))
+	if err != nil {
+		t.Fatalf("Expected public port to stay open: %v", err)
+	}
+	c.Close()
+
+	l.ReleaseConnection("conn-2")
+	if _, ok := reg.GetTunnel(tunnel.FullDomain); ok {
+		t.Error("Expected TCP tunnel to be unregistered with last connection")
+	}
+}
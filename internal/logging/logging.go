@@ -0,0 +1,94 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Setup cấu hình logging mặc định (slog + std log) theo level/file/structured
+// Code trong repo log qua slog với level tương ứng; std log (từ thư viện, vd. net/http)
+// vẫn đi qua cùng handler ở level INFO
+// Trả về io.Closer để đóng log file (no-op nếu log ra stdout)
+func Setup(level, file string, structured bool) (io.Closer, error) {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		return nil, fmt.Errorf("invalid log level %q: %w", level, err)
+	}
+
+	var out io.Writer = os.Stdout
+	closer := io.NopCloser(nil)
+	if file != "" {
+		f, err := os.OpenFile(file, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open log file: %w", err)
+		}
+		out = f
+		closer = f
+	}
+
+	opts := &slog.HandlerOptions{Level: lvl}
+	var handler slog.Handler
+	if structured {
+		handler = slog.NewJSONHandler(out, opts)
+	} else {
+		handler = &classicHandler{w: out, level: lvl, mu: &sync.Mutex{}}
+	}
+
+	// Handler tự ghi timestamp, bỏ prefix của std log
+	log.SetFlags(0)
+	slog.SetDefault(slog.New(handler))
+	return closer, nil
+}
+
+// classicHandler ghi log dạng "2006/01/02 15:04:05 LEVEL msg key=value"
+// giống format std log trước đây
+type classicHandler struct {
+	w     io.Writer
+	level slog.Level
+	attrs []slog.Attr
+	mu    *sync.Mutex
+}
+
+func (h *classicHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level
+}
+
+func (h *classicHandler) Handle(_ context.Context, r slog.Record) error {
+	var b strings.Builder
+	b.WriteString(r.Time.Format("2006/01/02 15:04:05"))
+	b.WriteByte(' ')
+	b.WriteString(r.Level.String())
+	b.WriteByte(' ')
+	b.WriteString(r.Message)
+
+	writeAttr := func(a slog.Attr) bool {
+		fmt.Fprintf(&b, " %s=%v", a.Key, a.Value)
+		return true
+	}
+	for _, a := range h.attrs {
+		writeAttr(a)
+	}
+	r.Attrs(writeAttr)
+	b.WriteByte('\n')
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	_, err := io.WriteString(h.w, b.String())
+	return err
+}
+
+func (h *classicHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	clone := *h
+	clone.attrs = append(append([]slog.Attr(nil), h.attrs...), attrs...)
+	return &clone
+}
+
+func (h *classicHandler) WithGroup(string) slog.Handler {
+	return h
+}
@@ -0,0 +1,158 @@
+// Package metrics cung cấp Prometheus metrics cho tunnel server (text exposition format, không cần client library)
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Auth failure reasons
+const (
+	AuthReasonBadFrame             = "bad_frame"
+	AuthReasonIncompatible         = "incompatible"
+	AuthReasonInvalidCert          = "invalid_cert"
+	AuthReasonInvalidToken         = "invalid_token"
+	AuthReasonRevoked              = "revoked"
+	AuthReasonTokenExpired         = "token_expired"
+	AuthReasonValidatorUnavailable = "validator_unavailable"
+)
+
+// Handshake rejection reasons (connection bị đóng trước khi xác thực)
+const (
+	HandshakeReasonLockedOut      = "locked_out"
+	HandshakeReasonRateLimited    = "rate_limited"
+	HandshakeReasonTimeout        = "timeout"
+	HandshakeReasonTooManyPending = "too_many_pending"
+)
+
+// Metrics là tập metrics của tunnel server
+// Các method an toàn khi gọi trên *Metrics nil (metrics bị tắt)
+type Metrics struct {
+	registry *Registry
+
+	streamsOpened     *CounterVec
+	streamsClosed     *CounterVec
+	requests          *CounterVec
+	requestDuration   *HistogramVec
+	bytesFromAgent    *CounterVec
+	bytesToAgent      *CounterVec
+	quotaRejections   *CounterVec
+	heartbeatTimeouts *CounterVec
+	authFailures      *CounterVec
+	handshakeRejected *CounterVec
+}
+
+// New tạo Metrics với registry riêng
+func New() *Metrics {
+	r := NewRegistry()
+	return &Metrics{
+		registry: r,
+		streamsOpened: r.NewCounterVec("tunnel_streams_opened_total",
+			"Streams opened to agents, by tunnel.", "tunnel"),
+		streamsClosed: r.NewCounterVec("tunnel_streams_closed_total",
+			"Streams closed, by tunnel.", "tunnel"),
+		requests: r.NewCounterVec("tunnel_http_requests_total",
+			"Proxied HTTP requests, by tunnel and status code.", "tunnel", "code"),
+		requestDuration: r.NewHistogramVec("tunnel_http_request_duration_seconds",
+			"Latency of proxied HTTP requests (upgraded connections excluded), by tunnel.", DefaultBuckets, "tunnel"),
+		bytesFromAgent: r.NewCounterVec("tunnel_agent_received_bytes_total",
+			"Frame payload bytes received from agents, by agent.", "agent_id"),
+		bytesToAgent: r.NewCounterVec("tunnel_agent_sent_bytes_total",
+			"Frame payload bytes sent to agents, by agent.", "agent_id"),
+		quotaRejections: r.NewCounterVec("tunnel_quota_rejections_total",
+			"Requests and streams rejected by quota limits, by reason.", "reason"),
+		heartbeatTimeouts: r.NewCounterVec("tunnel_heartbeat_timeouts_total",
+			"Agent connections closed because of heartbeat timeout."),
+		authFailures: r.NewCounterVec("tunnel_auth_failures_total",
+			"Failed agent authentications, by reason.", "reason"),
+		handshakeRejected: r.NewCounterVec("tunnel_handshake_rejections_total",
+			"Agent connections dropped before authentication, by reason.", "reason"),
+	}
+}
+
+// Handler trả về http.Handler cho scrape endpoint
+func (m *Metrics) Handler() http.Handler {
+	return m.registry
+}
+
+// Registry trả về registry để đăng ký thêm metrics (vd. gauges từ main)
+func (m *Metrics) Registry() *Registry {
+	return m.registry
+}
+
+// StreamOpened ghi nhận stream mới của tunnel
+func (m *Metrics) StreamOpened(tunnel string) {
+	if m == nil {
+		return
+	}
+	m.streamsOpened.Inc(tunnel)
+}
+
+// StreamClosed ghi nhận stream của tunnel đã đóng
+func (m *Metrics) StreamClosed(tunnel string) {
+	if m == nil {
+		return
+	}
+	m.streamsClosed.Inc(tunnel)
+}
+
+// ObserveRequest ghi nhận 1 HTTP request đã proxy
+// Upgraded connections (101) chỉ được đếm, không tính latency
+func (m *Metrics) ObserveRequest(tunnel string, code int, d time.Duration) {
+	if m == nil {
+		return
+	}
+	m.requests.Inc(tunnel, strconv.Itoa(code))
+	if code != http.StatusSwitchingProtocols {
+		m.requestDuration.Observe(d.Seconds(), tunnel)
+	}
+}
+
+// AgentBytesReceived ghi nhận bytes nhận từ agent
+func (m *Metrics) AgentBytesReceived(agentID string, n int) {
+	if m == nil || n == 0 {
+		return
+	}
+	m.bytesFromAgent.Add(float64(n), agentID)
+}
+
+// AgentBytesSent ghi nhận bytes gửi đến agent
+func (m *Metrics) AgentBytesSent(agentID string, n int) {
+	if m == nil || n == 0 {
+		return
+	}
+	m.bytesToAgent.Add(float64(n), agentID)
+}
+
+// QuotaRejected ghi nhận request/stream bị từ chối bởi quota
+func (m *Metrics) QuotaRejected(reason string) {
+	if m == nil {
+		return
+	}
+	m.quotaRejections.Inc(reason)
+}
+
+// HeartbeatTimeout ghi nhận connection bị đóng vì heartbeat timeout
+func (m *Metrics) HeartbeatTimeout() {
+	if m == nil {
+		return
+	}
+	m.heartbeatTimeouts.Inc()
+}
+
+// AuthFailed ghi nhận agent xác thực thất bại
+func (m *Metrics) AuthFailed(reason string) {
+	if m == nil {
+		return
+	}
+	m.authFailures.Inc(reason)
+}
+
+// HandshakeRejected ghi nhận agent connection bị đóng trước khi xác thực (flood, rate limit, lockout, timeout)
+func (m *Metrics) HandshakeRejected(reason string) {
+	if m == nil {
+		return
+	}
+	m.handshakeRejected.Inc(reason)
+}
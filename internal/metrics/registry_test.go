@@ -0,0 +1,94 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRegistry_TextFormat(t *testing.T) {
+	r := NewRegistry()
+
+	requests := r.NewCounterVec("test_requests_total", "Requests by code.", "tunnel", "code")
+	requests.Inc("app.localhost", "200")
+	requests.Add(2, "app.localhost", "200")
+	requests.Inc(`we"ird\host`, "502")
+	requests.Add(-1, "app.localhost", "200") // counter không giảm
+
+	r.NewGaugeFunc("test_connections", "Active connections.", func() float64 { return 3 })
+
+	latency := r.NewHistogramVec("test_latency_seconds", "Latency.", []float64{0.1, 1}, "tunnel")
+	latency.Observe(0.05, "app")
+	latency.Observe(0.1, "app")
+	latency.Observe(0.5, "app")
+	latency.Observe(5, "app")
+
+	var b strings.Builder
+	if _, err := r.WriteTo(&b); err != nil {
+		t.Fatalf("WriteTo failed: %v", err)
+	}
+
+	want := `# HELP test_requests_total Requests by code.
+# TYPE test_requests_total counter
+test_requests_total{tunnel="app.localhost",code="200"} 3
+test_requests_total{tunnel="we\"ird\\host",code="502"} 1
+# HELP test_connections Active connections.
+# TYPE test_connections gauge
+test_connections 3
+# HELP test_latency_seconds Latency.
+# TYPE test_latency_seconds histogram
+test_latency_seconds_bucket{tunnel="app",le="0.1"} 2
+test_latency_seconds_bucket{tunnel="app",le="1"} 3
+test_latency_seconds_bucket{tunnel="app",le="+Inf"} 4
+test_latency_seconds_sum{tunnel="app"} 5.65
+test_latency_seconds_count{tunnel="app"} 4
+`
+	if got := b.String(); got != want {
+		t.Errorf("Unexpected exposition:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestRegistry_LabelCountMismatchPanics(t *testing.T) {
+	c := NewRegistry().NewCounterVec("test_total", "Test.", "reason")
+
+	defer func() {
+		if recover() == nil {
+			t.Error("Expected panic on wrong label count")
+		}
+	}()
+	c.Inc()
+}
+
+func TestMetrics_Handler(t *testing.T) {
+	m := New()
+	m.QuotaRejected("agent_rate_limit")
+	m.HeartbeatTimeout()
+	m.HandshakeRejected(HandshakeReasonLockedOut)
+
+	rec := httptest.NewRecorder()
+	m.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("Unexpected content type %q", ct)
+	}
+	body := rec.Body.String()
+	for _, line := range []string{
+		`tunnel_quota_rejections_total{reason="agent_rate_limit"} 1`,
+		"tunnel_heartbeat_timeouts_total 1",
+		`tunnel_handshake_rejections_total{reason="locked_out"} 1`,
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("Expected %q in output:\n%s", line, body)
+		}
+	}
+}
+
+func TestMetrics_NilSafe(t *testing.T) {
+	var m *Metrics
+	m.StreamOpened("app")
+	m.ObserveRequest("app", 200, 0)
+	m.AgentBytesSent("agent-1", 10)
+	m.AuthFailed(AuthReasonInvalidToken)
+	m.HandshakeRejected(HandshakeReasonTimeout)
+}
@@ -0,0 +1,151 @@
+package quota
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+// meterWindow là time constant của throughput meter (EWMA)
+const meterWindow = 2 * time.Second
+
+// Shaper giới hạn bandwidth data của 1 stream theo MaxBandwidth của agent và domain
+// Inbound = agent → server, outbound = server → agent, mỗi chiều có budget riêng.
+// Limit được tra lại mỗi lần gọi nên thay đổi lúc runtime áp dụng ngay cho streams đang chạy
+type Shaper struct {
+	limiter *Limiter
+	agentID string
+	domain  string
+}
+
+// Shaper tạo bandwidth shaper cho stream của agent/domain
+func (l *Limiter) Shaper(agentID, domain string) *Shaper {
+	return &Shaper{
+		limiter: l,
+		agentID: agentID,
+		domain:  domain,
+	}
+}
+
+// WaitInbound ghi nhận n bytes nhận từ agent, chờ nếu vượt bandwidth (delay, không drop)
+func (s *Shaper) WaitInbound(ctx context.Context, n int) error {
+	return s.wait(ctx, n, true)
+}
+
+// WaitOutbound ghi nhận n bytes gửi đến agent, chờ nếu vượt bandwidth (delay, không drop)
+func (s *Shaper) WaitOutbound(ctx context.Context, n int) error {
+	return s.wait(ctx, n, false)
+}
+
+func (s *Shaper) wait(ctx context.Context, n int, inbound bool) error {
+	if s == nil || n <= 0 {
+		return nil
+	}
+
+	if limit, ok := s.limiter.agentLimit(s.agentID); ok {
+		if err := limit.bandwidth.wait(ctx, n, inbound); err != nil {
+			return err
+		}
+	}
+	if limit, ok := s.limiter.domainLimit(s.domain); ok {
+		if err := limit.bandwidth.wait(ctx, n, inbound); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// bandwidth là byte buckets và throughput meters 2 chiều của 1 agent/domain
+type bandwidth struct {
+	inbound  *TokenBucket // nil = không giới hạn
+	outbound *TokenBucket
+	in       *rateMeter
+	out      *rateMeter
+}
+
+// newBandwidth tạo buckets cho maxBandwidth (bytes/second, burst 1 giây)
+// Giữ meters của limit cũ (nếu có) để throughput không reset khi đổi limit
+func newBandwidth(maxBandwidth int64, old *bandwidth) *bandwidth {
+	b := &bandwidth{in: &rateMeter{}, out: &rateMeter{}}
+	if old != nil {
+		b.in, b.out = old.in, old.out
+	}
+	if maxBandwidth > 0 {
+		b.inbound = NewTokenBucket(int(maxBandwidth), int(maxBandwidth))
+		b.outbound = NewTokenBucket(int(maxBandwidth), int(maxBandwidth))
+	}
+	return b
+}
+
+func (b *bandwidth) wait(ctx context.Context, n int, inbound bool) error {
+	bucket, meter := b.outbound, b.out
+	if inbound {
+		bucket, meter = b.inbound, b.in
+	}
+
+	meter.add(n)
+	if bucket == nil {
+		return nil
+	}
+	return bucket.WaitN(ctx, n)
+}
+
+// WaitN consume n tokens, chờ đến khi đủ hoặc ctx bị cancel
+// Bucket được phép nợ nên n > capacity vẫn qua được, các lần gọi sau chờ trả nợ
+func (tb *TokenBucket) WaitN(ctx context.Context, n int) error {
+	tb.mu.Lock()
+	now := time.Now()
+	elapsed := now.Sub(tb.lastRefill).Seconds()
+	tb.tokens = min(float64(tb.capacity), tb.tokens+elapsed*tb.refillRate)
+	tb.lastRefill = now
+
+	tb.tokens -= float64(n)
+	var wait time.Duration
+	if tb.tokens < 0 {
+		wait = time.Duration(-tb.tokens / tb.refillRate * float64(time.Second))
+	}
+	tb.mu.Unlock()
+
+	if wait <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return context.Cause(ctx)
+	}
+}
+
+// rateMeter đo throughput (bytes/second) bằng exponential moving average
+type rateMeter struct {
+	mu   sync.Mutex
+	rate float64
+	last time.Time
+}
+
+func (m *rateMeter) add(n int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	m.rate = m.decayed(now) + float64(n)/meterWindow.Seconds()
+	m.last = now
+}
+
+// Rate trả về throughput hiện tại (bytes/second)
+func (m *rateMeter) Rate() float64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.decayed(time.Now())
+}
+
+func (m *rateMeter) decayed(now time.Time) float64 {
+	if m.last.IsZero() {
+		return 0
+	}
+	return m.rate * math.Exp(-now.Sub(m.last).Seconds()/meterWindow.Seconds())
+}
@@ -0,0 +1,86 @@
+package quota
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestTokenBucket_WaitNDelays(t *testing.T) {
+	tb := NewTokenBucket(1000, 1000)
+
+	start := time.Now()
+	if err := tb.WaitN(context.Background(), 1000); err != nil {
+		t.Fatalf("WaitN failed: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 50*time.Millisecond {
+		t.Errorf("First WaitN within capacity should not wait, took %v", elapsed)
+	}
+
+	// Bucket rỗng: 200 bytes ở 1000 B/s → chờ ~200ms
+	start = time.Now()
+	if err := tb.WaitN(context.Background(), 200); err != nil {
+		t.Fatalf("WaitN failed: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 150*time.Millisecond || elapsed > time.Second {
+		t.Errorf("Expected ~200ms delay, got %v", elapsed)
+	}
+}
+
+func TestTokenBucket_WaitNCanceled(t *testing.T) {
+	tb := NewTokenBucket(100, 100)
+	tb.WaitN(context.Background(), 100)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := tb.WaitN(ctx, 1000); err != context.DeadlineExceeded {
+		t.Errorf("Expected DeadlineExceeded, got %v", err)
+	}
+}
+
+func TestShaper_AgentAndDomainBandwidth(t *testing.T) {
+	l := NewLimiter(10, 100)
+	l.SetAgentLimit("agent-1", 10, 0, 100) // agent không giới hạn bandwidth
+	l.SetDomainLimit("app.localhost", 10, 1000, 100)
+
+	shaper := l.Shaper("agent-1", "app.localhost")
+	shaper.WaitOutbound(context.Background(), 1000)
+
+	// Domain đã hết budget chiều outbound, chiều inbound độc lập
+	start := time.Now()
+	shaper.WaitInbound(context.Background(), 500)
+	if elapsed := time.Since(start); elapsed > 50*time.Millisecond {
+		t.Errorf("Inbound should have its own budget, waited %v", elapsed)
+	}
+	start = time.Now()
+	shaper.WaitOutbound(context.Background(), 200)
+	if elapsed := time.Since(start); elapsed < 150*time.Millisecond {
+		t.Errorf("Expected outbound to be delayed, waited %v", elapsed)
+	}
+
+	agent, _ := l.AgentLimitStatus("agent-1")
+	domain, _ := l.DomainLimitStatus("app.localhost")
+	if agent.ThroughputOut <= 0 || agent.ThroughputIn <= 0 || domain.ThroughputOut <= agent.ThroughputIn {
+		t.Errorf("Unexpected throughput: agent in=%.0f out=%.0f, domain out=%.0f",
+			agent.ThroughputIn, agent.ThroughputOut, domain.ThroughputOut)
+	}
+
+	// Đổi limit lúc runtime giữ throughput
+	l.SetAgentLimit("agent-1", 10, 5000, 100)
+	if st, _ := l.AgentLimitStatus("agent-1"); st.ThroughputOut <= 0 || st.MaxBandwidth != 5000 {
+		t.Errorf("Expected throughput kept after SetAgentLimit, got %+v", st)
+	}
+}
+
+func TestShaper_NoLimits(t *testing.T) {
+	l := NewLimiter(10, 100)
+	shaper := l.Shaper("agent-1", "app.localhost")
+
+	start := time.Now()
+	for i := 0; i < 100; i++ {
+		shaper.WaitOutbound(context.Background(), 1<<20)
+	}
+	if elapsed := time.Since(start); elapsed > 50*time.Millisecond {
+		t.Errorf("Expected no delay without limits, took %v", elapsed)
+	}
+}
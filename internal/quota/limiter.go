@@ -2,7 +2,18 @@ package quota
 
 import (
 	"sync"
+	"sync/atomic"
 	"time"
+
+	"github.com/hydragon2m/tunnel-core/internal/metrics"
+)
+
+const (
+	// limitIdleTTL là thời gian không dùng trước khi limit tạo từ default limit bị xóa
+	// (agent IDs và Host headers do client gửi, giữ mãi sẽ làm map tăng không giới hạn)
+	limitIdleTTL = 5 * time.Minute
+	// limitPruneInterval là chu kỳ tối thiểu giữa 2 lần dọn limits idle
+	limitPruneInterval = time.Minute
 )
 
 // Limiter quản lý rate limiting và resource quotas
@@ -15,9 +26,21 @@ type Limiter struct {
 	domainLimits map[string]*DomainLimit
 	domainMu     sync.RWMutex
 
+	// Default limits cho agent/domain chưa có limit riêng (nil = không giới hạn)
+	defaultAgent  *AgentLimit
+	defaultDomain *DomainLimit
+
+	// Lần dọn gần nhất các limits tạo từ default limit
+	agentLastPrune  time.Time
+	domainLastPrune time.Time
+
 	// Global limits
 	maxConnections int
 	maxStreams     int
+	currentStreams int
+	globalMu       sync.Mutex
+
+	metrics *metrics.Metrics
 }
 
 // AgentLimit là limit cho 1 agent
@@ -29,6 +52,9 @@ type AgentLimit struct {
 	TokenBucket    *TokenBucket // Token bucket cho rate limiting
 	CurrentStreams int          // Current active streams
 	LastReset      time.Time    // Last time limits were reset
+	bandwidth      *bandwidth
+	fromDefault    bool         // Tạo từ default limit, bị xóa khi idle
+	lastUsed       atomic.Int64 // UnixNano lần dùng gần nhất
 	mu             sync.RWMutex
 }
 
@@ -36,10 +62,14 @@ type AgentLimit struct {
 type DomainLimit struct {
 	Domain         string
 	MaxStreams     int          // Max concurrent streams
+	MaxBandwidth   int64        // Max bandwidth (bytes/second), 0 = unlimited
 	RateLimit      int          // Max requests per second
 	TokenBucket    *TokenBucket // Token bucket cho rate limiting
 	CurrentStreams int          // Current active streams
 	LastReset      time.Time    // Last time limits were reset
+	bandwidth      *bandwidth
+	fromDefault    bool         // Tạo từ default limit, bị xóa khi idle
+	lastUsed       atomic.Int64 // UnixNano lần dùng gần nhất
 	mu             sync.RWMutex
 }
 
@@ -54,19 +84,81 @@ type TokenBucket struct {
 
 // NewLimiter tạo Limiter mới
 func NewLimiter(maxConnections, maxStreams int) *Limiter {
+	now := time.Now()
 	return &Limiter{
-		agentLimits:    make(map[string]*AgentLimit),
-		domainLimits:   make(map[string]*DomainLimit),
-		maxConnections: maxConnections,
-		maxStreams:     maxStreams,
+		agentLimits:     make(map[string]*AgentLimit),
+		domainLimits:    make(map[string]*DomainLimit),
+		agentLastPrune:  now,
+		domainLastPrune: now,
+		maxConnections:  maxConnections,
+		maxStreams:      maxStreams,
 	}
 }
 
 // SetAgentLimit set limit cho agent
+// Có thể gọi lúc runtime: streams đang active vẫn được tính vào limit mới
 func (l *Limiter) SetAgentLimit(agentID string, maxStreams int, maxBandwidth int64, rateLimit int) {
 	l.agentMu.Lock()
 	defer l.agentMu.Unlock()
 
+	old := l.agentLimits[agentID]
+	limit := newAgentLimit(agentID, maxStreams, maxBandwidth, rateLimit, old)
+	if old != nil {
+		old.mu.Lock()
+		limit.CurrentStreams = old.CurrentStreams
+		old.mu.Unlock()
+	}
+
+	l.agentLimits[agentID] = limit
+}
+
+// SetDomainLimit set limit cho domain
+// Có thể gọi lúc runtime: streams đang active vẫn được tính vào limit mới
+func (l *Limiter) SetDomainLimit(domain string, maxStreams int, maxBandwidth int64, rateLimit int) {
+	l.domainMu.Lock()
+	defer l.domainMu.Unlock()
+
+	old := l.domainLimits[domain]
+	limit := newDomainLimit(domain, maxStreams, maxBandwidth, rateLimit, old)
+	if old != nil {
+		old.mu.Lock()
+		limit.CurrentStreams = old.CurrentStreams
+		old.mu.Unlock()
+	}
+
+	l.domainLimits[domain] = limit
+}
+
+// SetDefaultAgentLimit set limit mặc định, áp dụng cho agent chưa có limit riêng
+func (l *Limiter) SetDefaultAgentLimit(maxStreams int, maxBandwidth int64, rateLimit int) {
+	l.agentMu.Lock()
+	defer l.agentMu.Unlock()
+
+	l.defaultAgent = &AgentLimit{
+		MaxStreams:   maxStreams,
+		MaxBandwidth: maxBandwidth,
+		RateLimit:    rateLimit,
+	}
+}
+
+// SetDefaultDomainLimit set limit mặc định, áp dụng cho domain chưa có limit riêng
+func (l *Limiter) SetDefaultDomainLimit(maxStreams int, maxBandwidth int64, rateLimit int) {
+	l.domainMu.Lock()
+	defer l.domainMu.Unlock()
+
+	l.defaultDomain = &DomainLimit{
+		MaxStreams:   maxStreams,
+		MaxBandwidth: maxBandwidth,
+		RateLimit:    rateLimit,
+	}
+}
+
+// newAgentLimit tạo AgentLimit, giữ throughput meters của limit cũ (nếu có)
+func newAgentLimit(agentID string, maxStreams int, maxBandwidth int64, rateLimit int, old *AgentLimit) *AgentLimit {
+	var oldBandwidth *bandwidth
+	if old != nil {
+		oldBandwidth = old.bandwidth
+	}
 	limit := &AgentLimit{
 		AgentID:      agentID,
 		MaxStreams:   maxStreams,
@@ -74,33 +166,144 @@ func (l *Limiter) SetAgentLimit(agentID string, maxStreams int, maxBandwidth int
 		RateLimit:    rateLimit,
 		TokenBucket:  NewTokenBucket(rateLimit, rateLimit),
 		LastReset:    time.Now(),
+		bandwidth:    newBandwidth(maxBandwidth, oldBandwidth),
+	}
+	limit.touch()
+	return limit
+}
+
+// newDomainLimit tạo DomainLimit, giữ throughput meters của limit cũ (nếu có)
+func newDomainLimit(domain string, maxStreams int, maxBandwidth int64, rateLimit int, old *DomainLimit) *DomainLimit {
+	var oldBandwidth *bandwidth
+	if old != nil {
+		oldBandwidth = old.bandwidth
+	}
+	limit := &DomainLimit{
+		Domain:       domain,
+		MaxStreams:   maxStreams,
+		MaxBandwidth: maxBandwidth,
+		RateLimit:    rateLimit,
+		TokenBucket:  NewTokenBucket(rateLimit, rateLimit),
+		LastReset:    time.Now(),
+		bandwidth:    newBandwidth(maxBandwidth, oldBandwidth),
 	}
+	limit.touch()
+	return limit
+}
+
+// touch ghi nhận limit vừa được dùng
+func (a *AgentLimit) touch() {
+	a.lastUsed.Store(time.Now().UnixNano())
+}
+
+// idle cho biết limit tạo từ default, không có stream active và không được dùng trong limitIdleTTL
+func (a *AgentLimit) idle(now time.Time) bool {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.fromDefault && a.CurrentStreams == 0 && now.Sub(time.Unix(0, a.lastUsed.Load())) > limitIdleTTL
+}
+
+// touch ghi nhận limit vừa được dùng
+func (d *DomainLimit) touch() {
+	d.lastUsed.Store(time.Now().UnixNano())
+}
+
+// idle cho biết limit tạo từ default, không có stream active và không được dùng trong limitIdleTTL
+func (d *DomainLimit) idle(now time.Time) bool {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.fromDefault && d.CurrentStreams == 0 && now.Sub(time.Unix(0, d.lastUsed.Load())) > limitIdleTTL
+}
+
+// agentLimit lấy limit của agent, tạo từ default limit nếu chưa có
+// Limits tạo từ default được dọn khi idle (xem pruneAgentLimits)
+func (l *Limiter) agentLimit(agentID string) (*AgentLimit, bool) {
+	l.agentMu.RLock()
+	limit, exists := l.agentLimits[agentID]
+	def := l.defaultAgent
+	l.agentMu.RUnlock()
 
+	if exists {
+		limit.touch()
+		return limit, true
+	}
+	if def == nil {
+		return nil, false
+	}
+
+	l.agentMu.Lock()
+	defer l.agentMu.Unlock()
+
+	if limit, exists := l.agentLimits[agentID]; exists {
+		limit.touch()
+		return limit, true
+	}
+	if now := time.Now(); now.Sub(l.agentLastPrune) >= limitPruneInterval {
+		l.pruneAgentLimits(now)
+	}
+	limit = newAgentLimit(agentID, def.MaxStreams, def.MaxBandwidth, def.RateLimit, nil)
+	limit.fromDefault = true
 	l.agentLimits[agentID] = limit
+	return limit, true
 }
 
-// SetDomainLimit set limit cho domain
-func (l *Limiter) SetDomainLimit(domain string, maxStreams int, rateLimit int) {
+// pruneAgentLimits xóa limits tạo từ default đã idle (caller giữ agentMu)
+// Limit do admin set (SetAgentLimit) không bao giờ bị xóa
+func (l *Limiter) pruneAgentLimits(now time.Time) {
+	for agentID, limit := range l.agentLimits {
+		if limit.idle(now) {
+			delete(l.agentLimits, agentID)
+		}
+	}
+	l.agentLastPrune = now
+}
+
+// domainLimit lấy limit của domain, tạo từ default limit nếu chưa có
+// Limits tạo từ default được dọn khi idle (xem pruneDomainLimits)
+func (l *Limiter) domainLimit(domain string) (*DomainLimit, bool) {
+	l.domainMu.RLock()
+	limit, exists := l.domainLimits[domain]
+	def := l.defaultDomain
+	l.domainMu.RUnlock()
+
+	if exists {
+		limit.touch()
+		return limit, true
+	}
+	if def == nil {
+		return nil, false
+	}
+
 	l.domainMu.Lock()
 	defer l.domainMu.Unlock()
 
-	limit := &DomainLimit{
-		Domain:      domain,
-		MaxStreams:  maxStreams,
-		RateLimit:   rateLimit,
-		TokenBucket: NewTokenBucket(rateLimit, rateLimit),
-		LastReset:   time.Now(),
+	if limit, exists := l.domainLimits[domain]; exists {
+		limit.touch()
+		return limit, true
 	}
-
+	if now := time.Now(); now.Sub(l.domainLastPrune) >= limitPruneInterval {
+		l.pruneDomainLimits(now)
+	}
+	limit = newDomainLimit(domain, def.MaxStreams, def.MaxBandwidth, def.RateLimit, nil)
+	limit.fromDefault = true
 	l.domainLimits[domain] = limit
+	return limit, true
+}
+
+// pruneDomainLimits xóa limits tạo từ default đã idle (caller giữ domainMu)
+// Limit do admin set (SetDomainLimit) không bao giờ bị xóa
+func (l *Limiter) pruneDomainLimits(now time.Time) {
+	for domain, limit := range l.domainLimits {
+		if limit.idle(now) {
+			delete(l.domainLimits, domain)
+		}
+	}
+	l.domainLastPrune = now
 }
 
 // CheckAgentStreamLimit kiểm tra xem agent có thể tạo stream mới không
 func (l *Limiter) CheckAgentStreamLimit(agentID string) error {
-	l.agentMu.RLock()
-	limit, exists := l.agentLimits[agentID]
-	l.agentMu.RUnlock()
-
+	limit, exists := l.agentLimit(agentID)
 	if !exists {
 		// No limit set, allow
 		return nil
@@ -118,10 +321,7 @@ func (l *Limiter) CheckAgentStreamLimit(agentID string) error {
 
 // CheckDomainStreamLimit kiểm tra xem domain có thể tạo stream mới không
 func (l *Limiter) CheckDomainStreamLimit(domain string) error {
-	l.domainMu.RLock()
-	limit, exists := l.domainLimits[domain]
-	l.domainMu.RUnlock()
-
+	limit, exists := l.domainLimit(domain)
 	if !exists {
 		// No limit set, allow
 		return nil
@@ -139,10 +339,7 @@ func (l *Limiter) CheckDomainStreamLimit(domain string) error {
 
 // CheckAgentRateLimit kiểm tra rate limit cho agent
 func (l *Limiter) CheckAgentRateLimit(agentID string) error {
-	l.agentMu.RLock()
-	limit, exists := l.agentLimits[agentID]
-	l.agentMu.RUnlock()
-
+	limit, exists := l.agentLimit(agentID)
 	if !exists {
 		// No limit set, allow
 		return nil
@@ -157,10 +354,7 @@ func (l *Limiter) CheckAgentRateLimit(agentID string) error {
 
 // CheckDomainRateLimit kiểm tra rate limit cho domain
 func (l *Limiter) CheckDomainRateLimit(domain string) error {
-	l.domainMu.RLock()
-	limit, exists := l.domainLimits[domain]
-	l.domainMu.RUnlock()
-
+	limit, exists := l.domainLimit(domain)
 	if !exists {
 		// No limit set, allow
 		return nil
@@ -173,8 +367,43 @@ func (l *Limiter) CheckDomainRateLimit(domain string) error {
 	return nil
 }
 
+// SetMetrics set metrics cho rejections (nil = tắt), gọi trước khi dùng limiter
+func (l *Limiter) SetMetrics(m *metrics.Metrics) {
+	l.metrics = m
+}
+
+// observeRejection ghi nhận rejection theo loại lỗi
+func (l *Limiter) observeRejection(err error) {
+	if err == nil {
+		return
+	}
+	l.metrics.QuotaRejected(rejectReason(err))
+}
+
+// rejectReason map lỗi quota → label cho metrics
+func rejectReason(err error) string {
+	switch err {
+	case ErrAgentStreamLimitExceeded:
+		return "agent_stream_limit"
+	case ErrDomainStreamLimitExceeded:
+		return "domain_stream_limit"
+	case ErrAgentRateLimitExceeded:
+		return "agent_rate_limit"
+	case ErrDomainRateLimitExceeded:
+		return "domain_rate_limit"
+	case ErrGlobalStreamLimitExceeded:
+		return "global_stream_limit"
+	case ErrGlobalConnectionLimitExceeded:
+		return "global_connection_limit"
+	default:
+		return "other"
+	}
+}
+
 // AcquireStream tăng stream count cho agent và domain
-func (l *Limiter) AcquireStream(agentID, domain string) error {
+func (l *Limiter) AcquireStream(agentID, domain string) (err error) {
+	defer func() { l.observeRejection(err) }()
+
 	// Check agent limit
 	if err := l.CheckAgentStreamLimit(agentID); err != nil {
 		return err
@@ -185,6 +414,15 @@ func (l *Limiter) AcquireStream(agentID, domain string) error {
 		return err
 	}
 
+	// Check global limit
+	l.globalMu.Lock()
+	if l.maxStreams > 0 && l.currentStreams >= l.maxStreams {
+		l.globalMu.Unlock()
+		return ErrGlobalStreamLimitExceeded
+	}
+	l.currentStreams++
+	l.globalMu.Unlock()
+
 	// Acquire
 	l.agentMu.Lock()
 	if limit, exists := l.agentLimits[agentID]; exists {
@@ -207,6 +445,12 @@ func (l *Limiter) AcquireStream(agentID, domain string) error {
 
 // ReleaseStream giảm stream count cho agent và domain
 func (l *Limiter) ReleaseStream(agentID, domain string) {
+	l.globalMu.Lock()
+	if l.currentStreams > 0 {
+		l.currentStreams--
+	}
+	l.globalMu.Unlock()
+
 	l.agentMu.Lock()
 	if limit, exists := l.agentLimits[agentID]; exists {
 		limit.mu.Lock()
@@ -229,7 +473,9 @@ func (l *Limiter) ReleaseStream(agentID, domain string) {
 }
 
 // CheckRequest kiểm tra tất cả limits cho 1 request
-func (l *Limiter) CheckRequest(agentID, domain string) error {
+func (l *Limiter) CheckRequest(agentID, domain string) (err error) {
+	defer func() { l.observeRejection(err) }()
+
 	// Check rate limits
 	if err := l.CheckAgentRateLimit(agentID); err != nil {
 		return err
@@ -301,6 +547,14 @@ func (tb *TokenBucket) AllowN(n int) bool {
 	return false
 }
 
+// fill nạp đầy bucket (dùng khi admin reset limit)
+func (tb *TokenBucket) fill() {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+	tb.tokens = float64(tb.capacity)
+	tb.lastRefill = time.Now()
+}
+
 // GetStats lấy statistics của token bucket
 func (tb *TokenBucket) GetStats() (tokens float64, capacity int) {
 	tb.mu.Lock()
@@ -335,35 +589,35 @@ func (l *Limiter) GetAgentLimit(agentID string) (*AgentLimit, bool) {
 // GetDomainLimit lấy limit của domain
 func (l *Limiter) GetDomainLimit(domain string) (*DomainLimit, bool) {
 	l.domainMu.RLock()
-	defer l.domainMu.Unlock()
+	defer l.domainMu.RUnlock()
 
 	limit, ok := l.domainLimits[domain]
 	return limit, ok
 }
 
 // ResetAgentLimits reset limits cho agent (for testing/admin)
+// Rate limit được nạp đầy lại; số streams đang active giữ nguyên vì streams vẫn đang chạy
 func (l *Limiter) ResetAgentLimits(agentID string) {
 	l.agentMu.Lock()
 	defer l.agentMu.Unlock()
 
 	if limit, exists := l.agentLimits[agentID]; exists {
 		limit.mu.Lock()
-		limit.CurrentStreams = 0
-		limit.TokenBucket = NewTokenBucket(limit.RateLimit, limit.RateLimit)
+		limit.TokenBucket.fill()
 		limit.LastReset = time.Now()
 		limit.mu.Unlock()
 	}
 }
 
 // ResetDomainLimits reset limits cho domain (for testing/admin)
+// Rate limit được nạp đầy lại; số streams đang active giữ nguyên vì streams vẫn đang chạy
 func (l *Limiter) ResetDomainLimits(domain string) {
 	l.domainMu.Lock()
 	defer l.domainMu.Unlock()
 
 	if limit, exists := l.domainLimits[domain]; exists {
 		limit.mu.Lock()
-		limit.CurrentStreams = 0
-		limit.TokenBucket = NewTokenBucket(limit.RateLimit, limit.RateLimit)
+		limit.TokenBucket.fill()
 		limit.LastReset = time.Now()
 		limit.mu.Unlock()
 	}
@@ -0,0 +1,63 @@
+package quota
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestLimiter_PrunesIdleDefaultLimits(t *testing.T) {
+	l := NewLimiter(10, 100)
+	l.SetDefaultAgentLimit(10, 0, 100)
+	l.SetDefaultDomainLimit(10, 0, 100)
+	l.SetAgentLimit("pinned", 5, 0, 10)
+
+	for i := 0; i < 3; i++ {
+		l.CheckRequest(fmt.Sprintf("agent-%d", i), fmt.Sprintf("app-%d.localhost", i))
+	}
+	if err := l.AcquireStream("agent-0", "app-0.localhost"); err != nil {
+		t.Fatalf("AcquireStream failed: %v", err)
+	}
+	if n := len(l.AgentLimitStatuses()); n != 4 {
+		t.Fatalf("Expected 4 agent limits, got %d", n)
+	}
+
+	// Hết idle TTL: limits từ default không có stream active bị xóa khi có limit mới được tạo
+	past := time.Now().Add(-2 * limitIdleTTL).UnixNano()
+	for _, limit := range l.agentLimits {
+		limit.lastUsed.Store(past)
+	}
+	for _, limit := range l.domainLimits {
+		limit.lastUsed.Store(past)
+	}
+	l.agentLastPrune = time.Time{}
+	l.domainLastPrune = time.Time{}
+	l.CheckRequest("agent-new", "new.localhost")
+
+	agents := map[string]bool{}
+	for _, st := range l.AgentLimitStatuses() {
+		agents[st.AgentID] = true
+	}
+	for _, agentID := range []string{"pinned", "agent-0", "agent-new"} {
+		if !agents[agentID] {
+			t.Errorf("Expected limit of %s to be kept", agentID)
+		}
+	}
+	if agents["agent-1"] || agents["agent-2"] {
+		t.Errorf("Expected idle default limits to be pruned, got %v", agents)
+	}
+	if _, ok := l.DomainLimitStatus("app-1.localhost"); ok {
+		t.Error("Expected idle domain limit to be pruned")
+	}
+	if st, ok := l.DomainLimitStatus("app-0.localhost"); !ok || st.CurrentStreams != 1 {
+		t.Errorf("Expected domain limit with active stream to be kept, got %+v", st)
+	}
+
+	// Limit bị xóa được tạo lại từ default khi agent quay lại
+	if err := l.CheckRequest("agent-1", "app-1.localhost"); err != nil {
+		t.Errorf("CheckRequest failed: %v", err)
+	}
+	if _, ok := l.AgentLimitStatus("agent-1"); !ok {
+		t.Error("Expected limit to be recreated from default")
+	}
+}
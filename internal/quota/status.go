@@ -0,0 +1,120 @@
+package quota
+
+import (
+	"sort"
+	"time"
+)
+
+// AgentLimitStatus là snapshot của AgentLimit, đọc an toàn trong khi limit đang được dùng
+type AgentLimitStatus struct {
+	AgentID         string
+	MaxStreams      int
+	MaxBandwidth    int64
+	RateLimit       int
+	CurrentStreams  int
+	AvailableTokens float64
+	ThroughputIn    float64 // bytes/second agent → server, trung bình vài giây gần nhất
+	ThroughputOut   float64 // bytes/second server → agent
+	LastReset       time.Time
+}
+
+// DomainLimitStatus là snapshot của DomainLimit
+type DomainLimitStatus struct {
+	Domain          string
+	MaxStreams      int
+	MaxBandwidth    int64
+	RateLimit       int
+	CurrentStreams  int
+	AvailableTokens float64
+	ThroughputIn    float64
+	ThroughputOut   float64
+	LastReset       time.Time
+}
+
+// AgentLimitStatus lấy snapshot limit của agent
+func (l *Limiter) AgentLimitStatus(agentID string) (AgentLimitStatus, bool) {
+	limit, ok := l.GetAgentLimit(agentID)
+	if !ok {
+		return AgentLimitStatus{}, false
+	}
+	return limit.status(), true
+}
+
+// AgentLimitStatuses liệt kê snapshot limits của tất cả agents (sort theo agent ID)
+func (l *Limiter) AgentLimitStatuses() []AgentLimitStatus {
+	l.agentMu.RLock()
+	limits := make([]*AgentLimit, 0, len(l.agentLimits))
+	for _, limit := range l.agentLimits {
+		limits = append(limits, limit)
+	}
+	l.agentMu.RUnlock()
+
+	statuses := make([]AgentLimitStatus, 0, len(limits))
+	for _, limit := range limits {
+		statuses = append(statuses, limit.status())
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].AgentID < statuses[j].AgentID })
+	return statuses
+}
+
+// DomainLimitStatus lấy snapshot limit của domain
+func (l *Limiter) DomainLimitStatus(domain string) (DomainLimitStatus, bool) {
+	limit, ok := l.GetDomainLimit(domain)
+	if !ok {
+		return DomainLimitStatus{}, false
+	}
+	return limit.status(), true
+}
+
+// DomainLimitStatuses liệt kê snapshot limits của tất cả domains (sort theo domain)
+func (l *Limiter) DomainLimitStatuses() []DomainLimitStatus {
+	l.domainMu.RLock()
+	limits := make([]*DomainLimit, 0, len(l.domainLimits))
+	for _, limit := range l.domainLimits {
+		limits = append(limits, limit)
+	}
+	l.domainMu.RUnlock()
+
+	statuses := make([]DomainLimitStatus, 0, len(limits))
+	for _, limit := range limits {
+		statuses = append(statuses, limit.status())
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Domain < statuses[j].Domain })
+	return statuses
+}
+
+func (a *AgentLimit) status() AgentLimitStatus {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	tokens, _ := a.TokenBucket.GetStats()
+	return AgentLimitStatus{
+		AgentID:         a.AgentID,
+		MaxStreams:      a.MaxStreams,
+		MaxBandwidth:    a.MaxBandwidth,
+		RateLimit:       a.RateLimit,
+		CurrentStreams:  a.CurrentStreams,
+		AvailableTokens: tokens,
+		ThroughputIn:    a.bandwidth.in.Rate(),
+		ThroughputOut:   a.bandwidth.out.Rate(),
+		LastReset:       a.LastReset,
+	}
+}
+
+func (d *DomainLimit) status() DomainLimitStatus {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	tokens, _ := d.TokenBucket.GetStats()
+	return DomainLimitStatus{
+		Domain:          d.Domain,
+		MaxStreams:      d.MaxStreams,
+		MaxBandwidth:    d.MaxBandwidth,
+		RateLimit:       d.RateLimit,
+		CurrentStreams:  d.CurrentStreams,
+		AvailableTokens: tokens,
+		ThroughputIn:    d.bandwidth.in.Rate(),
+		ThroughputOut:   d.bandwidth.out.Rate(),
+		LastReset:       d.LastReset,
+	}
+}
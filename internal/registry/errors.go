@@ -6,5 +6,9 @@ var (
 	ErrDomainMismatch         = errors.New("domain mismatch")
 	ErrDomainAlreadyRegistered = errors.New("domain already registered")
 	ErrTunnelNotFound         = errors.New("tunnel not found")
+	ErrTunnelNotOwned         = errors.New("tunnel not owned by connection")
+	ErrInvalidSubdomain       = errors.New("invalid subdomain")
+	ErrSubdomainExhausted     = errors.New("could not allocate random subdomain")
+	ErrInvalidPathPrefix      = errors.New("invalid path prefix")
 )
 
@@ -0,0 +1,184 @@
+package registry
+
+import (
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// wildcardPrefix là label đầu của wildcard domain (*.app.example.com)
+const wildcardPrefix = "*."
+
+// Route chọn phần host/path mà HTTP tunnel phục vụ
+type Route struct {
+	Wildcard    bool   // Phục vụ mọi subdomain (mọi độ sâu) của domain, không gồm chính domain
+	PathPrefix  string // Chỉ phục vụ path bắt đầu bằng prefix (rỗng = mọi path)
+	StripPrefix bool   // Bỏ PathPrefix khỏi path trước khi gửi đến agent
+	Passthrough bool   // TLS passthrough (ProtocolTLS): chọn theo SNI, agent tự terminate TLS; không dùng với PathPrefix
+}
+
+// Key là key của tunnel trong registry: FullDomain + PathPrefix (không có prefix: FullDomain)
+func (t *Tunnel) Key() string {
+	return t.FullDomain + t.PathPrefix
+}
+
+// StripPath trả về path gửi đến agent (bỏ PathPrefix nếu StripPrefix)
+func (t *Tunnel) StripPath(p string) string {
+	if !t.StripPrefix || t.PathPrefix == "" {
+		return p
+	}
+	if p = strings.TrimPrefix(p, t.PathPrefix); p == "" {
+		return "/"
+	}
+	return p
+}
+
+// NormalizePathPrefix chuẩn hóa path prefix: "/" hoặc rỗng → rỗng, bỏ "/" cuối
+// Prefix phải là path sạch (không "..", "//", query hay ký tự '*')
+func NormalizePathPrefix(prefix string) (string, error) {
+	if prefix == "" || prefix == "/" {
+		return "", nil
+	}
+	prefix = strings.TrimSuffix(prefix, "/")
+	if !strings.HasPrefix(prefix, "/") || strings.ContainsAny(prefix, "?#*") || path.Clean(prefix) != prefix {
+		return "", ErrInvalidPathPrefix
+	}
+	return prefix, nil
+}
+
+// matchesPath kiểm tra path thuộc prefix theo ranh giới segment (/api khớp /api, /api/x, không khớp /apix)
+func matchesPath(prefix, p string) bool {
+	return prefix == "" || p == prefix || strings.HasPrefix(p, prefix+"/")
+}
+
+// Resolve tìm HTTP tunnel cho request theo host và path
+// Host khớp chính xác được ưu tiên, sau đó wildcard cụ thể nhất (*.b.c trước *.c);
+// trong cùng host, path prefix dài nhất thắng. Host không có prefix nào khớp → thử wildcard kế tiếp
+func (r *Registry) Resolve(host, p string) (*Tunnel, bool) {
+	r.tunnelsMu.RLock()
+	tunnel := r.resolveLocked(host, p)
+	r.tunnelsMu.RUnlock()
+
+	if tunnel == nil {
+		return nil, false
+	}
+	r.touch(tunnel.Key())
+	return tunnel, true
+}
+
+func (r *Registry) resolveLocked(host, p string) *Tunnel {
+	return lookupHost(host, func(candidate string) *Tunnel {
+		return matchRoute(r.hosts[candidate], p)
+	})
+}
+
+// ResolveSNI tìm TLS passthrough tunnel cho server name: exact trước, sau đó wildcard cụ thể nhất
+func (r *Registry) ResolveSNI(serverName string) (*Tunnel, bool) {
+	r.tunnelsMu.RLock()
+	tunnel := lookupHost(serverName, func(candidate string) *Tunnel {
+		if t, exists := r.tunnels[candidate]; exists && t.Protocol == ProtocolTLS {
+			return t
+		}
+		return nil
+	})
+	r.tunnelsMu.RUnlock()
+
+	if tunnel == nil {
+		return nil, false
+	}
+	r.touch(tunnel.Key())
+	return tunnel, true
+}
+
+// lookupHost thử host rồi các wildcard từ cụ thể nhất (*.b.c trước *.c), trả về kết quả khác nil đầu tiên
+func lookupHost(host string, match func(candidate string) *Tunnel) *Tunnel {
+	if tunnel := match(host); tunnel != nil {
+		return tunnel
+	}
+	for rest := host; ; {
+		i := strings.IndexByte(rest, '.')
+		if i < 0 {
+			return nil
+		}
+		rest = rest[i+1:]
+		if tunnel := match(wildcardPrefix + rest); tunnel != nil {
+			return tunnel
+		}
+	}
+}
+
+// hostConflictLocked kiểm tra host đã có tunnel khác kiểu: SNI quyết định trước HTTP
+// nên 1 host chỉ là passthrough hoặc HTTP, không cả hai (caller giữ tunnelsMu)
+func (r *Registry) hostConflictLocked(host, protocol string) bool {
+	if protocol == ProtocolTLS {
+		return len(r.hosts[host]) > 0
+	}
+	existing, exists := r.tunnels[host]
+	return exists && existing.Protocol == ProtocolTLS
+}
+
+// matchRoute chọn route đầu tiên khớp path (routes đã sort prefix dài trước)
+func matchRoute(routes []*Tunnel, p string) *Tunnel {
+	for _, tunnel := range routes {
+		if matchesPath(tunnel.PathPrefix, p) {
+			return tunnel
+		}
+	}
+	return nil
+}
+
+// HostTunnels trả về bản copy các tunnels của host (FullDomain, kể cả dạng *.domain):
+// mọi HTTP routes, hoặc TLS passthrough tunnel (không nằm trong routes vì không có path)
+func (r *Registry) HostTunnels(host string) []Tunnel {
+	r.tunnelsMu.RLock()
+	defer r.tunnelsMu.RUnlock()
+
+	if tunnel, exists := r.tunnels[host]; exists && tunnel.Protocol == ProtocolTLS {
+		return []Tunnel{*tunnel}
+	}
+	routes := r.hosts[host]
+	tunnels := make([]Tunnel, 0, len(routes))
+	for _, tunnel := range routes {
+		tunnels = append(tunnels, *tunnel)
+	}
+	return tunnels
+}
+
+// indexLocked thêm HTTP tunnel vào routes của host (caller giữ tunnelsMu)
+func (r *Registry) indexLocked(tunnel *Tunnel) {
+	if tunnel.Protocol != ProtocolHTTP {
+		return
+	}
+	routes := append(append([]*Tunnel(nil), r.hosts[tunnel.FullDomain]...), tunnel)
+	sort.SliceStable(routes, func(i, j int) bool {
+		return len(routes[i].PathPrefix) > len(routes[j].PathPrefix)
+	})
+	r.hosts[tunnel.FullDomain] = routes
+}
+
+// unindexLocked xóa tunnel khỏi routes của host (caller giữ tunnelsMu)
+func (r *Registry) unindexLocked(tunnel *Tunnel) {
+	routes := make([]*Tunnel, 0, len(r.hosts[tunnel.FullDomain]))
+	for _, t := range r.hosts[tunnel.FullDomain] {
+		if t != tunnel {
+			routes = append(routes, t)
+		}
+	}
+	if len(routes) == 0 {
+		delete(r.hosts, tunnel.FullDomain)
+		return
+	}
+	r.hosts[tunnel.FullDomain] = routes
+}
+
+// touch cập nhật LastAccess của tunnel (async, không block request)
+func (r *Registry) touch(key string) {
+	go func() {
+		r.tunnelsMu.Lock()
+		if t, exists := r.tunnels[key]; exists {
+			t.LastAccess = time.Now()
+		}
+		r.tunnelsMu.Unlock()
+	}()
+}
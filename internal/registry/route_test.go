@@ -0,0 +1,162 @@
+package registry
+
+import "testing"
+
+func TestRegistry_ResolveWildcard(t *testing.T) {
+	r := NewRegistry("example.com")
+
+	if _, err := r.RegisterTunnelRoute("app", Route{Wildcard: true}, "conn-1", "agent-1", nil); err != nil {
+		t.Fatalf("Register wildcard failed: %v", err)
+	}
+	if _, err := r.RegisterCustomTunnel("eu.app.example.com", Route{Wildcard: true}, "conn-2", "agent-2", nil); err != nil {
+		t.Fatalf("Register nested wildcard failed: %v", err)
+	}
+	if _, err := r.RegisterTunnel("", "app", "conn-3", "agent-3", nil); err != nil {
+		t.Fatalf("Register exact failed: %v", err)
+	}
+
+	tests := []struct {
+		host  string
+		agent string
+	}{
+		{"app.example.com", "agent-3"},      // Exact thắng wildcard
+		{"x.app.example.com", "agent-1"},    // *.app
+		{"a.b.app.example.com", "agent-1"},  // Wildcard khớp mọi độ sâu
+		{"x.eu.app.example.com", "agent-2"}, // Wildcard cụ thể hơn thắng
+		{"eu.app.example.com", "agent-1"},   // *.eu.app không gồm chính eu.app
+		{"other.example.com", ""},
+	}
+	for _, tt := range tests {
+		tunnel, ok := r.Resolve(tt.host, "/")
+		switch {
+		case tt.agent == "" && ok:
+			t.Errorf("Resolve(%q): expected no tunnel, got %s", tt.host, tunnel.Key())
+		case tt.agent != "" && (!ok || tunnel.AgentID != tt.agent):
+			t.Errorf("Resolve(%q): expected %s, got %+v", tt.host, tt.agent, tunnel)
+		}
+	}
+
+	if err := r.UnregisterTunnel("*.app.example.com"); err != nil {
+		t.Fatalf("UnregisterTunnel failed: %v", err)
+	}
+	if _, ok := r.Resolve("x.app.example.com", "/"); ok {
+		t.Error("Expected wildcard to be removed from routing")
+	}
+}
+
+func TestRegistry_ResolvePathPrefix(t *testing.T) {
+	r := NewRegistry("example.com")
+
+	r.RegisterTunnel("", "app", "conn-1", "agent-1", nil)
+	api, err := r.RegisterTunnelRoute("app", Route{PathPrefix: "/api/", StripPrefix: true}, "conn-2", "agent-2", nil)
+	if err != nil {
+		t.Fatalf("Register prefix route failed: %v", err)
+	}
+	if api.Key() != "app.example.com/api" || api.FullDomain != "app.example.com" {
+		t.Errorf("Unexpected route key %q / domain %q", api.Key(), api.FullDomain)
+	}
+	r.RegisterTunnelRoute("app", Route{PathPrefix: "/api/v2"}, "conn-3", "agent-3", nil)
+	r.RegisterTunnelRoute("app", Route{Wildcard: true, PathPrefix: "/static"}, "conn-4", "agent-4", nil)
+
+	tests := []struct {
+		host, path string
+		agent      string
+	}{
+		{"app.example.com", "/", "agent-1"},
+		{"app.example.com", "/api", "agent-2"},
+		{"app.example.com", "/api/users", "agent-2"},
+		{"app.example.com", "/apix", "agent-1"}, // Ranh giới segment
+		{"app.example.com", "/api/v2/users", "agent-3"},
+		{"x.app.example.com", "/static/a.css", "agent-4"},
+		{"x.app.example.com", "/", ""},
+	}
+	for _, tt := range tests {
+		tunnel, ok := r.Resolve(tt.host, tt.path)
+		switch {
+		case tt.agent == "" && ok:
+			t.Errorf("Resolve(%q, %q): expected no tunnel, got %s", tt.host, tt.path, tunnel.Key())
+		case tt.agent != "" && (!ok || tunnel.AgentID != tt.agent):
+			t.Errorf("Resolve(%q, %q): expected %s, got %+v", tt.host, tt.path, tt.agent, tunnel)
+		}
+	}
+
+	if got := api.StripPath("/api/users"); got != "/users" {
+		t.Errorf("Expected stripped path /users, got %q", got)
+	}
+	if got := api.StripPath("/api"); got != "/" {
+		t.Errorf("Expected stripped path /, got %q", got)
+	}
+
+	// Connection cuối đóng → route bị gỡ, request rơi về route "/"
+	r.UnregisterConnectionTunnels("conn-2")
+	if tunnel, ok := r.Resolve("app.example.com", "/api/users"); !ok || tunnel.AgentID != "agent-1" {
+		t.Errorf("Expected fallback to / route, got %+v", tunnel)
+	}
+	if n := len(r.HostTunnels("app.example.com")); n != 2 {
+		t.Errorf("Expected 2 routes left on host, got %d", n)
+	}
+}
+
+func TestNormalizePathPrefix(t *testing.T) {
+	valid := map[string]string{"": "", "/": "", "/api": "/api", "/api/": "/api", "/a/b": "/a/b"}
+	for in, want := range valid {
+		if got, err := NormalizePathPrefix(in); err != nil || got != want {
+			t.Errorf("NormalizePathPrefix(%q) = %q, %v; want %q", in, got, err, want)
+		}
+	}
+	for _, in := range []string{"api", "/a//b", "/a/../b", "/a?x=1", "/a/*"} {
+		if _, err := NormalizePathPrefix(in); err != ErrInvalidPathPrefix {
+			t.Errorf("NormalizePathPrefix(%q): expected ErrInvalidPathPrefix, got %v", in, err)
+		}
+	}
+}
+
+func TestRegistry_ResolveSNI(t *testing.T) {
+	r := NewRegistry("example.com")
+
+	secure, err := r.RegisterTunnelRoute("secure", Route{Passthrough: true}, "conn-1", "agent-1", nil)
+	if err != nil {
+		t.Fatalf("Register passthrough failed: %v", err)
+	}
+	if secure.Protocol != ProtocolTLS {
+		t.Errorf("Expected protocol %s, got %s", ProtocolTLS, secure.Protocol)
+	}
+	r.RegisterTunnelRoute("mtls", Route{Passthrough: true, Wildcard: true}, "conn-1", "agent-1", nil)
+	r.RegisterTunnel("", "web", "conn-2", "agent-2", nil)
+
+	tests := []struct {
+		serverName string
+		key        string
+	}{
+		{"secure.example.com", "secure.example.com"},
+		{"a.mtls.example.com", "*.mtls.example.com"},
+		{"web.example.com", ""}, // HTTP tunnel: server terminate TLS
+		{"other.example.com", ""},
+	}
+	for _, tt := range tests {
+		tunnel, ok := r.ResolveSNI(tt.serverName)
+		switch {
+		case tt.key == "" && ok:
+			t.Errorf("ResolveSNI(%q): expected no tunnel, got %s", tt.serverName, tunnel.Key())
+		case tt.key != "" && (!ok || tunnel.Key() != tt.key):
+			t.Errorf("ResolveSNI(%q): expected %s, got %+v", tt.serverName, tt.key, tunnel)
+		}
+	}
+	if _, ok := r.Resolve("secure.example.com", "/"); ok {
+		t.Error("Expected passthrough tunnel not to be routed as HTTP")
+	}
+
+	// 1 host chỉ là passthrough hoặc HTTP
+	if _, err := r.RegisterTunnel("", "secure", "conn-1", "agent-1", nil); err != ErrDomainAlreadyRegistered {
+		t.Errorf("Expected ErrDomainAlreadyRegistered for HTTP on passthrough host, got %v", err)
+	}
+	if _, err := r.RegisterTunnelRoute("secure", Route{PathPrefix: "/api"}, "conn-1", "agent-1", nil); err != ErrDomainAlreadyRegistered {
+		t.Errorf("Expected ErrDomainAlreadyRegistered for prefix route on passthrough host, got %v", err)
+	}
+	if _, err := r.RegisterTunnelRoute("web", Route{Passthrough: true}, "conn-2", "agent-2", nil); err != ErrDomainAlreadyRegistered {
+		t.Errorf("Expected ErrDomainAlreadyRegistered for passthrough on HTTP host, got %v", err)
+	}
+	if _, err := r.RegisterTunnelRoute("x", Route{Passthrough: true, PathPrefix: "/api"}, "conn-1", "agent-1", nil); err != ErrInvalidPathPrefix {
+		t.Errorf("Expected ErrInvalidPathPrefix for passthrough with prefix, got %v", err)
+	}
+}
@@ -1,16 +1,44 @@
 package registry
 
 import (
+	"crypto/rand"
+	"encoding/hex"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 )
 
-// Tunnel đại diện cho 1 tunnel mapping domain → connection
+const (
+	// randomSubdomainBytes là số byte random cho subdomain (hex → 2x ký tự)
+	randomSubdomainBytes = 4
+	// randomSubdomainAttempts là số lần thử khi random subdomain bị trùng
+	randomSubdomainAttempts = 10
+	// maxLabelLength theo RFC 1035
+	maxLabelLength = 63
+)
+
+// Tunnel protocols
+const (
+	ProtocolHTTP = "http"
+	ProtocolTLS  = "tls" // TLS passthrough: server chọn tunnel theo SNI, không giải mã
+	ProtocolTCP  = "tcp"
+)
+
+// Tunnel đại diện cho 1 tunnel mapping domain → connections của agent
+// ConnectionID là connection attach sớm nhất còn lại (tương thích ngược)
 type Tunnel struct {
 	Domain      string
 	Subdomain   string
-	FullDomain  string // subdomain + base domain
+	FullDomain  string // subdomain + base domain, "*." đầu nếu wildcard (TCP: baseDomain:port)
+	PathPrefix  string // Chỉ phục vụ path bắt đầu bằng prefix (rỗng = mọi path)
+	StripPrefix bool   // Bỏ PathPrefix khỏi path trước khi gửi đến agent
+	Protocol    string // ProtocolHTTP, ProtocolTLS hoặc ProtocolTCP
+	Port        int    // Public port (chỉ cho TCP tunnel)
 	ConnectionID string
+	ConnectionIDs []string // Tất cả connections của agent phục vụ tunnel, theo thứ tự attach
 	AgentID     string
 	CreatedAt   time.Time
 	LastAccess  time.Time
@@ -20,11 +48,12 @@ type Tunnel struct {
 // Registry quản lý mapping domain → tunnel → connection
 type Registry struct {
 	// Domain → Tunnel mapping (read-heavy)
-	tunnels map[string]*Tunnel // fullDomain -> Tunnel
+	tunnels map[string]*Tunnel // Tunnel.Key() (fullDomain + pathPrefix) -> Tunnel
+	hosts   map[string][]*Tunnel // fullDomain -> HTTP tunnels, path prefix dài trước
 	tunnelsMu sync.RWMutex
 	
 	// ConnectionID → []Tunnel (để cleanup khi connection close)
-	connTunnels map[string]map[string]*Tunnel // connectionID -> fullDomain -> Tunnel
+	connTunnels map[string]map[string]*Tunnel // connectionID -> Tunnel.Key() -> Tunnel
 	connTunnelsMu sync.RWMutex
 	
 	// Base domain config
@@ -35,13 +64,25 @@ type Registry struct {
 func NewRegistry(baseDomain string) *Registry {
 	return &Registry{
 		tunnels:     make(map[string]*Tunnel),
+		hosts:       make(map[string][]*Tunnel),
 		connTunnels: make(map[string]map[string]*Tunnel),
 		baseDomain:  baseDomain,
 	}
 }
 
 // RegisterTunnel đăng ký tunnel mới
+// Domain đã được agent đăng ký trên connection khác → attach thêm connection vào tunnel
 func (r *Registry) RegisterTunnel(domain, subdomain, connectionID, agentID string, metadata map[string]string) (*Tunnel, error) {
+	return r.registerTunnel(domain, subdomain, Route{}, connectionID, agentID, metadata, true)
+}
+
+// RegisterTunnelRoute đăng ký tunnel cho subdomain với wildcard/path prefix
+// Các agents khác nhau có thể chia cùng host theo path prefix
+func (r *Registry) RegisterTunnelRoute(subdomain string, route Route, connectionID, agentID string, metadata map[string]string) (*Tunnel, error) {
+	return r.registerTunnel("", subdomain, route, connectionID, agentID, metadata, true)
+}
+
+func (r *Registry) registerTunnel(domain, subdomain string, route Route, connectionID, agentID string, metadata map[string]string, attach bool) (*Tunnel, error) {
 	// Build full domain
 	fullDomain := r.buildFullDomain(subdomain)
 	
@@ -50,18 +91,46 @@ func (r *Registry) RegisterTunnel(domain, subdomain, connectionID, agentID strin
 		return nil, ErrDomainMismatch
 	}
 	
+	return r.addHTTPTunnel(domain, subdomain, fullDomain, route, connectionID, agentID, metadata, attach)
+}
+
+// RegisterCustomTunnel đăng ký tunnel cho custom domain (ngoài base domain)
+// Caller phải xác minh agent sở hữu domain trước (domains.Manager)
+func (r *Registry) RegisterCustomTunnel(domain string, route Route, connectionID, agentID string, metadata map[string]string) (*Tunnel, error) {
+	return r.addHTTPTunnel(domain, "", domain, route, connectionID, agentID, metadata, true)
+}
+
+// addHTTPTunnel tạo HTTP (hoặc TLS passthrough) tunnel cho host + route hoặc attach connection vào tunnel đã có
+func (r *Registry) addHTTPTunnel(domain, subdomain, host string, route Route, connectionID, agentID string, metadata map[string]string, attach bool) (*Tunnel, error) {
+	pathPrefix, err := NormalizePathPrefix(route.PathPrefix)
+	if err != nil {
+		return nil, err
+	}
+	protocol := ProtocolHTTP
+	if route.Passthrough {
+		// Passthrough không thấy HTTP path
+		if pathPrefix != "" {
+			return nil, ErrInvalidPathPrefix
+		}
+		protocol = ProtocolTLS
+	}
+	fullDomain := host
+	if route.Wildcard {
+		fullDomain = wildcardPrefix + host
+	}
+	
 	r.tunnelsMu.Lock()
 	defer r.tunnelsMu.Unlock()
 	
 	// Check duplicate
-	if existing, exists := r.tunnels[fullDomain]; exists {
-		if existing.ConnectionID != connectionID {
+	if existing, exists := r.tunnels[fullDomain+pathPrefix]; exists {
+		if existing.Protocol != protocol || (!existing.hasConnection(connectionID) && !(attach && existing.AgentID == agentID)) {
 			return nil, ErrDomainAlreadyRegistered
 		}
-		// Same connection, update metadata
-		existing.Metadata = metadata
-		existing.LastAccess = time.Now()
-		return existing, nil
+		return r.attachLocked(existing, connectionID, metadata), nil
+	}
+	if r.hostConflictLocked(fullDomain, protocol) {
+		return nil, ErrDomainAlreadyRegistered
 	}
 	
 	// Create tunnel
@@ -69,52 +138,150 @@ func (r *Registry) RegisterTunnel(domain, subdomain, connectionID, agentID strin
 		Domain:       domain,
 		Subdomain:    subdomain,
 		FullDomain:   fullDomain,
+		PathPrefix:   pathPrefix,
+		StripPrefix:  route.StripPrefix && pathPrefix != "",
+		Protocol:     protocol,
 		ConnectionID: connectionID,
+		ConnectionIDs: []string{connectionID},
 		AgentID:      agentID,
 		CreatedAt:    time.Now(),
 		LastAccess:   time.Now(),
 		Metadata:     metadata,
 	}
 	
-	r.tunnels[fullDomain] = tunnel
+	r.addTunnelLocked(tunnel)
 	
-	// Track by connection
+	return tunnel, nil
+}
+
+// RegisterTCPTunnel đăng ký TCP tunnel cho public port
+// Port đã được agent đăng ký trên connection khác → attach thêm connection vào tunnel
+func (r *Registry) RegisterTCPTunnel(port int, connectionID, agentID string, metadata map[string]string) (*Tunnel, error) {
+	fullDomain := r.TCPAddress(port)
+
+	r.tunnelsMu.Lock()
+	defer r.tunnelsMu.Unlock()
+
+	if existing, exists := r.tunnels[fullDomain]; exists {
+		if !existing.hasConnection(connectionID) && existing.AgentID != agentID {
+			return nil, ErrDomainAlreadyRegistered
+		}
+		return r.attachLocked(existing, connectionID, metadata), nil
+	}
+
+	tunnel := &Tunnel{
+		Domain:       r.baseDomain,
+		FullDomain:   fullDomain,
+		Protocol:     ProtocolTCP,
+		Port:         port,
+		ConnectionID: connectionID,
+		ConnectionIDs: []string{connectionID},
+		AgentID:      agentID,
+		CreatedAt:    time.Now(),
+		LastAccess:   time.Now(),
+		Metadata:     metadata,
+	}
+
+	r.addTunnelLocked(tunnel)
+
+	return tunnel, nil
+}
+
+// addTunnelLocked thêm tunnel vào registry (caller giữ tunnelsMu)
+func (r *Registry) addTunnelLocked(tunnel *Tunnel) {
+	r.tunnels[tunnel.Key()] = tunnel
+	r.indexLocked(tunnel)
+	r.trackConnection(tunnel.ConnectionID, tunnel)
+}
+
+// attachLocked thêm connection vào tunnel đã có và cập nhật metadata (caller giữ tunnelsMu)
+func (r *Registry) attachLocked(tunnel *Tunnel, connectionID string, metadata map[string]string) *Tunnel {
+	tunnel.Metadata = metadata
+	tunnel.LastAccess = time.Now()
+	if tunnel.hasConnection(connectionID) {
+		return tunnel
+	}
+
+	// Copy-on-write: bản copy từ SnapshotTunnels/LookupTunnel không bị sửa theo
+	ids := make([]string, 0, len(tunnel.ConnectionIDs)+1)
+	tunnel.ConnectionIDs = append(append(ids, tunnel.ConnectionIDs...), connectionID)
+	r.trackConnection(connectionID, tunnel)
+	return tunnel
+}
+
+// trackConnection ghi nhận tunnel thuộc connection (để cleanup khi connection close)
+func (r *Registry) trackConnection(connectionID string, tunnel *Tunnel) {
 	r.connTunnelsMu.Lock()
+	defer r.connTunnelsMu.Unlock()
+
 	if r.connTunnels[connectionID] == nil {
 		r.connTunnels[connectionID] = make(map[string]*Tunnel)
 	}
-	r.connTunnels[connectionID][fullDomain] = tunnel
-	r.connTunnelsMu.Unlock()
-	
-	return tunnel, nil
+	r.connTunnels[connectionID][tunnel.Key()] = tunnel
+}
+
+// untrackConnection xóa tunnel khỏi tracking của connection
+func (r *Registry) untrackConnection(connectionID, domain string) {
+	r.connTunnelsMu.Lock()
+	defer r.connTunnelsMu.Unlock()
+
+	if connTunnels, exists := r.connTunnels[connectionID]; exists {
+		delete(connTunnels, domain)
+		if len(connTunnels) == 0 {
+			delete(r.connTunnels, connectionID)
+		}
+	}
+}
+
+// hasConnection kiểm tra connection có đang phục vụ tunnel không
+func (t *Tunnel) hasConnection(connectionID string) bool {
+	for _, id := range t.ConnectionIDs {
+		if id == connectionID {
+			return true
+		}
+	}
+	return false
+}
+
+// RegisterRandomTunnel đăng ký tunnel với subdomain random
+func (r *Registry) RegisterRandomTunnel(connectionID, agentID string, metadata map[string]string) (*Tunnel, error) {
+	for i := 0; i < randomSubdomainAttempts; i++ {
+		subdomain, err := randomSubdomain()
+		if err != nil {
+			return nil, err
+		}
+
+		// Không attach: subdomain random trùng tunnel khác của agent vẫn phải thử lại
+		tunnel, err := r.registerTunnel("", subdomain, Route{}, connectionID, agentID, metadata, false)
+		if err == ErrDomainAlreadyRegistered {
+			continue
+		}
+		return tunnel, err
+	}
+
+	return nil, ErrSubdomainExhausted
 }
 
-// GetTunnel lấy tunnel theo domain
+// GetTunnel lấy tunnel theo key chính xác (domain, hoặc domain + path prefix); routing dùng Resolve
 func (r *Registry) GetTunnel(domain string) (*Tunnel, bool) {
 	r.tunnelsMu.RLock()
 	defer r.tunnelsMu.RUnlock()
 	
 	tunnel, ok := r.tunnels[domain]
 	if ok {
-		// Update last access (async, không block)
-		go func() {
-			r.tunnelsMu.Lock()
-			if t, exists := r.tunnels[domain]; exists {
-				t.LastAccess = time.Now()
-			}
-			r.tunnelsMu.Unlock()
-		}()
+		r.touch(domain)
 	}
 	
 	return tunnel, ok
 }
 
-// UnregisterTunnel xóa tunnel
+// UnregisterTunnel xóa tunnel khỏi tất cả connections
 func (r *Registry) UnregisterTunnel(domain string) error {
 	r.tunnelsMu.Lock()
 	tunnel, exists := r.tunnels[domain]
 	if exists {
 		delete(r.tunnels, domain)
+		r.unindexLocked(tunnel)
 	}
 	r.tunnelsMu.Unlock()
 	
@@ -123,19 +290,56 @@ func (r *Registry) UnregisterTunnel(domain string) error {
 	}
 	
 	// Remove from connection tracking
-	r.connTunnelsMu.Lock()
-	if connTunnels, exists := r.connTunnels[tunnel.ConnectionID]; exists {
-		delete(connTunnels, domain)
-		if len(connTunnels) == 0 {
-			delete(r.connTunnels, tunnel.ConnectionID)
-		}
+	for _, connectionID := range tunnel.ConnectionIDs {
+		r.untrackConnection(connectionID, domain)
 	}
-	r.connTunnelsMu.Unlock()
 	
 	return nil
 }
 
-// UnregisterConnectionTunnels xóa tất cả tunnels của connection
+// DetachConnection gỡ connection khỏi tunnel, tunnel chỉ bị xóa khi không còn connection nào
+// Trả về số connections còn lại
+func (r *Registry) DetachConnection(domain, connectionID string) (int, error) {
+	r.tunnelsMu.Lock()
+	defer r.tunnelsMu.Unlock()
+
+	tunnel, exists := r.tunnels[domain]
+	if !exists || !tunnel.hasConnection(connectionID) {
+		return 0, ErrTunnelNotFound
+	}
+
+	ids := make([]string, 0, len(tunnel.ConnectionIDs))
+	for _, id := range tunnel.ConnectionIDs {
+		if id != connectionID {
+			ids = append(ids, id)
+		}
+	}
+	r.untrackConnection(connectionID, domain)
+
+	if len(ids) == 0 {
+		delete(r.tunnels, domain)
+		r.unindexLocked(tunnel)
+		return 0, nil
+	}
+	tunnel.ConnectionIDs = ids
+	tunnel.ConnectionID = ids[0]
+	return len(ids), nil
+}
+
+// GetTunnelConnections lấy bản copy các connection IDs đang phục vụ tunnel
+func (r *Registry) GetTunnelConnections(domain string) []string {
+	r.tunnelsMu.RLock()
+	defer r.tunnelsMu.RUnlock()
+
+	tunnel, exists := r.tunnels[domain]
+	if !exists {
+		return nil
+	}
+	return append([]string(nil), tunnel.ConnectionIDs...)
+}
+
+// UnregisterConnectionTunnels gỡ connection khỏi tất cả tunnels của nó
+// Tunnel còn connection khác của agent được giữ lại (failover), ngược lại bị xóa
 func (r *Registry) UnregisterConnectionTunnels(connectionID string) {
 	r.connTunnelsMu.RLock()
 	connTunnels, exists := r.connTunnels[connectionID]
@@ -151,9 +355,9 @@ func (r *Registry) UnregisterConnectionTunnels(connectionID string) {
 	}
 	r.connTunnelsMu.RUnlock()
 	
-	// Unregister từng tunnel
+	// Detach từng tunnel
 	for _, domain := range domains {
-		r.UnregisterTunnel(domain)
+		r.DetachConnection(domain, connectionID)
 	}
 }
 
@@ -170,6 +374,32 @@ func (r *Registry) ListTunnels() []*Tunnel {
 	return tunnels
 }
 
+// LookupTunnel lấy bản copy của tunnel, không cập nhật LastAccess (cho admin/debug)
+func (r *Registry) LookupTunnel(domain string) (Tunnel, bool) {
+	r.tunnelsMu.RLock()
+	defer r.tunnelsMu.RUnlock()
+
+	tunnel, ok := r.tunnels[domain]
+	if !ok {
+		return Tunnel{}, false
+	}
+	return *tunnel, true
+}
+
+// SnapshotTunnels trả về bản copy của tất cả tunnels, sort theo domain
+// Khác ListTunnels, đọc LastAccess an toàn khi tunnels đang được truy cập
+func (r *Registry) SnapshotTunnels() []Tunnel {
+	r.tunnelsMu.RLock()
+	tunnels := make([]Tunnel, 0, len(r.tunnels))
+	for _, tunnel := range r.tunnels {
+		tunnels = append(tunnels, *tunnel)
+	}
+	r.tunnelsMu.RUnlock()
+
+	sort.Slice(tunnels, func(i, j int) bool { return tunnels[i].FullDomain < tunnels[j].FullDomain })
+	return tunnels
+}
+
 // GetConnectionTunnels lấy tất cả tunnels của connection
 func (r *Registry) GetConnectionTunnels(connectionID string) []*Tunnel {
 	r.connTunnelsMu.RLock()
@@ -196,6 +426,38 @@ func (r *Registry) buildFullDomain(subdomain string) string {
 	return subdomain + "." + r.baseDomain
 }
 
+// ValidateSubdomain kiểm tra subdomain là DNS label hợp lệ (a-z, 0-9, '-')
+func ValidateSubdomain(subdomain string) error {
+	if subdomain == "" || len(subdomain) > maxLabelLength {
+		return ErrInvalidSubdomain
+	}
+	if strings.HasPrefix(subdomain, "-") || strings.HasSuffix(subdomain, "-") {
+		return ErrInvalidSubdomain
+	}
+	for _, ch := range subdomain {
+		switch {
+		case ch >= 'a' && ch <= 'z', ch >= '0' && ch <= '9', ch == '-':
+		default:
+			return ErrInvalidSubdomain
+		}
+	}
+	return nil
+}
+
+// randomSubdomain sinh subdomain random dạng hex
+func randomSubdomain() (string, error) {
+	b := make([]byte, randomSubdomainBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// TCPAddress trả về public address (baseDomain:port) của TCP tunnel
+func (r *Registry) TCPAddress(port int) string {
+	return net.JoinHostPort(r.baseDomain, strconv.Itoa(port))
+}
+
 // GetBaseDomain trả về base domain
 func (r *Registry) GetBaseDomain() string {
 	return r.baseDomain
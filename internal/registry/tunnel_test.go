@@ -207,3 +207,83 @@ func TestTunnel_LastAccess(t *testing.T) {
 	}
 }
 
+
+func TestRegistry_RegisterRandomTunnel(t *testing.T) {
+	reg := NewRegistry("localhost")
+
+	tunnel, err := reg.RegisterRandomTunnel("conn-1", "agent-1", nil)
+	if err != nil {
+		t.Fatalf("RegisterRandomTunnel failed: %v", err)
+	}
+
+	if err := ValidateSubdomain(tunnel.Subdomain); err != nil {
+		t.Errorf("Expected valid random subdomain, got '%s'", tunnel.Subdomain)
+	}
+
+	if _, ok := reg.GetTunnel(tunnel.FullDomain); !ok {
+		t.Error("Expected random tunnel to exist")
+	}
+}
+
+func TestValidateSubdomain(t *testing.T) {
+	valid := []string{"app", "my-app", "a1"}
+	for _, s := range valid {
+		if err := ValidateSubdomain(s); err != nil {
+			t.Errorf("Expected '%s' to be valid", s)
+		}
+	}
+
+	invalid := []string{"", "-app", "app-", "App", "a.b", "a_b"}
+	for _, s := range invalid {
+		if err := ValidateSubdomain(s); err == nil {
+			t.Errorf("Expected '%s' to be invalid", s)
+		}
+	}
+}
+
+func TestRegistry_AttachAgentConnections(t *testing.T) {
+	reg := NewRegistry("localhost")
+
+	reg.RegisterTunnel("", "example", "conn-1", "agent-1", nil)
+	if _, err := reg.RegisterTunnel("", "example", "conn-2", "agent-1", nil); err != nil {
+		t.Fatalf("Expected second connection of same agent to attach, got %v", err)
+	}
+	if _, err := reg.RegisterTunnel("", "example", "conn-3", "agent-2", nil); err != ErrDomainAlreadyRegistered {
+		t.Errorf("Expected ErrDomainAlreadyRegistered for other agent, got %v", err)
+	}
+
+	ids := reg.GetTunnelConnections("example.localhost")
+	if len(ids) != 2 || ids[0] != "conn-1" || ids[1] != "conn-2" {
+		t.Fatalf("Expected [conn-1 conn-2], got %v", ids)
+	}
+	if len(reg.GetConnectionTunnels("conn-2")) != 1 {
+		t.Error("Expected tunnel to be tracked for attached connection")
+	}
+
+	// Connection đầu đóng: tunnel còn, chuyển sang connection còn lại
+	reg.UnregisterConnectionTunnels("conn-1")
+	tunnel, ok := reg.LookupTunnel("example.localhost")
+	if !ok {
+		t.Fatal("Expected tunnel to survive while agent has another connection")
+	}
+	if tunnel.ConnectionID != "conn-2" || len(tunnel.ConnectionIDs) != 1 {
+		t.Errorf("Expected conn-2 only, got %s %v", tunnel.ConnectionID, tunnel.ConnectionIDs)
+	}
+
+	reg.UnregisterConnectionTunnels("conn-2")
+	if _, ok := reg.LookupTunnel("example.localhost"); ok {
+		t.Error("Expected tunnel to be removed with last connection")
+	}
+}
+
+func TestRegistry_UnregisterAttachedTunnel(t *testing.T) {
+	reg := NewRegistry("localhost")
+
+	reg.RegisterTunnel("", "example", "conn-1", "agent-1", nil)
+	reg.RegisterTunnel("", "example", "conn-2", "agent-1", nil)
+	reg.UnregisterTunnel("example.localhost")
+
+	if len(reg.GetConnectionTunnels("conn-1")) != 0 || len(reg.GetConnectionTunnels("conn-2")) != 0 {
+		t.Error("Expected tunnel to be untracked from all connections")
+	}
+}
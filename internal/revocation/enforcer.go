@@ -0,0 +1,105 @@
+package revocation
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/hydragon2m/tunnel-core/internal/connection"
+	v1 "github.com/hydragon2m/tunnel-protocol/go/v1"
+)
+
+// ReasonAuthRevoked là reason trong FrameClose gửi cho agent có credentials bị thu hồi
+const ReasonAuthRevoked = "auth_revoked"
+
+// CloseReason là payload (JSON) của FrameClose (StreamID 0, FlagError) trước khi server đóng connection
+type CloseReason struct {
+	Code    v1.ErrorCode `json:"code"`
+	Reason  string       `json:"reason"`
+	Message string       `json:"message,omitempty"`
+}
+
+// Enforcer ngắt các connection đang sống có credentials bị thu hồi
+// Kiểm tra định kỳ (kèm đọc lại file nếu thay đổi) và ngay khi list có entry mới
+type Enforcer struct {
+	list        *List
+	connManager *connection.Manager
+	interval    time.Duration
+}
+
+// NewEnforcer tạo Enforcer, interval là chu kỳ kiểm tra định kỳ
+func NewEnforcer(list *List, connManager *connection.Manager, interval time.Duration) *Enforcer {
+	return &Enforcer{
+		list:        list,
+		connManager: connManager,
+		interval:    interval,
+	}
+}
+
+// Run chạy đến khi ctx bị hủy
+func (e *Enforcer) Run(ctx context.Context) {
+	ticker := time.NewTicker(e.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if reloaded, err := e.list.ReloadIfChanged(); err != nil {
+				slog.Warn("Failed to reload revocation list, keeping previous one", "file", e.list.Path(), "error", err)
+			} else if reloaded {
+				slog.Info("Revocation list reloaded", "file", e.list.Path())
+			}
+			e.Sweep()
+		case <-e.list.Changed():
+			e.Sweep()
+		}
+	}
+}
+
+// Sweep ngắt mọi connection có credentials bị thu hồi, trả về số connection đã ngắt
+func (e *Enforcer) Sweep() int {
+	var wg sync.WaitGroup
+	disconnected := 0
+	for _, c := range e.connManager.Connections() {
+		kind, revoked := e.list.match(c.AgentID, c.Metadata)
+		if !revoked {
+			continue
+		}
+
+		disconnected++
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			e.disconnect(c, kind)
+		}()
+	}
+	wg.Wait()
+	return disconnected
+}
+
+// disconnect gửi FrameClose auth_revoked rồi đóng connection qua Manager.CloseConnection
+// (tunnels/ports được dọn qua onConnectionClosed)
+func (e *Enforcer) disconnect(c *connection.Connection, kind Kind) {
+	payload, _ := json.Marshal(CloseReason{
+		Code:    v1.ErrCodeUnauthorized,
+		Reason:  ReasonAuthRevoked,
+		Message: "credentials revoked",
+	})
+	if err := c.SendFrame(&v1.Frame{
+		Version:  v1.Version,
+		Type:     v1.FrameClose,
+		Flags:    v1.FlagError,
+		StreamID: v1.StreamIDControl,
+		Payload:  payload,
+	}); err != nil {
+		slog.Debug("Failed to send auth_revoked to agent", "conn_id", c.ID, "error", err)
+	}
+
+	if err := e.connManager.CloseConnection(c.ID); err == nil {
+		slog.Warn("Disconnected agent with revoked credentials", "conn_id", c.ID, "agent_id", c.AgentID, "matched", kind)
+	}
+}
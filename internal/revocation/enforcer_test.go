@@ -0,0 +1,99 @@
+package revocation
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/hydragon2m/tunnel-core/internal/connection"
+	"github.com/hydragon2m/tunnel-core/internal/connection/conntest"
+	"github.com/hydragon2m/tunnel-core/internal/handshake"
+	v1 "github.com/hydragon2m/tunnel-protocol/go/v1"
+)
+
+// readFrame đọc 1 frame từ phía agent
+func readFrame(t *testing.T, agent net.Conn) *v1.Frame {
+	t.Helper()
+	agent.SetReadDeadline(time.Now().Add(2 * time.Second))
+	frame, err := v1.Decode(agent)
+	if err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+	return frame
+}
+
+func TestEnforcer_DisconnectsRevokedConnections(t *testing.T) {
+	cm := connection.NewManager(10, 30*time.Second)
+	closed := make(chan string, 2)
+	cm.SetOnConnectionClosed(func(connID string) { closed <- connID })
+
+	server, agent := net.Pipe()
+	t.Cleanup(func() {
+		server.Close()
+		agent.Close()
+	})
+	metadata := map[string]string{handshake.MetadataTokenHash: handshake.TokenHash("tok-1")}
+	if _, err := cm.RegisterConnection("conn-1", "agent-1", &conntest.Conn{Conn: server}, metadata); err != nil {
+		t.Fatalf("RegisterConnection failed: %v", err)
+	}
+	conntest.Register(t, cm, "conn-2", "agent-2")
+
+	l, _ := NewList("")
+	e := NewEnforcer(l, cm, time.Hour)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go e.Run(ctx)
+
+	// Revoke → kiểm tra ngay, không chờ interval
+	if err := l.Revoke(KindToken, handshake.TokenHash("tok-1")); err != nil {
+		t.Fatalf("Revoke failed: %v", err)
+	}
+
+	frame := readFrame(t, agent)
+	var reason CloseReason
+	json.Unmarshal(frame.Payload, &reason)
+	if frame.Type != v1.FrameClose || !frame.IsControlFrame() || !frame.IsError() ||
+		reason.Reason != ReasonAuthRevoked || reason.Code != v1.ErrCodeUnauthorized {
+		t.Errorf("Unexpected close frame: type %d flags %d payload %s", frame.Type, frame.Flags, frame.Payload)
+	}
+
+	select {
+	case connID := <-closed:
+		if connID != "conn-1" {
+			t.Errorf("Expected conn-1 to be closed, got %s", connID)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected revoked connection to be closed")
+	}
+	if _, ok := cm.GetConnection("conn-2"); !ok {
+		t.Error("Expected other connection to stay open")
+	}
+}
+
+func TestEnforcer_Sweep(t *testing.T) {
+	cm := connection.NewManager(10, 30*time.Second)
+	_, agent := conntest.Register(t, cm, "conn-1", "agent-1")
+	go func() {
+		for {
+			if _, err := v1.Decode(agent); err != nil {
+				return
+			}
+		}
+	}()
+
+	l, _ := NewList("")
+	e := NewEnforcer(l, cm, time.Hour)
+	if n := e.Sweep(); n != 0 {
+		t.Errorf("Expected no disconnects, got %d", n)
+	}
+
+	l.Revoke(KindAgent, "agent-1")
+	if n := e.Sweep(); n != 1 {
+		t.Errorf("Expected 1 disconnect, got %d", n)
+	}
+	if _, ok := cm.GetConnection("conn-1"); ok {
+		t.Error("Expected connection to be removed from manager")
+	}
+}
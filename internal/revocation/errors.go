@@ -0,0 +1,11 @@
+package revocation
+
+import "errors"
+
+var (
+	ErrInvalidKind  = errors.New("invalid revocation kind: must be one of tokens, agents, cert_fingerprints")
+	ErrEmptyValue   = errors.New("revocation value must not be empty")
+	ErrNotRevoked   = errors.New("entry is not revoked")
+	ErrNoListFile   = errors.New("revocation list has no file to reload")
+	ErrInvalidEntry = errors.New("invalid revocation entry: token and certificate entries must be SHA-256 hex")
+)
@@ -0,0 +1,333 @@
+// Package revocation quản lý credentials bị thu hồi (token, agent ID, client cert) và ngắt agent đang dùng chúng
+package revocation
+
+import (
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hydragon2m/tunnel-core/internal/handshake"
+	"gopkg.in/yaml.v3"
+)
+
+// Kind là loại entry trong revocation list (cũng là key trong file)
+type Kind string
+
+const (
+	KindToken Kind = "tokens"            // SHA-256 (hex) của token
+	KindAgent Kind = "agents"            // Agent ID
+	KindCert  Kind = "cert_fingerprints" // SHA-256 (hex) của client certificate
+)
+
+// Entries là nội dung revocation list, cũng là format của file:
+//
+//	tokens:
+//	  - "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"
+//	agents:
+//	  - "agent-1"
+//	cert_fingerprints:
+//	  - "3b1f..."
+type Entries struct {
+	Tokens           []string `yaml:"tokens" json:"tokens"`
+	Agents           []string `yaml:"agents" json:"agents"`
+	CertFingerprints []string `yaml:"cert_fingerprints" json:"cert_fingerprints"`
+}
+
+// List là revocation list an toàn cho concurrent access
+// Có file: đọc lại khi file thay đổi, thay đổi qua API được ghi lại vào file
+type List struct {
+	path string // rỗng = chỉ trong bộ nhớ
+
+	mu      sync.RWMutex
+	sets    map[Kind]map[string]struct{}
+	modTime time.Time
+
+	changed chan struct{}
+}
+
+// NewList tạo List, đọc file nếu path != "" (file chưa tồn tại = list rỗng, được tạo khi revoke lần đầu)
+func NewList(path string) (*List, error) {
+	l := &List{
+		path:    path,
+		sets:    newSets(),
+		changed: make(chan struct{}, 1),
+	}
+	if path == "" {
+		return l, nil
+	}
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return l, nil
+	}
+	if err := l.Reload(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func newSets() map[Kind]map[string]struct{} {
+	return map[Kind]map[string]struct{}{
+		KindToken: {},
+		KindAgent: {},
+		KindCert:  {},
+	}
+}
+
+// Path trả về file của list (rỗng = chỉ trong bộ nhớ)
+func (l *List) Path() string {
+	return l.path
+}
+
+// Changed báo hiệu mỗi khi có entry mới (revoke hoặc reload) để kiểm tra lại connections đang sống
+func (l *List) Changed() <-chan struct{} {
+	return l.changed
+}
+
+func (l *List) notify() {
+	select {
+	case l.changed <- struct{}{}:
+	default:
+	}
+}
+
+// normalize chuẩn hóa value theo kind: hash/fingerprint về hex thường (bỏ dấu ":")
+func normalize(kind Kind, value string) (string, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return "", ErrEmptyValue
+	}
+
+	switch kind {
+	case KindAgent:
+		return value, nil
+	case KindToken, KindCert:
+		value = strings.ToLower(strings.ReplaceAll(value, ":", ""))
+		if b, err := hex.DecodeString(value); err != nil || len(b) != 32 {
+			return "", fmt.Errorf("%w: %q", ErrInvalidEntry, value)
+		}
+		return value, nil
+	default:
+		return "", ErrInvalidKind
+	}
+}
+
+// Revoked implements handshake.RevocationChecker: agent ID, hash của token hoặc fingerprint của cert bị thu hồi
+func (l *List) Revoked(agentID string, metadata map[string]string) bool {
+	_, revoked := l.match(agentID, metadata)
+	return revoked
+}
+
+// match trả về loại entry khớp với credentials
+func (l *List) match(agentID string, metadata map[string]string) (Kind, bool) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	if _, ok := l.sets[KindAgent][agentID]; ok {
+		return KindAgent, true
+	}
+	if hash := metadata[handshake.MetadataTokenHash]; hash != "" {
+		if _, ok := l.sets[KindToken][hash]; ok {
+			return KindToken, true
+		}
+	}
+	if fingerprint := metadata[handshake.MetadataCertFingerprint]; fingerprint != "" {
+		if _, ok := l.sets[KindCert][fingerprint]; ok {
+			return KindCert, true
+		}
+	}
+	return "", false
+}
+
+// Revoke thêm entry (token là SHA-256 hex, xem handshake.TokenHash) và ghi file nếu có
+// Ghi file lỗi → list không đổi
+func (l *List) Revoke(kind Kind, value string) error {
+	value, err := normalize(kind, value)
+	if err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	if _, ok := l.sets[kind][value]; ok {
+		l.mu.Unlock()
+		return nil
+	}
+	sets := l.copySets()
+	sets[kind][value] = struct{}{}
+	if err := l.commit(sets); err != nil {
+		l.mu.Unlock()
+		return err
+	}
+	l.mu.Unlock()
+
+	l.notify()
+	return nil
+}
+
+// Unrevoke xóa entry và ghi file nếu có
+func (l *List) Unrevoke(kind Kind, value string) error {
+	value, err := normalize(kind, value)
+	if err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if _, ok := l.sets[kind][value]; !ok {
+		return ErrNotRevoked
+	}
+	sets := l.copySets()
+	delete(sets[kind], value)
+	return l.commit(sets)
+}
+
+// copySets copy sets hiện tại (caller giữ l.mu)
+func (l *List) copySets() map[Kind]map[string]struct{} {
+	sets := newSets()
+	for kind, set := range l.sets {
+		for value := range set {
+			sets[kind][value] = struct{}{}
+		}
+	}
+	return sets
+}
+
+// commit ghi sets vào file (nếu có) rồi thay sets hiện tại (caller giữ l.mu)
+func (l *List) commit(sets map[Kind]map[string]struct{}) error {
+	if l.path != "" {
+		modTime, err := writeFile(l.path, toEntries(sets))
+		if err != nil {
+			return err
+		}
+		l.modTime = modTime
+	}
+	l.sets = sets
+	return nil
+}
+
+// Entries trả về các entries hiện tại (đã sort)
+func (l *List) Entries() Entries {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return toEntries(l.sets)
+}
+
+func toEntries(sets map[Kind]map[string]struct{}) Entries {
+	return Entries{
+		Tokens:           sortedValues(sets[KindToken]),
+		Agents:           sortedValues(sets[KindAgent]),
+		CertFingerprints: sortedValues(sets[KindCert]),
+	}
+}
+
+func sortedValues(set map[string]struct{}) []string {
+	values := make([]string, 0, len(set))
+	for value := range set {
+		values = append(values, value)
+	}
+	sort.Strings(values)
+	return values
+}
+
+// Reload đọc lại file, thay toàn bộ entries
+func (l *List) Reload() error {
+	if l.path == "" {
+		return ErrNoListFile
+	}
+
+	info, err := os.Stat(l.path)
+	if err != nil {
+		return fmt.Errorf("failed to read revocation file: %w", err)
+	}
+	data, err := os.ReadFile(l.path)
+	if err != nil {
+		return fmt.Errorf("failed to read revocation file: %w", err)
+	}
+
+	var file Entries
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return fmt.Errorf("failed to parse revocation file %s: %w", l.path, err)
+	}
+
+	sets := newSets()
+	for kind, values := range map[Kind][]string{
+		KindToken: file.Tokens,
+		KindAgent: file.Agents,
+		KindCert:  file.CertFingerprints,
+	} {
+		for _, value := range values {
+			value, err := normalize(kind, value)
+			if err != nil {
+				return fmt.Errorf("revocation file %s: %s: %w", l.path, kind, err)
+			}
+			sets[kind][value] = struct{}{}
+		}
+	}
+
+	l.mu.Lock()
+	l.sets = sets
+	l.modTime = info.ModTime()
+	l.mu.Unlock()
+
+	l.notify()
+	return nil
+}
+
+// ReloadIfChanged đọc lại file nếu mtime thay đổi kể từ lần đọc/ghi trước
+// Trả về true nếu đã đọc lại; đọc lỗi → giữ entries cũ
+func (l *List) ReloadIfChanged() (bool, error) {
+	if l.path == "" {
+		return false, nil
+	}
+
+	info, err := os.Stat(l.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to read revocation file: %w", err)
+	}
+
+	l.mu.RLock()
+	changed := !info.ModTime().Equal(l.modTime)
+	l.mu.RUnlock()
+	if !changed {
+		return false, nil
+	}
+	return true, l.Reload()
+}
+
+// writeFile ghi entries vào path (file tạm + rename) và trả về mtime của file mới
+func writeFile(path string, entries Entries) (time.Time, error) {
+	data, err := yaml.Marshal(entries)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to write revocation file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return time.Time{}, fmt.Errorf("failed to write revocation file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return time.Time{}, fmt.Errorf("failed to write revocation file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return time.Time{}, fmt.Errorf("failed to write revocation file: %w", err)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to write revocation file: %w", err)
+	}
+	return info.ModTime(), nil
+}
@@ -0,0 +1,122 @@
+package revocation
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hydragon2m/tunnel-core/internal/handshake"
+)
+
+func TestList_RevokeAndMatch(t *testing.T) {
+	l, err := NewList("")
+	if err != nil {
+		t.Fatalf("NewList failed: %v", err)
+	}
+
+	tokenHash := handshake.TokenHash("tok-1")
+	metadata := map[string]string{handshake.MetadataTokenHash: tokenHash}
+	if l.Revoked("agent-1", metadata) {
+		t.Fatal("Expected empty list to revoke nothing")
+	}
+
+	if err := l.Revoke(KindToken, strings.ToUpper(tokenHash)); err != nil {
+		t.Fatalf("Revoke token failed: %v", err)
+	}
+	if kind, ok := l.match("agent-1", metadata); !ok || kind != KindToken {
+		t.Errorf("Expected token match, got %q, %v", kind, ok)
+	}
+	select {
+	case <-l.Changed():
+	default:
+		t.Error("Expected change notification after revoke")
+	}
+
+	// Fingerprint dạng "AB:CD:..." được chuẩn hóa
+	fingerprint := strings.Repeat("ab", 32)
+	if err := l.Revoke(KindCert, strings.ToUpper(strings.Repeat("ab:", 31)+"ab")); err != nil {
+		t.Fatalf("Revoke cert failed: %v", err)
+	}
+	if !l.Revoked("agent-2", map[string]string{handshake.MetadataCertFingerprint: fingerprint}) {
+		t.Error("Expected cert fingerprint to be revoked")
+	}
+
+	if err := l.Revoke(KindAgent, "agent-3"); err != nil {
+		t.Fatalf("Revoke agent failed: %v", err)
+	}
+	if !l.Revoked("agent-3", nil) {
+		t.Error("Expected agent to be revoked")
+	}
+
+	if err := l.Revoke(KindToken, "not-a-hash"); !errors.Is(err, ErrInvalidEntry) {
+		t.Errorf("Expected ErrInvalidEntry, got %v", err)
+	}
+	if err := l.Revoke("users", "x"); !errors.Is(err, ErrInvalidKind) {
+		t.Errorf("Expected ErrInvalidKind, got %v", err)
+	}
+
+	if err := l.Unrevoke(KindToken, tokenHash); err != nil {
+		t.Fatalf("Unrevoke failed: %v", err)
+	}
+	if l.Revoked("agent-1", metadata) {
+		t.Error("Expected token to be unrevoked")
+	}
+	if err := l.Unrevoke(KindToken, tokenHash); !errors.Is(err, ErrNotRevoked) {
+		t.Errorf("Expected ErrNotRevoked, got %v", err)
+	}
+	if err := l.Reload(); !errors.Is(err, ErrNoListFile) {
+		t.Errorf("Expected ErrNoListFile, got %v", err)
+	}
+}
+
+func TestList_File(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "revoked.yaml")
+
+	// File chưa tồn tại: list rỗng, được tạo khi revoke
+	l, err := NewList(path)
+	if err != nil {
+		t.Fatalf("NewList failed: %v", err)
+	}
+	if err := l.Revoke(KindAgent, "agent-1"); err != nil {
+		t.Fatalf("Revoke failed: %v", err)
+	}
+	if reloaded, err := l.ReloadIfChanged(); reloaded || err != nil {
+		t.Errorf("Expected own write not to trigger reload, got %v, %v", reloaded, err)
+	}
+
+	reopened, err := NewList(path)
+	if err != nil {
+		t.Fatalf("NewList on written file failed: %v", err)
+	}
+	if !reopened.Revoked("agent-1", nil) {
+		t.Error("Expected revocation to be persisted")
+	}
+
+	// Operator sửa file: đọc lại khi mtime thay đổi
+	os.WriteFile(path, []byte("agents:\n  - agent-2\n"), 0o600)
+	future := time.Now().Add(time.Minute)
+	os.Chtimes(path, future, future)
+	if reloaded, err := l.ReloadIfChanged(); !reloaded || err != nil {
+		t.Fatalf("Expected reload, got %v, %v", reloaded, err)
+	}
+	if l.Revoked("agent-1", nil) || !l.Revoked("agent-2", nil) {
+		t.Errorf("Unexpected entries after reload: %+v", l.Entries())
+	}
+
+	// File lỗi: giữ entries cũ
+	os.WriteFile(path, []byte("tokens:\n  - not-a-hash\n"), 0o600)
+	future = future.Add(time.Minute)
+	os.Chtimes(path, future, future)
+	if _, err := l.ReloadIfChanged(); !errors.Is(err, ErrInvalidEntry) {
+		t.Errorf("Expected ErrInvalidEntry, got %v", err)
+	}
+	if !l.Revoked("agent-2", nil) {
+		t.Error("Expected previous entries to be kept after failed reload")
+	}
+	if _, err := NewList(path); err == nil {
+		t.Error("Expected NewList to fail on invalid file")
+	}
+}
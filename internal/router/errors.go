@@ -0,0 +1,11 @@
+package router
+
+import "errors"
+
+var (
+	ErrInvalidResponse     = errors.New("invalid response from agent")
+	ErrResponseInterrupted = errors.New("response interrupted")
+	ErrTimeout             = errors.New("timeout waiting for agent")
+	ErrRequestTimeout      = errors.New("request exceeded maximum duration")
+	ErrUpgradeNotSupported = errors.New("connection upgrade not supported")
+)
@@ -0,0 +1,54 @@
+package router
+
+import (
+	"io"
+	"time"
+)
+
+// idleTimer gọi onIdle khi không có hoạt động trong khoảng timeout,
+// và onMax khi hết maxDuration kể từ lúc tạo dù vẫn có data (maxDuration = 0: không giới hạn)
+type idleTimer struct {
+	timer    *time.Timer
+	timeout  time.Duration
+	deadline *time.Timer // nil = không giới hạn tổng thời gian
+}
+
+// newIdleTimer tạo idleTimer và bắt đầu đếm
+func newIdleTimer(timeout, maxDuration time.Duration, onIdle, onMax func()) *idleTimer {
+	t := &idleTimer{
+		timer:   time.AfterFunc(timeout, onIdle),
+		timeout: timeout,
+	}
+	if maxDuration > 0 {
+		t.deadline = time.AfterFunc(maxDuration, onMax)
+	}
+	return t
+}
+
+// reset đếm lại idle timeout từ đầu (gọi khi có data), không gia hạn maxDuration
+func (t *idleTimer) reset() {
+	t.timer.Reset(t.timeout)
+}
+
+// stop dừng cả 2 timers
+func (t *idleTimer) stop() {
+	t.timer.Stop()
+	if t.deadline != nil {
+		t.deadline.Stop()
+	}
+}
+
+// activityReader reset idleTimer mỗi khi đọc được data
+type activityReader struct {
+	r    io.Reader
+	idle *idleTimer
+}
+
+// Read implements io.Reader
+func (a *activityReader) Read(p []byte) (int, error) {
+	n, err := a.r.Read(p)
+	if n > 0 {
+		a.idle.reset()
+	}
+	return n, err
+}
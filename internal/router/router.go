@@ -1,39 +1,76 @@
 package router
 
 import (
+	"bufio"
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"io"
+	"log/slog"
 	"net/http"
+	"net/textproto"
+	"slices"
+	"strconv"
+	"strings"
 	"time"
 
-	"github.com/hydragon2m/tunnel-protocol/go/v1"
 	"github.com/hydragon2m/tunnel-core/internal/connection"
+	"github.com/hydragon2m/tunnel-core/internal/metrics"
 	"github.com/hydragon2m/tunnel-core/internal/quota"
 	"github.com/hydragon2m/tunnel-core/internal/registry"
 )
 
+// bodyChunkSize là kích thước tối đa của 1 FrameData khi stream body
+const bodyChunkSize = connection.MaxDataFrameSize
+
+// statusClientClosedRequest ghi nhận (metrics) request bị client hủy trước khi có response
+// (theo quy ước của nginx, không gửi được cho client)
+const statusClientClosedRequest = 499
+
 // Router route HTTP requests đến agent connections
 type Router struct {
 	registry    *registry.Registry
 	connManager *connection.Manager
 	limiter     *quota.Limiter
-	timeout     time.Duration
+	idleTimeout time.Duration
+	maxDuration time.Duration // 0 = không giới hạn tổng thời gian của request
+	metrics     *metrics.Metrics
 }
 
 // NewRouter tạo Router mới
-func NewRouter(reg *registry.Registry, connManager *connection.Manager, limiter *quota.Limiter, timeout time.Duration) *Router {
+// idleTimeout: request bị hủy (504) khi không có data theo cả 2 chiều trong khoảng này
+func NewRouter(reg *registry.Registry, connManager *connection.Manager, limiter *quota.Limiter, idleTimeout time.Duration) *Router {
 	return &Router{
 		registry:    reg,
 		connManager: connManager,
 		limiter:     limiter,
-		timeout:     timeout,
+		idleTimeout: idleTimeout,
 	}
 }
 
+// SetRequestTimeout giới hạn tổng thời gian của 1 request kể cả khi data vẫn đang chảy (0 = không giới hạn)
+// Không áp dụng cho upgraded connections (WebSocket) sau khi đã switch protocols
+func (r *Router) SetRequestTimeout(d time.Duration) {
+	r.maxDuration = d
+}
+
+// SetMetrics set metrics cho requests/streams (nil = tắt)
+func (r *Router) SetMetrics(m *metrics.Metrics) {
+	r.metrics = m
+}
+
 // ServeHTTP implements http.Handler
 func (r *Router) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	// Request không khớp tunnel nào được ghi với tunnel="" (tránh label theo Host tùy ý)
+	var tunnelName string
+	if r.metrics != nil {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w}
+		w = rec
+		defer func() { r.metrics.ObserveRequest(tunnelName, rec.Status(), time.Since(start)) }()
+	}
+
 	// Extract domain from Host header
 	host := req.Host
 	if host == "" {
@@ -41,48 +78,79 @@ func (r *Router) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	// Lookup tunnel
-	tunnel, ok := r.registry.GetTunnel(host)
-	if !ok {
+	// Lookup tunnel theo host (exact rồi wildcard) và path prefix
+	tunnel, ok := r.registry.Resolve(host, req.URL.Path)
+	if !ok || tunnel.Protocol != registry.ProtocolHTTP {
 		http.Error(w, fmt.Sprintf("Tunnel not found for domain: %s", host), http.StatusNotFound)
 		return
 	}
+	tunnelName = tunnel.Key()
 
-	// Check quota/rate limits
+	// Check quota/rate limits (domain limit theo FullDomain: mọi host của wildcard dùng chung limit)
 	if r.limiter != nil {
-		if err := r.limiter.CheckRequest(tunnel.AgentID, host); err != nil {
+		if err := r.limiter.CheckRequest(tunnel.AgentID, tunnel.FullDomain); err != nil {
 			http.Error(w, fmt.Sprintf("Rate limit exceeded: %v", err), http.StatusTooManyRequests)
 			return
 		}
 	}
 
-	// Get connection
-	conn, ok := r.connManager.GetConnection(tunnel.ConnectionID)
-	if !ok {
+	// Connections đang phục vụ tunnel (agent có thể giữ nhiều connections)
+	connIDs := r.registry.GetTunnelConnections(tunnel.Key())
+	if _, ok := r.connManager.PickConnection(connIDs); !ok {
 		http.Error(w, "Connection not found", http.StatusServiceUnavailable)
 		return
 	}
 
 	// Acquire stream quota
 	if r.limiter != nil {
-		if err := r.limiter.AcquireStream(tunnel.AgentID, host); err != nil {
+		if err := r.limiter.AcquireStream(tunnel.AgentID, tunnel.FullDomain); err != nil {
 			http.Error(w, fmt.Sprintf("Stream limit exceeded: %v", err), http.StatusTooManyRequests)
 			return
 		}
 		// Release stream quota when done
-		defer r.limiter.ReleaseStream(tunnel.AgentID, host)
+		defer r.limiter.ReleaseStream(tunnel.AgentID, tunnel.FullDomain)
 	}
 
-	// Create new stream
-	streamID := conn.AllocateStreamID()
+	// Context theo request: client ngắt kết nối → cancel → stream bị reset ngay
+	// Idle timeout reset mỗi khi có data; tổng thời gian giới hạn bởi SetRequestTimeout (nếu có)
+	ctx, cancel := context.WithCancelCause(req.Context())
+	defer cancel(nil)
+	idle := newIdleTimer(r.idleTimeout, r.maxDuration, func() { cancel(ErrTimeout) }, func() { cancel(ErrRequestTimeout) })
+	defer idle.stop()
+
+	// Bandwidth shaping theo agent/domain (delay, không drop)
+	var shaper connection.Shaper
+	if r.limiter != nil {
+		shaper = r.limiter.Shaper(tunnel.AgentID, tunnel.FullDomain)
+	}
 
-	// Create context with timeout
-	ctx, cancel := context.WithTimeout(context.Background(), r.timeout)
-	defer cancel()
+	if tunnel.StripPrefix {
+		req = stripPathPrefix(req, tunnel)
+	}
 
 	// Handle request
-	if err := r.handleRequest(ctx, conn, streamID, w, req); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	if err := r.handleRequest(ctx, idle, connIDs, tunnelName, shaper, w, req); err != nil {
+		switch {
+		case req.Context().Err() != nil:
+			// Client đã đóng: stream đã reset và stream quota được trả khi return
+			slog.Debug("Client canceled request", "host", host, "agent_id", tunnel.AgentID)
+			w.WriteHeader(statusClientClosedRequest)
+		case errors.Is(err, connection.ErrConnectionNotFound):
+			// Tất cả connections của agent vừa đóng
+			http.Error(w, "Connection not found", http.StatusServiceUnavailable)
+		case errors.Is(err, ErrResponseInterrupted):
+			// Headers đã gửi, abort để client thấy response bị cắt
+			panic(http.ErrAbortHandler)
+		case errors.Is(err, ErrInvalidResponse):
+			// Chi tiết parse error chỉ log phía server, không gửi cho public client
+			slog.Warn("Invalid response from agent", "host", host, "agent_id", tunnel.AgentID, "error", err)
+			http.Error(w, http.StatusText(http.StatusBadGateway), http.StatusBadGateway)
+		case errors.Is(err, ErrTimeout), errors.Is(err, ErrRequestTimeout), errors.Is(err, context.DeadlineExceeded):
+			http.Error(w, err.Error(), http.StatusGatewayTimeout)
+		default:
+			slog.Error("Proxy request failed", "host", host, "agent_id", tunnel.AgentID, "error", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		}
 		return
 	}
 }
@@ -90,83 +158,171 @@ func (r *Router) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 // handleRequest handles a single HTTP request
 func (r *Router) handleRequest(
 	ctx context.Context,
-	conn *connection.Connection,
-	streamID uint32,
+	idle *idleTimer,
+	connIDs []string,
+	tunnelName string,
+	shaper connection.Shaper,
 	w http.ResponseWriter,
 	req *http.Request,
-) error {
-	// Build request payload (simplified - can be enhanced with full HTTP serialization)
+) (err error) {
+	// Body được gửi song song với việc chờ response: agent có thể trả response (và stream response body)
+	// trước khi client gửi hết body (gRPC bidirectional streaming)
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
 	requestData := r.buildRequestPayload(req)
 
-	// Send FrameOpenStream
-	openFrame := &v1.Frame{
-		Version:  v1.Version,
-		Type:     v1.FrameOpenStream,
-		Flags:    v1.FlagNone,
-		StreamID: streamID,
-		Payload:  requestData,
+	// Create stream trên connection ít streams nhất, failover nếu connection vừa đóng
+	conn, stream, err := r.connManager.OpenStream(connIDs, requestData)
+	if err != nil {
+		return fmt.Errorf("failed to open stream: %w", err)
+	}
+	// Mọi đường thoát đều đóng stream và báo agent (no-op nếu agent đã kết thúc stream):
+	// lỗi, timeout hay client ngắt → reset để agent hủy request upstream ngay
+	defer func() {
+		if err != nil || ctx.Err() != nil {
+			conn.ResetStream(stream.ID)
+			return
+		}
+		conn.CloseStream(stream.ID)
+	}()
+	if shaper != nil {
+		stream.SetShaper(shaper)
 	}
+	r.metrics.StreamOpened(tunnelName)
+	defer r.metrics.StreamClosed(tunnelName)
 
-	if err := conn.SendFrame(openFrame); err != nil {
-		return fmt.Errorf("failed to send open stream frame: %w", err)
+	writer := connection.NewStreamWriter(conn, stream.ID)
+
+	// Upgrade (WebSocket, ...): body đã gửi, không gửi EndStream, stream trở thành kênh bytes 2 chiều
+	if isUpgradeRequest(req) {
+		if req.Body != nil {
+			if err := r.sendRequestBody(ctx, idle, writer, req.Body); err != nil {
+				return err
+			}
+		}
+		return r.handleUpgrade(ctx, idle, conn, stream, w, req)
 	}
 
-	// Get stream
-	stream, ok := conn.GetStream(streamID)
-	if !ok {
-		return fmt.Errorf("stream not found after creation")
+	// HTTP/1.x mặc định không cho đọc body sau khi đã ghi response
+	if err := http.NewResponseController(w).EnableFullDuplex(); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		return err
 	}
+	go func() {
+		if err := r.sendRequest(ctx, idle, writer, req); err != nil {
+			cancel(err)
+		}
+	}()
+
+	// Wait for response from stream
+	return r.waitForResponse(ctx, idle, stream, w, req)
+}
+
+// sendRequest gửi request body rồi EndStream
+// Request có trailers: body theo chunked encoding, trailers sau chunk cuối (như HTTP/1.1)
+func (r *Router) sendRequest(
+	ctx context.Context,
+	idle *idleTimer,
+	writer *connection.StreamWriter,
+	req *http.Request,
+) error {
+	chunked := hasTrailers(req)
 
-	// Forward request body if present
+	var body io.Writer = writer
+	if chunked {
+		body = &chunkedWriter{w: writer}
+	}
 	if req.Body != nil {
-		body, err := io.ReadAll(req.Body)
-		if err != nil {
-			return fmt.Errorf("failed to read request body: %w", err)
-		}
-
-		if len(body) > 0 {
-			dataFrame := &v1.Frame{
-				Version:  v1.Version,
-				Type:     v1.FrameData,
-				Flags:    v1.FlagNone,
-				StreamID: streamID,
-				Payload:  body,
-			}
+		if err := r.sendRequestBody(ctx, idle, body, req.Body); err != nil {
+			return err
+		}
+	}
 
-			if err := conn.SendFrame(dataFrame); err != nil {
-				return fmt.Errorf("failed to send request body: %w", err)
-			}
+	if chunked {
+		// Giá trị trailers chỉ có sau khi đọc hết body
+		var buf bytes.Buffer
+		buf.WriteString("0\r\n")
+		req.Trailer.Write(&buf)
+		buf.WriteString("\r\n")
+		if _, err := writer.Write(buf.Bytes()); err != nil {
+			return fmt.Errorf("failed to send request trailers: %w", err)
 		}
 	}
 
 	// Send EndStream flag to indicate request complete
-	endFrame := &v1.Frame{
-		Version:  v1.Version,
-		Type:     v1.FrameData,
-		Flags:    v1.FlagEndStream,
-		StreamID: streamID,
-		Payload:  nil,
+	if err := writer.CloseWrite(); err != nil {
+		return fmt.Errorf("failed to send end stream frame: %w", err)
 	}
+	return nil
+}
 
-	if err := conn.SendFrame(endFrame); err != nil {
-		return fmt.Errorf("failed to send end stream frame: %w", err)
+// sendRequestBody gửi request body đến agent, mỗi chunk đọc được là 1 FrameData
+func (r *Router) sendRequestBody(
+	ctx context.Context,
+	idle *idleTimer,
+	writer io.Writer,
+	body io.Reader,
+) error {
+	buf := make([]byte, bodyChunkSize)
+	for {
+		n, readErr := body.Read(buf)
+		if n > 0 {
+			// Response đã xong (stream đã đóng) trong lúc chờ client gửi body
+			if ctx.Err() != nil {
+				return context.Cause(ctx)
+			}
+			idle.reset()
+
+			if _, err := writer.Write(buf[:n]); err != nil {
+				return fmt.Errorf("failed to send request body: %w", err)
+			}
+		}
+
+		if readErr == io.EOF {
+			return nil
+		}
+		if readErr != nil {
+			return fmt.Errorf("failed to read request body: %w", readErr)
+		}
+		if ctx.Err() != nil {
+			return context.Cause(ctx)
+		}
 	}
+}
 
-	// Wait for response from stream
-	return r.waitForResponse(ctx, stream, streamID, w)
+// stripPathPrefix trả về bản copy request với path đã bỏ PathPrefix của tunnel
+// Prefix gốc được gửi cho agent qua X-Forwarded-Prefix
+func stripPathPrefix(req *http.Request, tunnel *registry.Tunnel) *http.Request {
+	stripped := req.Clone(req.Context())
+	stripped.URL.Path = tunnel.StripPath(req.URL.Path)
+	stripped.URL.RawPath = ""
+	stripped.Header.Set("X-Forwarded-Prefix", tunnel.PathPrefix)
+	return stripped
 }
 
-// buildRequestPayload builds request payload from HTTP request
+// buildRequestPayload serialize request line và headers (HTTP/1.1 format) làm payload của FrameOpenStream
+// Proto được giữ nguyên ("HTTP/2.0" cho client HTTP/2, vd. gRPC) để agent chọn upstream phù hợp
 func (r *Router) buildRequestPayload(req *http.Request) []byte {
-	// Simplified payload - can be enhanced with full HTTP/1.1 serialization
-	// Format: "METHOD PATH HTTP/1.1\r\nHeaders\r\n\r\n"
 	var buf bytes.Buffer
 
 	// Request line
-	buf.WriteString(fmt.Sprintf("%s %s %s\r\n", req.Method, req.URL.Path, req.Proto))
+	buf.WriteString(fmt.Sprintf("%s %s %s\r\n", req.Method, req.URL.RequestURI(), req.Proto))
 
 	// Headers
-	for key, values := range req.Header {
+	header := req.Header
+	if hasTrailers(req) {
+		// Trailers chỉ gửi được với chunked encoding
+		header = header.Clone()
+		header.Del("Content-Length")
+		header.Set("Transfer-Encoding", "chunked")
+		keys := make([]string, 0, len(req.Trailer))
+		for key := range req.Trailer {
+			keys = append(keys, key)
+		}
+		slices.Sort(keys)
+		header.Set("Trailer", strings.Join(keys, ", "))
+	}
+	for key, values := range header {
 		for _, value := range values {
 			buf.WriteString(fmt.Sprintf("%s: %s\r\n", key, value))
 		}
@@ -177,50 +333,135 @@ func (r *Router) buildRequestPayload(req *http.Request) []byte {
 	return buf.Bytes()
 }
 
-// waitForResponse waits for response from stream and writes to HTTP response
+// hasTrailers kiểm tra client đã khai báo request trailers (gửi sau body)
+func hasTrailers(req *http.Request) bool {
+	return len(req.Trailer) > 0
+}
+
+// chunkedWriter ghi mỗi Write thành 1 chunk của HTTP/1.1 chunked encoding (chunk header, data, CRLF trong 1 lần ghi)
+type chunkedWriter struct {
+	w   io.Writer
+	buf []byte
+}
+
+// Write implements io.Writer
+func (c *chunkedWriter) Write(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	c.buf = strconv.AppendInt(c.buf[:0], int64(len(p)), 16)
+	c.buf = append(c.buf, "\r\n"...)
+	c.buf = append(c.buf, p...)
+	c.buf = append(c.buf, "\r\n"...)
+	if _, err := c.w.Write(c.buf); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// hopHeaders là hop-by-hop headers, không forward từ agent về client (RFC 7230 6.1)
+var hopHeaders = []string{
+	"Connection",
+	"Proxy-Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// copyAndFlush copy body ra client, flush sau mỗi lần đọc để client nhận bytes sớm
+func copyAndFlush(w http.ResponseWriter, body io.Reader) error {
+	rc := http.NewResponseController(w)
+	buf := make([]byte, bodyChunkSize)
+	for {
+		n, readErr := body.Read(buf)
+		if n > 0 {
+			if _, err := w.Write(buf[:n]); err != nil {
+				return err
+			}
+			if err := rc.Flush(); err != nil && !errors.Is(err, http.ErrNotSupported) {
+				return err
+			}
+		}
+
+		if readErr == io.EOF {
+			return nil
+		}
+		if readErr != nil {
+			return readErr
+		}
+	}
+}
+
+// waitForResponse đọc HTTP/1.1 response từ stream và ghi ra http.ResponseWriter
+// Status line, headers, chunked body và trailers được giữ nguyên
 func (r *Router) waitForResponse(
 	ctx context.Context,
+	idle *idleTimer,
 	stream *connection.Stream,
-	streamID uint32,
 	w http.ResponseWriter,
+	req *http.Request,
 ) error {
-	// Read response data from stream
-	responseData := make([]byte, 0)
-	streamClosed := false
+	reader := &activityReader{r: connection.NewStreamReader(ctx, stream), idle: idle}
+	resp, err := http.ReadResponse(bufio.NewReader(reader), req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return context.Cause(ctx)
+		}
+		return fmt.Errorf("%w: %v", ErrInvalidResponse, err)
+	}
 
-	for {
-		select {
-		case <-ctx.Done():
-			return ctx.Err()
-
-		case data, ok := <-stream.DataIn():
-			if !ok {
-				streamClosed = true
-				break
-			}
-			responseData = append(responseData, data...)
+	return r.writeResponse(resp, w)
+}
 
-		case <-stream.CloseCh():
-			streamClosed = true
-			break
-		}
+// writeResponse ghi response của agent ra client: headers, body (flush từng phần) và trailers
+func (r *Router) writeResponse(resp *http.Response, w http.ResponseWriter) error {
+	defer resp.Body.Close()
 
-		if streamClosed {
-			break
+	// Copy headers (bỏ hop-by-hop)
+	removeHopHeaders(resp.Header)
+	header := w.Header()
+	for key, values := range resp.Header {
+		for _, value := range values {
+			header.Add(key, value)
 		}
 	}
 
-	// Parse and write response (simplified - assumes response is already HTTP formatted)
-	// In production, should parse HTTP response from agent
-	if len(responseData) > 0 {
-		// For now, just write raw response
-		// TODO: Parse HTTP response headers and status
-		w.WriteHeader(http.StatusOK)
-		w.Write(responseData)
-	} else {
-		w.WriteHeader(http.StatusNoContent)
+	// Khai báo trailers trước khi ghi body
+	for key := range resp.Trailer {
+		header.Add("Trailer", key)
+	}
+
+	w.WriteHeader(resp.StatusCode)
+
+	// Ghi và flush từng phần body ngay khi nhận được từ agent
+	if err := copyAndFlush(w, resp.Body); err != nil {
+		return fmt.Errorf("%w: %v", ErrResponseInterrupted, err)
+	}
+
+	// Trailers chỉ có giá trị sau khi đọc hết body
+	for key, values := range resp.Trailer {
+		for _, value := range values {
+			header.Add(http.TrailerPrefix+key, value)
+		}
 	}
 
 	return nil
 }
 
+// removeHopHeaders xóa hop-by-hop headers, kể cả headers được liệt kê trong Connection
+func removeHopHeaders(h http.Header) {
+	for _, value := range h.Values("Connection") {
+		for _, field := range strings.Split(value, ",") {
+			if field = textproto.TrimString(field); field != "" {
+				h.Del(field)
+			}
+		}
+	}
+	for _, key := range hopHeaders {
+		h.Del(key)
+	}
+}
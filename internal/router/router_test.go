@@ -0,0 +1,783 @@
+package router
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hydragon2m/tunnel-core/internal/connection"
+	"github.com/hydragon2m/tunnel-core/internal/connection/conntest"
+	"github.com/hydragon2m/tunnel-core/internal/domains"
+	"github.com/hydragon2m/tunnel-core/internal/metrics"
+	"github.com/hydragon2m/tunnel-core/internal/quota"
+	"github.com/hydragon2m/tunnel-core/internal/registry"
+	v1 "github.com/hydragon2m/tunnel-protocol/go/v1"
+)
+
+// newTestRouter tạo router với 1 agent connection và tunnel "app.localhost"
+func newTestRouter(t *testing.T) (*Router, net.Conn) {
+	t.Helper()
+
+	reg := registry.NewRegistry("localhost")
+	cm := connection.NewManager(10, 30*time.Second)
+
+	_, agent := conntest.Register(t, cm, "conn-1", "agent-1")
+	if _, err := reg.RegisterTunnel("", "app", "conn-1", "agent-1", nil); err != nil {
+		t.Fatalf("RegisterTunnel failed: %v", err)
+	}
+
+	return NewRouter(reg, cm, nil, 5*time.Second), agent
+}
+
+// fakeAgent đọc request đến EndStream rồi trả về raw response theo các chunks
+func fakeAgent(t *testing.T, agent net.Conn, chunks ...string) {
+	t.Helper()
+
+	go func() {
+		var streamID uint32
+		for {
+			frame, err := v1.Decode(agent)
+			if err != nil {
+				return
+			}
+			streamID = frame.StreamID
+			if frame.Type == v1.FrameData && frame.IsEndStream() {
+				break
+			}
+		}
+
+		for i, chunk := range chunks {
+			flags := v1.FlagNone
+			if i == len(chunks)-1 {
+				flags = v1.FlagEndStream
+			}
+			v1.Encode(agent, &v1.Frame{
+				Version:  v1.Version,
+				Type:     v1.FrameData,
+				Flags:    flags,
+				StreamID: streamID,
+				Payload:  []byte(chunk),
+			})
+		}
+	}()
+}
+
+func TestRouter_ResponsePassthrough(t *testing.T) {
+	router, agent := newTestRouter(t)
+	fakeAgent(t, agent,
+		"HTTP/1.1 302 Found\r\nLocation: /login\r\n",
+		"Set-Cookie: a=1\r\nSet-Cookie: b=2\r\nConnection: close\r\nContent-Length: 5\r\n\r\n",
+		"hello",
+	)
+
+	req := httptest.NewRequest(http.MethodGet, "http://app.localhost/", nil)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+
+	resp := rec.Result()
+	if resp.StatusCode != http.StatusFound {
+		t.Errorf("Expected status 302, got %d", resp.StatusCode)
+	}
+	if got := resp.Header.Get("Location"); got != "/login" {
+		t.Errorf("Expected Location '/login', got '%s'", got)
+	}
+	if got := resp.Header.Values("Set-Cookie"); len(got) != 2 {
+		t.Errorf("Expected 2 cookies, got %v", got)
+	}
+	if resp.Header.Get("Connection") != "" {
+		t.Error("Expected hop-by-hop header to be removed")
+	}
+	if body, _ := io.ReadAll(resp.Body); string(body) != "hello" {
+		t.Errorf("Expected body 'hello', got '%s'", body)
+	}
+}
+
+func TestRouter_ChunkedWithTrailers(t *testing.T) {
+	router, agent := newTestRouter(t)
+	fakeAgent(t, agent,
+		"HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\nTrailer: X-Checksum\r\n\r\n",
+		"5\r\nhello\r\n6\r\n world\r\n",
+		"0\r\nX-Checksum: abc\r\n\r\n",
+	)
+
+	req := httptest.NewRequest(http.MethodGet, "http://app.localhost/", nil)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+
+	resp := rec.Result()
+	if body, _ := io.ReadAll(resp.Body); string(body) != "hello world" {
+		t.Errorf("Expected body 'hello world', got '%s'", body)
+	}
+	if got := resp.Trailer.Get("X-Checksum"); got != "abc" {
+		t.Errorf("Expected trailer 'abc', got '%s'", got)
+	}
+}
+
+func TestRouter_MalformedResponse(t *testing.T) {
+	router, agent := newTestRouter(t)
+	fakeAgent(t, agent, "this is not http\r\n\r\n")
+
+	req := httptest.NewRequest(http.MethodGet, "http://app.localhost/", nil)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusBadGateway {
+		t.Errorf("Expected status 502, got %d", rec.Code)
+	}
+	if body := strings.TrimSpace(rec.Body.String()); body != "Bad Gateway" {
+		t.Errorf("Expected generic 'Bad Gateway' body, got %q", body)
+	}
+}
+
+func TestRouter_StreamsRequestBody(t *testing.T) {
+	router, agent := newTestRouter(t)
+
+	body := strings.Repeat("x", 3*bodyChunkSize+10)
+	received := make(chan []*v1.Frame, 1)
+
+	go func() {
+		var frames []*v1.Frame
+		for {
+			frame, err := v1.Decode(agent)
+			if err != nil {
+				return
+			}
+			frames = append(frames, frame)
+			if frame.Type == v1.FrameData && frame.IsEndStream() {
+				break
+			}
+		}
+		received <- frames
+
+		v1.Encode(agent, &v1.Frame{
+			Version:  v1.Version,
+			Type:     v1.FrameData,
+			Flags:    v1.FlagEndStream,
+			StreamID: frames[0].StreamID,
+			Payload:  []byte("HTTP/1.1 204 No Content\r\n\r\n"),
+		})
+	}()
+
+	req := httptest.NewRequest(http.MethodPost, "http://app.localhost/upload", strings.NewReader(body))
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("Expected status 204, got %d", rec.Code)
+	}
+
+	frames := <-received
+	var got strings.Builder
+	dataFrames := 0
+	for _, frame := range frames[1:] {
+		if len(frame.Payload) > bodyChunkSize {
+			t.Errorf("Expected frame payload <= %d, got %d", bodyChunkSize, len(frame.Payload))
+		}
+		if len(frame.Payload) > 0 {
+			dataFrames++
+		}
+		got.Write(frame.Payload)
+	}
+	if dataFrames < 2 {
+		t.Errorf("Expected body split into multiple frames, got %d", dataFrames)
+	}
+	if got.String() != body {
+		t.Errorf("Expected body of %d bytes, got %d", len(body), got.Len())
+	}
+}
+
+func TestRouter_StreamsResponseBody(t *testing.T) {
+	router, agent := newTestRouter(t)
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	release := make(chan struct{})
+	go func() {
+		var streamID uint32
+		for {
+			frame, err := v1.Decode(agent)
+			if err != nil {
+				return
+			}
+			streamID = frame.StreamID
+			if frame.Type == v1.FrameData && frame.IsEndStream() {
+				break
+			}
+		}
+
+		send := func(flags uint8, payload string) {
+			v1.Encode(agent, &v1.Frame{
+				Version:  v1.Version,
+				Type:     v1.FrameData,
+				Flags:    flags,
+				StreamID: streamID,
+				Payload:  []byte(payload),
+			})
+		}
+		send(v1.FlagNone, "HTTP/1.1 200 OK\r\nContent-Length: 10\r\n\r\nfirst")
+		<-release
+		send(v1.FlagEndStream, "-last")
+	}()
+
+	req, _ := http.NewRequest(http.MethodGet, server.URL, nil)
+	req.Host = "app.localhost"
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	// Phần đầu phải tới client trước khi agent gửi xong
+	first := make([]byte, 5)
+	if _, err := io.ReadFull(resp.Body, first); err != nil || string(first) != "first" {
+		t.Fatalf("Expected 'first' before agent finished, got '%s' (%v)", first, err)
+	}
+	close(release)
+
+	rest, _ := io.ReadAll(resp.Body)
+	if string(rest) != "-last" {
+		t.Errorf("Expected '-last', got '%s'", rest)
+	}
+}
+
+func TestRouter_HTTP2BidirectionalStreaming(t *testing.T) {
+	router, agent := newTestRouter(t)
+	server := httptest.NewUnstartedServer(router)
+	server.EnableHTTP2 = true
+	server.StartTLS()
+	defer server.Close()
+
+	// Agent (như gRPC service): trả response header + message đầu ngay khi nhận message đầu của client,
+	// response trailers gửi sau khi client kết thúc body
+	heads := make(chan string, 1)
+	rests := make(chan string, 1)
+	go func() {
+		open, err := v1.Decode(agent)
+		if err != nil {
+			return
+		}
+		heads <- string(open.Payload)
+
+		send := func(flags uint8, payload string) {
+			v1.Encode(agent, &v1.Frame{
+				Version:  v1.Version,
+				Type:     v1.FrameData,
+				Flags:    flags,
+				StreamID: open.StreamID,
+				Payload:  []byte(payload),
+			})
+		}
+		var body strings.Builder
+		for !strings.Contains(body.String(), "hello\r\n") {
+			frame, err := v1.Decode(agent)
+			if err != nil {
+				return
+			}
+			body.Write(frame.Payload)
+		}
+		send(v1.FlagNone, "HTTP/1.1 200 OK\r\nContent-Type: application/grpc\r\nTransfer-Encoding: chunked\r\nTrailer: Grpc-Status\r\n\r\n5\r\nworld\r\n")
+
+		for {
+			frame, err := v1.Decode(agent)
+			if err != nil {
+				return
+			}
+			body.Write(frame.Payload)
+			if frame.IsEndStream() {
+				break
+			}
+		}
+		rests <- body.String()
+		send(v1.FlagEndStream, "0\r\nGrpc-Status: 0\r\n\r\n")
+	}()
+
+	pr, pw := io.Pipe()
+	req, _ := http.NewRequest(http.MethodPost, server.URL+"/pkg.Service/Chat", pr)
+	req.Host = "app.localhost"
+	req.Header.Set("Content-Type", "application/grpc")
+	req.Trailer = http.Header{"X-Client-Checksum": nil}
+	go io.WriteString(pw, "hello")
+
+	resp, err := server.Client().Do(req)
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.ProtoMajor != 2 {
+		t.Errorf("Expected HTTP/2 response, got %s", resp.Proto)
+	}
+
+	// Message của agent tới trước khi client gửi xong body
+	first := make([]byte, 5)
+	if _, err := io.ReadFull(resp.Body, first); err != nil || string(first) != "world" {
+		t.Fatalf("Expected 'world' before request body finished, got '%s' (%v)", first, err)
+	}
+
+	req.Trailer.Set("X-Client-Checksum", "abc")
+	io.WriteString(pw, "bye")
+	pw.Close()
+
+	if rest, _ := io.ReadAll(resp.Body); len(rest) != 0 {
+		t.Errorf("Unexpected extra body %q", rest)
+	}
+	if got := resp.Trailer.Get("Grpc-Status"); got != "0" {
+		t.Errorf("Expected response trailer Grpc-Status 0, got %q", got)
+	}
+
+	head := <-heads
+	if !strings.HasPrefix(head, "POST /pkg.Service/Chat HTTP/2.0\r\n") ||
+		!strings.Contains(head, "Transfer-Encoding: chunked\r\n") ||
+		!strings.Contains(head, "Trailer: X-Client-Checksum\r\n") {
+		t.Errorf("Unexpected request head %q", head)
+	}
+	if rest := <-rests; !strings.HasSuffix(rest, "3\r\nbye\r\n0\r\nX-Client-Checksum: abc\r\n\r\n") {
+		t.Errorf("Expected chunked body with request trailers, got %q", rest)
+	}
+}
+
+func TestRouter_IdleTimeout(t *testing.T) {
+	router, agent := newTestRouter(t)
+	router.idleTimeout = 50 * time.Millisecond
+
+	// Agent nhận request nhưng không bao giờ trả lời
+	go func() {
+		for {
+			if _, err := v1.Decode(agent); err != nil {
+				return
+			}
+		}
+	}()
+
+	req := httptest.NewRequest(http.MethodGet, "http://app.localhost/", nil)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusGatewayTimeout {
+		t.Errorf("Expected status 504, got %d", rec.Code)
+	}
+}
+
+func TestRouter_RequestTimeoutCapsTrickle(t *testing.T) {
+	router, agent := newTestRouter(t)
+	router.idleTimeout = time.Second
+	router.SetRequestTimeout(200 * time.Millisecond)
+
+	// Agent trả response chunked từng byte, đủ nhanh để không bao giờ chạm idle timeout
+	reset := make(chan struct{})
+	go func() {
+		var streamID uint32
+		for {
+			frame, err := v1.Decode(agent)
+			if err != nil {
+				return
+			}
+			streamID = frame.StreamID
+			if frame.Type == v1.FrameData && frame.IsEndStream() {
+				break
+			}
+		}
+		go func() {
+			for {
+				frame, err := v1.Decode(agent)
+				if err != nil {
+					return
+				}
+				if frame.Type == v1.FrameClose && frame.StreamID == streamID {
+					close(reset)
+					return
+				}
+			}
+		}()
+
+		payload := "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n"
+		for {
+			v1.Encode(agent, &v1.Frame{Version: v1.Version, Type: v1.FrameData, StreamID: streamID, Payload: []byte(payload)})
+			payload = "1\r\nx\r\n"
+			select {
+			case <-reset:
+				return
+			case <-time.After(20 * time.Millisecond):
+			}
+		}
+	}()
+
+	server := httptest.NewServer(router)
+	defer server.Close()
+	req, _ := http.NewRequest(http.MethodGet, server.URL+"/", nil)
+	req.Host = "app.localhost"
+
+	start := time.Now()
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	_, err = io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err == nil {
+		t.Error("Expected response to be cut off at the request timeout")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Expected response to end after ~200ms, took %v", elapsed)
+	}
+
+	select {
+	case <-reset:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected stream reset at the request timeout")
+	}
+}
+
+func TestRouter_TimeoutClosesStream(t *testing.T) {
+	router, agent := newTestRouter(t)
+	router.idleTimeout = 50 * time.Millisecond
+
+	closedCh := make(chan uint32, 1)
+	go func() {
+		// Request 1: không trả lời, chờ server đóng stream
+		var streamID uint32
+		for {
+			frame, err := v1.Decode(agent)
+			if err != nil {
+				return
+			}
+			if frame.Type == v1.FrameOpenStream {
+				streamID = frame.StreamID
+			}
+			if frame.Type == v1.FrameClose {
+				if !frame.IsError() {
+					t.Error("Expected timeout to reset the stream (FlagError)")
+				}
+				closedCh <- frame.StreamID
+				break
+			}
+		}
+
+		// Response đến muộn cho stream đã bị đóng không được chặn connection
+		for i := 0; i < 20; i++ {
+			v1.Encode(agent, &v1.Frame{
+				Version:  v1.Version,
+				Type:     v1.FrameData,
+				Flags:    v1.FlagNone,
+				StreamID: streamID,
+				Payload:  []byte("late"),
+			})
+		}
+
+		// Request 2: trả lời bình thường
+		fakeAgent(t, agent, "HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nok")
+	}()
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://app.localhost/", nil))
+	if rec.Code != http.StatusGatewayTimeout {
+		t.Fatalf("Expected status 504, got %d", rec.Code)
+	}
+
+	select {
+	case streamID := <-closedCh:
+		if streamID == 0 {
+			t.Error("Expected FrameClose on a data stream")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected FrameClose for timed out stream")
+	}
+
+	conn, _ := router.connManager.GetConnection("conn-1")
+	if n := conn.StreamCount(); n != 0 {
+		t.Errorf("Expected timed out stream to be removed, %d streams open", n)
+	}
+
+	router.idleTimeout = 2 * time.Second
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://app.localhost/", nil))
+	if rec.Code != http.StatusOK || rec.Body.String() != "ok" {
+		t.Errorf("Expected later request to succeed, got %d '%s'", rec.Code, rec.Body.String())
+	}
+}
+
+func TestRouter_Metrics(t *testing.T) {
+	router, agent := newTestRouter(t)
+	m := metrics.New()
+	router.SetMetrics(m)
+	router.limiter = quota.NewLimiter(10, 100)
+	router.limiter.SetMetrics(m)
+	router.limiter.SetDomainLimit("app.localhost", 10, 0, 1)
+
+	fakeAgent(t, agent, "HTTP/1.1 201 Created\r\nContent-Length: 2\r\n\r\nok")
+
+	for _, host := range []string{"app.localhost", "app.localhost", "unknown.localhost"} {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://"+host+"/", nil))
+	}
+
+	var b strings.Builder
+	m.Registry().WriteTo(&b)
+	out := b.String()
+
+	for _, line := range []string{
+		`tunnel_http_requests_total{tunnel="app.localhost",code="201"} 1`,
+		`tunnel_http_requests_total{tunnel="app.localhost",code="429"} 1`,
+		`tunnel_http_requests_total{tunnel="",code="404"} 1`,
+		`tunnel_http_request_duration_seconds_count{tunnel="app.localhost"} 2`,
+		`tunnel_streams_opened_total{tunnel="app.localhost"} 1`,
+		`tunnel_streams_closed_total{tunnel="app.localhost"} 1`,
+		`tunnel_quota_rejections_total{reason="domain_rate_limit"} 1`,
+	} {
+		if !strings.Contains(out, line+"\n") {
+			t.Errorf("Expected %q in metrics:\n%s", line, out)
+		}
+	}
+}
+
+func TestRouter_FailoverToAgentConnection(t *testing.T) {
+	reg := registry.NewRegistry("localhost")
+	cm := connection.NewManager(10, 30*time.Second)
+	cm.SetOnConnectionClosed(reg.UnregisterConnectionTunnels)
+
+	_, agent1 := conntest.Register(t, cm, "conn-1", "agent-1")
+	_, agent2 := conntest.Register(t, cm, "conn-2", "agent-1")
+	for _, connID := range []string{"conn-1", "conn-2"} {
+		if _, err := reg.RegisterTunnel("", "app", connID, "agent-1", nil); err != nil {
+			t.Fatalf("RegisterTunnel failed: %v", err)
+		}
+	}
+	router := NewRouter(reg, cm, nil, 5*time.Second)
+
+	// conn-1 rớt: tunnel vẫn còn, request đi qua conn-2
+	agent1.Close()
+	deadline := time.Now().Add(2 * time.Second)
+	for len(reg.GetTunnelConnections("app.localhost")) != 1 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if ids := reg.GetTunnelConnections("app.localhost"); len(ids) != 1 || ids[0] != "conn-2" {
+		t.Fatalf("Expected tunnel to be served by conn-2 only, got %v", ids)
+	}
+
+	fakeAgent(t, agent2, "HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nok")
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://app.localhost/", nil))
+	if rec.Code != http.StatusOK || rec.Body.String() != "ok" {
+		t.Errorf("Expected request via conn-2, got %d '%s'", rec.Code, rec.Body.String())
+	}
+}
+
+func TestRouter_ClientCancelResetsStream(t *testing.T) {
+	router, agent := newTestRouter(t)
+	router.limiter = quota.NewLimiter(10, 100)
+	router.limiter.SetAgentLimit("agent-1", 10, 0, 100)
+
+	closedStreams := make(chan uint32, 1)
+	router.connManager.SetOnStreamClosed(func(connID string, streamID uint32) { closedStreams <- streamID })
+
+	// Agent nhận request rồi không trả lời, chờ reset
+	requested := make(chan struct{})
+	reset := make(chan *v1.Frame, 1)
+	go func() {
+		for {
+			frame, err := v1.Decode(agent)
+			if err != nil {
+				return
+			}
+			if frame.Type == v1.FrameData && frame.IsEndStream() {
+				close(requested)
+			}
+			if frame.Type == v1.FrameClose {
+				reset <- frame
+				return
+			}
+		}
+	}()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	req := httptest.NewRequest(http.MethodGet, "http://app.localhost/", nil).WithContext(ctx)
+	rec := httptest.NewRecorder()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		router.ServeHTTP(rec, req)
+	}()
+
+	<-requested
+	cancel() // Client ngắt kết nối
+
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("ServeHTTP still running after client cancellation")
+	}
+
+	select {
+	case frame := <-reset:
+		if !frame.IsError() || frame.StreamID == 0 {
+			t.Errorf("Expected stream reset, got flags=%d stream=%d", frame.Flags, frame.StreamID)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected FrameClose after client cancellation")
+	}
+	select {
+	case <-closedStreams:
+	default:
+		t.Error("Expected onStreamClosed to fire")
+	}
+
+	conn, _ := router.connManager.GetConnection("conn-1")
+	if n := conn.StreamCount(); n != 0 {
+		t.Errorf("Expected stream to be removed, %d streams open", n)
+	}
+	if st, _ := router.limiter.AgentLimitStatus("agent-1"); st.CurrentStreams != 0 {
+		t.Errorf("Expected stream quota released, got %d", st.CurrentStreams)
+	}
+	if rec.Code != statusClientClosedRequest {
+		t.Errorf("Expected status %d, got %d", statusClientClosedRequest, rec.Code)
+	}
+}
+
+func TestRouter_CustomDomain(t *testing.T) {
+	router, agent := newTestRouter(t)
+	dm := domains.NewManager("localhost", domains.Config{})
+
+	// Router không tự trả HTTP challenge: domain chỉ trỏ về server thì không xác minh được
+	claim, err := dm.Claim("www.example.com", "agent-1", domains.MethodHTTP)
+	if err != nil {
+		t.Fatalf("Claim failed: %v", err)
+	}
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, claim.URL(), nil))
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("Expected 404 for challenge without tunnel, got %d %q", rec.Code, rec.Body.String())
+	}
+
+	// Tunnel của custom domain được route như subdomain
+	if _, err := router.registry.RegisterCustomTunnel("shop.example.com", registry.Route{}, "conn-1", "agent-1", nil); err != nil {
+		t.Fatalf("RegisterCustomTunnel failed: %v", err)
+	}
+	fakeAgent(t, agent, "HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nok")
+
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://shop.example.com/", nil))
+	if rec.Code != http.StatusOK || rec.Body.String() != "ok" {
+		t.Errorf("Expected proxied response, got %d %q", rec.Code, rec.Body.String())
+	}
+}
+
+func TestRouter_PathPrefixRouting(t *testing.T) {
+	router, agent1 := newTestRouter(t)
+	_, agent2 := conntest.Register(t, router.connManager, "conn-2", "agent-2")
+	route := registry.Route{PathPrefix: "/api", StripPrefix: true}
+	if _, err := router.registry.RegisterTunnelRoute("app", route, "conn-2", "agent-2", nil); err != nil {
+		t.Fatalf("RegisterTunnelRoute failed: %v", err)
+	}
+
+	// agent-2 ghi lại request head (payload của OpenStream) rồi trả response
+	heads := make(chan string, 1)
+	go func() {
+		var streamID uint32
+		for {
+			frame, err := v1.Decode(agent2)
+			if err != nil {
+				return
+			}
+			streamID = frame.StreamID
+			if frame.Type == v1.FrameOpenStream {
+				heads <- string(frame.Payload)
+			}
+			if frame.Type == v1.FrameData && frame.IsEndStream() {
+				break
+			}
+		}
+		v1.Encode(agent2, &v1.Frame{
+			Version:  v1.Version,
+			Type:     v1.FrameData,
+			Flags:    v1.FlagEndStream,
+			StreamID: streamID,
+			Payload:  []byte("HTTP/1.1 200 OK\r\nContent-Length: 3\r\n\r\napi"),
+		})
+	}()
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://app.localhost/api/users", nil))
+	if rec.Code != http.StatusOK || rec.Body.String() != "api" {
+		t.Fatalf("Expected response from agent-2, got %d %q", rec.Code, rec.Body.String())
+	}
+	head := <-heads
+	if !strings.HasPrefix(head, "GET /users ") || !strings.Contains(head, "X-Forwarded-Prefix: /api\r\n") {
+		t.Errorf("Expected stripped path with X-Forwarded-Prefix, got %q", head)
+	}
+
+	// Path ngoài prefix vẫn đến tunnel "/" của agent-1
+	fakeAgent(t, agent1, "HTTP/1.1 200 OK\r\nContent-Length: 4\r\n\r\nroot")
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://app.localhost/apix", nil))
+	if rec.Code != http.StatusOK || rec.Body.String() != "root" {
+		t.Errorf("Expected response from agent-1, got %d %q", rec.Code, rec.Body.String())
+	}
+}
+
+func TestRouter_CancelWhileThrottled(t *testing.T) {
+	router, agent := newTestRouter(t)
+	router.limiter = quota.NewLimiter(10, 100)
+	router.limiter.SetAgentLimit("agent-1", 10, 64*1024, 100)
+
+	// Agent đếm bytes của request body; sau FrameClose không được nhận thêm data
+	throttled := make(chan struct{})
+	reset := make(chan struct{})
+	lateData := make(chan int, 1)
+	go func() {
+		received := 0
+		closed := false
+		for {
+			frame, err := v1.Decode(agent)
+			if err != nil {
+				return
+			}
+			switch {
+			case frame.Type == v1.FrameClose:
+				closed = true
+				close(reset)
+			case frame.Type == v1.FrameData && closed:
+				lateData <- len(frame.Payload)
+				return
+			case frame.Type == v1.FrameData:
+				// Burst 1 giây đã dùng hết, chunk tiếp theo phải chờ bandwidth
+				if received += len(frame.Payload); received == 64*1024 {
+					close(throttled)
+				}
+			}
+		}
+	}()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	body := strings.NewReader(strings.Repeat("x", 256*1024))
+	req := httptest.NewRequest(http.MethodPost, "http://app.localhost/upload", body).WithContext(ctx)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		router.ServeHTTP(httptest.NewRecorder(), req)
+	}()
+
+	select {
+	case <-throttled:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected request body to reach the bandwidth limit")
+	}
+	cancel() // Client ngắt kết nối trong lúc body đang bị giới hạn
+
+	<-done
+	select {
+	case <-reset:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected stream reset after cancellation")
+	}
+
+	// Chunk kế tiếp có bandwidth sau ~0.5 giây: data nhận sau reset là do writer chờ xong vẫn gửi tiếp
+	select {
+	case n := <-lateData:
+		t.Errorf("Received %d bytes of request body after stream reset", n)
+	case <-time.After(1200 * time.Millisecond):
+	}
+}
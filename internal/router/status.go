@@ -0,0 +1,55 @@
+package router
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+)
+
+// statusRecorder ghi lại status code đã gửi cho client (cho metrics)
+// Flush/Hijack đi qua http.ResponseController nhờ Unwrap
+type statusRecorder struct {
+	http.ResponseWriter
+	status   int
+	hijacked bool
+}
+
+func (s *statusRecorder) WriteHeader(code int) {
+	// 1xx là informational, status cuối cùng đến sau
+	if s.status == 0 && code >= http.StatusOK {
+		s.status = code
+	}
+	s.ResponseWriter.WriteHeader(code)
+}
+
+func (s *statusRecorder) Write(b []byte) (int, error) {
+	if s.status == 0 {
+		s.status = http.StatusOK
+	}
+	return s.ResponseWriter.Write(b)
+}
+
+// Hijack đánh dấu connection đã upgrade (101 được ghi trực tiếp lên conn)
+func (s *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, buf, err := http.NewResponseController(s.ResponseWriter).Hijack()
+	if err == nil {
+		s.hijacked = true
+	}
+	return conn, buf, err
+}
+
+// Unwrap cho http.ResponseController
+func (s *statusRecorder) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
+
+// Status trả về status code cuối cùng (200 nếu handler không ghi gì)
+func (s *statusRecorder) Status() int {
+	switch {
+	case s.hijacked:
+		return http.StatusSwitchingProtocols
+	case s.status == 0:
+		return http.StatusOK
+	}
+	return s.status
+}
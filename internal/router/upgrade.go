@@ -0,0 +1,120 @@
+package router
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/hydragon2m/tunnel-core/internal/connection"
+)
+
+// isUpgradeRequest kiểm tra request có yêu cầu Upgrade (WebSocket, h2c, ...) không
+func isUpgradeRequest(req *http.Request) bool {
+	if req.Header.Get("Upgrade") == "" {
+		return false
+	}
+	for _, value := range req.Header.Values("Connection") {
+		for _, token := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(token), "upgrade") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// handleUpgrade relay 101 Switching Protocols từ agent, hijack client connection
+// rồi pipe bytes 2 chiều qua stream cho đến khi 1 phía đóng
+// Nếu agent không trả 101, response được forward như request thường
+func (r *Router) handleUpgrade(
+	ctx context.Context,
+	idle *idleTimer,
+	conn *connection.Connection,
+	stream *connection.Stream,
+	w http.ResponseWriter,
+	req *http.Request,
+) error {
+	agentReader := bufio.NewReader(&activityReader{r: connection.NewStreamReader(ctx, stream), idle: idle})
+	resp, err := http.ReadResponse(agentReader, req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return context.Cause(ctx)
+		}
+		return fmt.Errorf("%w: %v", ErrInvalidResponse, err)
+	}
+
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		// Agent từ chối upgrade, kết thúc phía request và forward response
+		if err := connection.NewStreamWriter(conn, stream.ID).CloseWrite(); err != nil {
+			resp.Body.Close()
+			return fmt.Errorf("failed to send end stream frame: %w", err)
+		}
+		return r.writeResponse(resp, w)
+	}
+
+	// Upgraded connection có thể idle/sống lâu (WebSocket), không áp idle timeout và request timeout nữa
+	idle.stop()
+
+	clientConn, clientBuf, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrUpgradeNotSupported, err)
+	}
+	defer clientConn.Close()
+
+	// Ghi 101 response nguyên vẹn (giữ Upgrade/Connection headers)
+	if err := writeSwitchingProtocols(clientBuf.Writer, resp); err != nil {
+		return nil
+	}
+
+	pipeUpgraded(conn, stream, clientConn, clientBuf.Reader, agentReader)
+	return nil
+}
+
+// writeSwitchingProtocols ghi status line + headers của 101 response ra client
+func writeSwitchingProtocols(w *bufio.Writer, resp *http.Response) error {
+	if _, err := fmt.Fprintf(w, "HTTP/1.1 %s\r\n", resp.Status); err != nil {
+		return err
+	}
+	if err := resp.Header.Write(w); err != nil {
+		return err
+	}
+	if _, err := w.WriteString("\r\n"); err != nil {
+		return err
+	}
+	return w.Flush()
+}
+
+// pipeUpgraded copy bytes 2 chiều giữa client đã hijack và stream
+// clientReader/agentReader giữ bytes đã buffer trước khi upgrade
+// Client EOF → EndStream; lỗi đọc client (RST, ...) → đóng stream, dừng luôn chiều agent → client
+func pipeUpgraded(
+	conn *connection.Connection,
+	stream *connection.Stream,
+	clientConn net.Conn,
+	clientReader io.Reader,
+	agentReader io.Reader,
+) {
+	writer := connection.NewStreamWriter(conn, stream.ID)
+
+	// agent → client
+	agentDone := make(chan struct{})
+	go func() {
+		defer close(agentDone)
+		io.Copy(clientConn, agentReader)
+	}()
+
+	// client → agent (kết thúc khi clientConn bị đóng)
+	go func() {
+		if _, err := io.Copy(writer, clientReader); err != nil {
+			conn.ResetStream(stream.ID)
+			return
+		}
+		writer.CloseWrite()
+	}()
+
+	<-agentDone
+}
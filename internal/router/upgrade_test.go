@@ -0,0 +1,258 @@
+package router
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hydragon2m/tunnel-core/internal/quota"
+	v1 "github.com/hydragon2m/tunnel-protocol/go/v1"
+)
+
+func TestRouter_WebSocketUpgrade(t *testing.T) {
+	router, agent := newTestRouter(t)
+	router.limiter = quota.NewLimiter(10, 100)
+	router.limiter.SetAgentLimit("agent-1", 10, 0, 100)
+
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	openCh := make(chan string, 1)
+	dataCh := make(chan string, 1)
+	go func() {
+		open, err := v1.Decode(agent)
+		if err != nil {
+			return
+		}
+		openCh <- string(open.Payload)
+
+		send := func(flags uint8, payload string) {
+			v1.Encode(agent, &v1.Frame{
+				Version:  v1.Version,
+				Type:     v1.FrameData,
+				Flags:    flags,
+				StreamID: open.StreamID,
+				Payload:  []byte(payload),
+			})
+		}
+		send(v1.FlagNone, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n\r\nhello")
+
+		data, err := v1.Decode(agent)
+		if err != nil {
+			return
+		}
+		dataCh <- string(data.Payload)
+		send(v1.FlagEndStream, "bye")
+	}()
+
+	client, err := net.Dial("tcp", server.Listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer client.Close()
+	client.SetDeadline(time.Now().Add(2 * time.Second))
+
+	io.WriteString(client, "GET /ws HTTP/1.1\r\nHost: app.localhost\r\nConnection: Upgrade\r\nUpgrade: websocket\r\n\r\n")
+
+	br := bufio.NewReader(client)
+	resp, err := http.ReadResponse(br, nil)
+	if err != nil {
+		t.Fatalf("ReadResponse failed: %v", err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("Expected status 101, got %d", resp.StatusCode)
+	}
+	if resp.Header.Get("Upgrade") != "websocket" {
+		t.Errorf("Expected Upgrade header to be relayed, got '%s'", resp.Header.Get("Upgrade"))
+	}
+
+	if open := <-openCh; !strings.Contains(open, "Upgrade: websocket") {
+		t.Errorf("Expected Upgrade header forwarded to agent, got %q", open)
+	}
+
+	hello := make([]byte, 5)
+	if _, err := io.ReadFull(br, hello); err != nil || string(hello) != "hello" {
+		t.Fatalf("Expected 'hello' from agent, got '%s' (%v)", hello, err)
+	}
+
+	// Upgrade stream vẫn chiếm stream quota
+	if limit, _ := router.limiter.GetAgentLimit("agent-1"); limit.CurrentStreams != 1 {
+		t.Errorf("Expected 1 active stream during upgrade, got %d", limit.CurrentStreams)
+	}
+
+	io.WriteString(client, "hi")
+	if got := <-dataCh; got != "hi" {
+		t.Errorf("Expected agent to receive 'hi', got '%s'", got)
+	}
+
+	rest, _ := io.ReadAll(br)
+	if string(rest) != "bye" {
+		t.Errorf("Expected 'bye' then close, got '%s'", rest)
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		limit, _ := router.limiter.GetAgentLimit("agent-1")
+		if limit.CurrentStreams == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Expected stream quota to be released after upgrade ends")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestRouter_UpgradeRejectedByAgent(t *testing.T) {
+	router, agent := newTestRouter(t)
+
+	// Agent trả lời ngay sau OpenStream (upgrade request không có EndStream trước response)
+	go func() {
+		open, err := v1.Decode(agent)
+		if err != nil {
+			return
+		}
+		v1.Encode(agent, &v1.Frame{
+			Version:  v1.Version,
+			Type:     v1.FrameData,
+			Flags:    v1.FlagEndStream,
+			StreamID: open.StreamID,
+			Payload:  []byte("HTTP/1.1 400 Bad Request\r\nContent-Length: 2\r\n\r\nno"),
+		})
+		for {
+			if _, err := v1.Decode(agent); err != nil {
+				return
+			}
+		}
+	}()
+
+	req := httptest.NewRequest(http.MethodGet, "http://app.localhost/ws", nil)
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusBadRequest || rec.Body.String() != "no" {
+		t.Errorf("Expected 400 'no', got %d '%s'", rec.Code, rec.Body.String())
+	}
+}
+
+func TestRouter_UpgradeWithBody(t *testing.T) {
+	router, agent := newTestRouter(t)
+
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	// Agent chỉ trả 101 sau khi nhận đủ body
+	bodyCh := make(chan string, 1)
+	go func() {
+		open, err := v1.Decode(agent)
+		if err != nil {
+			return
+		}
+		var body strings.Builder
+		for body.Len() < len("payload") {
+			frame, err := v1.Decode(agent)
+			if err != nil {
+				return
+			}
+			if frame.Flags&v1.FlagEndStream != 0 {
+				bodyCh <- "unexpected end stream"
+				return
+			}
+			body.Write(frame.Payload)
+		}
+		bodyCh <- body.String()
+
+		v1.Encode(agent, &v1.Frame{
+			Version:  v1.Version,
+			Type:     v1.FrameData,
+			Flags:    v1.FlagEndStream,
+			StreamID: open.StreamID,
+			Payload:  []byte("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n\r\n"),
+		})
+		for {
+			if _, err := v1.Decode(agent); err != nil {
+				return
+			}
+		}
+	}()
+
+	client, err := net.Dial("tcp", server.Listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer client.Close()
+	client.SetDeadline(time.Now().Add(2 * time.Second))
+
+	io.WriteString(client, "POST /ws HTTP/1.1\r\nHost: app.localhost\r\nConnection: Upgrade\r\nUpgrade: websocket\r\nContent-Length: 7\r\n\r\npayload")
+
+	resp, err := http.ReadResponse(bufio.NewReader(client), nil)
+	if err != nil {
+		t.Fatalf("ReadResponse failed: %v", err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("Expected status 101, got %d", resp.StatusCode)
+	}
+	if got := <-bodyCh; got != "payload" {
+		t.Errorf("Expected agent to receive body 'payload' before 101, got %q", got)
+	}
+}
+
+func TestRouter_UpgradeClientResetClosesStream(t *testing.T) {
+	router, agent := newTestRouter(t)
+
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	// Agent nhận upgrade rồi im lặng, chờ FrameClose từ server
+	closed := make(chan struct{})
+	go func() {
+		open, err := v1.Decode(agent)
+		if err != nil {
+			return
+		}
+		v1.Encode(agent, &v1.Frame{
+			Version:  v1.Version,
+			Type:     v1.FrameData,
+			StreamID: open.StreamID,
+			Payload:  []byte("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n\r\n"),
+		})
+		for {
+			frame, err := v1.Decode(agent)
+			if err != nil {
+				return
+			}
+			if frame.Type == v1.FrameClose && frame.StreamID == open.StreamID {
+				close(closed)
+				return
+			}
+		}
+	}()
+
+	client, err := net.Dial("tcp", server.Listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	client.SetDeadline(time.Now().Add(2 * time.Second))
+
+	io.WriteString(client, "GET /ws HTTP/1.1\r\nHost: app.localhost\r\nConnection: Upgrade\r\nUpgrade: websocket\r\n\r\n")
+	if resp, err := http.ReadResponse(bufio.NewReader(client), nil); err != nil || resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("Expected 101, got %v (%v)", resp, err)
+	}
+
+	// RST thay vì FIN
+	client.(*net.TCPConn).SetLinger(0)
+	client.Close()
+
+	select {
+	case <-closed:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected FrameClose after client reset")
+	}
+}